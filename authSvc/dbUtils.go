@@ -2,8 +2,9 @@ package authSvc
 
 import (
 	"context"
-	"fmt"
 
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/DenysNahurnyi/deal/common/tracing"
 	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
 	"github.com/mongodb/mongo-go-driver/bson"
 	"github.com/mongodb/mongo-go-driver/bson/primitive"
@@ -22,21 +23,25 @@ func (user UserDB) GetUserID() string {
 }
 
 func CreateUserDB(ctx context.Context, user *UserDB, table *mongo.Collection) (string, error) {
+	ctx, span := tracing.StartDB(ctx, "CreateUserDB", table)
+	defer span.End()
 	res, err := table.InsertOne(ctx, *user)
 	if err != nil {
-		fmt.Println("Error creating user in mongo: ", err)
+		logging.Error(ctx, "Error creating user in mongo", "err", err)
 	}
 	return res.InsertedID.(primitive.ObjectID).Hex(), err
 }
 
 func GetUserByUsernameDB(ctx context.Context, username string, table *mongo.Collection) (*pb.User, error) {
+	ctx, span := tracing.StartDB(ctx, "GetUserByUsernameDB", table)
+	defer span.End()
 	userDB := UserDB{}
 	err := table.FindOne(ctx, bson.D{{Key: "username", Value: username}}).Decode(&userDB)
 	if err != nil {
 		if err.Error() == "mongo: no documents in result" {
 			return &pb.User{}, nil
 		}
-		fmt.Println("Error getting user from mongo: ", err)
+		logging.Error(ctx, "Error getting user from mongo", "err", err)
 		return nil, err
 	}
 
@@ -49,11 +54,13 @@ func GetUserByUsernameDB(ctx context.Context, username string, table *mongo.Coll
 }
 
 func DeleteUserByTokenIdDB(ctx context.Context, tokenID string, table *mongo.Collection) error {
+	ctx, span := tracing.StartDB(ctx, "DeleteUserByTokenIdDB", table)
+	defer span.End()
 	userDB := UserDB{}
 
 	err := table.FindOneAndDelete(ctx, bson.D{{Key: "tokenid", Value: tokenID}}).Decode(&userDB)
 	if err != nil {
-		fmt.Println("Error getting user from mongo: ", err)
+		logging.Error(ctx, "Error getting user from mongo", "err", err)
 		return err
 	}
 	return nil
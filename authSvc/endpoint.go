@@ -65,6 +65,19 @@ func makeDeleteSecureUserReqEndpoint(svc Service) endpoint.Endpoint {
 	}
 }
 
+func makeSignEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.SignReq)
+		tid := req.ReqHdr.Tid
+		signature, err := svc.Sign(ctx, req.GetPayload())
+
+		return pb.SignResp{
+			RespHdr:   &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Signature: signature,
+		}, err
+	}
+}
+
 func makeExistenceCheckEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		return pb.EmptyResp{}, nil
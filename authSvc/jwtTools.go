@@ -1,11 +1,13 @@
 package authSvc
 
 import (
+	"context"
 	"crypto/rsa"
-	"errors"
 	"fmt"
 	"io/ioutil"
 
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	"github.com/DenysNahurnyi/deal/common/logging"
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
@@ -18,7 +20,8 @@ type TokenData struct {
 	userId string
 }
 
-func loadKeys(createNewKeys bool) (*rsa.PrivateKey, *rsa.PublicKey, error) {
+// loadKeys reads RSA keys from PEM files {privateKeyFile} and {publicKeyFile}
+func loadKeys(createNewKeys bool, privateKeyFile, publicKeyFile string) (*rsa.PrivateKey, *rsa.PublicKey, error) {
 	if createNewKeys {
 		err := createKeys()
 		if err != nil {
@@ -26,26 +29,26 @@ func loadKeys(createNewKeys bool) (*rsa.PrivateKey, *rsa.PublicKey, error) {
 		}
 	}
 
-	data, err := getFile("/usr/bin/private.pem")
-	// data, err := getFile("private.pem")
+	data, err := getFile(privateKeyFile)
 	if err != nil {
-		fmt.Println("Failed to get `private.pem` key file")
+		logging.Error(context.Background(), "Failed to get `"+privateKeyFile+"` key file")
+		return nil, nil, err
 	}
 
 	rKey, err := jwt.ParseRSAPrivateKeyFromPEM(data)
 	if err != nil {
-		fmt.Println("Failed to convert PEM file to RSA private key")
+		logging.Error(context.Background(), "Failed to convert PEM file to RSA private key")
 	}
 
-	data, err = getFile("/usr/bin/public.pem")
-	// data, err = getFile("public.pem")
+	data, err = getFile(publicKeyFile)
 	if err != nil {
-		fmt.Println("Failed to get `public.pem` key file")
+		logging.Error(context.Background(), "Failed to get `"+publicKeyFile+"` key file")
+		return nil, nil, err
 	}
 
 	uKey, err := jwt.ParseRSAPublicKeyFromPEM(data)
 	if err != nil {
-		fmt.Println("Failed to convert PEM file to RSA public key")
+		logging.Error(context.Background(), "Failed to convert PEM file to RSA public key")
 	}
 
 	return rKey, uKey, err
@@ -59,7 +62,7 @@ func createKeys() error {
 func getFile(filename string) ([]byte, error) {
 	dat, err := ioutil.ReadFile(filename)
 	if err != nil {
-		fmt.Println("Error: ", err)
+		logging.Error(context.Background(), "Error", "err", err)
 		return nil, err
 	}
 	return dat, err
@@ -83,14 +86,14 @@ func checkToken(uKey interface{}, tokenStr string) (string, error) {
 		return uKey, nil
 	})
 	if err != nil {
-		fmt.Println("Error parsing token:", err)
-		return "", err
+		logging.Error(context.Background(), "Error parsing token", "err", err)
+		return "", dealerrors.New(dealerrors.INVALID_TOKEN, "Failed to parse token: %v", err)
 	}
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 		if userId, ok := claims["userID"]; ok {
 			return userId.(string), err
 		}
-		fmt.Println("Token is invalid")
+		logging.Warn(context.Background(), "Token is invalid")
 	}
-	return "", errors.New("Token is inappropriate")
+	return "", dealerrors.New(dealerrors.INVALID_TOKEN, "Token is inappropriate")
 }
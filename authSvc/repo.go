@@ -0,0 +1,98 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package authSvc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+)
+
+// SecureUserRepo keeps user credentials, they are stored apart from user profiles of dataSvc
+type SecureUserRepo interface {
+	Create(ctx context.Context, user *UserDB) (string, error)
+	// GetByUsername returns empty user if it doesn't exist, Id of returned user is dataSvc user id
+	GetByUsername(ctx context.Context, username string) (*pb.User, error)
+	DeleteByTokenID(ctx context.Context, tokenID string) error
+}
+
+// mongoSecureUserRepo is SecureUserRepo on top of mongo collection
+type mongoSecureUserRepo struct {
+	table *mongo.Collection
+}
+
+// NewMongoSecureUserRepo creates SecureUserRepo that keeps credentials in the {table}
+func NewMongoSecureUserRepo(table *mongo.Collection) SecureUserRepo {
+	return &mongoSecureUserRepo{table: table}
+}
+
+func (r *mongoSecureUserRepo) Create(ctx context.Context, user *UserDB) (string, error) {
+	return CreateUserDB(ctx, user, r.table)
+}
+
+func (r *mongoSecureUserRepo) GetByUsername(ctx context.Context, username string) (*pb.User, error) {
+	return GetUserByUsernameDB(ctx, username, r.table)
+}
+
+func (r *mongoSecureUserRepo) DeleteByTokenID(ctx context.Context, tokenID string) error {
+	return DeleteUserByTokenIdDB(ctx, tokenID, r.table)
+}
+
+// memSecureUserRepo is SecureUserRepo that keeps credentials in memory, it's safe for concurrent use
+type memSecureUserRepo struct {
+	m     sync.RWMutex
+	users map[string]UserDB
+}
+
+// NewMemSecureUserRepo creates empty in-memory SecureUserRepo
+func NewMemSecureUserRepo() SecureUserRepo {
+	return &memSecureUserRepo{users: map[string]UserDB{}}
+}
+
+func (r *memSecureUserRepo) Create(ctx context.Context, user *UserDB) (string, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	stored := *user
+	if stored.Id == (primitive.ObjectID{}) {
+		stored.Id = primitive.NewObjectID()
+	}
+	if _, ok := r.users[stored.GetUserID()]; ok {
+		return "", fmt.Errorf("User %s already exists", stored.GetUserID())
+	}
+	r.users[stored.GetUserID()] = stored
+	return stored.GetUserID(), nil
+}
+
+func (r *memSecureUserRepo) GetByUsername(ctx context.Context, username string) (*pb.User, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	for _, u := range r.users {
+		if u.Username == username {
+			return &pb.User{
+				Username: u.Username,
+				Id:       u.TokenId,
+			}, nil
+		}
+	}
+	return &pb.User{}, nil
+}
+
+func (r *memSecureUserRepo) DeleteByTokenID(ctx context.Context, tokenID string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	for id, u := range r.users {
+		if u.TokenId == tokenID {
+			delete(r.users, id)
+			return nil
+		}
+	}
+	return mongo.ErrNoDocuments
+}
@@ -8,18 +8,21 @@ package authSvc
 
 import (
 	"context"
+	"crypto"
+	"crypto/rand"
 	"crypto/rsa"
 	"encoding/base64"
 	"errors"
-	"fmt"
 	"strings"
 
+	"github.com/DenysNahurnyi/deal/common/config"
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	grpcutils "github.com/DenysNahurnyi/deal/common/grpc"
+	"github.com/DenysNahurnyi/deal/common/logging"
 	"github.com/DenysNahurnyi/deal/pb/generated/pb"
 
 	"github.com/go-kit/kit/log"
 	"github.com/mongodb/mongo-go-driver/mongo"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 type Service interface {
@@ -27,6 +30,7 @@ type Service interface {
 	SignUp(ctx context.Context, userReq *pb.CreateUserReq, password string) (string, error)
 	GetKey(ctx context.Context) (string, int64, error)
 	DeleteUser(ctx context.Context, tokenId string) error
+	Sign(ctx context.Context, payload []byte) ([]byte, error)
 	GetPubKey() *rsa.PublicKey
 }
 
@@ -35,43 +39,46 @@ type service struct {
 	mongoClient   *mongo.Client
 	uKey          *rsa.PublicKey
 	rKey          *rsa.PrivateKey
-	table         *mongo.Collection
+	users         SecureUserRepo
 	dataSvcClient pb.DataServiceClient
 }
 
-func NewService(logger log.Logger, mgc *mongo.Client, dataSvcClient *pb.DataServiceClient) (Service, error) {
-	rKey, uKey, err := loadKeys(false)
+// NewService creates auth service that keeps credentials in mongo database from {cfg} and signs tokens with keys from {cfg}
+func NewService(logger log.Logger, cfg *config.Config, mgc *mongo.Client, dataSvcClient *pb.DataServiceClient) (Service, error) {
+	rKey, uKey, err := loadKeys(false, cfg.Keys.PrivateKeyFile, cfg.Keys.PublicKeyFile)
 	if err != nil {
-		fmt.Println("Failed to get keys")
+		logging.Error(context.Background(), "Failed to get keys")
 		return nil, err
 	}
-	collection := mgc.Database("travel").Collection("usersSecure")
+	collection := mgc.Database(cfg.Mongo.Database).Collection(cfg.Collection("usersSecure"))
 
 	return &service{
-		envType:       "test",
+		envType:       cfg.EnvType,
 		mongoClient:   mgc,
 		uKey:          uKey,
 		rKey:          rKey,
-		table:         collection,
+		users:         NewMongoSecureUserRepo(collection),
 		dataSvcClient: *dataSvcClient,
 	}, nil
 }
 
+// NewServiceWithRepo creates service that keeps credentials in {users}, e.g. in-memory repo for tests.
+// Tokens are signed with {rKey} instead of key files
+func NewServiceWithRepo(users SecureUserRepo, dataSvcClient pb.DataServiceClient, rKey *rsa.PrivateKey) Service {
+	return &service{
+		envType:       "test",
+		uKey:          &rKey.PublicKey,
+		rKey:          rKey,
+		users:         users,
+		dataSvcClient: dataSvcClient,
+	}
+}
+
 func (s *service) GetPubKey() *rsa.PublicKey {
 	return s.uKey
 }
 
 func (s *service) SignUp(ctx context.Context, userReq *pb.CreateUserReq, password string) (string, error) {
-	if len(password) < 3 || len(password) > 30 {
-		return "", status.Errorf(codes.InvalidArgument, "Validation error: password length must be in [3, 30] range")
-	}
-
-	if userReq == nil || userReq.GetUser() == nil {
-		return "", status.Errorf(codes.InvalidArgument, "User object mustn't be empty")
-	}
-	if len(userReq.GetUser().GetUsername()) == 0 {
-		return "", status.Errorf(codes.InvalidArgument, "Validation error: username length mustn't be empty")
-	}
 	userReq.User.Username = strings.TrimSpace(userReq.User.Username)
 
 	user := UserDB{
@@ -80,28 +87,28 @@ func (s *service) SignUp(ctx context.Context, userReq *pb.CreateUserReq, passwor
 	}
 
 	// Check if this user exist in secure DB
-	userGet, err := GetUserByUsernameDB(ctx, user.Username, s.table)
+	userGet, err := s.users.GetByUsername(ctx, user.Username)
 	if err != nil {
-		fmt.Println("Failed to get user from DB")
+		logging.Error(ctx, "Failed to get user from DB")
 		return "", err
 	}
 	if len(userGet.GetUsername()) > 0 {
-		fmt.Println("[WARNING] user already exist")
-		return "", errors.New("User already exist")
+		logging.Warn(ctx, "User already exists")
+		return "", dealerrors.New(dealerrors.USERNAME_TAKEN, "User %s already exists", user.Username)
 	}
 
 	// Create user in common DB
 	createUserDataRes, err := s.dataSvcClient.CreateUser(ctx, userReq)
 	if err != nil {
-		fmt.Println("Failed to create user in dataSvc: ", err)
+		logging.Error(ctx, "Failed to create user in dataSvc", "err", err)
 		return "", err
 	}
 	user.TokenId = createUserDataRes.UserId
 
 	// Create user in secure table
-	userID, err := CreateUserDB(ctx, &user, s.table)
+	userID, err := s.users.Create(ctx, &user)
 	if err != nil {
-		fmt.Println("Failed to create user in dataSvc: ", err)
+		logging.Error(ctx, "Failed to create user in dataSvc", "err", err)
 		// Call dataSvc to delete user
 		return "", err
 	}
@@ -109,23 +116,17 @@ func (s *service) SignUp(ctx context.Context, userReq *pb.CreateUserReq, passwor
 }
 
 func (s *service) Login(ctx context.Context, username, password string) (string, error) {
-	if len(username) == 0 {
-		return "", status.Errorf(codes.InvalidArgument, "Invalid params, username musn't be empty")
-	}
-	if len(password) == 0 {
-		return "", status.Errorf(codes.InvalidArgument, "Invalid params, password musn't be empty")
-	}
-	userGet, err := GetUserByUsernameDB(ctx, username, s.table)
+	userGet, err := s.users.GetByUsername(ctx, username)
 	if err != nil {
-		return "", status.Errorf(codes.Internal, "Failed to get user from DB: %q", err)
+		return "", dealerrors.New(dealerrors.INTERNAL, "Failed to get user from DB: %v", err)
 	}
 	if len(userGet.GetId()) == 0 {
-		return "", status.Errorf(codes.InvalidArgument, "User does not exist")
+		return "", dealerrors.New(dealerrors.INVALID_CREDENTIALS, "User %s doesn't exist", username)
 	}
 
 	jwtToken, err := createToken(userGet.GetId(), s.rKey)
 	if err != nil {
-		return "", status.Errorf(codes.Internal, "Error with token")
+		return "", dealerrors.New(dealerrors.INTERNAL, "Error with token")
 	}
 	return jwtToken, nil
 }
@@ -146,5 +147,13 @@ func (s *service) GetKey(ctx context.Context) (string, int64, error) {
 }
 
 func (s *service) DeleteUser(ctx context.Context, tokenId string) error {
-	return DeleteUserByTokenIdDB(ctx, tokenId, s.table)
+	return s.users.DeleteByTokenID(ctx, tokenId)
+}
+
+// Sign signs {payload} with the private key of tokens, signature is checked by the public key
+func (s *service) Sign(ctx context.Context, payload []byte) ([]byte, error) {
+	if s.rKey == nil {
+		return nil, errors.New("Private key is not present in Auth service")
+	}
+	return rsa.SignPKCS1v15(rand.Reader, s.rKey, crypto.SHA256, grpcutils.SignatureDigest(payload))
 }
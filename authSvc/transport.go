@@ -20,6 +20,7 @@ type grpcServer struct {
 	deleteUser       grpctransport.Handler
 	getCheckTokenKey grpctransport.Handler
 	existenceCheck   grpctransport.Handler
+	sign             grpctransport.Handler
 }
 
 func NewGRPCServer(svc Service, logger log.Logger) pb.AuthServiceServer {
@@ -52,6 +53,11 @@ func NewGRPCServer(svc Service, logger log.Logger) pb.AuthServiceServer {
 			decodeEmptyReq,
 			encodeEmptyResp,
 			options...),
+		sign: grpctransport.NewServer(
+			makeSignEndpoint(svc),
+			decodeSignReq,
+			encodeSignResp,
+			options...),
 	}
 }
 
@@ -134,3 +140,21 @@ func encodeSignUpResp(_ context.Context, response interface{}) (interface{}, err
 	resp := response.(pb.SignUpResp)
 	return &resp, nil
 }
+
+func (s *grpcServer) Sign(ctx context.Context, req *pb.SignReq) (*pb.SignResp, error) {
+	_, resp, err := s.sign.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.SignResp), nil
+}
+
+func decodeSignReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.SignReq)
+	return req, nil
+}
+
+func encodeSignResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.SignResp)
+	return &resp, nil
+}
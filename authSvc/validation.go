@@ -0,0 +1,45 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package authSvc
+
+import (
+	"github.com/DenysNahurnyi/deal/common/validation"
+	"github.com/DenysNahurnyi/deal/pb/generated/pb"
+)
+
+const (
+	minPasswordLen = 3
+	maxPasswordLen = 30
+)
+
+// Validation rules of every request of authSvc, they are checked by the server interceptor
+func init() {
+	validation.Register(&pb.SignUpReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.SignUpReq)
+		// Spaces are a part of the password, so it isn't trimmed like other strings
+		if n := len(r.GetPassword()); n < minPasswordLen || n > maxPasswordLen {
+			v.Add("password", "Must be from %d to %d characters", minPasswordLen, maxPasswordLen)
+		}
+		v.Present("user_req.user", r.GetUserReq().GetUser() != nil)
+		if r.GetUserReq().GetUser() != nil {
+			v.Required("user_req.user.username", r.GetUserReq().GetUser().GetUsername())
+		}
+	})
+	validation.Register(&pb.LoginReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.LoginReq)
+		v.Required("username", r.GetUsername())
+		v.Required("password", r.GetPassword())
+	})
+	validation.Register(&pb.DeleteSecureUserReq{}, func(req interface{}, v *validation.Violations) {
+		v.ID("token_id", req.(*pb.DeleteSecureUserReq).GetTokenId())
+	})
+	validation.Register(&pb.SignReq{}, func(req interface{}, v *validation.Violations) {
+		if len(req.(*pb.SignReq).GetPayload()) == 0 {
+			v.Add("payload", "Mustn't be empty")
+		}
+	})
+}
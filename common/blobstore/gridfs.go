@@ -0,0 +1,141 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package blobstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/options"
+)
+
+// Default GridFS chunk size, 255KB
+const gridFSChunkSize = 255 * 1024
+
+// GridFSStore keeps blobs in the GridFS layout ({bucket}.files and {bucket}.chunks collections),
+// key is stored as a file name, so mongofiles and other GridFS tools can read them
+type GridFSStore struct {
+	files  *mongo.Collection
+	chunks *mongo.Collection
+}
+
+type gridFSFile struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Length     int64              `bson:"length"`
+	ChunkSize  int32              `bson:"chunkSize"`
+	UploadDate time.Time          `bson:"uploadDate"`
+	Filename   string             `bson:"filename"`
+}
+
+type gridFSChunk struct {
+	FilesID primitive.ObjectID `bson:"files_id"`
+	N       int32              `bson:"n"`
+	Data    []byte             `bson:"data"`
+}
+
+// NewGridFSStore creates store in bucket {bucket} of the database {db}
+func NewGridFSStore(db *mongo.Database, bucket string) *GridFSStore {
+	return &GridFSStore{
+		files:  db.Collection(bucket + ".files"),
+		chunks: db.Collection(bucket + ".chunks"),
+	}
+}
+
+func (s *GridFSStore) Put(ctx context.Context, key string, data []byte) error {
+	file := gridFSFile{
+		ID:         primitive.NewObjectID(),
+		Length:     int64(len(data)),
+		ChunkSize:  gridFSChunkSize,
+		UploadDate: time.Now(),
+		Filename:   key,
+	}
+	chunks := []interface{}{}
+	for n := 0; n*gridFSChunkSize < len(data) || n == 0; n++ {
+		end := (n + 1) * gridFSChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunks = append(chunks, gridFSChunk{FilesID: file.ID, N: int32(n), Data: data[n*gridFSChunkSize : end]})
+	}
+	// Chunks go first, so file record never points to missing data
+	if _, err := s.chunks.InsertMany(ctx, chunks); err != nil {
+		return fmt.Errorf("Failed to write chunks of blob %s, err: %v", key, err)
+	}
+	if _, err := s.files.InsertOne(ctx, file); err != nil {
+		return fmt.Errorf("Failed to write blob %s, err: %v", key, err)
+	}
+	return nil
+}
+
+func (s *GridFSStore) Get(ctx context.Context, key string) ([]byte, error) {
+	file, err := s.latest(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := s.chunks.Find(ctx,
+		bson.D{{Key: "files_id", Value: file.ID}},
+		options.Find().SetSort(bson.D{{Key: "n", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	data := make([]byte, 0, file.Length)
+	for cursor.Next(ctx) {
+		c := &gridFSChunk{}
+		if err := cursor.Decode(c); err != nil {
+			return nil, err
+		}
+		data = append(data, c.Data...)
+	}
+	if int64(len(data)) != file.Length {
+		return nil, fmt.Errorf("Blob %s is corrupted, expected %d bytes, got %d", key, file.Length, len(data))
+	}
+	return data, nil
+}
+
+func (s *GridFSStore) Delete(ctx context.Context, key string) error {
+	cursor, err := s.files.Find(ctx, bson.D{{Key: "filename", Value: key}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		f := &gridFSFile{}
+		if err := cursor.Decode(f); err != nil {
+			return err
+		}
+		if _, err := s.files.DeleteOne(ctx, bson.D{{Key: "_id", Value: f.ID}}); err != nil {
+			return err
+		}
+		if _, err := s.chunks.DeleteMany(ctx, bson.D{{Key: "files_id", Value: f.ID}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// latest returns newest revision of the file {key}, as GridFS allows several files with the same name
+func (s *GridFSStore) latest(ctx context.Context, key string) (*gridFSFile, error) {
+	file := &gridFSFile{}
+	err := s.files.FindOne(ctx,
+		bson.D{{Key: "filename", Value: key}},
+		options.FindOne().SetSort(bson.D{{Key: "uploadDate", Value: -1}}),
+	).Decode(file)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return file, nil
+}
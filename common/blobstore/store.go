@@ -0,0 +1,38 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/DenysNahurnyi/deal/common/config"
+	"github.com/mongodb/mongo-go-driver/mongo"
+)
+
+// ErrNotFound is returned when there is no blob for the key
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps binary objects by key, implementations must be safe for concurrent use
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// New creates store of kind {cfg}.Kind. Local store keeps files in {cfg}.Dir, GridFS store uses {cfg}.Bucket bucket of the {db}
+func New(db *mongo.Database, cfg config.BlobStoreConfig) (Store, error) {
+	switch cfg.Kind {
+	case config.BLOB_STORE_LOCAL:
+		return NewLocalStore(cfg.Dir)
+	case config.BLOB_STORE_GRIDFS:
+		return NewGridFSStore(db, cfg.Bucket), nil
+	default:
+		return nil, fmt.Errorf("Unknown blob store kind %q", cfg.Kind)
+	}
+}
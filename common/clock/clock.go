@@ -0,0 +1,148 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates timers, so time based logic could be driven by fake clock in tests
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a single event timer, the same as time.Timer
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// New returns clock that uses system time
+func New() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{t: time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (rt realTimer) C() <-chan time.Time {
+	return rt.t.C
+}
+
+func (rt realTimer) Stop() bool {
+	return rt.t.Stop()
+}
+
+// Fake is a clock that moves only when it's advanced, timers fire once their time comes
+type Fake struct {
+	m      sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFake returns fake clock that starts at {now}
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.now
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	f.m.Lock()
+	defer f.m.Unlock()
+	t := &fakeTimer{
+		c:    make(chan time.Time, 1),
+		when: f.now.Add(d),
+		f:    f,
+	}
+	if d <= 0 {
+		t.c <- f.now
+		return t
+	}
+	f.timers = append(f.timers, t)
+	return t
+}
+
+// Advance moves the clock {d} forward and fires every timer that is due by then
+func (f *Fake) Advance(d time.Duration) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.now = f.now.Add(d)
+	pending := f.timers[:0]
+	for _, t := range f.timers {
+		if t.when.After(f.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.c <- f.now
+	}
+	f.timers = pending
+}
+
+// Pending returns number of timers that wait to fire
+func (f *Fake) Pending() int {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return len(f.timers)
+}
+
+// FirePending moves the clock to the latest pending timer, so every pending timer fires.
+// Returns number of fired timers
+func (f *Fake) FirePending() int {
+	f.m.Lock()
+	latest := f.now
+	for _, t := range f.timers {
+		if t.when.After(latest) {
+			latest = t.when
+		}
+	}
+	fired := len(f.timers)
+	d := latest.Sub(f.now)
+	f.m.Unlock()
+	f.Advance(d)
+	return fired
+}
+
+type fakeTimer struct {
+	c    chan time.Time
+	when time.Time
+	f    *Fake
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop prevents the timer from firing, returns false if it already fired or was stopped
+func (t *fakeTimer) Stop() bool {
+	t.f.m.Lock()
+	defer t.f.m.Unlock()
+	for i, pending := range t.f.timers {
+		if pending == t {
+			t.f.timers = append(t.f.timers[:i], t.f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
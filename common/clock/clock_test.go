@@ -0,0 +1,99 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var start = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func fired(t Timer) bool {
+	select {
+	case <-t.C():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFakeAdvance(t *testing.T) {
+	f := NewFake(start)
+	early := f.NewTimer(time.Hour)
+	late := f.NewTimer(3 * time.Hour)
+
+	f.Advance(59 * time.Minute)
+	if fired(early) || fired(late) {
+		t.Fatalf("Timer fired before its time")
+	}
+	f.Advance(time.Minute)
+	if !fired(early) {
+		t.Fatalf("Timer didn't fire at its time")
+	}
+	if fired(late) || f.Pending() != 1 {
+		t.Fatalf("Late timer fired too early, %d timers pending", f.Pending())
+	}
+	if now := f.Now(); !now.Equal(start.Add(time.Hour)) {
+		t.Fatalf("Clock is at %v, want %v", now, start.Add(time.Hour))
+	}
+	f.Advance(5 * time.Hour)
+	if !fired(late) || f.Pending() != 0 {
+		t.Fatalf("Late timer didn't fire, %d timers pending", f.Pending())
+	}
+}
+
+func TestFakeFirePending(t *testing.T) {
+	f := NewFake(start)
+	timers := []Timer{f.NewTimer(time.Minute), f.NewTimer(time.Hour), f.NewTimer(48 * time.Hour)}
+	if n := f.FirePending(); n != len(timers) {
+		t.Fatalf("Fired %d timers, want %d", n, len(timers))
+	}
+	for i, timer := range timers {
+		if !fired(timer) {
+			t.Fatalf("Timer %d didn't fire", i)
+		}
+	}
+	if now := f.Now(); !now.Equal(start.Add(48 * time.Hour)) {
+		t.Fatalf("Clock is at %v, want the latest timer %v", now, start.Add(48*time.Hour))
+	}
+	if n := f.FirePending(); n != 0 {
+		t.Fatalf("Fired %d timers without pending ones", n)
+	}
+}
+
+func TestFakeTimerStop(t *testing.T) {
+	f := NewFake(start)
+	stopped := f.NewTimer(time.Hour)
+	running := f.NewTimer(time.Hour)
+	if !stopped.Stop() {
+		t.Fatalf("Pending timer wasn't stopped")
+	}
+	if stopped.Stop() {
+		t.Fatalf("Timer was stopped twice")
+	}
+	f.Advance(time.Hour)
+	if fired(stopped) {
+		t.Fatalf("Stopped timer fired")
+	}
+	if !fired(running) {
+		t.Fatalf("Stop of one timer affected another")
+	}
+	if running.Stop() {
+		t.Fatalf("Fired timer was stopped")
+	}
+}
+
+func TestFakeTimerFiresRightAway(t *testing.T) {
+	f := NewFake(start)
+	if !fired(f.NewTimer(0)) || !fired(f.NewTimer(-time.Second)) {
+		t.Fatalf("Timer without duration didn't fire right away")
+	}
+	if f.Pending() != 0 {
+		t.Fatalf("%d timers pending, want none", f.Pending())
+	}
+}
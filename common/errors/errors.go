@@ -0,0 +1,313 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dealerrors
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/DenysNahurnyi/deal/common/logging"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/golang/protobuf/proto"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Code is a stable code of the error, clients rely on it, so codes are never changed or reused
+type Code int32
+
+// Generic codes, errors that are not in the catalog get them by their gRPC code
+const (
+	INTERNAL            Code = 1
+	INVALID_ARGUMENT    Code = 2
+	NOT_FOUND           Code = 3
+	ALREADY_EXISTS      Code = 4
+	PERMISSION_DENIED   Code = 5
+	UNAUTHENTICATED     Code = 6
+	FAILED_PRECONDITION Code = 7
+	UNAVAILABLE         Code = 8
+	DEADLINE_EXCEEDED   Code = 9
+	CANCELLED           Code = 10
+	UNIMPLEMENTED       Code = 11
+	DATA_LOSS           Code = 12
+)
+
+// Codes of deals, users and judges, dataSvc
+const (
+	USER_NOT_FOUND        Code = 1001
+	USER_ALREADY_EXISTS   Code = 1002
+	DEAL_NOT_FOUND        Code = 1003
+	NOT_A_PARTICIPANT     Code = 1004
+	ALREADY_ACCEPTED      Code = 1005
+	DEAL_CANCELLED        Code = 1006
+	INVALID_TRANSITION    Code = 1007
+	NOT_A_JUDGE           Code = 1008
+	JUSTICE_TOO_LOW       Code = 1009
+	NOT_CREATOR           Code = 1010
+	NO_OFFER              Code = 1011
+	INVALID_DEAL          Code = 1012
+	ALREADY_PARTICIPATES  Code = 1013
+	BLAME_NOT_FOUND       Code = 1014
+	WEBHOOK_LIMIT_REACHED Code = 1015
+	INSUFFICIENT_FUNDS    Code = 1016
+)
+
+// Codes of credentials and tokens, authSvc
+const (
+	INVALID_CREDENTIALS Code = 2001
+	INVALID_TOKEN       Code = 2002
+	USERNAME_TAKEN      Code = 2003
+)
+
+// Codes of deal timers, watcherSvc
+const (
+	INVALID_TIMEOUT  Code = 3001
+	WATCHER_STOPPING Code = 3002
+)
+
+// definition tells how error with the code is shown to gRPC and HTTP clients and to users
+type definition struct {
+	name    string
+	grpc    codes.Code
+	message string
+}
+
+var catalog = map[Code]definition{
+	INTERNAL:            {"INTERNAL", codes.Internal, "Something went wrong, try again later"},
+	INVALID_ARGUMENT:    {"INVALID_ARGUMENT", codes.InvalidArgument, "Request is invalid"},
+	NOT_FOUND:           {"NOT_FOUND", codes.NotFound, "Requested object doesn't exist"},
+	ALREADY_EXISTS:      {"ALREADY_EXISTS", codes.AlreadyExists, "Object already exists"},
+	PERMISSION_DENIED:   {"PERMISSION_DENIED", codes.PermissionDenied, "You are not allowed to do that"},
+	UNAUTHENTICATED:     {"UNAUTHENTICATED", codes.Unauthenticated, "Log in to do that"},
+	FAILED_PRECONDITION: {"FAILED_PRECONDITION", codes.FailedPrecondition, "It can't be done now"},
+	UNAVAILABLE:         {"UNAVAILABLE", codes.Unavailable, "Service is unavailable, try again later"},
+	DEADLINE_EXCEEDED:   {"DEADLINE_EXCEEDED", codes.DeadlineExceeded, "Service took too long to answer, try again later"},
+	CANCELLED:           {"CANCELLED", codes.Canceled, "Request was cancelled"},
+	UNIMPLEMENTED:       {"UNIMPLEMENTED", codes.Unimplemented, "It isn't supported here"},
+	DATA_LOSS:           {"DATA_LOSS", codes.DataLoss, "Stored data is damaged"},
+
+	USER_NOT_FOUND:        {"USER_NOT_FOUND", codes.NotFound, "User doesn't exist"},
+	USER_ALREADY_EXISTS:   {"USER_ALREADY_EXISTS", codes.AlreadyExists, "User already exists"},
+	DEAL_NOT_FOUND:        {"DEAL_NOT_FOUND", codes.NotFound, "Deal doesn't exist"},
+	NOT_A_PARTICIPANT:     {"NOT_A_PARTICIPANT", codes.PermissionDenied, "You don't participate in the deal"},
+	ALREADY_ACCEPTED:      {"ALREADY_ACCEPTED", codes.FailedPrecondition, "Deal is already accepted"},
+	DEAL_CANCELLED:        {"DEAL_CANCELLED", codes.FailedPrecondition, "Deal is cancelled"},
+	INVALID_TRANSITION:    {"INVALID_TRANSITION", codes.FailedPrecondition, "Deal can't do that in its current status"},
+	NOT_A_JUDGE:           {"NOT_A_JUDGE", codes.PermissionDenied, "Only judges can do that"},
+	JUSTICE_TOO_LOW:       {"JUSTICE_TOO_LOW", codes.FailedPrecondition, "Your justice is too low for that"},
+	NOT_CREATOR:           {"NOT_CREATOR", codes.PermissionDenied, "Only creator of the deal can do that"},
+	NO_OFFER:              {"NO_OFFER", codes.FailedPrecondition, "You don't have offer of the deal"},
+	INVALID_DEAL:          {"INVALID_DEAL", codes.InvalidArgument, "Deal is invalid"},
+	ALREADY_PARTICIPATES:  {"ALREADY_PARTICIPATES", codes.FailedPrecondition, "You already participate in it"},
+	BLAME_NOT_FOUND:       {"BLAME_NOT_FOUND", codes.NotFound, "Blame doesn't exist"},
+	WEBHOOK_LIMIT_REACHED: {"WEBHOOK_LIMIT_REACHED", codes.ResourceExhausted, "You can't register more webhooks"},
+	INSUFFICIENT_FUNDS:    {"INSUFFICIENT_FUNDS", codes.FailedPrecondition, "You don't have enough credits for the stake"},
+
+	INVALID_CREDENTIALS: {"INVALID_CREDENTIALS", codes.Unauthenticated, "Username or password is wrong"},
+	INVALID_TOKEN:       {"INVALID_TOKEN", codes.Unauthenticated, "Session is invalid, log in again"},
+	USERNAME_TAKEN:      {"USERNAME_TAKEN", codes.AlreadyExists, "Username is already taken"},
+
+	INVALID_TIMEOUT:  {"INVALID_TIMEOUT", codes.InvalidArgument, "Deal timeout is invalid"},
+	WATCHER_STOPPING: {"WATCHER_STOPPING", codes.Unavailable, "Deal timers are being stopped, try again later"},
+}
+
+// byGRPCCode is generic code of errors that are not in the catalog
+var byGRPCCode = map[codes.Code]Code{
+	codes.InvalidArgument:    INVALID_ARGUMENT,
+	codes.OutOfRange:         INVALID_ARGUMENT,
+	codes.NotFound:           NOT_FOUND,
+	codes.AlreadyExists:      ALREADY_EXISTS,
+	codes.PermissionDenied:   PERMISSION_DENIED,
+	codes.Unauthenticated:    UNAUTHENTICATED,
+	codes.FailedPrecondition: FAILED_PRECONDITION,
+	codes.Aborted:            FAILED_PRECONDITION,
+	codes.Unavailable:        UNAVAILABLE,
+	codes.ResourceExhausted:  UNAVAILABLE,
+	codes.DeadlineExceeded:   DEADLINE_EXCEEDED,
+	codes.Canceled:           CANCELLED,
+	codes.Unimplemented:      UNIMPLEMENTED,
+	codes.DataLoss:           DATA_LOSS,
+}
+
+func (c Code) definition() definition {
+	if d, ok := catalog[c]; ok {
+		return d
+	}
+	return catalog[INTERNAL]
+}
+
+func (c Code) String() string {
+	return c.definition().name
+}
+
+// GRPCCode returns gRPC code errors with code {c} are returned with
+func (c Code) GRPCCode() codes.Code {
+	return c.definition().grpc
+}
+
+// Message returns message about the error that could be shown to users
+func (c Code) Message() string {
+	return c.definition().message
+}
+
+// HTTPStatus returns HTTP status gateway responds with for errors with code {c}
+func (c Code) HTTPStatus() int {
+	return runtime.HTTPStatusFromCode(c.GRPCCode())
+}
+
+// Error is error of the catalog. It's returned to gRPC clients as status with pb.Error in details
+// and to HTTP clients as RespHdr.err
+type Error struct {
+	Code Code
+	// Service that returned the error, it's set once error leaves the service
+	Service pb.ServiceId
+	// Details are for developers, they tell what exactly went wrong
+	Details string
+	// Violations are invalid fields of the request, set for INVALID_ARGUMENT
+	Violations []*pb.FieldViolation
+	// peer is set if error came from another service, its service is kept then
+	peer bool
+}
+
+// New creates error with {code}, details are formatted from {format} and {args}
+func New(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Details: fmt.Sprintf(format, args...)}
+}
+
+// Invalid creates INVALID_ARGUMENT error with {violations} of request fields
+func Invalid(violations []*pb.FieldViolation) *Error {
+	details := make([]string, 0, len(violations))
+	for _, v := range violations {
+		details = append(details, v.GetField()+": "+v.GetDescription())
+	}
+	return &Error{Code: INVALID_ARGUMENT, Details: strings.Join(details, "; "), Violations: violations}
+}
+
+func (e *Error) Error() string {
+	return e.Code.String() + ": " + e.Details
+}
+
+// GRPCStatus makes the error gRPC status, so status.Code and status.FromError work with it as well
+func (e *Error) GRPCStatus() *status.Status {
+	message := e.Details
+	if len(message) == 0 {
+		message = e.Code.Message()
+	}
+	st := status.New(e.Code.GRPCCode(), message)
+	details := []proto.Message{e.Proto()}
+	// BadRequest is understood by clients that don't know about pb.Error
+	if len(e.Violations) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, v := range e.Violations {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.GetField(),
+				Description: v.GetDescription(),
+			})
+		}
+		details = append(details, badRequest)
+	}
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		return withDetails
+	}
+	return st
+}
+
+// Proto returns the error as it's sent in RespHdr.err
+func (e *Error) Proto() *pb.Error {
+	return &pb.Error{
+		ServiceId:       e.Service,
+		Code:            int32(e.Code),
+		UserMessage:     e.Code.Message(),
+		DevDetails:      e.Details,
+		FieldViolations: e.Violations,
+	}
+}
+
+// FromError converts {err} returned by {service} to Error: errors of the catalog get the service,
+// errors of other services keep theirs and other errors get generic code of their gRPC code
+func FromError(service pb.ServiceId, err error) *Error {
+	if err == nil {
+		return nil
+	}
+	e := Parse(err)
+	if !e.peer {
+		e.Service = service
+	}
+	return e
+}
+
+// Parse converts {err} to Error without changing its service, e.g. to check code of error returned by another service
+func Parse(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*Error); ok {
+		res := *e
+		return &res
+	}
+	switch err {
+	case context.Canceled:
+		return &Error{Code: CANCELLED, Details: err.Error()}
+	case context.DeadlineExceeded:
+		return &Error{Code: DEADLINE_EXCEEDED, Details: err.Error()}
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return &Error{Code: INTERNAL, Details: err.Error()}
+	}
+	for _, detail := range st.Details() {
+		if pbErr, ok := detail.(*pb.Error); ok {
+			return &Error{
+				Code:       Code(pbErr.GetCode()),
+				Service:    pbErr.GetServiceId(),
+				Details:    pbErr.GetDevDetails(),
+				Violations: pbErr.GetFieldViolations(),
+				peer:       true,
+			}
+		}
+	}
+	code, ok := byGRPCCode[st.Code()]
+	if !ok {
+		code = INTERNAL
+	}
+	return &Error{Code: code, Details: st.Message()}
+}
+
+// Is checks whether {err} is error with {code}, err could come from another service
+func Is(err error, code Code) bool {
+	return err != nil && Parse(err).Code == code
+}
+
+// HTTPErrorHandler writes error of the gateway call as response with filled RespHdr.err
+// and HTTP status of the error code, it replaces default error handler of grpc-gateway
+func HTTPErrorHandler(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	e := Parse(err)
+	tid := ""
+	if md, ok := runtime.ServerMetadataFromContext(ctx); ok {
+		if values := md.HeaderMD.Get(logging.TID_HEADER); len(values) != 0 {
+			tid = values[0]
+		}
+	}
+	body, mErr := marshaler.Marshal(&pb.EmptyResp{
+		RespHdr: &pb.RespHdr{Tid: tid, ReqTid: tid, Err: e.Proto()},
+	})
+	if mErr != nil {
+		logging.Error(ctx, "Failed to marshal error response", "err", mErr)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", marshaler.ContentType())
+	if len(tid) > 0 {
+		w.Header().Set(logging.TID_HEADER, tid)
+	}
+	w.WriteHeader(e.Code.HTTPStatus())
+	w.Write(body)
+}
@@ -0,0 +1,150 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package grpcutils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/DenysNahurnyi/deal/common/clock"
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// States of circuit breaker
+const (
+	BREAKER_CLOSED    = "closed"
+	BREAKER_OPEN      = "open"
+	BREAKER_HALF_OPEN = "half-open"
+)
+
+// CircuitBreaker stops calls to service that keeps failing. After {maxFailures} failures in a row it opens
+// and rejects calls for {cooldown}, then lets one trial call through: success closes it, failure opens it again.
+// Only failures of the service itself count, like Unavailable or DeadlineExceeded, business errors are successes.
+// Calls that tell nothing about the service, like cancelled ones, don't change the state, after such trial
+// the next call is the trial
+type CircuitBreaker struct {
+	name        string
+	maxFailures int
+	cooldown    time.Duration
+	clock       clock.Clock
+
+	m        sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	trial    bool // Trial call of half-open breaker is running
+}
+
+// NewCircuitBreaker creates closed breaker for calls to service {name}, cooldown is measured by {clk}
+func NewCircuitBreaker(name string, maxFailures int, cooldown time.Duration, clk clock.Clock) *CircuitBreaker {
+	return &CircuitBreaker{
+		name:        name,
+		maxFailures: maxFailures,
+		cooldown:    cooldown,
+		clock:       clk,
+		state:       BREAKER_CLOSED,
+	}
+}
+
+// State returns current state of the breaker
+func (b *CircuitBreaker) State() string {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.state
+}
+
+// allow returns error if call can't go through
+func (b *CircuitBreaker) allow() error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	switch b.state {
+	case BREAKER_OPEN:
+		if wait := b.cooldown - b.clock.Now().Sub(b.openedAt); wait > 0 {
+			return status.Errorf(codes.Unavailable, "Circuit breaker of %s is open, retry in %v", b.name, wait.Round(time.Millisecond))
+		}
+		// Cooldown passed, this call is the trial
+		b.state = BREAKER_HALF_OPEN
+		b.trial = true
+		return nil
+	case BREAKER_HALF_OPEN:
+		if b.trial {
+			return status.Errorf(codes.Unavailable, "Circuit breaker of %s is half-open, waiting for the trial call", b.name)
+		}
+		b.trial = true
+		return nil
+	}
+	return nil
+}
+
+// Results of the call for the breaker
+const (
+	callSucceeded = iota
+	callFailed
+	callInconclusive
+)
+
+// record updates the breaker with result of the call
+func (b *CircuitBreaker) record(err error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.trial = false
+	switch callResult(err) {
+	case callSucceeded:
+		b.state = BREAKER_CLOSED
+		b.failures = 0
+	case callFailed:
+		b.failures++
+		if b.state == BREAKER_HALF_OPEN || b.failures >= b.maxFailures {
+			if b.state != BREAKER_OPEN {
+				logging.Error(context.Background(), "Circuit breaker of "+b.name+" opened after error", "err", err)
+			}
+			b.state = BREAKER_OPEN
+			b.openedAt = b.clock.Now()
+		}
+	}
+}
+
+// callResult tells whether call with {err} shows that service works, fails or tells nothing about it
+func callResult(err error) int {
+	switch status.Code(err) {
+	case codes.OK, codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied,
+		codes.FailedPrecondition, codes.Aborted, codes.OutOfRange, codes.Unimplemented, codes.Unauthenticated:
+		return callSucceeded
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return callFailed
+	}
+	// Cancelled by the caller or failed in an unexpected way, e.g. Internal or Unknown
+	return callInconclusive
+}
+
+// UnaryClientInterceptor guards unary calls with the breaker
+func (b *CircuitBreaker) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if err := b.allow(); err != nil {
+			return err
+		}
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		b.record(err)
+		return err
+	}
+}
+
+// DeadlineInterceptor sets {timeout} deadline to calls that don't have deadline yet
+func DeadlineInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
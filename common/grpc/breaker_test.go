@@ -0,0 +1,113 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package grpcutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DenysNahurnyi/deal/common/clock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testCooldown = time.Minute
+
+func newTestBreaker() (*CircuitBreaker, *clock.Fake) {
+	clk := clock.NewFake(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC))
+	return NewCircuitBreaker("test", 3, testCooldown, clk), clk
+}
+
+// call makes call through the breaker that ends with {code}, returns false if the breaker rejected it
+func call(b *CircuitBreaker, code codes.Code) bool {
+	if err := b.allow(); err != nil {
+		return false
+	}
+	b.record(status.Error(code, "call"))
+	return true
+}
+
+func expectState(t *testing.T, b *CircuitBreaker, want string) {
+	t.Helper()
+	if got := b.State(); got != want {
+		t.Fatalf("Breaker is %s, want %s", got, want)
+	}
+}
+
+func TestBreakerOpensAndCloses(t *testing.T) {
+	b, clk := newTestBreaker()
+	// Business errors and successes don't count
+	for _, code := range []codes.Code{codes.Unavailable, codes.Unavailable, codes.NotFound, codes.Unavailable, codes.OK} {
+		call(b, code)
+	}
+	expectState(t, b, BREAKER_CLOSED)
+
+	for i := 0; i < 3; i++ {
+		call(b, codes.DeadlineExceeded)
+	}
+	expectState(t, b, BREAKER_OPEN)
+	if call(b, codes.OK) {
+		t.Fatalf("Open breaker let the call through")
+	}
+
+	clk.Advance(testCooldown)
+	if err := b.allow(); err != nil {
+		t.Fatalf("Breaker rejected the trial after cooldown: %v", err)
+	}
+	expectState(t, b, BREAKER_HALF_OPEN)
+	if call(b, codes.OK) {
+		t.Fatalf("Half-open breaker let the call through during the trial")
+	}
+	b.record(nil)
+	expectState(t, b, BREAKER_CLOSED)
+	if !call(b, codes.OK) {
+		t.Fatalf("Closed breaker rejected the call")
+	}
+}
+
+func TestBreakerTrialFailureOpens(t *testing.T) {
+	b, clk := newTestBreaker()
+	for i := 0; i < 3; i++ {
+		call(b, codes.Unavailable)
+	}
+	clk.Advance(testCooldown)
+	if !call(b, codes.Unavailable) {
+		t.Fatalf("Breaker rejected the trial after cooldown")
+	}
+	expectState(t, b, BREAKER_OPEN)
+	clk.Advance(testCooldown - time.Second)
+	if call(b, codes.OK) {
+		t.Fatalf("Breaker let the call through before cooldown")
+	}
+}
+
+func TestBreakerInconclusiveTrial(t *testing.T) {
+	for _, code := range []codes.Code{codes.Canceled, codes.Internal, codes.Unknown} {
+		b, clk := newTestBreaker()
+		for i := 0; i < 3; i++ {
+			call(b, codes.Unavailable)
+		}
+		clk.Advance(testCooldown)
+		if !call(b, code) {
+			t.Fatalf("Breaker rejected the trial after cooldown")
+		}
+		// Trial told nothing about the service, the next call is the trial
+		expectState(t, b, BREAKER_HALF_OPEN)
+		if !call(b, codes.OK) {
+			t.Fatalf("Breaker rejected the next trial after %v", code)
+		}
+		expectState(t, b, BREAKER_CLOSED)
+	}
+}
+
+func TestBreakerIgnoresCancelledCalls(t *testing.T) {
+	b, _ := newTestBreaker()
+	for _, code := range []codes.Code{codes.Unavailable, codes.Unavailable, codes.Canceled, codes.Unavailable} {
+		call(b, code)
+	}
+	expectState(t, b, BREAKER_OPEN)
+}
@@ -7,16 +7,17 @@
 package grpcutils
 
 import (
+	"context"
 	"fmt"
 	"math"
-	"net"
-	"os"
-	"strconv"
 	"strings"
 	"time"
 
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
 	"github.com/go-kit/kit/log"
+	"github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/grpc-ecosystem/go-grpc-middleware/retry"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -37,14 +38,22 @@ type DialConfig struct {
 	MaxRetries uint
 	//GRPC dial options
 	DialOptions []grpc.DialOption
+	//Dial without checking that service has instances, connection waits for them. Default is false
+	Lazy bool
+	//Calls wait until connection is ready instead of failing right away. Default is false
+	WaitForReady bool
+	//Deadline for calls that have no deadline. Default is 0, which means disabled
+	CallTimeout time.Duration
+	//Circuit breaker around the calls. Default is nil, which means disabled
+	Breaker *CircuitBreaker
 }
 
-//This func does grpc dials with the retry dialoption (if needed) + grpc dial options
+//This func does grpc dials with the retry, deadline and circuit breaker interceptors (if needed) + grpc dial options
 func GrpcDial(target string, config DialConfig) (conn *grpc.ClientConn, err error) {
 	var (
-		callOption          []grpc_retry.CallOption
-		useDefault          bool            = true
-		grpcRetryDialOption grpc.DialOption = nil
+		callOption       []grpc_retry.CallOption
+		useDefault       bool                        = true
+		retryInterceptor grpc.UnaryClientInterceptor = nil
 	)
 	if config.UseRetry == true {
 		if config.WaitBetween > 0 {
@@ -68,60 +77,84 @@ func GrpcDial(target string, config DialConfig) (conn *grpc.ClientConn, err erro
 			callOption = append(callOption, grpc_retry.WithMax(config.MaxRetries))
 		}
 		if useDefault == true {
-			grpcRetryDialOption = grpc.WithUnaryInterceptor(grpc_retry.UnaryClientInterceptor())
+			retryInterceptor = grpc_retry.UnaryClientInterceptor()
 		} else {
-			grpcRetryDialOption = grpc.WithUnaryInterceptor(grpc_retry.UnaryClientInterceptor(callOption...))
+			retryInterceptor = grpc_retry.UnaryClientInterceptor(callOption...)
 		}
 	}
-	if grpcRetryDialOption != nil {
-		if config.DialOptions != nil {
-			config.DialOptions = append(config.DialOptions, grpcRetryDialOption)
-		} else {
-			config.DialOptions = []grpc.DialOption{grpcRetryDialOption}
-		}
-		conn, err = grpc.Dial(target, config.DialOptions...)
-	} else {
-		if config.DialOptions != nil {
-			conn, err = grpc.Dial(target, config.DialOptions...)
-		} else {
-			conn, err = grpc.Dial(target)
-		}
+	//Breaker sees the result after all retries, deadline limits all retries together.
+	//Metrics go before the breaker, so rejected calls are counted too, span covers all of them
+	interceptors := []grpc.UnaryClientInterceptor{otelgrpc.UnaryClientInterceptor(), TidClientInterceptor(), MetricsClientInterceptor()}
+	if config.Breaker != nil {
+		interceptors = append(interceptors, config.Breaker.UnaryClientInterceptor())
+	}
+	if config.CallTimeout > 0 {
+		interceptors = append(interceptors, DeadlineInterceptor(config.CallTimeout))
+	}
+	if retryInterceptor != nil {
+		interceptors = append(interceptors, retryInterceptor)
+	}
+	dialOptions := append([]grpc.DialOption{}, config.DialOptions...)
+	dialOptions = append(dialOptions, grpc.WithUnaryInterceptor(grpc_middleware.ChainUnaryClient(interceptors...)))
+	if config.WaitForReady {
+		dialOptions = append(dialOptions, grpc.WithDefaultCallOptions(grpc.WaitForReady(true)))
 	}
+	conn, err = grpc.Dial(target, dialOptions...)
 	return
 }
 
-//This is a wrapper on top of GrpcDial, which does dns lookup as well
+//This is a wrapper on top of GrpcDial, which finds service instances with Discovery (see SetDiscovery).
+//Requests are balanced across all instances and instance list is refreshed while connection is open
 func CreateGrpcConn(serviceName, portName string, config DialConfig, logger log.Logger) (conn *grpc.ClientConn, err error) {
-	//Discover the service endpoint
-	ns := os.Getenv("NAMESPACE")
-	var name string
-	if ns != "" {
-		name = serviceName + "-service." + ns + ".svc.cluster.local"
-	} else {
-		name = serviceName + "-service.default.svc.cluster.local"
-	}
-	_, rec, err := net.LookupSRV(portName, "tcp", name)
-	if err != nil {
-		// msg := "Failed to find service " + serviceName + " port " + portName
-		return nil, err
+	//Fail fast with clear error if service can't be found at all, lazy connection waits for instances instead
+	if !config.Lazy {
+		ctx, cancel := context.WithTimeout(context.Background(), discoveryLookupTimeout)
+		defer cancel()
+		if _, err := GetDiscovery().Lookup(ctx, serviceName, portName); err != nil {
+			return nil, err
+		}
 	}
 
-	grpcAddr := rec[0].Target + ":" + strconv.Itoa(int(rec[0].Port))
-	conn, err = GrpcDial(grpcAddr, config)
+	conn, err = GrpcDial(DiscoveryTarget(serviceName, portName), config)
 	if err != nil {
 		return nil, err
 	}
 	return conn, err
 }
 
-//Creates a newGRPC Server with max recv and send buffer size
-func NewServer() *grpc.Server {
-	return grpc.NewServer(grpc.MaxRecvMsgSize(math.MaxInt32), grpc.MaxSendMsgSize(math.MaxInt32))
+//Creates a newGRPC Server of {service} with max recv and send buffer size, every RPC is traced, logged with its transaction id and measured.
+//Errors are converted to errors of catalog before that, so logs and metrics see the final code, panics of handlers are INTERNAL errors.
+//Requests are checked with validation rules of their type right before the handler. Streams go through the same chain
+func NewServer(service pb.ServiceId) *grpc.Server {
+	return grpc.NewServer(
+		grpc.MaxRecvMsgSize(math.MaxInt32),
+		grpc.MaxSendMsgSize(math.MaxInt32),
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
+			otelgrpc.UnaryServerInterceptor(),
+			LoggingServerInterceptor(),
+			MetricsServerInterceptor(),
+			ErrorServerInterceptor(service),
+			RecoveryServerInterceptor(),
+			ValidationServerInterceptor(),
+		)),
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
+			otelgrpc.StreamServerInterceptor(),
+			LoggingStreamServerInterceptor(),
+			MetricsStreamServerInterceptor(),
+			ErrorStreamServerInterceptor(service),
+			RecoveryStreamServerInterceptor(),
+			ValidationStreamServerInterceptor(),
+		)),
+	)
 }
 
-//Returns grpc default options for grpc gateway
+//Returns grpc default options for grpc gateway, trace context of HTTP request is passed to the gRPC server
 func OptsGrpcGw() []grpc.DialOption {
-	return []grpc.DialOption{grpc.WithInsecure(), grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(math.MaxInt32))}
+	return []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(math.MaxInt32)),
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+	}
 }
 
 func forLogs(strRaw interface{}) string {
@@ -0,0 +1,250 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package grpcutils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Discovery finds addresses (host:port) of service instances
+type Discovery interface {
+	// Lookup returns addresses of {service} instances, {portName} is the name of the port in kubernetes service.
+	// It returns NoEndpointsError if the service has no instances
+	Lookup(ctx context.Context, service, portName string) ([]string, error)
+	// Name tells where addresses come from, it's used in errors
+	Name() string
+}
+
+// NoEndpointsError is returned when discovery doesn't know any instance of the service
+type NoEndpointsError struct {
+	Service string
+	Source  string
+	Err     error
+}
+
+func (e *NoEndpointsError) Error() string {
+	msg := "No endpoints found for service " + e.Service + " in " + e.Source
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// IsNoEndpoints tells whether {err} means that service has no instances
+func IsNoEndpoints(err error) bool {
+	_, ok := err.(*NoEndpointsError)
+	return ok
+}
+
+// NewDiscovery creates discovery that services use by default: env overrides go first, then static
+// {endpoints}, then {file} if it's set and kubernetes SRV records in {namespace} at last
+func NewDiscovery(endpoints map[string][]string, file, namespace string) Discovery {
+	backends := []Discovery{NewEnvDiscovery()}
+	if len(endpoints) != 0 {
+		backends = append(backends, NewStaticDiscovery(endpoints))
+	}
+	if len(file) > 0 {
+		backends = append(backends, NewFileDiscovery(file))
+	}
+	backends = append(backends, NewSRVDiscovery(namespace))
+	return NewChainDiscovery(backends...)
+}
+
+// staticDiscovery knows addresses beforehand
+type staticDiscovery struct {
+	endpoints map[string][]string
+}
+
+// NewStaticDiscovery creates discovery with fixed addresses of services, {endpoints} are addresses by service name
+func NewStaticDiscovery(endpoints map[string][]string) Discovery {
+	copied := map[string][]string{}
+	for service, addrs := range endpoints {
+		copied[service] = append([]string{}, addrs...)
+	}
+	return &staticDiscovery{endpoints: copied}
+}
+
+func (d *staticDiscovery) Name() string {
+	return "static config"
+}
+
+func (d *staticDiscovery) Lookup(ctx context.Context, service, portName string) ([]string, error) {
+	addrs := d.endpoints[service]
+	if len(addrs) == 0 {
+		return nil, &NoEndpointsError{Service: service, Source: d.Name()}
+	}
+	return normalizeAddrs(addrs), nil
+}
+
+// envDiscovery takes addresses from DEAL_<SERVICE>_ADDR env variables
+type envDiscovery struct{}
+
+// NewEnvDiscovery creates discovery that reads comma separated addresses of service from
+// DEAL_<SERVICE>_ADDR env variable, e.g. DEAL_DATASVC_ADDR=localhost:8010
+func NewEnvDiscovery() Discovery {
+	return envDiscovery{}
+}
+
+// EnvAddrVariable returns name of env variable with {service} addresses
+func EnvAddrVariable(service string) string {
+	return "DEAL_" + strings.ToUpper(strings.Replace(service, "-", "_", -1)) + "_ADDR"
+}
+
+func (envDiscovery) Name() string {
+	return "env"
+}
+
+func (d envDiscovery) Lookup(ctx context.Context, service, portName string) ([]string, error) {
+	addrs := normalizeAddrs(strings.Split(os.Getenv(EnvAddrVariable(service)), ","))
+	if len(addrs) == 0 {
+		return nil, &NoEndpointsError{Service: service, Source: d.Name() + " " + EnvAddrVariable(service)}
+	}
+	return addrs, nil
+}
+
+// srvDiscovery looks up kubernetes SRV records of the service
+type srvDiscovery struct {
+	namespace string
+}
+
+// NewSRVDiscovery creates discovery that uses SRV records of <service>-service.<namespace>.svc.cluster.local,
+// namespace is "default" if it's empty
+func NewSRVDiscovery(namespace string) Discovery {
+	if len(namespace) == 0 {
+		namespace = "default"
+	}
+	return &srvDiscovery{namespace: namespace}
+}
+
+func (d *srvDiscovery) Name() string {
+	return "kubernetes SRV records"
+}
+
+func (d *srvDiscovery) Lookup(ctx context.Context, service, portName string) ([]string, error) {
+	name := service + "-service." + d.namespace + ".svc.cluster.local"
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, portName, "tcp", name)
+	if err != nil || len(records) == 0 {
+		return nil, &NoEndpointsError{Service: service, Source: d.Name() + " of " + name, Err: err}
+	}
+	addrs := []string{}
+	for _, rec := range records {
+		addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(rec.Target, "."), strconv.Itoa(int(rec.Port))))
+	}
+	return normalizeAddrs(addrs), nil
+}
+
+// fileDiscovery reads addresses from JSON file and re-reads it when the file is changed
+type fileDiscovery struct {
+	path      string
+	m         sync.Mutex
+	modified  time.Time
+	endpoints map[string][]string
+}
+
+// NewFileDiscovery creates discovery that reads addresses from JSON file {path},
+// the file has addresses by service name: {"datasvc": ["10.0.0.1:8010", "10.0.0.2:8010"]}
+func NewFileDiscovery(path string) Discovery {
+	return &fileDiscovery{path: path}
+}
+
+func (d *fileDiscovery) Name() string {
+	return "file " + d.path
+}
+
+func (d *fileDiscovery) Lookup(ctx context.Context, service, portName string) ([]string, error) {
+	if err := d.reload(); err != nil {
+		return nil, &NoEndpointsError{Service: service, Source: d.Name(), Err: err}
+	}
+	d.m.Lock()
+	addrs := d.endpoints[service]
+	d.m.Unlock()
+	if len(addrs) == 0 {
+		return nil, &NoEndpointsError{Service: service, Source: d.Name()}
+	}
+	return normalizeAddrs(addrs), nil
+}
+
+// reload reads the file again if it's changed since the last read
+func (d *fileDiscovery) reload() error {
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return err
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.endpoints != nil && info.ModTime().Equal(d.modified) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(d.path)
+	if err != nil {
+		return err
+	}
+	endpoints := map[string][]string{}
+	if err := json.Unmarshal(data, &endpoints); err != nil {
+		return fmt.Errorf("Failed to parse endpoints file: %v", err)
+	}
+	d.endpoints = endpoints
+	d.modified = info.ModTime()
+	return nil
+}
+
+// chainDiscovery asks backends in order and returns addresses of the first one that knows the service
+type chainDiscovery struct {
+	backends []Discovery
+}
+
+// NewChainDiscovery creates discovery that tries {backends} in order
+func NewChainDiscovery(backends ...Discovery) Discovery {
+	return &chainDiscovery{backends: backends}
+}
+
+func (d *chainDiscovery) Name() string {
+	names := []string{}
+	for _, b := range d.backends {
+		names = append(names, b.Name())
+	}
+	return strings.Join(names, ", ")
+}
+
+func (d *chainDiscovery) Lookup(ctx context.Context, service, portName string) ([]string, error) {
+	for _, b := range d.backends {
+		addrs, err := b.Lookup(ctx, service, portName)
+		if err == nil {
+			return addrs, nil
+		}
+		if !IsNoEndpoints(err) {
+			return nil, err
+		}
+	}
+	return nil, &NoEndpointsError{Service: service, Source: d.Name()}
+}
+
+// normalizeAddrs drops empty and duplicate addresses and sorts them, so the same set looks the same
+func normalizeAddrs(addrs []string) []string {
+	seen := map[string]bool{}
+	res := []string{}
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		if len(addr) == 0 || seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		res = append(res, addr)
+	}
+	sort.Strings(res)
+	return res
+}
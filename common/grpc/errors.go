@@ -0,0 +1,37 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package grpcutils
+
+import (
+	"context"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"google.golang.org/grpc"
+)
+
+// ErrorServerInterceptor converts every error of {service} to the error of catalog, so clients always get
+// status with pb.Error in details. Errors of other services that are passed through keep their service and code
+func ErrorServerInterceptor(service pb.ServiceId) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, dealerrors.FromError(service, err)
+		}
+		return resp, nil
+	}
+}
+
+// ErrorStreamServerInterceptor is ErrorServerInterceptor of streams, error stream ends with is converted
+func ErrorStreamServerInterceptor(service pb.ServiceId) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := handler(srv, stream); err != nil {
+			return dealerrors.FromError(service, err)
+		}
+		return nil
+	}
+}
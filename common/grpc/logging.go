@@ -0,0 +1,116 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package grpcutils
+
+import (
+	"context"
+	"time"
+
+	"github.com/DenysNahurnyi/deal/common/logging"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// LoggingServerInterceptor starts transaction of the RPC and logs it once it's done with its method, user, code and latency.
+// Transaction id is taken from metadata, then from request header, new one is generated if there is none.
+// Trace id is logged too if the RPC is traced
+func LoggingServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		started := time.Now()
+		ctx, tid := startTransaction(ctx, info.FullMethod, req)
+		// Caller gets tid back even if it didn't send one
+		grpc.SetHeader(ctx, metadata.Pairs(logging.TID_HEADER, tid))
+
+		resp, err := handler(ctx, req)
+		logRPC(ctx, "RPC", started, err)
+		return resp, err
+	}
+}
+
+// LoggingStreamServerInterceptor is LoggingServerInterceptor of streams, transaction lasts until the stream ends.
+// Stream has no request header when it starts, so tid is taken from metadata only
+func LoggingStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		started := time.Now()
+		ctx, tid := startTransaction(stream.Context(), info.FullMethod, nil)
+		stream.SetHeader(metadata.Pairs(logging.TID_HEADER, tid))
+
+		err := handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+		logRPC(ctx, "Stream", started, err)
+		return err
+	}
+}
+
+// startTransaction adds tid, method and trace id of the RPC to the logging context
+func startTransaction(ctx context.Context, method string, req interface{}) (context.Context, string) {
+	tid := incomingTid(ctx, req)
+	ctx = logging.WithTid(ctx, tid)
+	ctx = logging.WithFields(ctx, "method", method)
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		ctx = logging.WithFields(ctx, "trace_id", sc.TraceID().String())
+	}
+	return ctx, tid
+}
+
+func logRPC(ctx context.Context, kind string, started time.Time, err error) {
+	code := status.Code(err)
+	keyvals := []interface{}{"code", code.String(), "latency", time.Since(started)}
+	switch code {
+	case codes.OK:
+		logging.Info(ctx, kind+" finished", keyvals...)
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		logging.Error(ctx, kind+" failed", append(keyvals, "err", err)...)
+	default:
+		logging.Warn(ctx, kind+" failed", append(keyvals, "err", err)...)
+	}
+}
+
+// contextStream is server stream with context of the interceptor, so handler and next interceptors see it
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// TidClientInterceptor passes transaction id of the context to the called service
+func TidClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if tid := logging.Tid(ctx); tid != "unknown" {
+			ctx = metadata.AppendToOutgoingContext(ctx, logging.TID_HEADER, tid)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// incomingTid returns transaction id sent by caller, header of request is filled with it if it's empty
+func incomingTid(ctx context.Context, req interface{}) string {
+	var hdr *pb.ReqHdr
+	if r, ok := req.(interface{ GetReqHdr() *pb.ReqHdr }); ok {
+		hdr = r.GetReqHdr()
+	}
+	tid := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(logging.TID_HEADER)) != 0 {
+		tid = md.Get(logging.TID_HEADER)[0]
+	}
+	if len(tid) == 0 && hdr != nil {
+		tid = hdr.GetTid()
+	}
+	if len(tid) == 0 {
+		tid = logging.GenerateTid()
+	}
+	if hdr != nil && len(hdr.Tid) == 0 {
+		hdr.Tid = tid
+	}
+	return tid
+}
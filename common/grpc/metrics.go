@@ -0,0 +1,82 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package grpcutils
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	rpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "deal",
+		Subsystem: "grpc",
+		Name:      "requests_total",
+		Help:      "RPCs handled (side=server) or sent (side=client) by method",
+	}, []string{"side", "method"})
+	rpcErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "deal",
+		Subsystem: "grpc",
+		Name:      "errors_total",
+		Help:      "RPCs that failed, by method and gRPC code",
+	}, []string{"side", "method", "code"})
+	rpcLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "deal",
+		Subsystem: "grpc",
+		Name:      "latency_seconds",
+		Help:      "Latency of RPCs by method",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"side", "method"})
+)
+
+func init() {
+	prometheus.MustRegister(rpcRequests, rpcErrors, rpcLatency)
+}
+
+// MetricsServerInterceptor counts RPCs of the server, their errors by code and latency
+func MetricsServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		started := time.Now()
+		resp, err := handler(ctx, req)
+		observeRPC("server", info.FullMethod, started, err)
+		return resp, err
+	}
+}
+
+// MetricsStreamServerInterceptor counts streams of the server like MetricsServerInterceptor, latency is the stream lifetime
+func MetricsStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		started := time.Now()
+		err := handler(srv, stream)
+		observeRPC("server", info.FullMethod, started, err)
+		return err
+	}
+}
+
+// MetricsClientInterceptor counts calls to other services, their errors by code and latency.
+// Calls rejected by circuit breaker are counted as Unavailable
+func MetricsClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		started := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		observeRPC("client", method, started, err)
+		return err
+	}
+}
+
+func observeRPC(side, method string, started time.Time, err error) {
+	rpcRequests.WithLabelValues(side, method).Inc()
+	rpcLatency.WithLabelValues(side, method).Observe(time.Since(started).Seconds())
+	if code := status.Code(err); code != codes.OK {
+		rpcErrors.WithLabelValues(side, method, code.String()).Inc()
+	}
+}
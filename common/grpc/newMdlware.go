@@ -2,13 +2,17 @@ package grpcutils
 
 import (
 	"context"
+	"crypto"
 	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"math/big"
 	"strings"
 
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	"github.com/DenysNahurnyi/deal/common/logging"
 	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/go-kit/kit/transport/grpc"
@@ -33,6 +37,14 @@ type ErrorContext struct {
 // In case of success returns another context fulfilled by tenant specific info, for now it is just tenant_id
 // In case of error returns ErrorContext with one property error that we check through type assertation in each function
 func VerifyToken(uKey *rsa.PublicKey) grpc.ServerRequestFunc {
+	return VerifyTokenWith(func() (*rsa.PublicKey, error) {
+		return uKey, nil
+	})
+}
+
+// VerifyTokenWith is VerifyToken that gets the key from {getKey} on each request, so the key could be loaded lazily.
+// If the key is not available, request stays unauthenticated
+func VerifyTokenWith(getKey func() (*rsa.PublicKey, error)) grpc.ServerRequestFunc {
 	return func(ctx context.Context, md metadata.MD) context.Context {
 
 		// Pull input info from context
@@ -43,10 +55,16 @@ func VerifyToken(uKey *rsa.PublicKey) grpc.ServerRequestFunc {
 		}
 		request := tmpMeta.Request
 		tokenString := request.Headers[GRPCAUTHORIZATIONHEADER]
+		uKey, err := getKey()
+		if err != nil {
+			logging.Error(ctx, "Failed to get key to verify token", "err", err)
+			return ctx
+		}
 		userID, err := checkToken(uKey, tokenString)
 		if err != nil {
 			return ctx
 		}
+		ctx = logging.WithFields(ctx, "user", userID)
 
 		var tokenRes = &pb.Token{
 			Content: map[string]string{
@@ -72,14 +90,14 @@ func checkToken(uKey interface{}, tokenStr string) (string, error) {
 		return uKey, nil
 	})
 	if err != nil {
-		fmt.Println("Error parsing token:", err)
+		logging.Error(context.Background(), "Error parsing token", "err", err)
 		return "", err
 	}
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 		if userID, ok := claims["userID"]; ok {
 			return userID.(string), err
 		}
-		fmt.Println("Token is invalid")
+		logging.Warn(context.Background(), "Token is invalid")
 	}
 	return "", errors.New("Token is inappropriate")
 }
@@ -183,7 +201,7 @@ func ParseHeader(headerName string) grpc.ServerRequestFunc {
 func GetUserIDFromJWT(ctx context.Context) (string, error) {
 	tenantID, err := getTokenKeyFromContext(ctx, USER_ID)
 	if err != nil {
-		return "", errors.New("Failed to get tenant from token")
+		return "", dealerrors.New(dealerrors.INVALID_TOKEN, "Failed to get user id from token: %v", err)
 	}
 	return tenantID, nil
 }
@@ -214,3 +232,31 @@ func CreatePubKey(nBytesBase64 string, e int) (*rsa.PublicKey, error) {
 		E: e,
 	}, nil
 }
+
+// signaturePrefix separates signed documents from JWTs made with the same key,
+// so signature of a document can never be used as a token signature
+const signaturePrefix = "deal-signature-v1\n"
+
+// SignatureDigest returns digest that authSvc signs for {payload}
+func SignatureDigest(payload []byte) []byte {
+	sum := sha256.Sum256(append([]byte(signaturePrefix), payload...))
+	return sum[:]
+}
+
+// VerifySignature checks that {signature} of {payload} was made by authSvc key
+func VerifySignature(uKey *rsa.PublicKey, payload, signature []byte) error {
+	return rsa.VerifyPKCS1v15(uKey, crypto.SHA256, SignatureDigest(payload), signature)
+}
+
+// StreamContext applies {before} functions to the context of the incoming stream.
+// go-kit transport works only with unary calls, so streaming handlers have to run them by hand
+func StreamContext(ctx context.Context, before ...grpc.ServerRequestFunc) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		md = metadata.MD{}
+	}
+	for _, f := range before {
+		ctx = f(ctx, md)
+	}
+	return ctx
+}
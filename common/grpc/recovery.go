@@ -0,0 +1,45 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package grpcutils
+
+import (
+	"context"
+	"runtime/debug"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"google.golang.org/grpc"
+)
+
+// RecoveryServerInterceptor turns panic of the handler into INTERNAL error, so one broken request doesn't crash the service
+func RecoveryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = recovered(ctx, info.FullMethod, p)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// RecoveryStreamServerInterceptor turns panic of the stream handler into INTERNAL error
+func RecoveryStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = recovered(stream.Context(), info.FullMethod, p)
+			}
+		}()
+		return handler(srv, stream)
+	}
+}
+
+func recovered(ctx context.Context, method string, p interface{}) error {
+	logging.Error(ctx, "Handler panicked", "method", method, "panic", p, "stack", string(debug.Stack()))
+	return dealerrors.New(dealerrors.INTERNAL, "Handler of %s panicked", method)
+}
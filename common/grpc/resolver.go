@@ -0,0 +1,151 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package grpcutils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	// Enables client side health checking that service config below asks for
+	"github.com/DenysNahurnyi/deal/common/logging"
+	_ "google.golang.org/grpc/health"
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	// DISCOVERY_SCHEME is gRPC target scheme resolved by Discovery: deal:///<service>/<port name>
+	DISCOVERY_SCHEME = "deal"
+
+	discoveryRefreshInterval = 10 * time.Second
+	discoveryLookupTimeout   = 5 * time.Second
+	// Requests are balanced across all instances, instances that fail health check don't get requests
+	discoveryServiceConfig = `{"loadBalancingPolicy": "round_robin", "healthCheckConfig": {"serviceName": ""}}`
+)
+
+var builder = &discoveryBuilder{discovery: NewDiscovery(nil, "", "")}
+
+func init() {
+	resolver.Register(builder)
+}
+
+// SetDiscovery sets discovery used to resolve deal:/// targets, it has to be called before connections are created
+func SetDiscovery(d Discovery) {
+	builder.m.Lock()
+	defer builder.m.Unlock()
+	builder.discovery = d
+}
+
+// GetDiscovery returns discovery used to resolve deal:/// targets
+func GetDiscovery() Discovery {
+	builder.m.Lock()
+	defer builder.m.Unlock()
+	return builder.discovery
+}
+
+// DiscoveryTarget returns gRPC target of {service} port {portName}
+func DiscoveryTarget(service, portName string) string {
+	return DISCOVERY_SCHEME + ":///" + service + "/" + portName
+}
+
+type discoveryBuilder struct {
+	m         sync.Mutex
+	discovery Discovery
+}
+
+func (b *discoveryBuilder) Scheme() string {
+	return DISCOVERY_SCHEME
+}
+
+func (b *discoveryBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOption) (resolver.Resolver, error) {
+	parts := strings.SplitN(target.Endpoint, "/", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return nil, fmt.Errorf("Invalid target %q, expected %s", target.Endpoint, DiscoveryTarget("<service>", "<port name>"))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &discoveryResolver{
+		discovery:  GetDiscovery(),
+		service:    parts[0],
+		portName:   parts[1],
+		cc:         cc,
+		ctx:        ctx,
+		cancel:     cancel,
+		resolveNow: make(chan struct{}, 1),
+	}
+	cc.NewServiceConfig(discoveryServiceConfig)
+	go r.watch()
+	return r, nil
+}
+
+// discoveryResolver periodically asks discovery for service addresses and updates connection once they change
+type discoveryResolver struct {
+	discovery  Discovery
+	service    string
+	portName   string
+	cc         resolver.ClientConn
+	ctx        context.Context
+	cancel     context.CancelFunc
+	resolveNow chan struct{}
+	addrs      []string
+}
+
+func (r *discoveryResolver) ResolveNow(resolver.ResolveNowOption) {
+	select {
+	case r.resolveNow <- struct{}{}:
+	default:
+	}
+}
+
+func (r *discoveryResolver) Close() {
+	r.cancel()
+}
+
+func (r *discoveryResolver) watch() {
+	ticker := time.NewTicker(discoveryRefreshInterval)
+	defer ticker.Stop()
+	for {
+		r.resolve()
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.resolveNow:
+		}
+	}
+}
+
+func (r *discoveryResolver) resolve() {
+	addrs, err := r.discovery.Lookup(r.ctx, r.service, r.portName)
+	if err != nil {
+		// Known instances are kept, lookup failure may be temporary
+		logging.Error(r.ctx, "Failed to resolve "+r.service, "err", err)
+		return
+	}
+	if equalAddrs(addrs, r.addrs) {
+		return
+	}
+	r.addrs = addrs
+	state := []resolver.Address{}
+	for _, addr := range addrs {
+		state = append(state, resolver.Address{Addr: addr})
+	}
+	r.cc.NewAddress(state)
+}
+
+func equalAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package grpcutils
+
+import (
+	"context"
+
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/DenysNahurnyi/deal/common/validation"
+	"google.golang.org/grpc"
+)
+
+// ValidationServerInterceptor rejects requests that break validation rules of their type before they reach the handler
+func ValidationServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := validation.Validate(req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// ValidationStreamServerInterceptor checks every message client sends to the stream with validation rules of its type
+func ValidationStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &validatedStream{ServerStream: stream, method: info.FullMethod})
+	}
+}
+
+type validatedStream struct {
+	grpc.ServerStream
+	method string
+}
+
+func (s *validatedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if err := validation.Validate(m); err != nil {
+		logging.Warn(s.Context(), "Invalid request", "method", s.method, "err", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/readpref"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	// HTTP paths of liveness and readiness probes on the gateway port
+	LIVENESS_PATH  = "/healthz"
+	READINESS_PATH = "/readyz"
+
+	// CHECK_INTERVAL is how often gRPC health status is updated
+	CHECK_INTERVAL = 10 * time.Second
+	checkTimeout   = 3 * time.Second
+
+	statusOK = "ok"
+)
+
+// Check is a dependency the service needs to serve requests
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+	// Optional checks are reported, but don't make the service not ready,
+	// e.g. peer services, as they have their own probes
+	Optional bool
+}
+
+// Report is result of the checks, it's the body of /readyz response
+type Report struct {
+	Service string            `json:"service"`
+	Ready   bool              `json:"ready"`
+	Checks  map[string]string `json:"checks"`
+}
+
+// Checker runs checks of the service and reports them with grpc.health.v1 service and HTTP probes
+type Checker struct {
+	service string
+	checks  []Check
+	server  *grpchealth.Server
+
+	m    sync.Mutex
+	last *Report
+}
+
+// New creates checker of {service}, service is not serving until the checks pass for the first time
+func New(service string, checks ...Check) *Checker {
+	c := &Checker{
+		service: service,
+		checks:  checks,
+		server:  grpchealth.NewServer(),
+	}
+	c.setServing(false)
+	return c
+}
+
+// Register adds grpc.health.v1 service to {s}, it reports status of the whole server ("") and of the {service}
+func (c *Checker) Register(s *grpc.Server) {
+	healthpb.RegisterHealthServer(s, c.server)
+}
+
+// Watch runs the checks every {interval} and updates gRPC health status until {ctx} is done
+func (c *Checker) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.Ready(ctx)
+		select {
+		case <-ctx.Done():
+			c.setServing(false)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Ready runs the checks and returns their report, gRPC health status is updated with the result
+func (c *Checker) Ready(ctx context.Context) *Report {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	report := &Report{
+		Service: c.service,
+		Ready:   true,
+		Checks:  map[string]string{},
+	}
+	results := make([]error, len(c.checks))
+	wg := sync.WaitGroup{}
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = check.Run(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+	for i, check := range c.checks {
+		if results[i] == nil {
+			report.Checks[check.Name] = statusOK
+			continue
+		}
+		report.Checks[check.Name] = results[i].Error()
+		if !check.Optional {
+			report.Ready = false
+		}
+	}
+
+	c.m.Lock()
+	if c.last == nil || c.last.Ready != report.Ready {
+		logging.Info(ctx, "Readiness of "+c.service+" changed", "ready", report.Ready, "failed", strings.Join(failed(report), "; "))
+	}
+	c.last = report
+	c.m.Unlock()
+	c.setServing(report.Ready)
+	return report
+}
+
+// Shutdown marks the service as not serving, so clients and balancers stop sending new requests
+func (c *Checker) Shutdown() {
+	c.server.Shutdown()
+}
+
+func (c *Checker) setServing(serving bool) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	c.server.SetServingStatus("", status)
+	c.server.SetServingStatus(c.service, status)
+}
+
+// Handler serves liveness and readiness probes and passes other requests to {next}.
+// Liveness only tells that process is up, readiness runs the checks and returns 503 if any required one fails
+func (c *Checker) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case LIVENESS_PATH:
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(statusOK))
+		case READINESS_PATH:
+			report := c.Ready(r.Context())
+			w.Header().Set("Content-Type", "application/json")
+			if !report.Ready {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			json.NewEncoder(w).Encode(report)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// MongoCheck checks that mongo primary answers ping
+func MongoCheck(mgc *mongo.Client) Check {
+	return Check{
+		Name: "mongo",
+		Run: func(ctx context.Context) error {
+			return mgc.Ping(ctx, readpref.Primary())
+		},
+	}
+}
+
+// failed returns names of failed checks in stable order, for logs
+func failed(report *Report) []string {
+	names := []string{}
+	for name, result := range report.Checks {
+		if result != statusOK {
+			names = append(names, name+": "+result)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,186 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"google.golang.org/grpc"
+)
+
+// SHUTDOWN_TIMEOUT is how long components have to stop, it's less than default kubernetes grace period of 30s
+const SHUTDOWN_TIMEOUT = 20 * time.Second
+
+// Component is a part of the service that is started and stopped together with it
+type Component struct {
+	Name string
+	// Start runs the component and blocks until it stops, nil if component has nothing to run.
+	// If Start returns before shutdown, the whole service is stopped
+	Start func(ctx context.Context) error
+	// Stop stops the component and waits for work in progress, it has to give up once {ctx} is done.
+	// Nil if component has nothing to stop
+	Stop func(ctx context.Context) error
+}
+
+// Runner starts components in the order they were added and stops them in reverse order,
+// so components are stopped before things they depend on
+type Runner struct {
+	components []Component
+	timeout    time.Duration
+}
+
+// New creates runner that gives components {timeout} to stop
+func New(timeout time.Duration) *Runner {
+	return &Runner{timeout: timeout}
+}
+
+// Add adds component {c}, it has to be added after components it depends on
+func (r *Runner) Add(c Component) {
+	r.components = append(r.components, c)
+}
+
+type result struct {
+	name string
+	err  error
+}
+
+// Run starts components and waits for SIGINT or SIGTERM, {ctx} cancel or one of components to stop,
+// then stops all components. Context of Start is cancelled once components are stopped.
+// Returns error of the component that caused shutdown or the first error of Stop
+func (r *Runner) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan result, len(r.components))
+	for _, c := range r.components {
+		if c.Start == nil {
+			continue
+		}
+		go func(c Component) {
+			results <- result{name: c.Name, err: c.Start(ctx)}
+		}(c)
+	}
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signalCh)
+
+	var runErr error
+	select {
+	case sig := <-signalCh:
+		logging.Info(ctx, "Got signal "+sig.String()+", shutting down")
+	case <-ctx.Done():
+		logging.Info(ctx, "Context is done, shutting down")
+	case res := <-results:
+		if ctx.Err() != nil {
+			// Component stopped because {ctx} is done, it's not a failure
+			logging.Info(ctx, "Context is done, shutting down")
+			break
+		}
+		if res.err != nil {
+			runErr = fmt.Errorf("%s failed: %v", res.name, res.err)
+		} else {
+			runErr = fmt.Errorf("%s stopped unexpectedly", res.name)
+		}
+		logging.Error(ctx, "Shutting down", "err", runErr)
+	}
+
+	stopErr := r.stop()
+	if runErr != nil {
+		return runErr
+	}
+	return stopErr
+}
+
+// stop stops components in reverse order, all of them share one deadline
+func (r *Runner) stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	var firstErr error
+	for i := len(r.components) - 1; i >= 0; i-- {
+		c := r.components[i]
+		if c.Stop == nil {
+			continue
+		}
+		started := time.Now()
+		if err := c.Stop(ctx); err != nil {
+			logging.Error(ctx, "Failed to stop "+c.Name, "err", err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %v", c.Name, err)
+			}
+			continue
+		}
+		logging.Info(ctx, "Stopped "+c.Name, "latency", time.Since(started))
+	}
+	return firstErr
+}
+
+// GRPCServer serves {srv} on {addr}. Stop rejects new RPCs and waits for in-flight ones,
+// connections are closed forcibly once deadline is reached
+func GRPCServer(name string, srv *grpc.Server, addr string) Component {
+	return Component{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			listener, err := net.Listen("tcp", addr)
+			if err != nil {
+				return err
+			}
+			return srv.Serve(listener)
+		},
+		Stop: func(ctx context.Context) error {
+			stopped := make(chan struct{})
+			go func() {
+				srv.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+				return nil
+			case <-ctx.Done():
+				srv.Stop()
+				return fmt.Errorf("In-flight RPCs were cancelled: %v", ctx.Err())
+			}
+		},
+	}
+}
+
+// HTTPServer serves {handler} on {addr}. Stop closes listener and waits for in-flight requests,
+// connections are closed forcibly once deadline is reached
+func HTTPServer(name, addr string, handler http.Handler) Component {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	return Component{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			if err := srv.Shutdown(ctx); err != nil {
+				srv.Close()
+				return fmt.Errorf("In-flight requests were cancelled: %v", err)
+			}
+			return nil
+		},
+	}
+}
+
+// Mongo disconnects {mgc} on stop, it has to be added before components that use it
+func Mongo(mgc *mongo.Client) Component {
+	return Component{
+		Name: "mongo",
+		Stop: mgc.Disconnect,
+	}
+}
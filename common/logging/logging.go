@@ -0,0 +1,173 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// Log levels, the level of service is set with DEAL_LOG_LEVEL env variable or -log-level flag
+	DEBUG = "debug"
+	INFO  = "info"
+	WARN  = "warn"
+	ERROR = "error"
+
+	// TID_HEADER is gRPC metadata key that carries transaction id between services
+	TID_HEADER = "x-deal-tid"
+
+	// Caller of Debug, Info, Warn and Error is 4 frames up from the valuer
+	callerDepth = 4
+)
+
+// Levels are log levels in order of severity
+var Levels = []string{DEBUG, INFO, WARN, ERROR}
+
+var (
+	m    sync.RWMutex
+	base = New("", INFO)
+)
+
+// New creates logfmt logger of {service} that writes to stdout records of {lvl} and more severe levels
+func New(service, lvl string) log.Logger {
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout))
+	// Filter goes first, so timestamp and caller are evaluated by the outer logger with known depth
+	logger = level.NewFilter(logger, allow(lvl))
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC, "caller", log.Caller(callerDepth))
+	if len(service) > 0 {
+		logger = log.With(logger, "service", service)
+	}
+	return logger
+}
+
+func allow(lvl string) level.Option {
+	switch lvl {
+	case DEBUG:
+		return level.AllowDebug()
+	case WARN:
+		return level.AllowWarn()
+	case ERROR:
+		return level.AllowError()
+	}
+	return level.AllowInfo()
+}
+
+// SetLogger sets logger that Debug, Info, Warn and Error write to, it has to be called once on start
+func SetLogger(logger log.Logger) {
+	m.Lock()
+	defer m.Unlock()
+	base = logger
+}
+
+// Logger returns logger set with SetLogger
+func Logger() log.Logger {
+	m.RLock()
+	defer m.RUnlock()
+	return base
+}
+
+// fields are key-values of a request that every log record of the request has, e.g. tid and user id.
+// They are shared by contexts of the request, so values added deep in the handler are seen by the interceptor
+type fields struct {
+	m       sync.Mutex
+	keyvals []interface{}
+}
+
+type fieldsKey struct{}
+
+type tidKey struct{}
+
+// WithFields returns context that logs {keyvals} with every record. Fields are added to fields of the
+// request if {ctx} has them, otherwise a new set of fields is started
+func WithFields(ctx context.Context, keyvals ...interface{}) context.Context {
+	if f, ok := ctx.Value(fieldsKey{}).(*fields); ok {
+		f.m.Lock()
+		f.keyvals = append(f.keyvals, keyvals...)
+		f.m.Unlock()
+		return ctx
+	}
+	return context.WithValue(ctx, fieldsKey{}, &fields{keyvals: append([]interface{}{}, keyvals...)})
+}
+
+// WithTid returns context of transaction {tid}, tid is logged and passed to other services
+func WithTid(ctx context.Context, tid string) context.Context {
+	return WithFields(context.WithValue(ctx, tidKey{}, tid), "tid", tid)
+}
+
+// NewTid returns context of new transaction, it's used by background jobs that aren't started by request
+func NewTid(ctx context.Context) context.Context {
+	return WithTid(ctx, GenerateTid())
+}
+
+// Tid returns transaction id of {ctx}, "unknown" if there is none
+func Tid(ctx context.Context) string {
+	if tid, ok := ctx.Value(tidKey{}).(string); ok && len(tid) > 0 {
+		return tid
+	}
+	return "unknown"
+}
+
+// GenerateTid returns random transaction id
+func GenerateTid() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", os.Getpid())
+	}
+	return hex.EncodeToString(b)
+}
+
+// FromContext returns logger with fields of {ctx}
+func FromContext(ctx context.Context) log.Logger {
+	logger := Logger()
+	if f, ok := ctx.Value(fieldsKey{}).(*fields); ok {
+		f.m.Lock()
+		logger = log.With(logger, f.keyvals...)
+		f.m.Unlock()
+	}
+	return logger
+}
+
+// Debug logs {msg} and {keyvals} with debug level and fields of {ctx}
+func Debug(ctx context.Context, msg string, keyvals ...interface{}) {
+	level.Debug(FromContext(ctx)).Log(append([]interface{}{"msg", msg}, keyvals...)...)
+}
+
+// Info logs {msg} and {keyvals} with info level and fields of {ctx}
+func Info(ctx context.Context, msg string, keyvals ...interface{}) {
+	level.Info(FromContext(ctx)).Log(append([]interface{}{"msg", msg}, keyvals...)...)
+}
+
+// Warn logs {msg} and {keyvals} with warn level and fields of {ctx}
+func Warn(ctx context.Context, msg string, keyvals ...interface{}) {
+	level.Warn(FromContext(ctx)).Log(append([]interface{}{"msg", msg}, keyvals...)...)
+}
+
+// Error logs {msg} and {keyvals} with error level and fields of {ctx}.
+// Span of {ctx} is marked as failed, so errors are visible in traces as well
+func Error(ctx context.Context, msg string, keyvals ...interface{}) {
+	level.Error(FromContext(ctx)).Log(append([]interface{}{"msg", msg}, keyvals...)...)
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	span.SetStatus(otelcodes.Error, msg)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if err, ok := keyvals[i+1].(error); ok && keyvals[i] == "err" {
+			span.RecordError(err)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// METRICS_PATH is HTTP path of Prometheus metrics on the gateway port
+const METRICS_PATH = "/metrics"
+
+// Handler serves metrics of the default registry, where RPC and business metrics are registered,
+// and passes other requests to {next}
+func Handler(next http.Handler) http.Handler {
+	metrics := promhttp.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == METRICS_PATH {
+			metrics.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
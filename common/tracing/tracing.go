@@ -0,0 +1,87 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/DenysNahurnyi/deal/common/config"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/DenysNahurnyi/deal"
+
+// Setup sets global tracer provider of {service} that exports spans as {cfg} says and returns func that
+// flushes spans that are not exported yet. With "none" exporter spans are not recorded, but trace context
+// is still passed from callers to called services
+func Setup(ctx context.Context, service string, cfg config.TracingConfig) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if cfg.Exporter != config.TRACING_OTLP {
+		return func(ctx context.Context) error { return nil }, nil
+	}
+	// Empty endpoint means OTEL_EXPORTER_OTLP_ENDPOINT or default localhost:4317
+	opts := []otlptracegrpc.Option{}
+	if len(cfg.Endpoint) > 0 {
+		opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create OTLP exporter: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(service))),
+		// Traces started by caller are recorded if caller records them
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts span {name} as a child of span of {ctx}
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartDB starts span of mongo {operation} on {table}, operation is name of the DB function.
+// Nil {table} is allowed, so span is started even if caller is about to fail on it
+func StartDB(ctx context.Context, operation string, table *mongo.Collection) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", "mongodb"),
+		attribute.String("db.operation", operation),
+	}
+	if table != nil {
+		attrs = append(attrs,
+			attribute.String("db.name", table.Database().Name()),
+			attribute.String("db.mongodb.collection", table.Name()),
+		)
+	}
+	return otel.Tracer(tracerName).Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// Handler starts span of every gateway request, trace context sent by HTTP client in traceparent header
+// is continued, so gateway passes it further to the gRPC server
+func Handler(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "gateway")
+}
@@ -8,17 +8,23 @@ package utils
 
 import (
 	"bufio"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
 	"os"
+	"sort"
+	"sync"
 	"time"
 
 	// "log"
 	"net"
 	"strings"
 
+	"github.com/DenysNahurnyi/deal/common/clock"
 	grpcutils "github.com/DenysNahurnyi/deal/common/grpc"
+	"github.com/DenysNahurnyi/deal/common/health"
+	"github.com/DenysNahurnyi/deal/common/logging"
 	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
 	"github.com/go-kit/kit/log"
 	"github.com/tidwall/gjson"
@@ -33,6 +39,9 @@ const (
 	GRPCMAXRETRIES               = 5
 	GRPCPERRETRYTIMEOUT          = 5 * time.Second
 	GRPCWAITTIMEOUT              = 5 * time.Second
+	GRPCCALLTIMEOUT              = 30 * time.Second
+	GRPCBREAKERFAILURES          = 5
+	GRPCBREAKERCOOLDOWN          = 10 * time.Second
 	INTENTSERVICE                = "intentmgr"
 	INTENTSERVICEPORTNAME        = "grpc-port"
 	ALERTMGRSERVICE              = "alertmgr"
@@ -167,48 +176,88 @@ func SliceDifference(slice1 []string, slice2 []string) []string {
 	return newslice
 }
 
-//CreateAuthSvcClient creates a connection to authSvc
+// Breakers of connections to other services by service name, they tell whether peers answer
+var (
+	breakersMu sync.Mutex
+	breakers   = map[string]*grpcutils.CircuitBreaker{}
+)
+
+// serviceDialConfig is config of connections between services. They connect lazily and calls wait for the
+// service to come up, so services can start in any order. Deadline and breaker keep callers from hanging
+// on a service that is down
+func serviceDialConfig(service string) grpcutils.DialConfig {
+	breaker := grpcutils.NewCircuitBreaker(service, GRPCBREAKERFAILURES, GRPCBREAKERCOOLDOWN, clock.New())
+	breakersMu.Lock()
+	breakers[service] = breaker
+	breakersMu.Unlock()
+	return grpcutils.DialConfig{
+		UseRetry:     true,
+		MaxRetries:   GRPCMAXRETRIES,
+		DialOptions:  grpcutils.OptsGrpcGw(),
+		Lazy:         true,
+		WaitForReady: true,
+		CallTimeout:  GRPCCALLTIMEOUT,
+		Breaker:      breaker,
+	}
+}
+
+//PeerChecks returns optional health checks of services this one has clients to, a peer fails the check
+//while breaker of its connection isn't closed. Peers have their own probes, so they don't affect readiness
+func PeerChecks() []health.Check {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	services := []string{}
+	for service := range breakers {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	checks := []health.Check{}
+	for _, service := range services {
+		breaker := breakers[service]
+		checks = append(checks, health.Check{
+			Name:     service,
+			Optional: true,
+			Run: func(ctx context.Context) error {
+				if state := breaker.State(); state != grpcutils.BREAKER_CLOSED {
+					return fmt.Errorf("Circuit breaker is %s", state)
+				}
+				return nil
+			},
+		})
+	}
+	return checks
+}
+
+//CreateAuthSvcClient creates a connection to authSvc, it doesn't wait for authSvc to be up
 func CreateAuthSvcClient(logger log.Logger) (*pb.AuthServiceClient, error) {
 	//Discover the authSvc endpoint
-	conn, err := grpcutils.CreateGrpcConn(AUTH_SERVICE, AUTH_SERVICE_PORT_NAME, grpcutils.DialConfig{
-		UseRetry:    true,
-		MaxRetries:  GRPCMAXRETRIES,
-		DialOptions: grpcutils.OptsGrpcGw(),
-	}, logger)
+	conn, err := grpcutils.CreateGrpcConn(AUTH_SERVICE, AUTH_SERVICE_PORT_NAME, serviceDialConfig(AUTH_SERVICE), logger)
 	if err != nil {
-		fmt.Println("grpc Dial failed: ", err)
+		logging.Error(context.Background(), "grpc Dial failed", "err", err)
 		return nil, err
 	}
 	authSvcClient := pb.NewAuthServiceClient(conn)
 	return &authSvcClient, nil
 }
 
-//CreateDataSvcClient creates a connection to dataSvc
+//CreateDataSvcClient creates a connection to dataSvc, it doesn't wait for dataSvc to be up
 func CreateDataSvcClient(logger log.Logger) (*pb.DataServiceClient, error) {
 	//Discover the dataSvc endpoint
-	conn, err := grpcutils.CreateGrpcConn(DATA_SERVICE, DATA_SERVICE_PORT_NAME, grpcutils.DialConfig{
-		UseRetry:    true,
-		MaxRetries:  GRPCMAXRETRIES,
-		DialOptions: grpcutils.OptsGrpcGw(),
-	}, logger)
+	conn, err := grpcutils.CreateGrpcConn(DATA_SERVICE, DATA_SERVICE_PORT_NAME, serviceDialConfig(DATA_SERVICE), logger)
 	if err != nil {
-		fmt.Println("grpc Dial failed: ", err)
+		logging.Error(context.Background(), "grpc Dial failed", "err", err)
 		return nil, err
 	}
 	dataSvcClient := pb.NewDataServiceClient(conn)
 	return &dataSvcClient, nil
 }
 
-//CreateWatcherSvcClient creates a connection to watcherSvc
+//CreateWatcherSvcClient creates a connection to watcherSvc, it doesn't wait for watcherSvc to be up
 func CreateWatcherSvcClient(logger log.Logger) (*pb.WatcherServiceClient, error) {
 	//Discover the watcherSvc endpoint
-	conn, err := grpcutils.CreateGrpcConn(WATCHER_SERVICE, WATCHER_SERVICE_PORT_NAME, grpcutils.DialConfig{
-		UseRetry:    true,
-		MaxRetries:  GRPCMAXRETRIES,
-		DialOptions: grpcutils.OptsGrpcGw(),
-	}, logger)
+	conn, err := grpcutils.CreateGrpcConn(WATCHER_SERVICE, WATCHER_SERVICE_PORT_NAME, serviceDialConfig(WATCHER_SERVICE), logger)
 	if err != nil {
-		fmt.Println("grpc Dial failed: ", err)
+		logging.Error(context.Background(), "grpc Dial failed", "err", err)
 		return nil, err
 	}
 	watcherSvcClient := pb.NewWatcherServiceClient(conn)
@@ -0,0 +1,144 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package validation
+
+import (
+	"fmt"
+	"math"
+	"net/mail"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+)
+
+// Rule checks fields of the request and adds every invalid one to {v}
+type Rule func(req interface{}, v *Violations)
+
+// rules of every registered request type, they are registered in init of services, so map isn't guarded
+var rules = map[reflect.Type][]Rule{}
+
+// Register sets {reqRules} of requests of the same type as {req}. Request header is required
+// for every registered request, so requests without other rules are registered without them
+func Register(req interface{}, reqRules ...Rule) {
+	t := reflect.TypeOf(req)
+	if _, ok := rules[t]; ok {
+		panic(fmt.Sprintf("Validation rules of %v are already registered", t))
+	}
+	rules[t] = reqRules
+}
+
+// Validate checks {req} with its rules, error has violation of every invalid field.
+// Requests that aren't registered are not checked
+func Validate(req interface{}) error {
+	reqRules, ok := rules[reflect.TypeOf(req)]
+	if !ok {
+		return nil
+	}
+	v := &Violations{}
+	if r, ok := req.(interface{ GetReqHdr() *pb.ReqHdr }); ok {
+		v.Present("req_hdr", r.GetReqHdr() != nil)
+	}
+	for _, rule := range reqRules {
+		rule(req, v)
+	}
+	return v.Err()
+}
+
+// Violations gathers invalid fields of the request, so client gets all of them at once
+type Violations struct {
+	list []*pb.FieldViolation
+}
+
+// Add adds violation of {field}, description is formatted from {format} and {args}
+func (v *Violations) Add(field, format string, args ...interface{}) {
+	v.list = append(v.list, &pb.FieldViolation{Field: field, Description: fmt.Sprintf(format, args...)})
+}
+
+// Err returns INVALID_ARGUMENT error with all violations or nil if there are none
+func (v *Violations) Err() error {
+	if len(v.list) == 0 {
+		return nil
+	}
+	return dealerrors.Invalid(v.list)
+}
+
+// Present checks that message {field} is set
+func (v *Violations) Present(field string, present bool) {
+	if !present {
+		v.Add(field, "Must be set")
+	}
+}
+
+// Required checks that {value} of {field} isn't empty or made of spaces only
+func (v *Violations) Required(field, value string) {
+	if len(strings.TrimSpace(value)) == 0 {
+		v.Add(field, "Mustn't be empty")
+	}
+}
+
+// Length checks that {value} of {field} has from {min} to {max} characters, spaces around are not counted
+func (v *Violations) Length(field, value string, min, max int) {
+	if n := utf8.RuneCountInString(strings.TrimSpace(value)); n < min || n > max {
+		v.Add(field, "Must be from %d to %d characters", min, max)
+	}
+}
+
+// Range checks that {value} of {field} is in [{min}, {max}] range
+func (v *Violations) Range(field string, value, min, max int64) {
+	if value < min || value > max {
+		v.Add(field, "Must be in [%d, %d] range", min, max)
+	}
+}
+
+// OneOf checks that {value} of {field} is one of {allowed}
+func (v *Violations) OneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.Add(field, "Must be one of: %s", strings.Join(allowed, ", "))
+}
+
+// Enum checks that {value} of {field} is defined in the enum with {names}
+func (v *Violations) Enum(field string, value int32, names map[int32]string) {
+	if _, ok := names[value]; !ok {
+		v.Add(field, "Unknown value %d", value)
+	}
+}
+
+// Time checks that {value} of {field} is time in {layout}
+func (v *Violations) Time(field, value, layout string) {
+	if _, err := time.Parse(layout, value); err != nil {
+		v.Add(field, "Must be time in format %s", layout)
+	}
+}
+
+// ID checks that {value} of {field} is id of the object, ids are hex of mongo object id
+func (v *Violations) ID(field, value string) {
+	if _, err := primitive.ObjectIDFromHex(value); err != nil {
+		v.Add(field, "Must be valid id")
+	}
+}
+
+// Email checks that {value} of {field} is bare email address, without name or anything else around it
+func (v *Violations) Email(field, value string) {
+	if addr, err := mail.ParseAddress(value); err != nil || addr.Address != value {
+		v.Add(field, "Must be email address")
+	}
+}
+
+// Pagination checks {page} and {pageSize} of list requests, zero means default
+func (v *Violations) Pagination(page, pageSize, maxPageSize int64) {
+	v.Range("page", page, 0, math.MaxInt32)
+	v.Range("page_size", pageSize, 0, maxPageSize)
+}
@@ -0,0 +1,358 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	grpcutils "github.com/DenysNahurnyi/deal/common/grpc"
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/DenysNahurnyi/deal/common/tracing"
+	"github.com/DenysNahurnyi/deal/common/utils"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/options"
+)
+
+const (
+	// Actor of changes made by other services, e.g. deal timeout from watcherSvc
+	systemActor = "system"
+	// Concurrent changes of the same deal race for the chain head, without transactions loser retries
+	auditChainRetries = 3
+)
+
+// AuditChange is a change of one deal field, values are JSON encoded
+type AuditChange struct {
+	Field  string `bson:"field" json:"field"`
+	Before string `bson:"before,omitempty" json:"before,omitempty"`
+	After  string `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// AuditEntryDB is an immutable record of the deal change. Entries of the deal are hash-chained:
+// every entry hash covers the previous one, so changed or removed entry breaks the chain
+type AuditEntryDB struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	DealID   string             `bson:"deal_id"`
+	Seq      int64              `bson:"seq"`
+	Actor    string             `bson:"actor"`
+	Action   string             `bson:"action"`
+	Tid      string             `bson:"tid,omitempty"`
+	Changes  []AuditChange      `bson:"changes,omitempty"`
+	Time     time.Time          `bson:"time"`
+	PrevHash string             `bson:"prev_hash,omitempty"`
+	Hash     string             `bson:"hash"`
+}
+
+// auditHead is the last entry of the deal chain, it's kept in counters collection
+type auditHead struct {
+	Seq  int64  `bson:"seq"`
+	Hash string `bson:"hash"`
+}
+
+func auditHeadID(dealID string) string {
+	return "audit:" + dealID
+}
+
+// computeHash returns hash of entry content together with the previous hash
+func (e *AuditEntryDB) computeHash() string {
+	changes, _ := json.Marshal(e.Changes)
+	h := sha256.New()
+	h.Write([]byte(strings.Join([]string{
+		e.PrevHash,
+		e.DealID,
+		strconv.FormatInt(e.Seq, 10),
+		e.Actor,
+		e.Action,
+		e.Tid,
+		e.Time.UTC().Format(time.RFC3339Nano),
+		string(changes),
+	}, "|")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AppendAuditEntryDB appends {entry} to the chain of it's deal, has to be called in the transaction
+func AppendAuditEntryDB(ctx context.Context, entry *AuditEntryDB, auditTable, counterTable *mongo.Collection) error {
+	ctx, span := tracing.StartDB(ctx, "AppendAuditEntryDB", auditTable)
+	defer span.End()
+	head := auditHead{}
+	err := counterTable.FindOne(ctx, bson.D{{Key: "_id", Value: auditHeadID(entry.DealID)}}).Decode(&head)
+	if err != nil && err.Error() != "mongo: no documents in result" {
+		logging.Error(ctx, "Error getting audit chain head from mongo", "err", err)
+		return err
+	}
+	entry.Seq = head.Seq + 1
+	entry.PrevHash = head.Hash
+	entry.Hash = entry.computeHash()
+	// Head moves only from the seq we've read, otherwise somebody appended in between
+	_, err = counterTable.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: auditHeadID(entry.DealID)}, {Key: "seq", Value: head.Seq}},
+		bson.D{{"$set", bson.D{{Key: "seq", Value: entry.Seq}, {Key: "hash", Value: entry.Hash}}}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = auditTable.InsertOne(ctx, entry)
+	if err != nil {
+		logging.Error(ctx, "Error creating audit entry in mongo", "err", err)
+	}
+	return err
+}
+
+// GetAuditEntriesDB returns entries of deal {dealID} in chain order
+func GetAuditEntriesDB(ctx context.Context, dealID string, table *mongo.Collection) ([]*AuditEntryDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetAuditEntriesDB", table)
+	defer span.End()
+	cursor, err := table.Find(ctx,
+		bson.D{{Key: "deal_id", Value: dealID}},
+		options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}),
+	)
+	if err != nil {
+		logging.Error(ctx, "Error getting audit entries from mongo", "err", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []*AuditEntryDB{}
+	for cursor.Next(ctx) {
+		e := &AuditEntryDB{}
+		if err := cursor.Decode(e); err != nil {
+			logging.Error(ctx, "Error getting audit entries from mongo", "err", err)
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// verifyAuditChain returns seq of the first entry that doesn't match the chain, 0 if chain is intact
+func verifyAuditChain(entries []*AuditEntryDB) int64 {
+	prevHash := ""
+	for i, e := range entries {
+		if e.Seq != int64(i+1) || e.PrevHash != prevHash || e.computeHash() != e.Hash {
+			return int64(i + 1)
+		}
+		prevHash = e.Hash
+	}
+	return 0
+}
+
+// diffDeals returns fields of the deal that differ between {before} and {after}, any of them could be nil
+func diffDeals(before, after *DealDocumentDB) []AuditChange {
+	fields := func(d *DealDocumentDB) map[string]json.RawMessage {
+		res := map[string]json.RawMessage{}
+		if d == nil {
+			return res
+		}
+		raw, err := json.Marshal(d)
+		if err == nil {
+			json.Unmarshal(raw, &res)
+		}
+		return res
+	}
+	b, a := fields(before), fields(after)
+	names := []string{}
+	for k := range b {
+		names = append(names, k)
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	changes := []AuditChange{}
+	for _, k := range names {
+		if string(b[k]) != string(a[k]) {
+			changes = append(changes, AuditChange{Field: k, Before: string(b[k]), After: string(a[k])})
+		}
+	}
+	return changes
+}
+
+// blamedDealOf returns deal blamed by the blame document, empty for common deals
+func blamedDealOf(dealDoc *DealDocumentDB) string {
+	if dealDoc == nil || dealDoc.Type != "BLAME" {
+		return ""
+	}
+	pact, err := dealDoc.getCurrentPact()
+	if err != nil || len(pact.Blue.Participants) == 0 {
+		return ""
+	}
+	return pact.Blue.Participants[0].ID
+}
+
+// snapshotDeals returns current state of deals {dealIDs}, blamed deals of blames are included as they change together
+func snapshotDeals(ctx context.Context, dealIDs []string, deals DealRepo) map[string]*DealDocumentDB {
+	snapshot := map[string]*DealDocumentDB{}
+	dealIDs = append([]string{}, dealIDs...)
+	for i := 0; i < len(dealIDs); i++ {
+		id := dealIDs[i]
+		if _, ok := snapshot[id]; ok || len(id) == 0 {
+			continue
+		}
+		dealDoc, err := deals.GetByID(ctx, id)
+		if err != nil {
+			logging.Error(ctx, "Failed to get deal "+id+" for audit", "err", err)
+		}
+		snapshot[id] = dealDoc
+		if blamed := blamedDealOf(dealDoc); len(blamed) > 0 {
+			dealIDs = append(dealIDs, blamed)
+		}
+	}
+	return snapshot
+}
+
+// auditSubjects returns deals changed by the request, {resp} is nil before the call
+func auditSubjects(req, resp interface{}) []string {
+	ids := []string{}
+	switch r := req.(type) {
+	case interface{ GetDealDocId() string }:
+		ids = append(ids, r.GetDealDocId())
+	case interface{ GetDealDocumentId() string }:
+		ids = append(ids, r.GetDealDocumentId())
+	case interface{ GetDealId() string }:
+		ids = append(ids, r.GetDealId())
+	case interface{ GetBlameId() string }:
+		ids = append(ids, r.GetBlameId())
+	case interface{ GetBlamedDealId() string }:
+		ids = append(ids, r.GetBlamedDealId())
+	}
+	switch r := resp.(type) {
+	case pb.CreateDealDocumentResp:
+		ids = append(ids, r.DealDocumentId)
+	case pb.CreateBlameDocumentResp:
+		ids = append(ids, r.BlameDocumentId)
+	}
+	return ids
+}
+
+// auditEndpoint records audit entry for every deal changed by successful call of {next}. Call and its audit entries
+// run in one transaction, so both snapshots see only this change and change without audit entry isn't committed
+func auditEndpoint(svc Service, action string, next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		actor, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			actor = systemActor
+		}
+		tid := ""
+		if r, ok := request.(interface{ GetReqHdr() *pb.ReqHdr }); ok {
+			tid = r.GetReqHdr().GetTid()
+		}
+		var response interface{}
+		err = svc.inTransaction(ctx, func(sc context.Context) error {
+			before := snapshotDeals(sc, auditSubjects(request, nil), svc.getDealRepo())
+			var err error
+			response, err = next(sc, request)
+			if err != nil {
+				return err
+			}
+			ids := auditSubjects(request, response)
+			for id := range before {
+				ids = append(ids, id)
+			}
+			return svc.recordAudit(sc, action, actor, tid, utils.UniqueStringSlice(ids), before)
+		})
+		return response, err
+	}
+}
+
+// recordAudit appends entry to the chain of every deal from {dealIDs} that has changed since {before},
+// {ctx} has to be the transaction of the change. Without transactions change is already applied,
+// so the error only tells that its audit entry is missing
+func (s *service) recordAudit(ctx context.Context, action, actor, tid string, dealIDs []string, before map[string]*DealDocumentDB) error {
+	if s.auditTable == nil {
+		return nil
+	}
+	after := snapshotDeals(ctx, dealIDs, s.deals)
+	for id, dealDoc := range after {
+		if dealDoc == nil {
+			continue
+		}
+		changes := diffDeals(before[id], dealDoc)
+		if len(changes) == 0 {
+			continue
+		}
+		var err error
+		for attempt := 0; attempt < auditChainRetries; attempt++ {
+			entry := &AuditEntryDB{
+				ID:      primitive.NewObjectID(),
+				DealID:  id,
+				Actor:   actor,
+				Action:  action,
+				Tid:     tid,
+				Changes: changes,
+				// Mongo keeps milliseconds only, hash has to match the stored time
+				Time: s.clock.Now().UTC().Truncate(time.Millisecond),
+			}
+			err = AppendAuditEntryDB(ctx, entry, s.auditTable, s.counterTable)
+			// Conflict aborts the transaction, so the whole change fails and is retried by the client
+			if err == nil || s.transactions {
+				break
+			}
+		}
+		if err != nil {
+			logging.Error(ctx, "Failed to record "+action+" of deal "+id+" in audit log", "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDealHistory returns audit log of deal {dealID} and seq of the first tampered entry, 0 if log is intact
+func (s *service) GetDealHistory(ctx context.Context, userID, dealID string) ([]*AuditEntryDB, int64, error) {
+	if err := requireTable(s.auditTable, "Deal history"); err != nil {
+		return nil, 0, err
+	}
+	dealDoc, err := s.deals.GetByID(ctx, dealID)
+	if err != nil {
+		logging.Error(ctx, "Failed to get deal document from DB", "err", err)
+		return nil, 0, err
+	}
+	if dealDoc == nil {
+		return nil, 0, dealerrors.New(dealerrors.DEAL_NOT_FOUND, "Deal %s doesn't exist", dealID)
+	}
+	if !utils.StringInSlice(userID, dealAudience(dealDoc)) {
+		return nil, 0, dealerrors.New(dealerrors.NOT_A_PARTICIPANT, "User %s doesn't participate in deal %s", userID, dealID)
+	}
+	entries, err := GetAuditEntriesDB(ctx, dealID, s.auditTable)
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, verifyAuditChain(entries), nil
+}
+
+func convertAuditEntry(e *AuditEntryDB) *pb.AuditEntry {
+	res := &pb.AuditEntry{
+		Seq:      e.Seq,
+		Actor:    e.Actor,
+		Action:   e.Action,
+		Tid:      e.Tid,
+		Time:     e.Time.Format(timeoutLayout),
+		PrevHash: e.PrevHash,
+		Hash:     e.Hash,
+	}
+	for _, c := range e.Changes {
+		res.Changes = append(res.Changes, &pb.AuditChange{
+			Field:  c.Field,
+			Before: c.Before,
+			After:  c.After,
+		})
+	}
+	return res
+}
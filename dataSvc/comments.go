@@ -0,0 +1,472 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/DenysNahurnyi/deal/common/tracing"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/options"
+)
+
+const (
+	maxCommentLen = 4000
+	// Number of different parties that have to flag comment to hide it, judge flag hides it at once
+	commentHideFlags = 3
+	// Streams re-read the DB with this interval to catch comments posted through other replicas
+	commentPollInterval = 2 * time.Second
+)
+
+// CommentDB is a comment of the deal that stores in the DB
+type CommentDB struct {
+	ID         primitive.ObjectID   `bson:"_id,omitempty"`
+	DealID     string               `bson:"deal_id"`
+	ParentID   string               `bson:"parent_id,omitempty"`
+	ThreadID   string               `bson:"thread_id"`
+	Author     string               `bson:"author"`
+	AuthorSide pb.SideType          `bson:"author_side"`
+	Visibility pb.CommentVisibility `bson:"visibility"`
+	Body       string               `bson:"body"`
+	History    []CommentRevision    `bson:"history,omitempty"`
+	Flags      []ModerationFlag     `bson:"flags,omitempty"`
+	Hidden     bool                 `bson:"hidden"`
+	Created    time.Time            `bson:"created"`
+	Updated    time.Time            `bson:"updated"`
+	Version    int64                `bson:"version"`
+}
+
+// CommentRevision is a previous version of the comment body
+type CommentRevision struct {
+	Body string    `bson:"body"`
+	Time time.Time `bson:"time"`
+}
+
+// ModerationFlag is a complaint of user about the comment
+type ModerationFlag struct {
+	UserID string    `bson:"user_id"`
+	Reason string    `bson:"reason"`
+	Time   time.Time `bson:"time"`
+}
+
+// CreateCommentDB stores new comment and returns it's id
+func CreateCommentDB(ctx context.Context, comment CommentDB, table *mongo.Collection) (string, error) {
+	ctx, span := tracing.StartDB(ctx, "CreateCommentDB", table)
+	defer span.End()
+	res, err := table.InsertOne(ctx, comment)
+	if err != nil {
+		logging.Error(ctx, "Error creating comment in mongo", "err", err)
+		return "", err
+	}
+	return res.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// GetCommentByIDDB returns comment by it's id, nil if there is no such comment
+func GetCommentByIDDB(ctx context.Context, commentID string, table *mongo.Collection) (*CommentDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetCommentByIDDB", table)
+	defer span.End()
+	commentIDDB, err := primitive.ObjectIDFromHex(commentID)
+	if err != nil {
+		logging.Error(ctx, "Error creating object id to get comment", "err", err)
+		return nil, err
+	}
+	comment := &CommentDB{}
+	err = table.FindOne(ctx, bson.D{{Key: "_id", Value: commentIDDB}}).Decode(comment)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return nil, nil
+		}
+		logging.Error(ctx, "Error getting comment from mongo", "err", err)
+		return nil, err
+	}
+	return comment, nil
+}
+
+// UpdateCommentDB saves changed comment, it fails if somebody changed comment after it was read
+func UpdateCommentDB(ctx context.Context, comment *CommentDB, table *mongo.Collection) error {
+	ctx, span := tracing.StartDB(ctx, "UpdateCommentDB", table)
+	defer span.End()
+	res, err := table.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: comment.ID}, {Key: "version", Value: comment.Version - 1}},
+		bson.D{{"$set", bson.D{
+			{Key: "body", Value: comment.Body},
+			{Key: "history", Value: comment.History},
+			{Key: "flags", Value: comment.Flags},
+			{Key: "hidden", Value: comment.Hidden},
+			{Key: "updated", Value: comment.Updated},
+			{Key: "version", Value: comment.Version},
+		}}},
+	)
+	if err != nil {
+		logging.Error(ctx, "Error updating comment in mongo", "err", err)
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return dealerrors.New(dealerrors.FAILED_PRECONDITION, "Comment %s was changed concurrently, try again", comment.ID.Hex())
+	}
+	return nil
+}
+
+// commentsFilter builds filter of deal {dealID} comments that user {userID} with role {role} is allowed to see
+func commentsFilter(dealID, userID string, role pb.SideType) bson.D {
+	visible := []pb.CommentVisibility{pb.CommentVisibility_ALL, pb.CommentVisibility_PARTIES}
+	if role == pb.SideType_JUDGE {
+		visible = []pb.CommentVisibility{pb.CommentVisibility_ALL, pb.CommentVisibility_JUDGES_ONLY}
+	}
+	return bson.D{
+		{Key: "deal_id", Value: dealID},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "visibility", Value: bson.D{{Key: "$in", Value: visible}}}},
+			bson.D{{Key: "author", Value: userID}},
+		}},
+	}
+}
+
+// GetCommentsDB returns comments matching {filter}
+func GetCommentsDB(ctx context.Context, filter bson.D, opts *options.FindOptions, table *mongo.Collection) ([]*CommentDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetCommentsDB", table)
+	defer span.End()
+	cursor, err := table.Find(ctx, filter, opts)
+	if err != nil {
+		logging.Error(ctx, "Error getting comments from mongo", "err", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	comments := []*CommentDB{}
+	for cursor.Next(ctx) {
+		c := &CommentDB{}
+		if err := cursor.Decode(c); err != nil {
+			logging.Error(ctx, "Error getting comments from mongo", "err", err)
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, nil
+}
+
+// commentHub wakes up comment streams of the deal when something is posted through this replica
+type commentHub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan struct{}]struct{}
+}
+
+func newCommentHub() *commentHub {
+	return &commentHub{subs: make(map[string]map[chan struct{}]struct{})}
+}
+
+func (h *commentHub) subscribe(dealID string) chan struct{} {
+	ch := make(chan struct{}, 1)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[dealID] == nil {
+		h.subs[dealID] = make(map[chan struct{}]struct{})
+	}
+	h.subs[dealID][ch] = struct{}{}
+	return ch
+}
+
+func (h *commentHub) unsubscribe(dealID string, ch chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs[dealID], ch)
+	if len(h.subs[dealID]) == 0 {
+		delete(h.subs, dealID)
+	}
+}
+
+func (h *commentHub) publish(dealID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[dealID] {
+		// Stream is already woken up if channel is full
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// commentRole returns role of user {userID} in deal {dealID}
+func (s *service) commentRole(ctx context.Context, userID, dealID string) (pb.SideType, error) {
+	dealDoc, err := s.deals.GetByID(ctx, dealID)
+	if err != nil {
+		logging.Error(ctx, "Failed to get deal document from DB", "err", err)
+		return pb.SideType_JUDGE, err
+	}
+	if dealDoc == nil {
+		return pb.SideType_JUDGE, dealerrors.New(dealerrors.DEAL_NOT_FOUND, "Deal %s doesn't exist", dealID)
+	}
+	role, ok := dealRole(dealDoc, userID)
+	if !ok {
+		return pb.SideType_JUDGE, dealerrors.New(dealerrors.NOT_A_PARTICIPANT, "User %s doesn't participate in deal %s", userID, dealID)
+	}
+	return role, nil
+}
+
+func canSeeComment(comment *CommentDB, userID string, role pb.SideType) bool {
+	if comment.Author == userID {
+		return true
+	}
+	switch comment.Visibility {
+	case pb.CommentVisibility_PARTIES:
+		return role != pb.SideType_JUDGE
+	case pb.CommentVisibility_JUDGES_ONLY:
+		return role == pb.SideType_JUDGE
+	}
+	return true
+}
+
+// commentVisible filters audience of {comment} events, so they don't reveal comments users can't see
+func commentVisible(comment *CommentDB) func(dealDoc *DealDocumentDB, userID string) bool {
+	return func(dealDoc *DealDocumentDB, userID string) bool {
+		role, ok := dealRole(dealDoc, userID)
+		return ok && canSeeComment(comment, userID, role)
+	}
+}
+
+// PostComment adds comment of user {userID} to the deal {dealID}, reply to {parentID} if it's not empty
+func (s *service) PostComment(ctx context.Context, userID, dealID, parentID, body string, visibility pb.CommentVisibility) (*pb.Comment, error) {
+	if err := requireTable(s.commentTable, "Comments"); err != nil {
+		return nil, err
+	}
+	body = strings.TrimSpace(body)
+	role, err := s.commentRole(ctx, userID, dealID)
+	if err != nil {
+		return nil, err
+	}
+	now := s.clock.Now()
+	comment := CommentDB{
+		ID:         primitive.NewObjectID(),
+		DealID:     dealID,
+		Author:     userID,
+		AuthorSide: role,
+		Visibility: visibility,
+		Body:       body,
+		Created:    now,
+		Updated:    now,
+		Version:    1,
+	}
+	comment.ThreadID = comment.ID.Hex()
+	if len(parentID) > 0 {
+		parent, err := GetCommentByIDDB(ctx, parentID, s.commentTable)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil || parent.DealID != dealID || !canSeeComment(parent, userID, role) {
+			return nil, dealerrors.New(dealerrors.NOT_FOUND, "Comment %s doesn't exist", parentID)
+		}
+		// Replies can't be seen wider than the thread they belong to
+		comment.ParentID = parentID
+		comment.ThreadID = parent.ThreadID
+		comment.Visibility = parent.Visibility
+	}
+	if (comment.Visibility == pb.CommentVisibility_PARTIES && role == pb.SideType_JUDGE) ||
+		(comment.Visibility == pb.CommentVisibility_JUDGES_ONLY && role != pb.SideType_JUDGE) {
+		return nil, dealerrors.New(dealerrors.PERMISSION_DENIED, "User %s can't post %s comments", userID, comment.Visibility.String())
+	}
+	_, err = CreateCommentDB(ctx, comment, s.commentTable)
+	if err != nil {
+		return nil, err
+	}
+	s.commentHub.publish(dealID)
+	s.emitVisibleDealEvent(ctx, EventCommentPosted, dealID, userID, map[string]string{"comment_id": comment.ID.Hex()}, commentVisible(&comment))
+	return convertComment(&comment, userID, role), nil
+}
+
+// EditComment changes body of comment {commentID}, previous body is kept in history
+func (s *service) EditComment(ctx context.Context, userID, commentID, body string) (*pb.Comment, error) {
+	if err := requireTable(s.commentTable, "Comments"); err != nil {
+		return nil, err
+	}
+	body = strings.TrimSpace(body)
+	comment, err := GetCommentByIDDB(ctx, commentID, s.commentTable)
+	if err != nil {
+		return nil, err
+	}
+	if comment == nil {
+		return nil, dealerrors.New(dealerrors.NOT_FOUND, "Comment %s doesn't exist", commentID)
+	}
+	if comment.Author != userID {
+		return nil, dealerrors.New(dealerrors.PERMISSION_DENIED, "Only author can edit comment %s", commentID)
+	}
+	if comment.Hidden {
+		return nil, dealerrors.New(dealerrors.FAILED_PRECONDITION, "Comment %s is hidden by moderation", commentID)
+	}
+	if comment.Body == body {
+		return convertComment(comment, userID, comment.AuthorSide), nil
+	}
+	comment.History = append(comment.History, CommentRevision{Body: comment.Body, Time: comment.Updated})
+	comment.Body = body
+	comment.Updated = s.clock.Now()
+	comment.Version++
+	if err := UpdateCommentDB(ctx, comment, s.commentTable); err != nil {
+		return nil, err
+	}
+	s.commentHub.publish(comment.DealID)
+	s.emitVisibleDealEvent(ctx, EventCommentChanged, comment.DealID, userID, map[string]string{"comment_id": comment.ID.Hex()}, commentVisible(comment))
+	return convertComment(comment, userID, comment.AuthorSide), nil
+}
+
+// FlagComment records complaint of user {userID} about comment {commentID} and hides it if needed
+func (s *service) FlagComment(ctx context.Context, userID, commentID, reason string) (*pb.Comment, error) {
+	if err := requireTable(s.commentTable, "Comments"); err != nil {
+		return nil, err
+	}
+	comment, err := GetCommentByIDDB(ctx, commentID, s.commentTable)
+	if err != nil {
+		return nil, err
+	}
+	if comment == nil {
+		return nil, dealerrors.New(dealerrors.NOT_FOUND, "Comment %s doesn't exist", commentID)
+	}
+	role, err := s.commentRole(ctx, userID, comment.DealID)
+	if err != nil {
+		return nil, err
+	}
+	if !canSeeComment(comment, userID, role) {
+		return nil, dealerrors.New(dealerrors.NOT_FOUND, "Comment %s doesn't exist", commentID)
+	}
+	if comment.Author == userID {
+		return nil, dealerrors.New(dealerrors.INVALID_ARGUMENT, "User can't flag their own comment")
+	}
+	for _, f := range comment.Flags {
+		if f.UserID == userID {
+			return convertComment(comment, userID, role), nil
+		}
+	}
+	comment.Flags = append(comment.Flags, ModerationFlag{UserID: userID, Reason: reason, Time: s.clock.Now()})
+	if role == pb.SideType_JUDGE || len(comment.Flags) >= commentHideFlags {
+		comment.Hidden = true
+	}
+	comment.Updated = s.clock.Now()
+	comment.Version++
+	if err := UpdateCommentDB(ctx, comment, s.commentTable); err != nil {
+		return nil, err
+	}
+	s.commentHub.publish(comment.DealID)
+	s.emitVisibleDealEvent(ctx, EventCommentChanged, comment.DealID, userID, map[string]string{"comment_id": comment.ID.Hex()}, commentVisible(comment))
+	return convertComment(comment, userID, role), nil
+}
+
+// ListComments returns page of deal comments visible to user {userID}, only of thread {threadID} if it's set
+func (s *service) ListComments(ctx context.Context, userID, dealID, threadID string, page, pageSize int) ([]*pb.Comment, error) {
+	if err := requireTable(s.commentTable, "Comments"); err != nil {
+		return nil, err
+	}
+	role, err := s.commentRole(ctx, userID, dealID)
+	if err != nil {
+		return nil, err
+	}
+	filter := commentsFilter(dealID, userID, role)
+	if len(threadID) > 0 {
+		filter = append(filter, bson.E{Key: "thread_id", Value: threadID})
+	}
+	skip, limit := pageBounds(page, pageSize)
+	comments, err := GetCommentsDB(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "created", Value: 1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit)), s.commentTable)
+	if err != nil {
+		return nil, err
+	}
+	res := []*pb.Comment{}
+	for _, c := range comments {
+		res = append(res, convertComment(c, userID, role))
+	}
+	return res, nil
+}
+
+// StreamComments sends comments of the deal changed after {since} and then every new change until {ctx} is done
+func (s *service) StreamComments(ctx context.Context, userID, dealID string, since time.Time, send func(*pb.Comment) error) error {
+	if err := requireTable(s.commentTable, "Comments"); err != nil {
+		return err
+	}
+	role, err := s.commentRole(ctx, userID, dealID)
+	if err != nil {
+		return err
+	}
+	notify := s.commentHub.subscribe(dealID)
+	defer s.commentHub.unsubscribe(dealID, notify)
+	ticker := time.NewTicker(commentPollInterval)
+	defer ticker.Stop()
+
+	// Changes with the same timestamp could come in different reads, so versions already sent are remembered
+	sent := make(map[string]int64)
+	for {
+		filter := append(commentsFilter(dealID, userID, role), bson.E{Key: "updated", Value: bson.D{{Key: "$gte", Value: since}}})
+		comments, err := GetCommentsDB(ctx, filter, options.Find().SetSort(bson.D{{Key: "updated", Value: 1}}), s.commentTable)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		for _, c := range comments {
+			if v, ok := sent[c.ID.Hex()]; ok && v >= c.Version {
+				continue
+			}
+			if err := send(convertComment(c, userID, role)); err != nil {
+				return err
+			}
+			sent[c.ID.Hex()] = c.Version
+			if c.Updated.After(since) {
+				since = c.Updated
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// convertComment converts comment for user {userID}, hidden comments keep the body only for author and judges
+func convertComment(c *CommentDB, userID string, role pb.SideType) *pb.Comment {
+	res := &pb.Comment{
+		Id:         c.ID.Hex(),
+		DealId:     c.DealID,
+		ParentId:   c.ParentID,
+		ThreadId:   c.ThreadID,
+		Author:     c.Author,
+		AuthorSide: c.AuthorSide,
+		Visibility: c.Visibility,
+		Body:       c.Body,
+		Hidden:     c.Hidden,
+		Created:    c.Created.Format(timeoutLayout),
+		Updated:    c.Updated.Format(timeoutLayout),
+		Version:    c.Version,
+	}
+	// Author sees own hidden comment and how many times it was flagged, but not who flagged it
+	judge := role == pb.SideType_JUDGE
+	if c.Hidden && !judge && c.Author != userID {
+		res.Body = ""
+		return res
+	}
+	for _, r := range c.History {
+		res.History = append(res.History, &pb.CommentRevision{Body: r.Body, Time: r.Time.Format(timeoutLayout)})
+	}
+	if judge || c.Author == userID {
+		res.FlagCount = int32(len(c.Flags))
+	}
+	if judge {
+		for _, f := range c.Flags {
+			res.Flags = append(res.Flags, &pb.ModerationFlag{UserId: f.UserID, Reason: f.Reason, Time: f.Time.Format(timeoutLayout)})
+		}
+	}
+	return res
+}
@@ -4,27 +4,40 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
 	"strconv"
 	"time"
 
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/DenysNahurnyi/deal/common/tracing"
+	"github.com/DenysNahurnyi/deal/common/utils"
 	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
 	"github.com/mongodb/mongo-go-driver/bson"
 	"github.com/mongodb/mongo-go-driver/bson/primitive"
 	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/options"
 )
 
 type UserDB struct {
-	Name          string             `bson:"name,omitempty"`
-	Surname       string             `bson:"surname,omitempty"`
-	Username      string             `bson:"username,omitempty"`
-	ID            primitive.ObjectID `bson:"_id,omitempty"`
-	DealDocs      []string           `bson:"deal_docs"`
-	Offerings     []string           `bson:"offerings"`
-	Accepted      []string           `bson:"accepted"`
-	Participating []string           `bson:"participating"`
-	DealResults   []string           `bson:"deal_results"`
-	IsJudge       bool               `bson:"is_judge"`
-	JudgeProfile  *JudgeProfile      `bson:"judge_profile"`
+	Name          string                `bson:"name,omitempty"`
+	Surname       string                `bson:"surname,omitempty"`
+	Username      string                `bson:"username,omitempty"`
+	ID            primitive.ObjectID    `bson:"_id,omitempty"`
+	DealDocs      []string              `bson:"deal_docs"`
+	Offerings     []string              `bson:"offerings"`
+	Accepted      []string              `bson:"accepted"`
+	Participating []string              `bson:"participating"`
+	DealResults   []string              `bson:"deal_results"`
+	IsJudge       bool                  `bson:"is_judge"`
+	JudgeProfile  *JudgeProfile         `bson:"judge_profile"`
+	Privacy       *PrivacySettings      `bson:"privacy,omitempty"`
+	Notifications *NotificationSettings `bson:"notifications,omitempty"`
+}
+
+// PrivacySettings is an object that defines what other users can see in user public profile
+type PrivacySettings struct {
+	HideDeals bool `bson:"hide_deals"`
 }
 
 // JudgeProfile is an object judge profile the stores in the DB
@@ -45,14 +58,30 @@ type Decision struct {
 type ParticipantDB struct {
 	ID       string `bson:"id,omitempty"`
 	Accepted bool   `bson:"accepted"`
+	PactHash string `bson:"pact_hash,omitempty"` // Hash of the pact terms at the moment of acceptance
 }
 
 // SideDB is an object of side that stores in the DB
 type SideDB struct {
 	Type         pb.SideType     `bson:"type"`
+	Members      int64           `bson:"members,omitempty"`
 	Participants []ParticipantDB `bson:"participants,omitempty"`
 }
 
+// capacity returns how many participants side needs, deals created before multi-party sides have one per side
+func (side SideDB) capacity() int {
+	if side.Members <= 0 {
+		return 1
+	}
+	return int(side.Members)
+}
+
+// isFull tells whether side has no free slots. Offered participants take slots before they accept,
+// so only declined or revoked offers free them
+func (side SideDB) isFull() bool {
+	return len(side.Participants) >= side.capacity()
+}
+
 // PactDB is an object of pact that stores in the DB
 type PactDB struct {
 	Content string `bson:"content,omitempty"`
@@ -60,6 +89,7 @@ type PactDB struct {
 	Blue    SideDB `bson:"blue,omitempty"`
 	Timeout string `bson:"timeout,omitempty"`
 	Version string `bson:"version,omitempty"`
+	Stake   int64  `bson:"stake,omitempty"`
 }
 
 // Status is an object of Status that stores in the DB
@@ -82,6 +112,7 @@ type DealDocumentDB struct {
 	Type         string             `bson:"type,omitempty"`
 	Completed    bool               `bson:"completed,omitempty"` // For now deal will be completed only when judge made his decision
 	JusticeCount int                `bson:"justice_count,omitempty"`
+	Signatures   []SignatureDB      `bson:"signatures,omitempty"` // Only appended by AddDealSignatureDB, UpdateDeal doesn't touch them
 }
 
 func (dealDoc DealDocumentDB) getCurrentPact() (PactDB, error) {
@@ -93,6 +124,86 @@ func (dealDoc DealDocumentDB) getCurrentPact() (PactDB, error) {
 	return PactDB{}, fmt.Errorf("Deal doesn't have pact for version %s", dealDoc.FinalVersion)
 }
 
+// getCurrentPactRef returns current pact that could be modified in place
+func (dealDoc *DealDocumentDB) getCurrentPactRef() (*PactDB, error) {
+	for i, pact := range dealDoc.Pacts {
+		if pact.Version == dealDoc.FinalVersion {
+			return &dealDoc.Pacts[i], nil
+		}
+	}
+	return nil, fmt.Errorf("Deal doesn't have pact for version %s", dealDoc.FinalVersion)
+}
+
+// creatorID returns id of user that created the deal, creator is always the first one on red side
+func (pact PactDB) creatorID() string {
+	if len(pact.Red.Participants) == 0 {
+		return ""
+	}
+	return pact.Red.Participants[0].ID
+}
+
+// participants returns participants of both red and blue sides
+func (pact PactDB) participants() []ParticipantDB {
+	return append(append([]ParticipantDB{}, pact.Red.Participants...), pact.Blue.Participants...)
+}
+
+// effectiveWinner returns winner of the deal taking blames into account
+func (dealDoc DealDocumentDB) effectiveWinner() string {
+	if dealDoc.Blamed != "Yes" {
+		return dealDoc.Winner
+	}
+	if dealDoc.Winner == "red" {
+		return "blue"
+	}
+	return "red"
+}
+
+// hasStatus checks whether deal ever had status {name}
+func (dealDoc DealDocumentDB) hasStatus(name string) bool {
+	for _, st := range dealDoc.Status {
+		if st.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// statusTime returns when deal got status {name} the last time
+func (dealDoc DealDocumentDB) statusTime(name string) (time.Time, bool) {
+	for i := len(dealDoc.Status) - 1; i >= 0; i-- {
+		if dealDoc.Status[i].Name == name {
+			return dealDoc.Status[i].Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// getSide returns pact side of type {sideType}, nil for the judge side
+func (pact *PactDB) getSide(sideType pb.SideType) *SideDB {
+	switch sideType {
+	case pb.SideType_RED:
+		return &pact.Red
+	case pb.SideType_BLUE:
+		return &pact.Blue
+	}
+	return nil
+}
+
+// findParticipant returns side and participant record of user {userID} in the pact
+func (pact PactDB) findParticipant(userID string) (pb.SideType, ParticipantDB, bool) {
+	for _, p := range pact.Red.Participants {
+		if p.ID == userID {
+			return pb.SideType_RED, p, true
+		}
+	}
+	for _, p := range pact.Blue.Participants {
+		if p.ID == userID {
+			return pb.SideType_BLUE, p, true
+		}
+	}
+	return pb.SideType_JUDGE, ParticipantDB{}, false
+}
+
 func (dealDoc DealDocumentDB) getStatus() (string, error) {
 	if len(dealDoc.Status) == 0 {
 		return "", errors.New("Status is empty")
@@ -105,23 +216,23 @@ func (dealDoc DealDocumentDB) getStatus() (string, error) {
 }
 
 // getSuccess counts success over all completed deals since their state can change
-func (user UserDB) getSuccess(ctx context.Context, dealTable *mongo.Collection) (int, error) {
+func (user UserDB) getSuccess(ctx context.Context, deals DealRepo) (int, error) {
 	// Go over all deals from deals_result, take each and get result from it
 	var success int
 	for _, dID := range user.DealResults {
-		dealSuccess, err := getUserSuccess(ctx, user.ID.Hex(), dID, dealTable)
+		dealSuccess, err := getUserSuccess(ctx, user.ID.Hex(), dID, deals)
 		if err != nil {
 			return 0, err
 		}
 		success += dealSuccess
 	}
-	fmt.Println("[Rating]: To count success for user " + user.ID.Hex() + " used " + strconv.Itoa(len(user.DealResults)) + " deals. Result: " + strconv.Itoa(success))
+	logging.Info(ctx, "To count success for user "+user.ID.Hex()+" used "+strconv.Itoa(len(user.DealResults))+" deals. Result: "+strconv.Itoa(success))
 	return success, nil
 }
 
 // getUserSuccess returns 2 or -2 points depending on what state of deal and status of blaming
-func getUserSuccess(ctx context.Context, userID, dealID string, dealTable *mongo.Collection) (int, error) {
-	deal, err := GetDealDocByIdDB(ctx, dealID, dealTable)
+func getUserSuccess(ctx context.Context, userID, dealID string, deals DealRepo) (int, error) {
+	deal, err := deals.GetByID(ctx, dealID)
 	if err != nil {
 		return 0, fmt.Errorf("Failed to get deal %s from DB, err: %v", dealID, err)
 	}
@@ -184,14 +295,45 @@ func getUserSuccess(ctx context.Context, userID, dealID string, dealTable *mongo
 	return redWon * isRed * notBlamed * 2, nil
 }
 
+// dealStats is a summary of user deal results
+type dealStats struct {
+	success   int
+	completed int
+	won       int
+	lost      int
+}
+
+// getDealStats counts success, won and lost deals over all completed deals since their state can change
+func (user UserDB) getDealStats(ctx context.Context, deals DealRepo) (dealStats, error) {
+	stats := dealStats{}
+	for _, dID := range user.DealResults {
+		dealSuccess, err := getUserSuccess(ctx, user.ID.Hex(), dID, deals)
+		if err != nil {
+			return dealStats{}, err
+		}
+		// Not completed deals (e.g. expired) give 0 points
+		if dealSuccess == 0 {
+			continue
+		}
+		stats.completed++
+		if dealSuccess > 0 {
+			stats.won++
+		} else {
+			stats.lost++
+		}
+		stats.success += dealSuccess
+	}
+	return stats, nil
+}
+
 // getSuccess counts justice over all completed deals since their state can change
-func (user UserDB) getJustice(ctx context.Context, dealTable *mongo.Collection) (int, error) {
+func (user UserDB) getJustice(ctx context.Context, dealRepo DealRepo) (int, error) {
 	// Go over all deals from deals_result, take each and get result from it
 	justice := 0
 	if user.JudgeProfile == nil || !user.IsJudge {
 		return 0, errors.New("Can't count justice of common user")
 	}
-	deals, err := GetCompletedDeals(ctx, dealTable)
+	deals, err := dealRepo.GetCompleted(ctx)
 	if err != nil {
 		return 0, fmt.Errorf("Can't get completed deals from DB")
 	}
@@ -251,6 +393,17 @@ func ConvertUserToDB(user *pb.User) (*UserDB, error) {
 		Participating: user.GetParticipating(),
 		IsJudge:       user.GetIsJudge(),
 	}
+	if user.GetPrivacy() != nil {
+		userResp.Privacy = &PrivacySettings{
+			HideDeals: user.GetPrivacy().GetHideDeals(),
+		}
+	}
+	if user.GetNotifications() != nil {
+		userResp.Notifications = &NotificationSettings{
+			Muted: user.GetNotifications().GetMuted(),
+			Email: user.GetNotifications().GetEmail(),
+		}
+	}
 	if len(user.GetId()) > 0 {
 		userID, err := primitive.ObjectIDFromHex(user.GetId())
 		if err != nil {
@@ -292,6 +445,17 @@ func ConvertDBToUser(user *UserDB) (*pb.User, error) {
 		Id:            user.ID.Hex(),
 		IsJudge:       user.IsJudge,
 	}
+	if user.Privacy != nil {
+		userResp.Privacy = &pb.PrivacySettings{
+			HideDeals: user.Privacy.HideDeals,
+		}
+	}
+	if user.Notifications != nil {
+		userResp.Notifications = &pb.NotificationSettings{
+			Muted: user.Notifications.Muted,
+			Email: user.Notifications.Email,
+		}
+	}
 	if user.JudgeProfile != nil {
 		userResp.JudgeProfile = &pb.JudgeProfile{
 			Propositions:   user.JudgeProfile.Propositions,
@@ -332,6 +496,12 @@ func (u *UserDB) toMongoFormat() bson.D {
 		es = append(es, bson.E{Key: "judge_profile.propositions", Value: u.JudgeProfile.Propositions})
 		es = append(es, bson.E{Key: "judge_profile.decisions", Value: u.JudgeProfile.Decisions})
 	}
+	if u.Privacy != nil {
+		es = append(es, bson.E{Key: "privacy", Value: u.Privacy})
+	}
+	if u.Notifications != nil {
+		es = append(es, bson.E{Key: "notifications", Value: u.Notifications})
+	}
 	return es
 }
 
@@ -367,18 +537,22 @@ func (dd *DealDocumentDB) toMongoFormat() bson.D {
 }
 
 func CreateUserDB(ctx context.Context, user UserDB, table *mongo.Collection) (string, error) {
+	ctx, span := tracing.StartDB(ctx, "CreateUserDB", table)
+	defer span.End()
 	res, err := table.InsertOne(ctx, user)
 	if err != nil {
-		fmt.Println("Error creating user in mongo: ", err)
+		logging.Error(ctx, "Error creating user in mongo", "err", err)
 	}
 	return res.InsertedID.(primitive.ObjectID).Hex(), err
 }
 
 // GetUserByIDDB returns user from DB by it's id
 func GetUserByIDDB(ctx context.Context, userId string, table *mongo.Collection) (*UserDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetUserByIDDB", table)
+	defer span.End()
 	userIDDB, err := primitive.ObjectIDFromHex(userId)
 	if err != nil {
-		fmt.Println("Error creating object id to get user: ", err)
+		logging.Error(ctx, "Error creating object id to get user", "err", err)
 		return nil, err
 	}
 	userDB := &UserDB{}
@@ -388,7 +562,7 @@ func GetUserByIDDB(ctx context.Context, userId string, table *mongo.Collection)
 		if err.Error() == "mongo: no documents in result" {
 			return nil, nil
 		}
-		fmt.Println("Error getting user from mongo: ", err)
+		logging.Error(ctx, "Error getting user from mongo", "err", err)
 		return nil, err
 	}
 
@@ -397,6 +571,8 @@ func GetUserByIDDB(ctx context.Context, userId string, table *mongo.Collection)
 
 // GetJudges returns all judges (users that has `isJudge` property true)
 func GetJudges(ctx context.Context, table *mongo.Collection) ([]*UserDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetJudges", table)
+	defer span.End()
 	judges := []*UserDB{}
 
 	cursor, err := table.Find(ctx, bson.D{{Key: "is_judge", Value: true}})
@@ -405,7 +581,7 @@ func GetJudges(ctx context.Context, table *mongo.Collection) ([]*UserDB, error)
 	for cursor.Next(ctx) {
 		j := &UserDB{}
 		if err := cursor.Decode(j); err != nil {
-			fmt.Println("Error getting judges from mongo: ", err)
+			logging.Error(ctx, "Error getting judges from mongo", "err", err)
 			return nil, err
 		}
 		judges = append(judges, j)
@@ -415,7 +591,7 @@ func GetJudges(ctx context.Context, table *mongo.Collection) ([]*UserDB, error)
 		if err.Error() == "mongo: no documents in result" {
 			return nil, nil
 		}
-		fmt.Println("Error getting judges from mongo: ", err)
+		logging.Error(ctx, "Error getting judges from mongo", "err", err)
 		return nil, err
 	}
 
@@ -423,9 +599,11 @@ func GetJudges(ctx context.Context, table *mongo.Collection) ([]*UserDB, error)
 }
 
 func GetDealDocByIdDB(ctx context.Context, dealDocID string, table *mongo.Collection) (*DealDocumentDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetDealDocByIdDB", table)
+	defer span.End()
 	dealDocIDDB, err := primitive.ObjectIDFromHex(dealDocID)
 	if err != nil {
-		fmt.Println("Error creating object id to get user: ", err)
+		logging.Error(ctx, "Error creating object id to get user", "err", err)
 		return nil, err
 	}
 	dealDocDB := &DealDocumentDB{}
@@ -435,17 +613,19 @@ func GetDealDocByIdDB(ctx context.Context, dealDocID string, table *mongo.Collec
 		if err.Error() == "mongo: no documents in result" {
 			return nil, nil
 		}
-		fmt.Println("Error getting deal document from mongo: ", err)
+		logging.Error(ctx, "Error getting deal document from mongo", "err", err)
 		return nil, err
 	}
 	return dealDocDB, err
 }
 
 func GetCompletedDeals(ctx context.Context, table *mongo.Collection) ([]*DealDocumentDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetCompletedDeals", table)
+	defer span.End()
 	// Get all deals is no watching deals
 	cursor, err := table.Find(ctx, bson.D{{Key: "completed", Value: true}})
 	if err != nil {
-		fmt.Println("Error getting deals from DB: ", err)
+		logging.Error(ctx, "Error getting deals from DB", "err", err)
 		return nil, err
 	}
 	defer cursor.Close(ctx)
@@ -454,7 +634,7 @@ func GetCompletedDeals(ctx context.Context, table *mongo.Collection) ([]*DealDoc
 	for cursor.Next(ctx) {
 		d := &DealDocumentDB{}
 		if err := cursor.Decode(d); err != nil {
-			fmt.Println("Error getting deals from DB: ", err)
+			logging.Error(ctx, "Error getting deals from DB", "err", err)
 			return nil, err
 		}
 		deals = append(deals, d)
@@ -462,8 +642,8 @@ func GetCompletedDeals(ctx context.Context, table *mongo.Collection) ([]*DealDoc
 	return deals, err
 }
 
-func GetDealDocByIdDBConvert(ctx context.Context, dealDocID string, table *mongo.Collection) (*pb.DealDocument, error) {
-	dealDocDB, err := GetDealDocByIdDB(ctx, dealDocID, table)
+func GetDealDocByIdDBConvert(ctx context.Context, dealDocID string, deals DealRepo) (*pb.DealDocument, error) {
+	dealDocDB, err := deals.GetByID(ctx, dealDocID)
 	if err != nil {
 		return nil, err
 	}
@@ -489,6 +669,7 @@ func GetDealDocByIdDBConvert(ctx context.Context, dealDocID string, table *mongo
 			participants = append(participants, &pb.Participant{
 				Id:       partID,
 				Accepted: partAcceptance,
+				PactHash: judgeParticipant.PactHash,
 			})
 		}
 		dealDocumentRes.Judge = &pb.Side{
@@ -504,26 +685,29 @@ func GetDealDocByIdDBConvert(ctx context.Context, dealDocID string, table *mongo
 			pactF := &pb.Pact{
 				Content: pact.Content,
 				Red: &pb.Side{
-					Members: int64(len(pact.Red.Participants)),
+					Members: int64(pact.Red.capacity()),
 					Side:    pb.SideType_RED,
 				},
 				Blue: &pb.Side{
-					Members: int64(len(pact.Blue.Participants)),
+					Members: int64(pact.Blue.capacity()),
 					Side:    pb.SideType_BLUE,
 				},
 				Timeout: pact.Timeout,
 				Version: pact.Version,
+				Stake:   pact.Stake,
 			}
 			for _, redParticipant := range pact.Red.Participants {
 				pactF.Red.Participants = append(pactF.Red.Participants, &pb.Participant{
 					Id:       redParticipant.ID,
 					Accepted: redParticipant.Accepted,
+					PactHash: redParticipant.PactHash,
 				})
 			}
 			for _, blueParticipant := range pact.Blue.Participants {
 				pactF.Blue.Participants = append(pactF.Blue.Participants, &pb.Participant{
 					Id:       blueParticipant.ID,
 					Accepted: blueParticipant.Accepted,
+					PactHash: blueParticipant.PactHash,
 				})
 			}
 			dealDocumentRes.Pacts[pact.Version] = pactF
@@ -534,9 +718,11 @@ func GetDealDocByIdDBConvert(ctx context.Context, dealDocID string, table *mongo
 
 // DeleteUserByIDDB deletes user `userId` from DB table `table`
 func DeleteUserByIDDB(ctx context.Context, userId string, table *mongo.Collection) (*UserDB, error) {
+	ctx, span := tracing.StartDB(ctx, "DeleteUserByIDDB", table)
+	defer span.End()
 	userIDDB, err := primitive.ObjectIDFromHex(userId)
 	if err != nil {
-		fmt.Println("Error creating object id to get user: ", err)
+		logging.Error(ctx, "Error creating object id to get user", "err", err)
 		return nil, err
 	}
 	userDB := &UserDB{}
@@ -546,7 +732,7 @@ func DeleteUserByIDDB(ctx context.Context, userId string, table *mongo.Collectio
 		if err.Error() == "mongo: no documents in result" {
 			return nil, nil
 		}
-		fmt.Println("Error getting user from mongo: ", err)
+		logging.Error(ctx, "Error getting user from mongo", "err", err)
 		return nil, err
 	}
 
@@ -555,24 +741,59 @@ func DeleteUserByIDDB(ctx context.Context, userId string, table *mongo.Collectio
 
 // GetUserByUsernameDB returns UserDB by username if it exists
 func GetUserByUsernameDB(ctx context.Context, username string, table *mongo.Collection) (*UserDB, string, error) {
+	ctx, span := tracing.StartDB(ctx, "GetUserByUsernameDB", table)
+	defer span.End()
 	userDB := &UserDB{}
 	err := table.FindOne(ctx, bson.D{{Key: "username", Value: username}}).Decode(userDB)
 	if err != nil {
 		if err.Error() == "mongo: no documents in result" {
 			return nil, "", nil
 		}
-		fmt.Println("Error getting user from mongo: ", err)
+		logging.Error(ctx, "Error getting user from mongo", "err", err)
 		return nil, "", err
 	}
 
 	return userDB, userDB.ID.Hex(), err
 }
 
+// SearchUsersByUsernameDB returns users whose username starts with {prefix}, ignoring case
+func SearchUsersByUsernameDB(ctx context.Context, prefix string, skip, limit int64, table *mongo.Collection) ([]*UserDB, error) {
+	ctx, span := tracing.StartDB(ctx, "SearchUsersByUsernameDB", table)
+	defer span.End()
+	filter := bson.D{{Key: "username", Value: primitive.Regex{
+		Pattern: "^" + regexp.QuoteMeta(prefix),
+		Options: "i",
+	}}}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "username", Value: 1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+	cursor, err := table.Find(ctx, filter, opts)
+	if err != nil {
+		logging.Error(ctx, "Error searching users in mongo", "err", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	users := []*UserDB{}
+	for cursor.Next(ctx) {
+		u := &UserDB{}
+		if err := cursor.Decode(u); err != nil {
+			logging.Error(ctx, "Error searching users in mongo", "err", err)
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
 // UpdateUserDB updates user in DB using {userID} to find it and user to update data
 func UpdateUserDB(ctx context.Context, userID string, user *UserDB, table *mongo.Collection) error {
+	ctx, span := tracing.StartDB(ctx, "UpdateUserDB", table)
+	defer span.End()
 	userIDDB, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		fmt.Println("Error creating object id to get user: ", err)
+		logging.Error(ctx, "Error creating object id to get user", "err", err)
 		return err
 	}
 	_, err = table.UpdateOne(ctx,
@@ -580,79 +801,65 @@ func UpdateUserDB(ctx context.Context, userID string, user *UserDB, table *mongo
 		bson.D{{"$set", user.toMongoFormat()}},
 	)
 	if err != nil {
-		fmt.Println("Error updating user in mongo: ", err)
+		logging.Error(ctx, "Error updating user in mongo", "err", err)
 	}
 	return err
 }
 
 // AcceptDealDocDB finds deal doc `dealDocID` in DB, and updates `Accepted` status to true of user `userID`, user should be on `side` side
-func AcceptDealDocDB(ctx context.Context, dealDocID, userID string, side pb.SideType, dealDocTable, userTable *mongo.Collection) error {
-	dealDocIDDB, err := primitive.ObjectIDFromHex(dealDocID)
+func AcceptDealDocDB(ctx context.Context, dealDocID, userID string, side pb.SideType, deals DealRepo, users UserRepo) error {
+	user, err := users.GetByID(ctx, userID)
 	if err != nil {
-		fmt.Println("Error creating object id to get deal document: ", err)
+		err = fmt.Errorf("Failed to get user by %q id: %v", userID, err)
+		logging.Error(ctx, "Failed to accept deal", "err", err)
 		return err
 	}
-
-	user, err := GetUserByIDDB(ctx, userID, userTable)
-	if err != nil || len(user.Username) == 0 {
-		err = fmt.Errorf("Failed to get user by %q id", userID)
-		fmt.Println("[ERROR]: ", err.Error())
-		return err
+	if user == nil || len(user.Username) == 0 {
+		return dealerrors.New(dealerrors.USER_NOT_FOUND, "User %q doesn't exist", userID)
 	}
 	userAccepted, err := userAcceptDeal(user, dealDocID)
 	if err != nil {
-		fmt.Println("[ERROR]: ", "Failed to accept deal for user "+userID, err.Error())
+		logging.Error(ctx, "Failed to accept deal for user "+userID, "err", err)
 		return err
 	}
-	err = UpdateUserDB(ctx, userID, userAccepted, userTable)
+	err = users.Update(ctx, userID, userAccepted)
 	if err != nil {
-		fmt.Println("[ERROR]: ", "Failed to update user "+userID, err.Error())
+		logging.Error(ctx, "Failed to update user "+userID, "err", err)
 		return err
 	}
 
-	dealDoc, err := GetDealDocByIdDB(ctx, dealDocID, dealDocTable)
+	dealDoc, err := deals.GetByID(ctx, dealDocID)
 	if err != nil {
-		fmt.Println("Failed to get deal document: ", err)
+		logging.Error(ctx, "Failed to get deal document", "err", err)
 		return err
 	}
 	dealDocAccepted, err := acceptDealForSide(dealDoc, side, userID)
 	if err != nil {
-		fmt.Println("Failed to accept deal: ", err)
+		logging.Error(ctx, "Failed to accept deal", "err", err)
 		return err
 	}
-	_, err = dealDocTable.UpdateOne(ctx,
-		bson.D{{Key: "_id", Value: dealDocIDDB}},
-		bson.D{{"$set", dealDocAccepted.toMongoFormat()}},
-	)
+	err = deals.Update(ctx, *dealDocAccepted)
 	if err != nil {
-		fmt.Println("Error updating deal document in mongo: ", err)
+		logging.Error(ctx, "Error updating deal document in mongo", "err", err)
 	}
 	return err
 }
 
 // OfferDealDocDB finds deal doc `dealDocID` in DB, and add user `userID` to the `side` side
-func OfferDealDocDB(ctx context.Context, dealDocID, userID string, side pb.SideType, table *mongo.Collection) error {
-	dealDocIDDB, err := primitive.ObjectIDFromHex(dealDocID)
-	if err != nil {
-		fmt.Println("Error creating object id to get deal document: ", err)
-		return err
-	}
-	dealDoc, err := GetDealDocByIdDB(ctx, dealDocID, table)
+func OfferDealDocDB(ctx context.Context, dealDocID, userID string, side pb.SideType, deals DealRepo) error {
+	dealDoc, err := deals.GetByID(ctx, dealDocID)
 	if err != nil {
-		fmt.Println("Failed to get deal document: ", err)
+		logging.Error(ctx, "Failed to get deal document", "err", err)
 		return err
 	}
 	dealDoc, err = offerDealForSide(dealDoc, side, userID)
 	if err != nil {
-		fmt.Println("Failed to offer the deal: ", err)
+		logging.Error(ctx, "Failed to offer the deal", "err", err)
 		return err
 	}
-	_, err = table.UpdateOne(ctx,
-		bson.D{{Key: "_id", Value: dealDocIDDB}},
-		bson.D{{"$set", dealDoc.toMongoFormat()}},
-	)
+	err = deals.Update(ctx, *dealDoc)
 	if err != nil {
-		fmt.Println("Error updating deal document in mongo: ", err)
+		logging.Error(ctx, "Error updating deal document in mongo", "err", err)
 	}
 	return err
 }
@@ -669,7 +876,6 @@ func offerDealForSide(dealDoc *DealDocumentDB, side pb.SideType, userID string)
 		}
 		if pact == nil {
 			err = errors.New("Deal document is invalid, can't find needed pact")
-			fmt.Println("[ERROR]: ", err.Error())
 			return nil, err
 		}
 		//Find side in pact
@@ -682,7 +888,6 @@ func offerDealForSide(dealDoc *DealDocumentDB, side pb.SideType, userID string)
 		}
 		if pactSide == nil {
 			err = fmt.Errorf("Invalid side %q", side)
-			fmt.Println("[ERROR]: ", err.Error())
 			return nil, err
 		}
 		//Create new participant `userID` on `pactSide`
@@ -715,7 +920,6 @@ func acceptDealForSide(dealDoc *DealDocumentDB, side pb.SideType, userID string)
 		}
 		if pact == nil {
 			err = errors.New("Deal document is invalid, can't find needed pact")
-			fmt.Println("[ERROR]: ", err.Error())
 			return nil, err
 		}
 		//Find side in pact
@@ -727,56 +931,52 @@ func acceptDealForSide(dealDoc *DealDocumentDB, side pb.SideType, userID string)
 			pactSide = &pact.Blue
 		}
 		if pactSide == nil {
-			err = fmt.Errorf("Invalid side %q", side)
-			err = fmt.Errorf("Failed to find user %q on %q side", userID, side)
-			fmt.Println("[ERROR]: ", err.Error())
+			err = dealerrors.New(dealerrors.NOT_A_PARTICIPANT, "Failed to find user %q on %q side", userID, side)
 			return nil, err
 		}
 		//Find participant in side and accept
 		for i, participant := range pactSide.Participants {
 			if participant.ID == userID {
 				if participant.Accepted == true {
-					err = fmt.Errorf("Failed to accept, user %q already accepted this deal", userID)
-					fmt.Println("[ERROR]: ", err.Error())
+					err = dealerrors.New(dealerrors.ALREADY_ACCEPTED, "Failed to accept, user %q already accepted this deal", userID)
 					return nil, err
 				}
 				pactSide.Participants[i].Accepted = true
+				pactSide.Participants[i].PactHash = pact.hash()
 				return dealDoc, nil
 			}
 		}
-		err = fmt.Errorf("Failed to find user %q on %q side", userID, side)
-		fmt.Println("[ERROR]: ", err.Error())
+		err = dealerrors.New(dealerrors.NOT_A_PARTICIPANT, "Failed to find user %q on %q side", userID, side)
 		return nil, err
 	} else {
 		// For judge
 		//Find judge in judge side and accept
 		if len(dealDoc.Judge.Participants) == 0 {
 			err = fmt.Errorf("No judge side exist in this deal document")
-			fmt.Println("[ERROR]: ", err.Error())
 			return nil, err
 		}
 		for i, judge := range dealDoc.Judge.Participants {
 			if judge.ID == userID {
 				if judge.Accepted == true {
-					err = fmt.Errorf("Failed to accept, judge %q already accepted this deal", userID)
-					fmt.Println("[ERROR]: ", err.Error())
+					err = dealerrors.New(dealerrors.ALREADY_ACCEPTED, "Failed to accept, judge %q already accepted this deal", userID)
 					return nil, err
 				}
 				dealDoc.Judge.Participants[i].Accepted = true
 				return dealDoc, nil
 			}
 		}
-		err = fmt.Errorf("Failed to judge %q on %q side", userID, side)
-		fmt.Println("[ERROR]: ", err.Error())
+		err = dealerrors.New(dealerrors.NOT_A_PARTICIPANT, "Failed to find judge %q on %q side", userID, side)
 		return nil, err
 	}
 }
 
 // CreateDealDocumentDB create deal dcoument in the DB
 func CreateDealDocumentDB(ctx context.Context, dealDocument DealDocumentDB, table *mongo.Collection) (string, error) {
+	ctx, span := tracing.StartDB(ctx, "CreateDealDocumentDB", table)
+	defer span.End()
 	res, err := table.InsertOne(ctx, dealDocument)
 	if err != nil {
-		fmt.Println("Error creating deal document in mongo: ", err)
+		logging.Error(ctx, "Error creating deal document in mongo", "err", err)
 	}
 	return res.InsertedID.(primitive.ObjectID).Hex(), err
 }
@@ -789,6 +989,9 @@ func userAcceptDeal(user *UserDB, dealID string) (*UserDB, error) {
 		err = fmt.Errorf("Invalid user in input")
 		return nil, err
 	}
+	if utils.StringInSlice(dealID, user.Accepted) {
+		return nil, dealerrors.New(dealerrors.ALREADY_ACCEPTED, "User already accepted deal %q", dealID)
+	}
 	initOfferLen := len(user.Offerings)
 	for i, offerID := range user.Offerings {
 		if offerID == dealID {
@@ -797,7 +1000,7 @@ func userAcceptDeal(user *UserDB, dealID string) (*UserDB, error) {
 		}
 	}
 	if initOfferLen == len(user.Offerings) {
-		err = fmt.Errorf("Failed to find offer %q in user offers", dealID)
+		err = dealerrors.New(dealerrors.NO_OFFER, "Failed to find offer %q in user offers", dealID)
 		return nil, err
 	}
 	resUser.Accepted = append(user.Accepted, dealID)
@@ -805,33 +1008,32 @@ func userAcceptDeal(user *UserDB, dealID string) (*UserDB, error) {
 }
 
 // CheckToWatchDeal checks whether it's needed to send deal `dealID` to the watcher to watch it's timeout
-func CheckToWatchDeal(ctx context.Context, dealDocID string, dealDocTable *mongo.Collection) (bool, error) {
+func CheckToWatchDeal(ctx context.Context, dealDocID string, deals DealRepo) (bool, error) {
 	// Get deal document
-	dealDoc, err := GetDealDocByIdDB(ctx, dealDocID, dealDocTable)
+	dealDoc, err := deals.GetByID(ctx, dealDocID)
 	if err != nil {
-		fmt.Println("Failed to get deal document: ", err)
+		logging.Error(ctx, "Failed to get deal document", "err", err)
 		return false, err
 	}
 	// Check whether deal document accepted by everyone
 	isDealDocAcceptedByUsers, err := isDealDocumentAcceptedByUsers(dealDoc)
 	if err != nil {
-		fmt.Println("Failed to check acceptance of deal document: ", err)
+		logging.Error(ctx, "Failed to check acceptance of deal document", "err", err)
 		return false, err
 	}
 	return isDealDocAcceptedByUsers, err
 }
 
-// JudgeAcceptDeal sets judge (only one possible) to the deal judge and update deal status
-func JudgeAcceptDeal(ctx context.Context, judgeID, dealID string, dealDocTable *mongo.Collection) error {
+// JudgeAcceptDeal sets judge (only one possible) to the deal judge and update deal status at {now}
+func JudgeAcceptDeal(ctx context.Context, judgeID, dealID string, now time.Time, deals DealRepo) error {
 	// Get deal document
-	dealDocIDDB, err := primitive.ObjectIDFromHex(dealID)
+	deal, err := deals.GetByID(ctx, dealID)
 	if err != nil {
-		fmt.Println("Error creating object id to get deal document: ", err)
+		logging.Error(ctx, "Failed to get deal "+dealID, "err", err)
 		return err
 	}
-	deal, err := GetDealDocByIdDB(ctx, dealID, dealDocTable)
+	pact, err := deal.getCurrentPact()
 	if err != nil {
-		fmt.Println("Failed to get deal "+dealID+": ", err)
 		return err
 	}
 	deal.Judge = SideDB{
@@ -840,40 +1042,40 @@ func JudgeAcceptDeal(ctx context.Context, judgeID, dealID string, dealDocTable *
 			ParticipantDB{
 				ID:       judgeID,
 				Accepted: true,
+				PactHash: pact.hash(),
 			},
 		},
 	}
-	_, err = dealDocTable.UpdateOne(ctx,
-		bson.D{{Key: "_id", Value: dealDocIDDB}},
-		bson.D{{"$set", deal.toMongoFormat()}},
-	)
+	err = deals.Update(ctx, *deal)
 	if err != nil {
 		return fmt.Errorf("Failed to update deal %s judge %s, err: %v", dealID, judgeID, err)
 	}
-	err = UpdateDealStatus(ctx, dealID, "ALL_ACCEPTED", dealDocTable)
+	err = deals.UpdateStatus(ctx, dealID, "ALL_ACCEPTED", now)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to update deal "+dealID+" status: ", err)
+		logging.Error(ctx, "Failed to update deal "+dealID+" status", "err", err)
 		return err
 	}
 	return err
 }
 
 // UpdateDealStatus updates deal document `dealDocID` status to `status`
-func UpdateDealStatus(ctx context.Context, dealDocID string, status string, dealDocTable *mongo.Collection) error {
+func UpdateDealStatus(ctx context.Context, dealDocID string, status string, at time.Time, dealDocTable *mongo.Collection) error {
+	ctx, span := tracing.StartDB(ctx, "UpdateDealStatus", dealDocTable)
+	defer span.End()
 	// Get deal document
 	dealDocIDDB, err := primitive.ObjectIDFromHex(dealDocID)
 	if err != nil {
-		fmt.Println("Error creating object id to get deal document: ", err)
+		logging.Error(ctx, "Error creating object id to get deal document", "err", err)
 		return err
 	}
 	deal, err := GetDealDocByIdDB(ctx, dealDocID, dealDocTable)
 	if err != nil {
-		fmt.Println("Failed to get deal "+dealDocID+": ", err)
+		logging.Error(ctx, "Failed to get deal "+dealDocID, "err", err)
 		return err
 	}
 	deal.Status = append(deal.Status, Status{
 		Name: status,
-		Time: time.Now(),
+		Time: at,
 	})
 	_, err = dealDocTable.UpdateOne(ctx,
 		bson.D{{Key: "_id", Value: dealDocIDDB}},
@@ -884,13 +1086,15 @@ func UpdateDealStatus(ctx context.Context, dealDocID string, status string, deal
 
 // UpdateDeal updates deal document `dealDocID`
 func UpdateDeal(ctx context.Context, dealDoc DealDocumentDB, dealDocTable *mongo.Collection) error {
+	ctx, span := tracing.StartDB(ctx, "UpdateDeal", dealDocTable)
+	defer span.End()
 	// Get deal document
 	_, err := GetDealDocByIdDB(ctx, dealDoc.ID.Hex(), dealDocTable)
 	if err != nil {
-		fmt.Println("Failed to get deal "+dealDoc.ID.Hex()+", error: ", err)
+		logging.Error(ctx, "Failed to get deal "+dealDoc.ID.Hex(), "err", err)
 		return err
 	}
-	fmt.Printf("Trying to update deal to blamed status %s and blameID %s\n", dealDoc.Blamed, dealDoc.BlameID)
+	logging.Debug(ctx, "Trying to update deal to blamed status", "blamed", dealDoc.Blamed, "blame", dealDoc.BlameID)
 	_, err = dealDocTable.UpdateOne(ctx,
 		bson.D{{Key: "_id", Value: dealDoc.ID}},
 		bson.D{{"$set", dealDoc.toMongoFormat()}},
@@ -898,8 +1102,8 @@ func UpdateDeal(ctx context.Context, dealDoc DealDocumentDB, dealDocTable *mongo
 	return err
 }
 
-// MakeDecision appends to judge decisions deal {dealDocID} with winner and removes deal {dealDocID} from {participatings}
-func MakeDecision(ctx context.Context, judge *UserDB, dealDocID, winner string, userTable *mongo.Collection) error {
+// MakeDecision appends to judge decisions deal {dealDocID} with winner made at {now} and removes deal {dealDocID} from {participatings}
+func MakeDecision(ctx context.Context, judge *UserDB, dealDocID, winner string, now time.Time, users UserRepo) error {
 	// Get deal document
 	dealIndex := -1
 
@@ -910,41 +1114,36 @@ func MakeDecision(ctx context.Context, judge *UserDB, dealDocID, winner string,
 		}
 	}
 	if dealIndex == -1 {
-		fmt.Println("{DEBUG}", "Judge "+judge.ID.Hex()+" doesn't participate in "+dealDocID+" deal")
-		return errors.New("Judge " + judge.ID.Hex() + " doesn't participate in " + dealDocID + " deal")
+		logging.Debug(ctx, "Judge "+judge.ID.Hex()+" doesn't participate in "+dealDocID+" deal")
+		return dealerrors.New(dealerrors.NOT_A_PARTICIPANT, "Judge %s doesn't participate in %s deal", judge.ID.Hex(), dealDocID)
 	}
 	judge.JudgeProfile.Decisions = append(judge.JudgeProfile.Decisions, Decision{
 		DealID: dealDocID,
 		Winner: winner,
-		When:   time.Now().String(),
+		When:   now.UTC().Format(timeoutLayout),
 	})
 	judge.JudgeProfile.Participatings = append(judge.JudgeProfile.Participatings[:dealIndex], judge.JudgeProfile.Participatings[dealIndex+1:]...)
-	_, err := userTable.UpdateOne(ctx,
-		bson.D{{Key: "_id", Value: judge.ID}},
-		bson.D{{"$set", judge.toMongoFormat()}},
-	)
-	return err
+	return users.Update(ctx, judge.ID.Hex(), judge)
 }
 
-// SetDealWinner sets deal {dealDocID} winner and updates it's status to WINNER_SET
-func SetDealWinner(ctx context.Context, dealDocID, winner string, dealDocTable, userTable *mongo.Collection) error {
-	// Get deal document
-	dealDocIDDB, err := primitive.ObjectIDFromHex(dealDocID)
-	if err != nil {
-		fmt.Println("Error creating object id to get deal document: ", err)
-		return err
+// SetDealWinner sets deal {dealDocID} winner ("red" or "blue") and updates it's status to WINNER_SET at {now}
+func SetDealWinner(ctx context.Context, dealDocID, winner string, now time.Time, deals DealRepo, users UserRepo) error {
+	// Requests are validated, but winner decides where stakes go, so it's checked before anything is written
+	if winner != "red" && winner != "blue" {
+		return dealerrors.Invalid([]*pb.FieldViolation{{Field: "winner", Description: "Must be one of: red, blue"}})
 	}
-	deal, err := GetDealDocByIdDB(ctx, dealDocID, dealDocTable)
+	// Get deal document
+	deal, err := deals.GetByID(ctx, dealDocID)
 	if err != nil {
-		fmt.Println("Failed to get deal "+dealDocID+": ", err)
+		logging.Error(ctx, "Failed to get deal "+dealDocID, "err", err)
 		return err
 	}
 	if deal.Completed {
-		return fmt.Errorf("Deal %s already completed, can't change decision", dealDocID)
+		return dealerrors.New(dealerrors.INVALID_TRANSITION, "Deal %s already completed, can't change decision", dealDocID)
 	}
 	deal.Status = append(deal.Status, Status{
 		Name: "WINNER_SET",
-		Time: time.Now(),
+		Time: now,
 	})
 	deal.Blamed = "No"
 	deal.Winner = winner
@@ -957,20 +1156,16 @@ func SetDealWinner(ctx context.Context, dealDocID, winner string, dealDocTable,
 	if len(judgeID) == 0 {
 		return fmt.Errorf("Invalid data in the deal %s, judge id can't be empty", dealDocID)
 	}
-	judge, err := GetUserByIDDB(ctx, judgeID, userTable)
+	judge, err := users.GetByID(ctx, judgeID)
 	if err != nil {
 		return fmt.Errorf("Failed to get judge %s from DB, err: %v", judgeID, err)
 	}
-	justiceCount, err := judge.getJustice(ctx, dealDocTable)
+	justiceCount, err := judge.getJustice(ctx, deals)
 	if err != nil {
 		return fmt.Errorf("Failed to get judge %s justice count, err: %v", judgeID, err)
 	}
 	deal.JusticeCount = justiceCount
-	_, err = dealDocTable.UpdateOne(ctx,
-		bson.D{{Key: "_id", Value: dealDocIDDB}},
-		bson.D{{"$set", deal.toMongoFormat()}},
-	)
-	return err
+	return deals.Update(ctx, *deal)
 }
 
 func isDealDocumentAcceptedByUsers(dealDoc *DealDocumentDB) (isDealDocAccepted bool, err error) {
@@ -982,14 +1177,11 @@ func isDealDocumentAcceptedByUsers(dealDoc *DealDocumentDB) (isDealDocAccepted b
 	}
 	if pact == nil {
 		err = errors.New("Deal document is invalid, can't find needed pact")
-		fmt.Println("[ERROR]: ", err.Error())
 		return false, err
 	}
-	// Check blue side
-	if len(pact.Blue.Participants) == 0 {
-		err = fmt.Errorf("Deal document has no participants on Blue side")
-		fmt.Println("[ERROR]: ", err.Error())
-		return false, err
+	// Check blue side, it has to be full before deal could start
+	if !pact.Blue.isFull() {
+		return false, nil
 	}
 	for _, participant := range pact.Blue.Participants {
 		if participant.Accepted == false {
@@ -1000,9 +1192,11 @@ func isDealDocumentAcceptedByUsers(dealDoc *DealDocumentDB) (isDealDocAccepted b
 	// Check red side
 	if len(pact.Red.Participants) == 0 {
 		err = fmt.Errorf("Deal document has no participants on Red side")
-		fmt.Println("[ERROR]: ", err.Error())
 		return false, err
 	}
+	if !pact.Red.isFull() {
+		return false, nil
+	}
 	for _, participant := range pact.Red.Participants {
 		if participant.Accepted == false {
 			return false, nil
@@ -1012,7 +1206,7 @@ func isDealDocumentAcceptedByUsers(dealDoc *DealDocumentDB) (isDealDocAccepted b
 }
 
 // TellUserDealStarted change user stats that shows that they are participating in deal
-func TellUserDealStarted(ctx context.Context, dealDoc DealDocumentDB, userTable *mongo.Collection) error {
+func TellUserDealStarted(ctx context.Context, dealDoc DealDocumentDB, users UserRepo) error {
 	// Get current pact
 	pact, err := dealDoc.getCurrentPact()
 	if err != nil {
@@ -1022,7 +1216,7 @@ func TellUserDealStarted(ctx context.Context, dealDoc DealDocumentDB, userTable
 	allParticipants := append(pact.Blue.Participants, pact.Red.Participants...)
 	for _, p := range allParticipants {
 		// Find user
-		user, err := GetUserByIDDB(ctx, p.ID, userTable)
+		user, err := users.GetByID(ctx, p.ID)
 		if err != nil {
 			return fmt.Errorf("Failed to get user from pact data: %s", err.Error())
 		}
@@ -1039,11 +1233,111 @@ func TellUserDealStarted(ctx context.Context, dealDoc DealDocumentDB, userTable
 			return fmt.Errorf("User %s don't accept deal %s", user.ID, dealDoc.ID.Hex())
 		}
 		// Save user
-		fmt.Printf("User %q participating in %v has accepted %v", resUser.Username, resUser.Participating, resUser.Accepted)
-		err = UpdateUserDB(ctx, p.ID, &resUser, userTable)
+		logging.Debug(ctx, "Save user", "username", resUser.Username, "participating", resUser.Participating, "accepted", resUser.Accepted)
+		err = users.Update(ctx, p.ID, &resUser)
 		if err != nil {
 			return fmt.Errorf("Failed to update user %s: %s", p.ID, err.Error())
 		}
 	}
 	return nil
 }
+
+// GetOpenBlamesDB returns all blame documents that are not activated yet
+func GetOpenBlamesDB(ctx context.Context, table *mongo.Collection) ([]*DealDocumentDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetOpenBlamesDB", table)
+	defer span.End()
+	// `completed` is omitted on insert when false, so check it's not true instead
+	cursor, err := table.Find(ctx, bson.D{
+		{Key: "type", Value: "BLAME"},
+		{Key: "completed", Value: bson.D{{Key: "$ne", Value: true}}},
+	})
+	if err != nil {
+		logging.Error(ctx, "Error getting blames from DB", "err", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	blames := make([]*DealDocumentDB, 0)
+	for cursor.Next(ctx) {
+		b := &DealDocumentDB{}
+		if err := cursor.Decode(b); err != nil {
+			logging.Error(ctx, "Error getting blames from DB", "err", err)
+			return nil, err
+		}
+		blames = append(blames, b)
+	}
+	return blames, err
+}
+
+// GetActiveDealsDB returns deals accepted by everyone that are not timed out or cancelled yet
+func GetActiveDealsDB(ctx context.Context, table *mongo.Collection) ([]*DealDocumentDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetActiveDealsDB", table)
+	defer span.End()
+	cursor, err := table.Find(ctx, bson.D{
+		{Key: "status.name", Value: bson.D{
+			{Key: "$eq", Value: "ALL_ACCEPTED"},
+			{Key: "$nin", Value: bson.A{"TIME_OUT", "CANCELLED"}},
+		}},
+	})
+	if err != nil {
+		logging.Error(ctx, "Error getting active deals from DB", "err", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	deals := make([]*DealDocumentDB, 0)
+	for cursor.Next(ctx) {
+		d := &DealDocumentDB{}
+		if err := cursor.Decode(d); err != nil {
+			logging.Error(ctx, "Error getting active deals from DB", "err", err)
+			return nil, err
+		}
+		deals = append(deals, d)
+	}
+	return deals, nil
+}
+
+// removeFromList removes {value} from {list} and reports whether it was there
+func removeFromList(list []string, value string) ([]string, bool) {
+	for i, v := range list {
+		if v == value {
+			return append(list[:i], list[i+1:]...), true
+		}
+	}
+	return list, false
+}
+
+// removeParticipant removes user {userID} from the side and returns removed participant
+func (side *SideDB) removeParticipant(userID string) (ParticipantDB, bool) {
+	for i, p := range side.Participants {
+		if p.ID == userID {
+			side.Participants = append(side.Participants[:i], side.Participants[i+1:]...)
+			return p, true
+		}
+	}
+	return ParticipantDB{}, false
+}
+
+// RemoveDealFromJudgesDB removes deal {dealDocID} from propositions and participations of all judges
+func RemoveDealFromJudgesDB(ctx context.Context, dealDocID string, users UserRepo) error {
+	judges, err := users.GetJudges(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to get judges, err: %v", err)
+	}
+	for _, j := range judges {
+		if j.JudgeProfile == nil {
+			continue
+		}
+		var proposed, participating bool
+		j.JudgeProfile.Propositions, proposed = removeFromList(j.JudgeProfile.Propositions, dealDocID)
+		j.JudgeProfile.Participatings, participating = removeFromList(j.JudgeProfile.Participatings, dealDocID)
+		if !proposed && !participating {
+			continue
+		}
+		err = users.Update(ctx, j.ID.Hex(), j)
+		if err != nil {
+			return fmt.Errorf("Failed to update judge %s propositions, err: %v", j.ID.Hex(), err)
+		}
+	}
+	return nil
+}
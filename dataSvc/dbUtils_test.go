@@ -0,0 +1,121 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+	"testing"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+)
+
+// setupOffer creates creator, user {username} and deal with blue side offered to that user
+func setupOffer(t *testing.T, username string) (context.Context, UserRepo, DealRepo, string, string) {
+	ctx := context.Background()
+	users := NewMemUserRepo()
+	deals := NewMemDealRepo()
+	creatorID, err := users.Create(ctx, UserDB{Username: "creator"})
+	if err != nil {
+		t.Fatalf("Failed to create creator: %v", err)
+	}
+	userID, err := users.Create(ctx, UserDB{Username: username})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	dealID, err := deals.Create(ctx, newTestDeal(creatorID, userID))
+	if err != nil {
+		t.Fatalf("Failed to create deal: %v", err)
+	}
+	user, _ := users.GetByID(ctx, userID)
+	user.Offerings = []string{dealID}
+	if err := users.Update(ctx, userID, user); err != nil {
+		t.Fatalf("Failed to offer deal: %v", err)
+	}
+	return ctx, users, deals, userID, dealID
+}
+
+func TestAcceptDealDocDB(t *testing.T) {
+	ctx, users, deals, userID, dealID := setupOffer(t, "bob")
+	if err := AcceptDealDocDB(ctx, dealID, userID, pb.SideType_BLUE, deals, users); err != nil {
+		t.Fatalf("Failed to accept deal: %v", err)
+	}
+	user, _ := users.GetByID(ctx, userID)
+	if len(user.Offerings) != 0 || len(user.Accepted) != 1 || user.Accepted[0] != dealID {
+		t.Fatalf("Deal wasn't moved from offerings %v to accepted %v", user.Offerings, user.Accepted)
+	}
+	dealDoc, _ := deals.GetByID(ctx, dealID)
+	pact, _ := dealDoc.getCurrentPact()
+	blue := pact.Blue.Participants[0]
+	if !blue.Accepted || blue.PactHash != pact.hash() {
+		t.Fatalf("Blue participant %+v didn't accept current terms %s", blue, pact.hash())
+	}
+	// The same deal can't be accepted twice
+	err := AcceptDealDocDB(ctx, dealID, userID, pb.SideType_BLUE, deals, users)
+	if e := dealerrors.Parse(err); e == nil || e.Code != dealerrors.ALREADY_ACCEPTED {
+		t.Fatalf("Second acceptance failed with %v, want ALREADY_ACCEPTED", err)
+	}
+}
+
+func TestAcceptDealDocDBFailures(t *testing.T) {
+	ctx, users, deals, userID, dealID := setupOffer(t, "bob")
+	if err := AcceptDealDocDB(ctx, dealID, primitive.NewObjectID().Hex(), pb.SideType_BLUE, deals, users); err == nil {
+		t.Fatalf("Unknown user accepted the deal")
+	}
+	// User is offered to blue side, so they can't accept red one
+	if err := AcceptDealDocDB(ctx, dealID, userID, pb.SideType_RED, deals, users); err == nil {
+		t.Fatalf("User accepted side they are not offered to")
+	}
+	otherID, _ := users.Create(ctx, UserDB{Username: "eve"})
+	err := AcceptDealDocDB(ctx, dealID, otherID, pb.SideType_BLUE, deals, users)
+	if e := dealerrors.Parse(err); e == nil || e.Code != dealerrors.NO_OFFER {
+		t.Fatalf("User without offer failed with %v, want NO_OFFER", err)
+	}
+	dealDoc, _ := deals.GetByID(ctx, dealID)
+	pact, _ := dealDoc.getCurrentPact()
+	if pact.Blue.Participants[0].Accepted {
+		t.Fatalf("Failed acceptances changed the deal")
+	}
+}
+
+func TestCheckToWatchDeal(t *testing.T) {
+	ctx, users, deals, userID, dealID := setupOffer(t, "bob")
+	ready, err := CheckToWatchDeal(ctx, dealID, deals)
+	if err != nil || ready {
+		t.Fatalf("Got %v, %v before blue side accepted, want false", ready, err)
+	}
+	if err := AcceptDealDocDB(ctx, dealID, userID, pb.SideType_BLUE, deals, users); err != nil {
+		t.Fatalf("Failed to accept deal: %v", err)
+	}
+	ready, err = CheckToWatchDeal(ctx, dealID, deals)
+	if err != nil || !ready {
+		t.Fatalf("Got %v, %v after every side accepted, want true", ready, err)
+	}
+}
+
+func TestCheckToWatchDealWaitsForFullSides(t *testing.T) {
+	ctx := context.Background()
+	deals := NewMemDealRepo()
+	deal := newTestDeal("red", "blue")
+	deal.Pacts[0].Blue.Participants[0].Accepted = true
+	// Blue side needs one more member, so accepted participants are not enough yet
+	deal.Pacts[0].Blue.Members = 2
+	dealID, _ := deals.Create(ctx, deal)
+	ready, err := CheckToWatchDeal(ctx, dealID, deals)
+	if err != nil || ready {
+		t.Fatalf("Got %v, %v for deal with free slot, want false", ready, err)
+	}
+
+	deal = newTestDeal("red", "blue")
+	deal.Pacts[0].Blue.Participants[0].Accepted = true
+	deal.FinalVersion = "missing"
+	dealID, _ = deals.Create(ctx, deal)
+	if _, err := CheckToWatchDeal(ctx, dealID, deals); err == nil {
+		t.Fatalf("Deal without current pact was checked")
+	}
+}
@@ -8,13 +8,14 @@ package dataSvc
 
 import (
 	"context"
-	"fmt"
+	"strings"
+	"time"
 
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
 	grpcutils "github.com/DenysNahurnyi/deal/common/grpc"
+	"github.com/DenysNahurnyi/deal/common/logging"
 	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
 	"github.com/go-kit/kit/endpoint"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 func makeCreateUserEndpoint(svc Service) endpoint.Endpoint {
@@ -40,31 +41,31 @@ func makeGetUserEndpoint(svc Service) endpoint.Endpoint {
 		tid := req.ReqHdr.Tid
 		userID, err := grpcutils.GetUserIDFromJWT(ctx)
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to get user id from token, err: ", err)
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
 			return nil, err
 		}
 		userDB, err := svc.GetUser(ctx, userID)
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to get user, err: ", err)
+			logging.Error(ctx, "Failed to get user", "err", err)
 			return nil, err
 		}
 		user, err := ConvertDBToUser(userDB)
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to convert DB user format to response, err: ", err)
+			logging.Error(ctx, "Failed to convert DB user format to response", "err", err)
 			return nil, err
 		}
-		success, err := userDB.getSuccess(ctx, svc.getDealsTable())
+		success, err := userDB.getSuccess(ctx, svc.getDealRepo())
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to count user success, err: ", err)
+			logging.Error(ctx, "Failed to count user success", "err", err)
 			return nil, err
 		}
 		user.Success = int64(success)
-		fmt.Println("{DEBUG}", "userID: ", userID, " user.JudgeProfile", user.JudgeProfile, " user.IsJudge: ", user.IsJudge)
+		logging.Debug(ctx, "Got user", "judgeProfile", user.JudgeProfile, "isJudge", user.IsJudge)
 		if user.JudgeProfile != nil && user.IsJudge {
-			justice, err := userDB.getJustice(ctx, svc.getDealsTable())
-			fmt.Println("{DEBUG}", "userID: ", userID, " justice, err: ", justice, err)
+			justice, err := userDB.getJustice(ctx, svc.getDealRepo())
+			logging.Debug(ctx, "Got justice of judge", "justice", justice, "err", err)
 			if err != nil {
-				fmt.Println("[LOG]:", "Failed to count judge justice, err: ", err)
+				logging.Error(ctx, "Failed to count judge justice", "err", err)
 				return nil, err
 			}
 			user.JudgeProfile.Justice = int64(justice)
@@ -77,20 +78,13 @@ func makeGetUserEndpoint(svc Service) endpoint.Endpoint {
 	}
 }
 
-func makeReadinessEndpoint(svc Service) endpoint.Endpoint {
-	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		fmt.Println("Readiness called")
-		return pb.Blank{}, nil
-	}
-}
-
 func makeDeleteUserEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(*pb.DeleteUserReq)
 		tid := req.ReqHdr.Tid
 		userID, err := grpcutils.GetUserIDFromJWT(ctx)
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to get user id from token, err: ", err)
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
 			return nil, err
 		}
 		userDB, err := svc.DeleteUser(ctx, userID)
@@ -114,15 +108,11 @@ func makeUpdateUserEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(*pb.UpdateUserReq)
 		tid := req.ReqHdr.Tid
-		// Validate user input
 		userReq := req.GetUser()
-		if userReq == nil {
-			return nil, status.Errorf(codes.InvalidArgument, "User data musn't be empty")
-		}
 		// Get user ID
 		userID, err := grpcutils.GetUserIDFromJWT(ctx)
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to get user id from token, err: ", err)
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
 			return nil, err
 		}
 		userReq.Id = userID
@@ -162,21 +152,10 @@ func makeCreateDealDocumentEndpoint(svc Service) endpoint.Endpoint {
 
 		userID, err := grpcutils.GetUserIDFromJWT(ctx)
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to get user id from token, err: ", err)
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
 			return "", err
 		}
 
-		dealDocument := req.GetDealDocument()
-		if dealDocument == nil {
-			return "", status.Errorf(codes.InvalidArgument, "Deal document musn't be empty")
-		}
-		if len(dealDocument.Content) == 0 {
-			return "", status.Errorf(codes.InvalidArgument, "Deal content musn't be empty")
-		}
-		if len(dealDocument.Timeout) == 0 {
-			return "", status.Errorf(codes.InvalidArgument, "Deal timeout musn't be empty")
-		}
-
 		dealDocumentID, err := svc.CreateDealDocument(ctx, userID, req.GetDealDocument())
 
 		return pb.CreateDealDocumentResp{
@@ -191,21 +170,15 @@ func makeOfferDealDocumentEndpoint(svc Service) endpoint.Endpoint {
 		req := request.(*pb.OfferDealDocumentReq)
 		tid := req.ReqHdr.Tid
 
-		_, err := grpcutils.GetUserIDFromJWT(ctx)
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to get user id from token, err: ", err)
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
 			return nil, err
 		}
 		dealDocID := req.GetDealDocId()
-		if len(dealDocID) == 0 {
-			return nil, status.Errorf(codes.InvalidArgument, "Deal id musn't be empty")
-		}
 		username := req.GetUsername()
-		if len(username) == 0 {
-			return nil, status.Errorf(codes.InvalidArgument, "Username musn't be empty")
-		}
 
-		err = svc.OfferDealDocument(ctx, dealDocID, username, req.GetToJudge())
+		err = svc.OfferDealDocument(ctx, userID, dealDocID, username, req.GetToJudge(), req.GetTeammate())
 
 		return pb.OfferDealDocumentResp{
 			RespHdr: &pb.RespHdr{Tid: tid, ReqTid: tid},
@@ -220,7 +193,7 @@ func makeGetDealDocumentEndpoint(svc Service) endpoint.Endpoint {
 
 		_, err := grpcutils.GetUserIDFromJWT(ctx)
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to get user id from token, err: ", err)
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
 			return nil, err
 		}
 
@@ -240,14 +213,10 @@ func makeAcceptDealDocumentEndpoint(svc Service) endpoint.Endpoint {
 
 		userID, err := grpcutils.GetUserIDFromJWT(ctx)
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to get user id from token, err: ", err)
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
 			return nil, err
 		}
 		dealDocID := req.GetDealDocId()
-		if len(dealDocID) == 0 {
-			fmt.Println("[LOG]:", "Invalid deal document id, err: ", err)
-			return nil, err
-		}
 
 		err = svc.AcceptDealDocument(ctx, userID, dealDocID, req.GetSideType())
 
@@ -264,14 +233,10 @@ func makeJudgeAcceptDealDocumentEndpoint(svc Service) endpoint.Endpoint {
 
 		userID, err := grpcutils.GetUserIDFromJWT(ctx)
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to get user id from token, err: ", err)
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
 			return nil, err
 		}
 		dealDocID := req.GetDealDocId()
-		if len(dealDocID) == 0 {
-			fmt.Println("[LOG]:", "Invalid deal document id, err: ", err)
-			return nil, err
-		}
 
 		err = svc.JudgeAccept(ctx, userID, dealDocID)
 
@@ -287,10 +252,6 @@ func makeDealTimeoutEndpoint(svc Service) endpoint.Endpoint {
 		tid := req.ReqHdr.Tid
 
 		dealDocID := req.GetDealDocumentId()
-		if len(dealDocID) == 0 {
-			err := fmt.Errorf("Empty deal document id")
-			return nil, err
-		}
 
 		err := svc.DealTimeout(ctx, dealDocID)
 
@@ -307,18 +268,17 @@ func makeJudgeDecideEndpoint(svc Service) endpoint.Endpoint {
 
 		userID, err := grpcutils.GetUserIDFromJWT(ctx)
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to get user id from token, err: ", err)
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
 			return nil, err
 		}
 		dealDocID := req.GetDealDocumentId()
-		if len(dealDocID) == 0 {
-			return nil, fmt.Errorf("[LOG]:", "Empty deal document id")
-		}
-		// Using bool here is bad because in case of missing this prop it will automaticly be false
-		// XD
-		winner := "blue"
-		if req.GetRedWon() {
-			winner = "red"
+		winner := req.GetWinner()
+		// Old clients send only red_won, missing red_won means blue won for them
+		if len(winner) == 0 {
+			winner = "blue"
+			if req.GetRedWon() {
+				winner = "red"
+			}
 		}
 
 		err = svc.JudgeDecide(ctx, userID, dealDocID, winner)
@@ -336,18 +296,12 @@ func makeCreateBlameDocumentEndpoint(svc Service) endpoint.Endpoint {
 
 		userID, err := grpcutils.GetUserIDFromJWT(ctx)
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to get user id from token, err: ", err)
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
 			return "", err
 		}
 
 		blamedDealID := req.GetBlamedDealId()
-		if len(blamedDealID) == 0 {
-			return "", status.Errorf(codes.InvalidArgument, "Blamed deal ID musn't be empty")
-		}
 		blameReason := req.GetBlameReson()
-		if len(blameReason) == 0 {
-			return "", status.Errorf(codes.InvalidArgument, "Blame reason musn't be empty")
-		}
 
 		blameDocumentID, err := svc.CreateBlameDocument(ctx, userID, blamedDealID, blameReason)
 
@@ -365,14 +319,11 @@ func makeJoinBlameEndpoint(svc Service) endpoint.Endpoint {
 
 		userID, err := grpcutils.GetUserIDFromJWT(ctx)
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to get user id from token, err: ", err)
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
 			return "", err
 		}
 
 		blameID := req.GetBlameId()
-		if len(blameID) == 0 {
-			return "", status.Errorf(codes.InvalidArgument, "Blame ID musn't be empty")
-		}
 
 		err = svc.JoinBlame(ctx, userID, blameID)
 
@@ -389,14 +340,11 @@ func makeActivateBlameEndpoint(svc Service) endpoint.Endpoint {
 
 		userID, err := grpcutils.GetUserIDFromJWT(ctx)
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to get user id from token, err: ", err)
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
 			return "", err
 		}
 
 		blameID := req.GetBlameId()
-		if len(blameID) == 0 {
-			return "", status.Errorf(codes.InvalidArgument, "Blame ID musn't be empty")
-		}
 
 		err = svc.ActivateBlame(ctx, userID, blameID)
 
@@ -405,3 +353,598 @@ func makeActivateBlameEndpoint(svc Service) endpoint.Endpoint {
 		}, err
 	}
 }
+
+func makeGetJudgeQueueEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.GetJudgeQueueReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+
+		queue, err := svc.GetJudgeQueue(ctx, userID, int(req.GetPage()), int(req.GetPageSize()))
+		if err != nil {
+			return nil, err
+		}
+
+		return pb.GetJudgeQueueResp{
+			RespHdr:           &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Propositions:      queue.Propositions,
+			ActiveCases:       queue.ActiveCases,
+			OpenBlames:        queue.OpenBlames,
+			PropositionsTotal: int64(queue.PropositionsTotal),
+			ActiveCasesTotal:  int64(queue.ActiveCasesTotal),
+			OpenBlamesTotal:   int64(queue.OpenBlamesTotal),
+		}, nil
+	}
+}
+
+func makeGetPublicProfileEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.GetPublicProfileReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+		username := req.GetUsername()
+
+		profile, err := svc.GetPublicProfile(ctx, userID, username)
+
+		return pb.GetPublicProfileResp{
+			RespHdr: &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Profile: profile,
+		}, err
+	}
+}
+
+func makeSearchUsersEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.SearchUsersReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+		query := strings.TrimSpace(req.GetQuery())
+
+		profiles, err := svc.SearchUsers(ctx, userID, query, int(req.GetPage()), int(req.GetPageSize()))
+
+		return pb.SearchUsersResp{
+			RespHdr:  &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Profiles: profiles,
+		}, err
+	}
+}
+
+func makeDeclineOfferEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.DeclineOfferReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+		dealDocID := req.GetDealDocId()
+
+		err = svc.DeclineOffer(ctx, userID, dealDocID)
+
+		return pb.DeclineOfferResp{
+			RespHdr: &pb.RespHdr{Tid: tid, ReqTid: tid},
+		}, err
+	}
+}
+
+func makeRevokeOfferEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.RevokeOfferReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+
+		err = svc.RevokeOffer(ctx, userID, req.GetDealDocId(), req.GetUsername())
+
+		return pb.RevokeOfferResp{
+			RespHdr: &pb.RespHdr{Tid: tid, ReqTid: tid},
+		}, err
+	}
+}
+
+func makeWithdrawFromDealEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.WithdrawFromDealReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+		dealDocID := req.GetDealDocId()
+
+		err = svc.WithdrawFromDeal(ctx, userID, dealDocID)
+
+		return pb.WithdrawFromDealResp{
+			RespHdr: &pb.RespHdr{Tid: tid, ReqTid: tid},
+		}, err
+	}
+}
+
+func makeCancelDealEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.CancelDealReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+		dealDocID := req.GetDealDocId()
+
+		err = svc.CancelDeal(ctx, userID, dealDocID)
+
+		return pb.CancelDealResp{
+			RespHdr: &pb.RespHdr{Tid: tid, ReqTid: tid},
+		}, err
+	}
+}
+
+func makeGetWalletEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.GetWalletReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+
+		wallet, entries, err := svc.GetWallet(ctx, userID, int(req.GetPage()), int(req.GetPageSize()))
+		if err != nil {
+			return nil, err
+		}
+		entriesResp := []*pb.LedgerEntry{}
+		for _, e := range entries {
+			entriesResp = append(entriesResp, convertLedgerEntry(e))
+		}
+
+		return pb.GetWalletResp{
+			RespHdr: &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Balance: wallet.Balance,
+			Entries: entriesResp,
+		}, nil
+	}
+}
+
+func makeUploadEvidenceEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.UploadEvidenceReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+		dealID := req.GetDealId()
+
+		evidence, err := svc.UploadEvidence(ctx, userID, dealID, req.GetName(), req.GetContentType(), req.GetVisibility(), req.GetData())
+		if err != nil {
+			return nil, err
+		}
+
+		return pb.UploadEvidenceResp{
+			RespHdr:  &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Evidence: convertEvidence(evidence),
+		}, nil
+	}
+}
+
+func makeListEvidenceEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.ListEvidenceReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+		dealID := req.GetDealId()
+
+		evidence, err := svc.ListEvidence(ctx, userID, dealID)
+		if err != nil {
+			return nil, err
+		}
+		evidenceResp := []*pb.Evidence{}
+		for _, e := range evidence {
+			evidenceResp = append(evidenceResp, convertEvidence(e))
+		}
+
+		return pb.ListEvidenceResp{
+			RespHdr:  &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Evidence: evidenceResp,
+		}, nil
+	}
+}
+
+func makeDownloadEvidenceEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.DownloadEvidenceReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+		evidenceID := req.GetEvidenceId()
+
+		evidence, data, err := svc.DownloadEvidence(ctx, userID, evidenceID)
+		if err != nil {
+			return nil, err
+		}
+
+		return pb.DownloadEvidenceResp{
+			RespHdr:  &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Evidence: convertEvidence(evidence),
+			Data:     data,
+		}, nil
+	}
+}
+
+func makePostCommentEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.PostCommentReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+		dealID := req.GetDealId()
+
+		comment, err := svc.PostComment(ctx, userID, dealID, req.GetParentId(), req.GetBody(), req.GetVisibility())
+		if err != nil {
+			return nil, err
+		}
+
+		return pb.PostCommentResp{
+			RespHdr: &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Comment: comment,
+		}, nil
+	}
+}
+
+func makeEditCommentEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.EditCommentReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+		commentID := req.GetCommentId()
+
+		comment, err := svc.EditComment(ctx, userID, commentID, req.GetBody())
+		if err != nil {
+			return nil, err
+		}
+
+		return pb.EditCommentResp{
+			RespHdr: &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Comment: comment,
+		}, nil
+	}
+}
+
+func makeFlagCommentEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.FlagCommentReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+		commentID := req.GetCommentId()
+
+		comment, err := svc.FlagComment(ctx, userID, commentID, req.GetReason())
+		if err != nil {
+			return nil, err
+		}
+
+		return pb.FlagCommentResp{
+			RespHdr: &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Comment: comment,
+		}, nil
+	}
+}
+
+func makeListCommentsEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.ListCommentsReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+		dealID := req.GetDealId()
+
+		comments, err := svc.ListComments(ctx, userID, dealID, req.GetThreadId(), int(req.GetPage()), int(req.GetPageSize()))
+		if err != nil {
+			return nil, err
+		}
+
+		return pb.ListCommentsResp{
+			RespHdr:  &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Comments: comments,
+		}, nil
+	}
+}
+
+func makeRegisterWebhookEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.RegisterWebhookReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+
+		webhook, err := svc.RegisterWebhook(ctx, userID, req.GetUrl(), req.GetEventTypes())
+		if err != nil {
+			return nil, err
+		}
+
+		return pb.RegisterWebhookResp{
+			RespHdr: &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Webhook: convertWebhook(webhook, true),
+		}, nil
+	}
+}
+
+func makeListWebhooksEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.ListWebhooksReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+
+		webhooks, err := svc.ListWebhooks(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		webhooksResp := []*pb.Webhook{}
+		for _, w := range webhooks {
+			webhooksResp = append(webhooksResp, convertWebhook(w, false))
+		}
+
+		return pb.ListWebhooksResp{
+			RespHdr:  &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Webhooks: webhooksResp,
+		}, nil
+	}
+}
+
+func makeDeleteWebhookEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.DeleteWebhookReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+
+		err = svc.DeleteWebhook(ctx, userID, req.GetWebhookId())
+		if err != nil {
+			return nil, err
+		}
+
+		return pb.DeleteWebhookResp{
+			RespHdr: &pb.RespHdr{Tid: tid, ReqTid: tid},
+		}, nil
+	}
+}
+
+func makeReplayWebhookEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.ReplayWebhookReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+		since, err := time.Parse(timeoutLayout, req.GetSince())
+		if err != nil {
+			return nil, dealerrors.New(dealerrors.INVALID_ARGUMENT, "Invalid since time %q, expected format %s", req.GetSince(), timeoutLayout)
+		}
+
+		replayed, err := svc.ReplayWebhook(ctx, userID, req.GetWebhookId(), since)
+		if err != nil {
+			return nil, err
+		}
+
+		return pb.ReplayWebhookResp{
+			RespHdr:  &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Replayed: int64(replayed),
+		}, nil
+	}
+}
+
+func makeListWebhookDeliveriesEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.ListWebhookDeliveriesReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+
+		deliveries, err := svc.ListWebhookDeliveries(ctx, userID, req.GetWebhookId(), int(req.GetPage()), int(req.GetPageSize()))
+		if err != nil {
+			return nil, err
+		}
+		deliveriesResp := []*pb.WebhookDelivery{}
+		for _, d := range deliveries {
+			deliveriesResp = append(deliveriesResp, convertDelivery(d))
+		}
+
+		return pb.ListWebhookDeliveriesResp{
+			RespHdr:    &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Deliveries: deliveriesResp,
+		}, nil
+	}
+}
+
+func makeListNotificationsEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.ListNotificationsReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+
+		notifications, unread, err := svc.ListNotifications(ctx, userID, req.GetUnreadOnly(), int(req.GetPage()), int(req.GetPageSize()))
+		if err != nil {
+			return nil, err
+		}
+		notificationsResp := []*pb.Notification{}
+		for _, n := range notifications {
+			notificationsResp = append(notificationsResp, convertNotification(n))
+		}
+
+		return pb.ListNotificationsResp{
+			RespHdr:       &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Notifications: notificationsResp,
+			Unread:        unread,
+		}, nil
+	}
+}
+
+func makeMarkReadEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.MarkReadReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+
+		marked, err := svc.MarkRead(ctx, userID, req.GetNotificationIds())
+		if err != nil {
+			return nil, err
+		}
+
+		return pb.MarkReadResp{
+			RespHdr: &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Marked:  marked,
+		}, nil
+	}
+}
+
+func makeGetUnreadCountEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.GetUnreadCountReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+
+		unread, err := svc.GetUnreadCount(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		return pb.GetUnreadCountResp{
+			RespHdr: &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Unread:  unread,
+		}, nil
+	}
+}
+
+func makeGetDealHistoryEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.GetDealHistoryReq)
+		tid := req.ReqHdr.Tid
+
+		userID, err := grpcutils.GetUserIDFromJWT(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user id from token", "err", err)
+			return nil, err
+		}
+		dealID := req.GetDealId()
+
+		entries, tamperedSeq, err := svc.GetDealHistory(ctx, userID, dealID)
+		if err != nil {
+			return nil, err
+		}
+		entriesResp := []*pb.AuditEntry{}
+		for _, e := range entries {
+			entriesResp = append(entriesResp, convertAuditEntry(e))
+		}
+
+		return pb.GetDealHistoryResp{
+			RespHdr:     &pb.RespHdr{Tid: tid, ReqTid: tid},
+			Entries:     entriesResp,
+			TamperedSeq: tamperedSeq,
+		}, nil
+	}
+}
+
+func makeVerifyDealIntegrityEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.VerifyDealIntegrityReq)
+		tid := req.ReqHdr.Tid
+
+		dealID := req.GetDealId()
+
+		resp, err := svc.VerifyDealIntegrity(ctx, dealID)
+		if err != nil {
+			return nil, err
+		}
+		resp.RespHdr = &pb.RespHdr{Tid: tid, ReqTid: tid}
+		return *resp, nil
+	}
+}
@@ -0,0 +1,500 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/DenysNahurnyi/deal/common/tracing"
+	"github.com/DenysNahurnyi/deal/common/utils"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/options"
+)
+
+// Types of domain events
+const (
+	EventUserCreated       = "USER_CREATED"
+	EventUserUpdated       = "USER_UPDATED"
+	EventUserDeleted       = "USER_DELETED"
+	EventDealCreated       = "DEAL_CREATED"
+	EventDealOffered       = "DEAL_OFFERED"
+	EventDealAccepted      = "DEAL_ACCEPTED"
+	EventOfferDeclined     = "OFFER_DECLINED"
+	EventOfferRevoked      = "OFFER_REVOKED"
+	EventDealWithdrawn     = "DEAL_WITHDRAWN"
+	EventDealCancelled     = "DEAL_CANCELLED"
+	EventJudgesOffered     = "JUDGES_OFFERED"
+	EventJudgeAssigned     = "JUDGE_ASSIGNED"
+	EventDealDecided       = "DEAL_DECIDED"
+	EventDealTimedOut      = "DEAL_TIMED_OUT"
+	EventBlameCreated      = "BLAME_CREATED"
+	EventBlameJoined       = "BLAME_JOINED"
+	EventBlameActivated    = "BLAME_ACTIVATED"
+	EventEvidenceUploaded  = "EVIDENCE_UPLOADED"
+	EventCommentPosted     = "COMMENT_POSTED"
+	EventCommentChanged    = "COMMENT_CHANGED"
+	eventsSeqName          = "events"
+	eventSubscriberBacklog = 256
+	// eventReorderWindow is how many seqs back stream looks for events stored late by concurrent emits
+	eventReorderWindow = 256
+	// eventSequenceGrace is how old event without seq has to be, before sequencer takes it from the hook of its change
+	eventSequenceGrace    = 10 * time.Second
+	eventSequenceInterval = time.Second
+	eventSequenceBatch    = 100
+)
+
+// EventDB is a domain event that stores in the DB, seq is a global order of events and is used as resume token.
+// Event is stored without seq and is not visible to streams until it gets one
+type EventDB struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	Seq      int64              `bson:"seq,omitempty"`
+	Type     string             `bson:"type"`
+	DealID   string             `bson:"deal_id,omitempty"`
+	Actor    string             `bson:"actor,omitempty"`
+	Audience []string           `bson:"audience"`
+	Data     map[string]string  `bson:"data,omitempty"`
+	Time     time.Time          `bson:"time"`
+}
+
+func (e *EventDB) visibleTo(userID string) bool {
+	return utils.StringInSlice(userID, e.Audience)
+}
+
+// NextSeqDB returns next value of the sequence {name}
+func NextSeqDB(ctx context.Context, name string, table *mongo.Collection) (int64, error) {
+	ctx, span := tracing.StartDB(ctx, "NextSeqDB", table)
+	defer span.End()
+	counter := struct {
+		Seq int64 `bson:"seq"`
+	}{}
+	err := table.FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: name}},
+		bson.D{{"$inc", bson.D{{Key: "seq", Value: 1}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		logging.Error(ctx, "Error getting next sequence value from mongo", "err", err)
+		return 0, err
+	}
+	return counter.Seq, nil
+}
+
+// CreateEventDB stores event
+func CreateEventDB(ctx context.Context, event EventDB, table *mongo.Collection) error {
+	ctx, span := tracing.StartDB(ctx, "CreateEventDB", table)
+	defer span.End()
+	_, err := table.InsertOne(ctx, event)
+	if err != nil {
+		logging.Error(ctx, "Error creating event in mongo", "err", err)
+	}
+	return err
+}
+
+// SetEventSeqDB sets {seq} of event {eventID} unless it already has one, returns false if it had
+func SetEventSeqDB(ctx context.Context, eventID primitive.ObjectID, seq int64, table *mongo.Collection) (bool, error) {
+	ctx, span := tracing.StartDB(ctx, "SetEventSeqDB", table)
+	defer span.End()
+	res, err := table.UpdateOne(ctx,
+		bson.D{
+			{Key: "_id", Value: eventID},
+			{Key: "seq", Value: bson.D{{Key: "$exists", Value: false}}},
+		},
+		bson.D{{"$set", bson.D{{Key: "seq", Value: seq}}}},
+	)
+	if err != nil {
+		logging.Error(ctx, "Error setting event seq in mongo", "err", err)
+		return false, err
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+// GetUnsequencedEventsDB returns events without seq stored before {before}, oldest first
+func GetUnsequencedEventsDB(ctx context.Context, before time.Time, limit int64, table *mongo.Collection) ([]*EventDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetUnsequencedEventsDB", table)
+	defer span.End()
+	cursor, err := table.Find(ctx,
+		bson.D{
+			{Key: "seq", Value: bson.D{{Key: "$exists", Value: false}}},
+			{Key: "time", Value: bson.D{{Key: "$lt", Value: before}}},
+		},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit),
+	)
+	if err != nil {
+		logging.Error(ctx, "Error getting unsequenced events from mongo", "err", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []*EventDB{}
+	for cursor.Next(ctx) {
+		e := &EventDB{}
+		if err := cursor.Decode(e); err != nil {
+			logging.Error(ctx, "Error getting unsequenced events from mongo", "err", err)
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// GetEventByIDDB returns event {eventID}
+func GetEventByIDDB(ctx context.Context, eventID primitive.ObjectID, table *mongo.Collection) (*EventDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetEventByIDDB", table)
+	defer span.End()
+	event := &EventDB{}
+	if err := table.FindOne(ctx, bson.D{{Key: "_id", Value: eventID}}).Decode(event); err != nil {
+		logging.Error(ctx, "Error getting event from mongo", "err", err)
+		return nil, err
+	}
+	return event, nil
+}
+
+// GetEventsAfterDB returns events of user {userID} with seq bigger than {afterSeq}, oldest first
+func GetEventsAfterDB(ctx context.Context, userID string, afterSeq int64, limit int64, table *mongo.Collection) ([]*EventDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetEventsAfterDB", table)
+	defer span.End()
+	cursor, err := table.Find(ctx,
+		bson.D{
+			{Key: "audience", Value: userID},
+			{Key: "seq", Value: bson.D{{Key: "$gt", Value: afterSeq}}},
+		},
+		options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}).SetLimit(limit),
+	)
+	if err != nil {
+		logging.Error(ctx, "Error getting events from mongo", "err", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []*EventDB{}
+	for cursor.Next(ctx) {
+		e := &EventDB{}
+		if err := cursor.Decode(e); err != nil {
+			logging.Error(ctx, "Error getting events from mongo", "err", err)
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// eventSubscriber receives events published in this process, if it can't keep up it is marked as lagged
+// and has to catch up from the DB
+type eventSubscriber struct {
+	events chan *EventDB
+	mu     sync.Mutex
+	lagged bool
+}
+
+func (sub *eventSubscriber) takeLagged() bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	lagged := sub.lagged
+	sub.lagged = false
+	return lagged
+}
+
+// eventBus fans events out to the streams of this process
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[*eventSubscriber]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[*eventSubscriber]struct{})}
+}
+
+func (b *eventBus) subscribe() *eventSubscriber {
+	sub := &eventSubscriber{events: make(chan *EventDB, eventSubscriberBacklog)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+func (b *eventBus) unsubscribe(sub *eventSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs, sub)
+}
+
+func (b *eventBus) publish(event *EventDB) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		select {
+		case sub.events <- event:
+		default:
+			sub.mu.Lock()
+			sub.lagged = true
+			sub.mu.Unlock()
+		}
+	}
+}
+
+// emit stores event of the mutation in its transaction, so committed changes don't lose events and aborted ones
+// have none. Failure to store the event fails the transaction, outside of it failure is only logged.
+// Seq is assigned once the transaction is committed, so concurrent transactions don't conflict on the events
+// sequence, event whose seq wasn't assigned (e.g. replica stopped right after the commit) is sequenced by runEventSequencer.
+// Concurrent events can get seqs and be published out of order, streams fill such gaps from the DB
+func (s *service) emit(ctx context.Context, event EventDB) {
+	// Service built on in-memory repos has no event log
+	if s.eventTable == nil {
+		return
+	}
+	event.ID = primitive.NewObjectID()
+	event.Time = s.clock.Now()
+	event.Audience = utils.UniqueStringSlice(event.Audience)
+	if err := CreateEventDB(ctx, event, s.eventTable); err != nil {
+		logging.Error(ctx, "Failed to emit event "+event.Type, "err", err)
+		failTransaction(ctx, err)
+		return
+	}
+	afterCommit(ctx, func(ctx context.Context) error {
+		if err := s.sequenceEvent(ctx, &event); err != nil {
+			logging.Error(ctx, "Failed to sequence event "+event.Type+", sequencer will retry", "err", err)
+		}
+		return nil
+	})
+}
+
+// sequenceEvent gives stored {event} the next seq and publishes it. Event sequenced concurrently by another
+// replica is skipped, its seq is left unused
+func (s *service) sequenceEvent(ctx context.Context, event *EventDB) error {
+	seq, err := NextSeqDB(ctx, eventsSeqName, s.counterTable)
+	if err != nil {
+		return err
+	}
+	set, err := SetEventSeqDB(ctx, event.ID, seq, s.eventTable)
+	if err != nil || !set {
+		return err
+	}
+	event.Seq = seq
+	// With change streams every replica publishes what it reads from mongo, including own events
+	if !s.eventsFromStream {
+		s.eventBus.publish(event)
+	}
+	return nil
+}
+
+// runEventSequencer sequences committed events that didn't get seq from the hook of their change until {ctx} is done
+func (s *service) runEventSequencer(ctx context.Context) {
+	ticker := time.NewTicker(eventSequenceInterval)
+	defer ticker.Stop()
+	for {
+		events, err := GetUnsequencedEventsDB(ctx, s.clock.Now().Add(-eventSequenceGrace), eventSequenceBatch, s.eventTable)
+		if err != nil {
+			logging.Error(ctx, "Failed to get unsequenced events", "err", err)
+		}
+		for _, e := range events {
+			if err := s.sequenceEvent(ctx, e); err != nil {
+				logging.Error(ctx, "Failed to sequence event "+e.Type, "err", err)
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// emitUserEvent emits event visible only to user {userID}
+func (s *service) emitUserEvent(ctx context.Context, eventType, userID string) {
+	s.emit(ctx, EventDB{Type: eventType, Actor: userID, Audience: []string{userID}})
+}
+
+// emitDealEvent emits event visible to everybody related to the deal {dealID} and to {extra} users
+func (s *service) emitDealEvent(ctx context.Context, eventType, dealID, actor string, data map[string]string, extra ...string) {
+	s.emitVisibleDealEvent(ctx, eventType, dealID, actor, data, nil, extra...)
+}
+
+// emitVisibleDealEvent emits deal event only to users of the deal that {visible} allows to see it, actor and {extra}
+// users get it anyway. Nil {visible} allows everybody
+func (s *service) emitVisibleDealEvent(ctx context.Context, eventType, dealID, actor string, data map[string]string,
+	visible func(dealDoc *DealDocumentDB, userID string) bool, extra ...string) {
+	audience := append([]string{}, extra...)
+	if len(actor) > 0 {
+		audience = append(audience, actor)
+	}
+	dealDoc, err := s.deals.GetByID(ctx, dealID)
+	if err != nil {
+		logging.Error(ctx, "Failed to get deal "+dealID+" for event "+eventType, "err", err)
+	}
+	if dealDoc != nil {
+		for _, userID := range dealAudience(dealDoc) {
+			if visible == nil || visible(dealDoc, userID) {
+				audience = append(audience, userID)
+			}
+		}
+	}
+	s.emit(ctx, EventDB{Type: eventType, DealID: dealID, Actor: actor, Audience: audience, Data: data})
+}
+
+// dealAudience returns everybody who could see the deal: participants, offered users and judges
+func dealAudience(dealDoc *DealDocumentDB) []string {
+	audience := []string{}
+	if pact, err := dealDoc.getCurrentPact(); err == nil {
+		for _, p := range pact.participants() {
+			audience = append(audience, p.ID)
+		}
+	}
+	for _, j := range dealDoc.Judge.Participants {
+		audience = append(audience, j.ID)
+	}
+	return audience
+}
+
+// followEventChanges publishes events sequenced by any replica, it's used instead of local publishing
+// when events source of the config is change_stream
+func (s *service) followEventChanges(ctx context.Context) {
+	for ctx.Err() == nil {
+		cs, err := s.eventTable.Watch(ctx, []bson.D{
+			{{Key: "$match", Value: bson.D{
+				{Key: "operationType", Value: "update"},
+				{Key: "updateDescription.updatedFields.seq", Value: bson.D{{Key: "$exists", Value: true}}},
+			}}},
+		})
+		if err != nil {
+			logging.Error(ctx, "Failed to watch events collection", "err", err)
+			retry := time.NewTimer(time.Second)
+			select {
+			case <-ctx.Done():
+				retry.Stop()
+				return
+			case <-retry.C:
+			}
+			continue
+		}
+		for cs.Next(ctx) {
+			change := struct {
+				DocumentKey struct {
+					ID primitive.ObjectID `bson:"_id"`
+				} `bson:"documentKey"`
+			}{}
+			if err := cs.Decode(&change); err != nil {
+				logging.Error(ctx, "Failed to decode event change", "err", err)
+				continue
+			}
+			event, err := GetEventByIDDB(ctx, change.DocumentKey.ID, s.eventTable)
+			if err != nil {
+				continue
+			}
+			s.eventBus.publish(event)
+		}
+		cs.Close(ctx)
+	}
+}
+
+// WatchEvents sends events of user {userID} after {resumeToken} and then every new one until {ctx} is done.
+// Only events of deal {dealID} and of {types} are sent if they are set
+func (s *service) WatchEvents(ctx context.Context, userID, resumeToken, dealID string, types []string, send func(*pb.Event) error) error {
+	if err := requireTable(s.eventTable, "Events"); err != nil {
+		return err
+	}
+	var lastSeq int64
+	if len(resumeToken) > 0 {
+		seq, err := strconv.ParseInt(resumeToken, 10, 64)
+		if err != nil || seq < 0 {
+			return dealerrors.Invalid([]*pb.FieldViolation{{Field: "resume_token", Description: "Must be resume token of received event"}})
+		}
+		lastSeq = seq
+	}
+	// Subscribe before reading the history, so nothing is lost in between
+	sub := s.eventBus.subscribe()
+	defer s.eventBus.unsubscribe(sub)
+
+	// Concurrent emits can store events out of seq order, so event with seq lower than the last sent one
+	// may still come. Seqs of recently sent events are kept, so such late events are sent once
+	sent := map[int64]struct{}{}
+	deliver := func(e *EventDB) error {
+		if _, ok := sent[e.Seq]; ok || !e.visibleTo(userID) {
+			return nil
+		}
+		sent[e.Seq] = struct{}{}
+		if e.Seq > lastSeq {
+			lastSeq = e.Seq
+			for seq := range sent {
+				if seq <= lastSeq-eventReorderWindow {
+					delete(sent, seq)
+				}
+			}
+		}
+		if len(dealID) > 0 && e.DealID != dealID {
+			return nil
+		}
+		if len(types) > 0 && !utils.StringInSlice(e.Type, types) {
+			return nil
+		}
+		return send(convertEvent(e))
+	}
+	catchUp := func(afterSeq int64) error {
+		for {
+			events, err := GetEventsAfterDB(ctx, userID, afterSeq, int64(maxPageSize), s.eventTable)
+			if err != nil {
+				return err
+			}
+			for _, e := range events {
+				if err := deliver(e); err != nil {
+					return err
+				}
+				afterSeq = e.Seq
+			}
+			if len(events) < maxPageSize {
+				return nil
+			}
+		}
+	}
+	if err := catchUp(lastSeq); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-sub.events:
+			if sub.takeLagged() {
+				// Dropped events could be late ones as well, so look back for them too
+				if err := catchUp(lastSeq - eventReorderWindow); err != nil {
+					return err
+				}
+			}
+			if !e.visibleTo(userID) {
+				continue
+			}
+			// Events between the last sent one and this one could be stored but not published yet
+			if e.Seq > lastSeq {
+				if err := catchUp(lastSeq); err != nil {
+					return err
+				}
+			}
+			if err := deliver(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func convertEvent(e *EventDB) *pb.Event {
+	return &pb.Event{
+		ResumeToken: strconv.FormatInt(e.Seq, 10),
+		Type:        e.Type,
+		DealId:      e.DealID,
+		Actor:       e.Actor,
+		Data:        e.Data,
+		Time:        e.Time.Format(timeoutLayout),
+	}
+}
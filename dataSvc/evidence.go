@@ -0,0 +1,272 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/DenysNahurnyi/deal/common/tracing"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/options"
+)
+
+const (
+	maxEvidenceSize    = 10 << 20
+	maxEvidenceNameLen = 256
+)
+
+// EvidenceDB is metadata of evidence that stores in the DB, content itself is kept in the blob store
+type EvidenceDB struct {
+	ID          primitive.ObjectID    `bson:"_id,omitempty"`
+	DealID      string                `bson:"deal_id"`
+	Uploader    string                `bson:"uploader"`
+	Side        pb.SideType           `bson:"side"`
+	Name        string                `bson:"name"`
+	ContentType string                `bson:"content_type"`
+	Hash        string                `bson:"hash"`
+	Size        int64                 `bson:"size"`
+	Time        time.Time             `bson:"time"`
+	Visibility  pb.EvidenceVisibility `bson:"visibility"`
+	BlobKey     string                `bson:"blob_key"`
+}
+
+// CreateEvidenceDB stores evidence metadata
+func CreateEvidenceDB(ctx context.Context, evidence EvidenceDB, table *mongo.Collection) error {
+	ctx, span := tracing.StartDB(ctx, "CreateEvidenceDB", table)
+	defer span.End()
+	_, err := table.InsertOne(ctx, evidence)
+	if err != nil {
+		logging.Error(ctx, "Error creating evidence in mongo", "err", err)
+	}
+	return err
+}
+
+// GetEvidenceByIDDB returns evidence metadata by it's id, nil if there is no such evidence
+func GetEvidenceByIDDB(ctx context.Context, evidenceID string, table *mongo.Collection) (*EvidenceDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetEvidenceByIDDB", table)
+	defer span.End()
+	evidenceIDDB, err := primitive.ObjectIDFromHex(evidenceID)
+	if err != nil {
+		logging.Error(ctx, "Error creating object id to get evidence", "err", err)
+		return nil, err
+	}
+	evidence := &EvidenceDB{}
+	err = table.FindOne(ctx, bson.D{{Key: "_id", Value: evidenceIDDB}}).Decode(evidence)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return nil, nil
+		}
+		logging.Error(ctx, "Error getting evidence from mongo", "err", err)
+		return nil, err
+	}
+	return evidence, nil
+}
+
+// GetDealEvidenceDB returns metadata of all evidence attached to the deal {dealID}, oldest first
+func GetDealEvidenceDB(ctx context.Context, dealID string, table *mongo.Collection) ([]*EvidenceDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetDealEvidenceDB", table)
+	defer span.End()
+	cursor, err := table.Find(ctx,
+		bson.D{{Key: "deal_id", Value: dealID}},
+		options.Find().SetSort(bson.D{{Key: "time", Value: 1}}),
+	)
+	if err != nil {
+		logging.Error(ctx, "Error getting evidence from mongo", "err", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	evidence := []*EvidenceDB{}
+	for cursor.Next(ctx) {
+		e := &EvidenceDB{}
+		if err := cursor.Decode(e); err != nil {
+			logging.Error(ctx, "Error getting evidence from mongo", "err", err)
+			return nil, err
+		}
+		evidence = append(evidence, e)
+	}
+	return evidence, nil
+}
+
+// dealRole returns side of user {userID} in the deal, JUDGE for judges of the deal
+func dealRole(dealDoc *DealDocumentDB, userID string) (pb.SideType, bool) {
+	pact, err := dealDoc.getCurrentPact()
+	if err == nil {
+		if side, p, ok := pact.findParticipant(userID); ok && p.Accepted {
+			return side, true
+		}
+	}
+	for _, j := range dealDoc.Judge.Participants {
+		if j.ID == userID && j.Accepted {
+			return pb.SideType_JUDGE, true
+		}
+	}
+	return pb.SideType_JUDGE, false
+}
+
+// isDecided checks whether the deal result is already known, evidence can't be changed after that
+func (dealDoc DealDocumentDB) isDecided() bool {
+	return dealDoc.Completed || dealDoc.hasStatus("WINNER_SET") || dealDoc.hasStatus("TIME_OUT") || dealDoc.hasStatus("CANCELLED")
+}
+
+// canSeeEvidence checks whether user with role {role} can see {evidence}
+func canSeeEvidence(evidence *EvidenceDB, userID string, role pb.SideType) bool {
+	if evidence.Uploader == userID || evidence.Visibility == pb.EvidenceVisibility_DEAL {
+		return true
+	}
+	// JUDGES visibility
+	return role == pb.SideType_JUDGE || role == evidence.Side
+}
+
+// UploadEvidence attaches {data} to the deal {dealID} as evidence of user {userID}
+func (s *service) UploadEvidence(ctx context.Context, userID, dealID, name, contentType string, visibility pb.EvidenceVisibility, data []byte) (*EvidenceDB, error) {
+	if err := requireTable(s.evidenceTable, "Evidence"); err != nil {
+		return nil, err
+	}
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	dealDoc, err := s.deals.GetByID(ctx, dealID)
+	if err != nil {
+		logging.Error(ctx, "Failed to get deal document from DB", "err", err)
+		return nil, err
+	}
+	if dealDoc == nil {
+		return nil, dealerrors.New(dealerrors.DEAL_NOT_FOUND, "Deal %s doesn't exist", dealID)
+	}
+	role, ok := dealRole(dealDoc, userID)
+	if !ok {
+		return nil, dealerrors.New(dealerrors.NOT_A_PARTICIPANT, "User %s doesn't participate in deal %s", userID, dealID)
+	}
+	if dealDoc.isDecided() {
+		return nil, dealerrors.New(dealerrors.INVALID_TRANSITION, "Deal %s is already decided, evidence can't be changed", dealID)
+	}
+
+	sum := sha256.Sum256(data)
+	evidence := EvidenceDB{
+		ID:          primitive.NewObjectID(),
+		DealID:      dealID,
+		Uploader:    userID,
+		Side:        role,
+		Name:        name,
+		ContentType: contentType,
+		Hash:        hex.EncodeToString(sum[:]),
+		Size:        int64(len(data)),
+		Time:        s.clock.Now(),
+		Visibility:  visibility,
+	}
+	evidence.BlobKey = dealID + "/" + evidence.ID.Hex()
+	err = s.evidenceStore.Put(ctx, evidence.BlobKey, data)
+	if err != nil {
+		logging.Error(ctx, "Failed to store evidence content", "err", err)
+		return nil, dealerrors.New(dealerrors.INTERNAL, "Failed to store evidence")
+	}
+	err = CreateEvidenceDB(ctx, evidence, s.evidenceTable)
+	if err != nil {
+		// Content without metadata is unreachable, don't keep it
+		if delErr := s.evidenceStore.Delete(ctx, evidence.BlobKey); delErr != nil {
+			logging.Error(ctx, "Failed to remove orphan evidence content "+evidence.BlobKey, "err", delErr)
+		}
+		return nil, err
+	}
+	s.emitVisibleDealEvent(ctx, EventEvidenceUploaded, dealID, userID, map[string]string{"evidence_id": evidence.ID.Hex()},
+		func(dealDoc *DealDocumentDB, id string) bool {
+			role, ok := dealRole(dealDoc, id)
+			return ok && canSeeEvidence(&evidence, id, role)
+		})
+	return &evidence, nil
+}
+
+// ListEvidence returns evidence of the deal {dealID} user {userID} is allowed to see
+func (s *service) ListEvidence(ctx context.Context, userID, dealID string) ([]*EvidenceDB, error) {
+	if err := requireTable(s.evidenceTable, "Evidence"); err != nil {
+		return nil, err
+	}
+	dealDoc, err := s.deals.GetByID(ctx, dealID)
+	if err != nil {
+		logging.Error(ctx, "Failed to get deal document from DB", "err", err)
+		return nil, err
+	}
+	if dealDoc == nil {
+		return nil, dealerrors.New(dealerrors.DEAL_NOT_FOUND, "Deal %s doesn't exist", dealID)
+	}
+	role, ok := dealRole(dealDoc, userID)
+	if !ok {
+		return nil, dealerrors.New(dealerrors.NOT_A_PARTICIPANT, "User %s doesn't participate in deal %s", userID, dealID)
+	}
+	all, err := GetDealEvidenceDB(ctx, dealID, s.evidenceTable)
+	if err != nil {
+		return nil, err
+	}
+	visible := []*EvidenceDB{}
+	for _, e := range all {
+		if canSeeEvidence(e, userID, role) {
+			visible = append(visible, e)
+		}
+	}
+	return visible, nil
+}
+
+// DownloadEvidence returns evidence {evidenceID} with it's content, content is checked against stored hash
+func (s *service) DownloadEvidence(ctx context.Context, userID, evidenceID string) (*EvidenceDB, []byte, error) {
+	if err := requireTable(s.evidenceTable, "Evidence"); err != nil {
+		return nil, nil, err
+	}
+	evidence, err := GetEvidenceByIDDB(ctx, evidenceID, s.evidenceTable)
+	if err != nil {
+		return nil, nil, err
+	}
+	if evidence == nil {
+		return nil, nil, dealerrors.New(dealerrors.NOT_FOUND, "Evidence %s doesn't exist", evidenceID)
+	}
+	dealDoc, err := s.deals.GetByID(ctx, evidence.DealID)
+	if err != nil {
+		logging.Error(ctx, "Failed to get deal document from DB", "err", err)
+		return nil, nil, err
+	}
+	if dealDoc == nil {
+		return nil, nil, dealerrors.New(dealerrors.DEAL_NOT_FOUND, "Deal %s doesn't exist", evidence.DealID)
+	}
+	role, ok := dealRole(dealDoc, userID)
+	// Don't tell outsiders that evidence exists
+	if !ok || !canSeeEvidence(evidence, userID, role) {
+		return nil, nil, dealerrors.New(dealerrors.NOT_FOUND, "Evidence %s doesn't exist", evidenceID)
+	}
+	data, err := s.evidenceStore.Get(ctx, evidence.BlobKey)
+	if err != nil {
+		logging.Error(ctx, "Failed to read evidence content "+evidence.BlobKey, "err", err)
+		return nil, nil, dealerrors.New(dealerrors.INTERNAL, "Failed to read evidence")
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != evidence.Hash {
+		return nil, nil, dealerrors.New(dealerrors.DATA_LOSS, "Evidence %s content doesn't match it's hash", evidenceID)
+	}
+	return evidence, data, nil
+}
+
+func convertEvidence(e *EvidenceDB) *pb.Evidence {
+	return &pb.Evidence{
+		Id:          e.ID.Hex(),
+		DealId:      e.DealID,
+		Uploader:    e.Uploader,
+		Side:        e.Side,
+		Name:        e.Name,
+		ContentType: e.ContentType,
+		Hash:        e.Hash,
+		Size:        e.Size,
+		Time:        e.Time.Format(timeoutLayout),
+		Visibility:  e.Visibility,
+	}
+}
@@ -0,0 +1,246 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	grpcutils "github.com/DenysNahurnyi/deal/common/grpc"
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/DenysNahurnyi/deal/common/tracing"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+)
+
+// Kinds of signed deal documents
+const (
+	SignatureAcceptance      = "ACCEPTANCE"
+	SignatureDecision        = "DECISION"
+	SignatureBlameActivation = "BLAME_ACTIVATION"
+)
+
+// signedAcceptancesSince is when acceptances started to be signed. Deals created after it must have hash and
+// signature of every acceptance, missing ones mean the deal was tampered with. Creation time of the deal
+// is taken from its id, so it can't be rewritten like other fields
+var signedAcceptancesSince = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// pactTerms is what parties agree to when they accept the pact. Participants are not part of it,
+// as sides are filled one by one after the terms are set
+type pactTerms struct {
+	Version     string `json:"version"`
+	Content     string `json:"content"`
+	Timeout     string `json:"timeout"`
+	Stake       int64  `json:"stake"`
+	RedMembers  int    `json:"red_members"`
+	BlueMembers int    `json:"blue_members"`
+}
+
+// hash returns hash of canonically serialized pact terms
+func (pact PactDB) hash() string {
+	// Struct fields are serialized in the declaration order, so the same terms always give the same bytes
+	raw, _ := json.Marshal(pactTerms{
+		Version:     pact.Version,
+		Content:     pact.Content,
+		Timeout:     pact.Timeout,
+		Stake:       pact.Stake,
+		RedMembers:  pact.Red.capacity(),
+		BlueMembers: pact.Blue.capacity(),
+	})
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// SignatureDB is a document about the deal signed by authSvc
+type SignatureDB struct {
+	Kind      string `bson:"kind"`
+	Signer    string `bson:"signer"`
+	Payload   string `bson:"payload"`   // Canonical JSON of signaturePayload
+	Signature string `bson:"signature"` // Base64 encoded
+}
+
+// signaturePayload is the signed content, it's bound to the pact terms the deal was accepted with
+type signaturePayload struct {
+	Kind     string            `json:"kind"`
+	DealID   string            `json:"deal_id"`
+	PactHash string            `json:"pact_hash"`
+	Signer   string            `json:"signer"`
+	Data     map[string]string `json:"data,omitempty"` // Maps are serialized with sorted keys
+	Time     string            `json:"time"`
+}
+
+// AddDealSignatureDB appends signature to the deal, signatures are never changed after that
+func AddDealSignatureDB(ctx context.Context, dealID string, signature SignatureDB, table *mongo.Collection) error {
+	ctx, span := tracing.StartDB(ctx, "AddDealSignatureDB", table)
+	defer span.End()
+	dealIDDB, err := primitive.ObjectIDFromHex(dealID)
+	if err != nil {
+		logging.Error(ctx, "Error creating object id to sign deal document", "err", err)
+		return err
+	}
+	_, err = table.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: dealIDDB}},
+		bson.D{{"$push", bson.D{{Key: "signatures", Value: signature}}}},
+	)
+	if err != nil {
+		logging.Error(ctx, "Error adding deal signature in mongo", "err", err)
+	}
+	return err
+}
+
+// signDeal asks authSvc to sign {kind} document of deal {dealDoc} made by {signer}
+func (s *service) signDeal(ctx context.Context, dealDoc *DealDocumentDB, kind, signer string, data map[string]string) (SignatureDB, error) {
+	pact, err := dealDoc.getCurrentPact()
+	if err != nil {
+		return SignatureDB{}, err
+	}
+	payload, err := json.Marshal(signaturePayload{
+		Kind:     kind,
+		DealID:   dealDoc.ID.Hex(),
+		PactHash: pact.hash(),
+		Signer:   signer,
+		Data:     data,
+		Time:     s.clock.Now().UTC().Format(timeoutLayout),
+	})
+	if err != nil {
+		return SignatureDB{}, err
+	}
+	resp, err := s.authSvcClient.Sign(ctx, &pb.SignReq{
+		ReqHdr:  &pb.ReqHdr{Tid: logging.Tid(ctx)},
+		Payload: payload,
+	})
+	if err != nil {
+		logging.Error(ctx, "Failed to sign "+kind+" of deal "+dealDoc.ID.Hex(), "err", err)
+		return SignatureDB{}, err
+	}
+	return SignatureDB{
+		Kind:      kind,
+		Signer:    signer,
+		Payload:   string(payload),
+		Signature: base64.StdEncoding.EncodeToString(resp.GetSignature()),
+	}, nil
+}
+
+// signAcceptance asks authSvc to sign that participant {userID} of {side} accepted current terms of {dealDoc}
+func (s *service) signAcceptance(ctx context.Context, dealDoc *DealDocumentDB, userID string, side pb.SideType) (SignatureDB, error) {
+	return s.signDeal(ctx, dealDoc, SignatureAcceptance, userID, map[string]string{"side": side.String()})
+}
+
+// acceptanceSigned checks that {dealDoc} has acceptance signature of participant {userID} of {side},
+// signature itself is checked with the rest of deal signatures
+func acceptanceSigned(dealDoc *DealDocumentDB, userID string, side pb.SideType) bool {
+	for _, sig := range dealDoc.Signatures {
+		if sig.Kind != SignatureAcceptance || sig.Signer != userID {
+			continue
+		}
+		payload := signaturePayload{}
+		if err := json.Unmarshal([]byte(sig.Payload), &payload); err == nil && payload.Data["side"] == side.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSignature checks that signature was made by authSvc and belongs to the deal with the current terms
+func (s *service) checkSignature(dealID, pactHash string, sig SignatureDB) error {
+	raw, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil {
+		return fmt.Errorf("signature is not base64")
+	}
+	uKey, err := s.GetPubKey()
+	if err != nil {
+		return fmt.Errorf("key of authSvc is not available: %v", err)
+	}
+	if err := grpcutils.VerifySignature(uKey, []byte(sig.Payload), raw); err != nil {
+		return fmt.Errorf("signature doesn't match the payload")
+	}
+	payload := signaturePayload{}
+	if err := json.Unmarshal([]byte(sig.Payload), &payload); err != nil {
+		return fmt.Errorf("payload is invalid")
+	}
+	if payload.DealID != dealID || payload.Kind != sig.Kind || payload.Signer != sig.Signer {
+		return fmt.Errorf("payload belongs to another document")
+	}
+	if payload.PactHash != pactHash {
+		return fmt.Errorf("deal terms changed after signing")
+	}
+	return nil
+}
+
+// VerifyDealIntegrity checks that current terms of deal {dealID} are the ones every party accepted
+// and that every signed document is genuine
+func (s *service) VerifyDealIntegrity(ctx context.Context, dealID string) (*pb.VerifyDealIntegrityResp, error) {
+	dealDoc, err := s.deals.GetByID(ctx, dealID)
+	if err != nil {
+		logging.Error(ctx, "Failed to get deal document from DB", "err", err)
+		return nil, err
+	}
+	if dealDoc == nil {
+		return nil, dealerrors.New(dealerrors.DEAL_NOT_FOUND, "Deal %s doesn't exist", dealID)
+	}
+	pact, err := dealDoc.getCurrentPact()
+	if err != nil {
+		return nil, dealerrors.New(dealerrors.DATA_LOSS, "Deal %s has no current pact", dealID)
+	}
+	pactHash := pact.hash()
+	res := &pb.VerifyDealIntegrityResp{
+		PactHash: pactHash,
+		Intact:   true,
+	}
+	signed := !dealDoc.ID.Timestamp().Before(signedAcceptancesSince)
+	check := func(side pb.SideType, participants []ParticipantDB) {
+		for _, p := range participants {
+			if !p.Accepted {
+				continue
+			}
+			acceptance := &pb.AcceptanceCheck{
+				UserId:   p.ID,
+				Side:     side,
+				PactHash: p.PactHash,
+				Valid:    p.PactHash == pactHash,
+			}
+			// Acceptances of deals created before signing was introduced may have no hash, they can't be checked.
+			// For newer deals blank hash or missing signature is tampering
+			if !signed && len(p.PactHash) == 0 {
+				acceptance.Unverifiable = true
+			} else if signed && !acceptanceSigned(dealDoc, p.ID, side) {
+				acceptance.Valid = false
+			}
+			if !acceptance.Valid && !acceptance.Unverifiable {
+				res.Intact = false
+			}
+			res.Acceptances = append(res.Acceptances, acceptance)
+		}
+	}
+	check(pb.SideType_RED, pact.Red.Participants)
+	check(pb.SideType_BLUE, pact.Blue.Participants)
+	check(pb.SideType_JUDGE, dealDoc.Judge.Participants)
+	for _, sig := range dealDoc.Signatures {
+		sigCheck := &pb.SignatureCheck{
+			Kind:      sig.Kind,
+			Signer:    sig.Signer,
+			Payload:   sig.Payload,
+			Signature: sig.Signature,
+			Valid:     true,
+		}
+		if err := s.checkSignature(dealID, pactHash, sig); err != nil {
+			sigCheck.Valid = false
+			sigCheck.Error = err.Error()
+			res.Intact = false
+		}
+		res.Signatures = append(res.Signatures, sigCheck)
+	}
+	return res, nil
+}
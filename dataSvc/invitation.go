@@ -0,0 +1,73 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/DenysNahurnyi/deal/pb/generated/pb"
+)
+
+// Max number of participants one side of the deal could have
+const maxSideMembers = 10
+
+// InvitationPolicy decides whether participant of the deal can invite somebody to the {targetSide}.
+// Policy isn't asked if the side is full: pending offers count against capacity() like accepted participants,
+// so a slot is freed only when the offer is declined by the invitee or revoked by the creator
+type InvitationPolicy interface {
+	CanInvite(pact PactDB, inviterID string, inviterSide, targetSide pb.SideType) error
+}
+
+// defaultInvitationPolicy lets every member invite teammates to their own side,
+// but only creator of the deal can invite opponents
+type defaultInvitationPolicy struct{}
+
+func (defaultInvitationPolicy) CanInvite(pact PactDB, inviterID string, inviterSide, targetSide pb.SideType) error {
+	if inviterSide == targetSide {
+		return nil
+	}
+	if pact.creatorID() != inviterID {
+		return dealerrors.New(dealerrors.NOT_CREATOR, "Only creator of the deal can invite participants to the opposite side")
+	}
+	return nil
+}
+
+func oppositeSide(side pb.SideType) pb.SideType {
+	if side == pb.SideType_RED {
+		return pb.SideType_BLUE
+	}
+	return pb.SideType_RED
+}
+
+// checkInvitation checks whether {inviterID} can invite {inviteeID} to the deal and returns side invitee will be offered
+func (s *service) checkInvitation(ctx context.Context, dealDoc *DealDocumentDB, inviterID, inviteeID string, teammate bool) (pb.SideType, error) {
+	pact, err := dealDoc.getCurrentPactRef()
+	if err != nil {
+		return pb.SideType_JUDGE, err
+	}
+	inviterSide, inviter, ok := pact.findParticipant(inviterID)
+	if !ok || !inviter.Accepted {
+		return pb.SideType_JUDGE, dealerrors.New(dealerrors.NOT_A_PARTICIPANT, "User %s can't invite to deal %s because they don't participate in it", inviterID, dealDoc.ID.Hex())
+	}
+	if _, _, ok := pact.findParticipant(inviteeID); ok {
+		return pb.SideType_JUDGE, dealerrors.New(dealerrors.ALREADY_PARTICIPATES, "User %s is already on the side of deal %s", inviteeID, dealDoc.ID.Hex())
+	}
+	targetSide := inviterSide
+	if !teammate {
+		targetSide = oppositeSide(inviterSide)
+	}
+	if err := s.invitationPolicy.CanInvite(*pact, inviterID, inviterSide, targetSide); err != nil {
+		return pb.SideType_JUDGE, err
+	}
+	if side := pact.getSide(targetSide); side.isFull() {
+		return pb.SideType_JUDGE, dealerrors.New(dealerrors.FAILED_PRECONDITION, "%s side of deal %s is full", targetSide.String(), dealDoc.ID.Hex())
+	}
+	logging.Info(ctx, "User invites user to deal", "inviter", inviterID, "invitee", inviteeID, "side", targetSide.String(), "deal", dealDoc.ID.Hex())
+	return targetSide, nil
+}
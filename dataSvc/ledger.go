@@ -0,0 +1,478 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/DenysNahurnyi/deal/common/tracing"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/options"
+)
+
+// Ledger is double-entry: every transfer writes two entries with the same tx_id, debit of the source
+// account and credit of the destination one, so the sum of all entries is always zero.
+// Accounts are named by their owner: users, deal escrows and system accounts that issue credits.
+const (
+	systemAccountPrefix = "system:"
+	mintAccount         = systemAccountPrefix + "mint"
+	// Credits every new wallet gets
+	initialCredits int64 = 1000
+
+	LedgerKindMint     = "MINT"
+	LedgerKindEscrow   = "ESCROW"
+	LedgerKindPayout   = "PAYOUT"
+	LedgerKindRefund   = "REFUND"
+	LedgerKindClawback = "CLAWBACK"
+)
+
+// WalletDB is a balance of one ledger account
+type WalletDB struct {
+	Account string `bson:"_id"`
+	Balance int64  `bson:"balance"`
+}
+
+// LedgerEntryDB is one leg of the transfer that stores in the DB
+type LedgerEntryDB struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty"`
+	TxID    string             `bson:"tx_id"`
+	Account string             `bson:"account"`
+	Amount  int64              `bson:"amount"`
+	DealID  string             `bson:"deal_id,omitempty"`
+	Kind    string             `bson:"kind"`
+	Time    time.Time          `bson:"time"`
+}
+
+func userAccount(userID string) string {
+	return "user:" + userID
+}
+
+func escrowAccount(dealID string) string {
+	return "escrow:" + dealID
+}
+
+// GetWalletDB returns wallet of account {account}, nil if account has no wallet yet
+func GetWalletDB(ctx context.Context, account string, table *mongo.Collection) (*WalletDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetWalletDB", table)
+	defer span.End()
+	wallet := &WalletDB{}
+	err := table.FindOne(ctx, bson.D{{Key: "_id", Value: account}}).Decode(wallet)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return nil, nil
+		}
+		logging.Error(ctx, "Error getting wallet from mongo", "err", err)
+		return nil, err
+	}
+	return wallet, nil
+}
+
+// TransferDB moves {amount} credits from account {from} to account {to} and records both legs in the ledger at {now}.
+// Balance can go below zero only for system accounts and clawbacks
+func TransferDB(ctx context.Context, from, to string, amount int64, dealID, kind string, now time.Time, walletTable, ledgerTable *mongo.Collection) error {
+	ctx, span := tracing.StartDB(ctx, "TransferDB", walletTable)
+	defer span.End()
+	if amount <= 0 {
+		return fmt.Errorf("Invalid transfer amount %d from %s to %s", amount, from, to)
+	}
+	res, err := walletTable.UpdateOne(ctx,
+		debitFilter(from, amount, kind),
+		bson.D{{"$inc", bson.D{{Key: "balance", Value: -amount}}}},
+		options.Update().SetUpsert(isSystemAccount(from)),
+	)
+	if err != nil {
+		logging.Error(ctx, "Error debiting wallet in mongo", "err", err)
+		return err
+	}
+	if res.MatchedCount == 0 && res.UpsertedCount == 0 {
+		return dealerrors.New(dealerrors.INSUFFICIENT_FUNDS, "Account %s doesn't have %d credits", from, amount)
+	}
+	_, err = walletTable.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: to}},
+		bson.D{{"$inc", bson.D{{Key: "balance", Value: amount}}}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		logging.Error(ctx, "Error crediting wallet in mongo", "err", err)
+		return err
+	}
+	return recordTransferDB(ctx, from, to, amount, dealID, kind, now, ledgerTable)
+}
+
+func isSystemAccount(account string) bool {
+	return strings.HasPrefix(account, systemAccountPrefix)
+}
+
+// canOverdraw tells whether transfer of {kind} can take {from} balance below zero
+func canOverdraw(from, kind string) bool {
+	return isSystemAccount(from) || kind == LedgerKindClawback
+}
+
+// debitFilter matches wallet {from} only if it has {amount} credits, unless it can be overdrawn
+func debitFilter(from string, amount int64, kind string) bson.D {
+	filter := bson.D{{Key: "_id", Value: from}}
+	if !canOverdraw(from, kind) {
+		filter = append(filter, bson.E{Key: "balance", Value: bson.D{{Key: "$gte", Value: amount}}})
+	}
+	return filter
+}
+
+// recordTransferDB writes both legs of the transfer to the ledger
+func recordTransferDB(ctx context.Context, from, to string, amount int64, dealID, kind string, now time.Time, ledgerTable *mongo.Collection) error {
+	txID := primitive.NewObjectID().Hex()
+	_, err := ledgerTable.InsertMany(ctx, []interface{}{
+		LedgerEntryDB{TxID: txID, Account: from, Amount: -amount, DealID: dealID, Kind: kind, Time: now},
+		LedgerEntryDB{TxID: txID, Account: to, Amount: amount, DealID: dealID, Kind: kind, Time: now},
+	})
+	if err != nil {
+		logging.Error(ctx, "Error writing ledger entries to mongo", "err", err)
+	}
+	return err
+}
+
+// CreateWalletDB creates wallet of {account} with {credits} minted to it at {now}. Wallet is created by a single upsert,
+// so concurrent calls mint credits only once. Returns false if wallet already exists
+func CreateWalletDB(ctx context.Context, account string, credits int64, now time.Time, walletTable, ledgerTable *mongo.Collection) (bool, error) {
+	ctx, span := tracing.StartDB(ctx, "CreateWalletDB", walletTable)
+	defer span.End()
+	res, err := walletTable.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: account}},
+		bson.D{{"$setOnInsert", bson.D{{Key: "balance", Value: credits}}}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		// Concurrent upsert of the same id can fail on the unique index instead of matching the created wallet
+		if strings.Contains(err.Error(), "E11000") {
+			return false, nil
+		}
+		logging.Error(ctx, "Error creating wallet in mongo", "err", err)
+		return false, err
+	}
+	if res.UpsertedCount == 0 {
+		return false, nil
+	}
+	// Credits of the new wallet are taken from the mint account, so the ledger stays balanced
+	_, err = walletTable.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: mintAccount}},
+		bson.D{{"$inc", bson.D{{Key: "balance", Value: -credits}}}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		logging.Error(ctx, "Error debiting mint account in mongo", "err", err)
+		return true, err
+	}
+	return true, recordTransferDB(ctx, mintAccount, account, credits, "", LedgerKindMint, now, ledgerTable)
+}
+
+// GetLedgerEntriesDB returns entries of account {account}, newest first
+func GetLedgerEntriesDB(ctx context.Context, account string, skip, limit int64, table *mongo.Collection) ([]*LedgerEntryDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetLedgerEntriesDB", table)
+	defer span.End()
+	opts := options.Find().
+		SetSort(bson.D{{Key: "time", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+	cursor, err := table.Find(ctx, bson.D{{Key: "account", Value: account}}, opts)
+	if err != nil {
+		logging.Error(ctx, "Error getting ledger entries from mongo", "err", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []*LedgerEntryDB{}
+	for cursor.Next(ctx) {
+		e := &LedgerEntryDB{}
+		if err := cursor.Decode(e); err != nil {
+			logging.Error(ctx, "Error getting ledger entries from mongo", "err", err)
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// txHooksKey keeps in the context functions that run once its transaction is committed
+type txHooksKey struct{}
+
+type txHooks struct {
+	fns []func(ctx context.Context) error
+	// err fails the transaction even if its function succeeds, see failTransaction
+	err error
+}
+
+// afterCommit runs {fn} once the transaction of {ctx} is committed, so side effects that can't be rolled back,
+// like calls to other services, don't happen for aborted changes. Outside of the transaction {fn} runs right away.
+// {fn} gets the context without mongo session, error of {fn} is returned by inTransaction
+func afterCommit(ctx context.Context, fn func(ctx context.Context) error) error {
+	if hooks, ok := ctx.Value(txHooksKey{}).(*txHooks); ok {
+		hooks.fns = append(hooks.fns, fn)
+		return nil
+	}
+	return fn(ctx)
+}
+
+// failTransaction makes the transaction of {ctx} fail with {err} once its function returns, it's used by writes
+// that belong to the change but don't return errors to the caller, like events. Outside of the transaction it does nothing
+func failTransaction(ctx context.Context, err error) {
+	if hooks, ok := ctx.Value(txHooksKey{}).(*txHooks); ok && hooks.err == nil {
+		hooks.err = err
+	}
+}
+
+// inTransaction runs {fn} in the mongo transaction, so deal status changes and money movement are applied together.
+// If {ctx} already belongs to the transaction, fn joins it. Service without mongo (in-memory repos) or on top of
+// standalone mongod, that can't run transactions, just runs {fn}, that's why deals with stakes are rejected there (see checkStakes). Functions passed to afterCommit run once
+// {fn} succeeds and the transaction is committed
+func (s *service) inTransaction(ctx context.Context, fn func(sc context.Context) error) error {
+	if _, ok := ctx.Value(txHooksKey{}).(*txHooks); ok {
+		return fn(ctx)
+	}
+	hooks := &txHooks{}
+	err := s.runTransaction(context.WithValue(ctx, txHooksKey{}, hooks), func(sc context.Context) error {
+		if err := fn(sc); err != nil {
+			return err
+		}
+		return hooks.err
+	})
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks.fns {
+		if hookErr := hook(ctx); hookErr != nil && err == nil {
+			err = hookErr
+		}
+	}
+	return err
+}
+
+func (s *service) runTransaction(ctx context.Context, fn func(sc context.Context) error) error {
+	if s.mongoClient == nil || !s.transactions {
+		return fn(ctx)
+	}
+	sess, err := s.mongoClient.StartSession()
+	if err != nil {
+		logging.Error(ctx, "Failed to start mongo session", "err", err)
+		return err
+	}
+	defer sess.EndSession(ctx)
+	return mongo.WithSession(ctx, sess, func(sc mongo.SessionContext) error {
+		if err := sess.StartTransaction(); err != nil {
+			return err
+		}
+		if err := fn(sc); err != nil {
+			if abortErr := sess.AbortTransaction(sc); abortErr != nil {
+				logging.Error(ctx, "Failed to abort transaction", "err", abortErr)
+			}
+			return err
+		}
+		return sess.CommitTransaction(sc)
+	})
+}
+
+// supportsTransactions checks that {mgc} is connected to the replica set or sharded cluster,
+// standalone mongod (e.g. mLab sandbox) fails every transaction
+func supportsTransactions(ctx context.Context, mgc *mongo.Client) (bool, error) {
+	info := struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}{}
+	err := mgc.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&info)
+	if err != nil {
+		return false, err
+	}
+	return len(info.SetName) > 0 || info.Msg == "isdbgrid", nil
+}
+
+// checkStakes fails if this service can't run deal with {stake}. Escrow is written together with the judge assignment
+// and deal start, standalone mongod can't roll them back, so stakes are allowed only with transactions
+func (s *service) checkStakes(stake int64) error {
+	if stake == 0 {
+		return nil
+	}
+	if s.ledger == nil {
+		return dealerrors.New(dealerrors.UNIMPLEMENTED, "Stakes are not supported by this service")
+	}
+	if !s.transactions {
+		return dealerrors.New(dealerrors.UNIMPLEMENTED, "Stakes need mongo replica set, this database can't run transactions")
+	}
+	return nil
+}
+
+// checkBalance fails with INSUFFICIENT_FUNDS if user {userID} can't pay {stake}. It's checked on every acceptance,
+// so deal isn't accepted by participant that would fail its escrow once judge takes it
+func (s *service) checkBalance(ctx context.Context, userID string, stake int64) error {
+	if stake == 0 {
+		return nil
+	}
+	wallet, err := s.ensureWallet(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if wallet.Balance < stake {
+		return dealerrors.New(dealerrors.INSUFFICIENT_FUNDS, "User %s has %d credits, but stake of the deal is %d", userID, wallet.Balance, stake)
+	}
+	return nil
+}
+
+// ensureWallet creates wallet for user {userID} with initial credits if user doesn't have one
+func (s *service) ensureWallet(ctx context.Context, userID string) (*WalletDB, error) {
+	account := userAccount(userID)
+	wallet, err := s.ledger.GetWallet(ctx, account)
+	if err != nil || wallet != nil {
+		return wallet, err
+	}
+	_, err = s.ledger.CreateWallet(ctx, account, initialCredits, s.clock.Now())
+	if err != nil {
+		logging.Error(ctx, "Failed to create wallet for user "+userID, "err", err)
+		return nil, err
+	}
+	// Wallet could be created by concurrent call, so it's read back either way
+	wallet, err = s.ledger.GetWallet(ctx, account)
+	if err == nil && wallet == nil {
+		err = fmt.Errorf("Wallet of user %s is not found after creation", userID)
+	}
+	return wallet, err
+}
+
+// GetWallet returns balance of user {userID} and page of their ledger entries
+func (s *service) GetWallet(ctx context.Context, userID string, page, pageSize int) (*WalletDB, []*LedgerEntryDB, error) {
+	if s.ledger == nil {
+		return nil, nil, dealerrors.New(dealerrors.UNIMPLEMENTED, "Wallets are not supported by this service")
+	}
+	var wallet *WalletDB
+	err := s.inTransaction(ctx, func(sc context.Context) error {
+		var err error
+		wallet, err = s.ensureWallet(sc, userID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	skip, limit := pageBounds(page, pageSize)
+	entries, err := s.ledger.GetEntries(ctx, userAccount(userID), int64(skip), int64(limit))
+	if err != nil {
+		return nil, nil, err
+	}
+	return wallet, entries, nil
+}
+
+// escrowStakes moves stake of every participant to the deal escrow account
+func (s *service) escrowStakes(ctx context.Context, dealDoc *DealDocumentDB) error {
+	pact, err := dealDoc.getCurrentPact()
+	if err != nil {
+		return err
+	}
+	if pact.Stake == 0 {
+		return nil
+	}
+	dealID := dealDoc.ID.Hex()
+	if s.ledger == nil {
+		return fmt.Errorf("Deal %s has stake, but service has no wallets", dealID)
+	}
+	for _, p := range pact.participants() {
+		if _, err := s.ensureWallet(ctx, p.ID); err != nil {
+			return err
+		}
+		err = s.ledger.Transfer(ctx, userAccount(p.ID), escrowAccount(dealID), pact.Stake, dealID, LedgerKindEscrow, s.clock.Now())
+		if err != nil {
+			logging.Error(ctx, "Failed to escrow stake of user "+p.ID+" in deal "+dealID, "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// settleStakes pays the escrow out to the winner side, or refunds every participant if deal has no winner
+func (s *service) settleStakes(ctx context.Context, dealDoc *DealDocumentDB, dealStatus string) error {
+	pact, err := dealDoc.getCurrentPact()
+	if err != nil {
+		return err
+	}
+	if pact.Stake == 0 {
+		return nil
+	}
+	dealID := dealDoc.ID.Hex()
+	if dealStatus != "WINNER_SET" {
+		for _, p := range pact.participants() {
+			err = s.ledger.Transfer(ctx, escrowAccount(dealID), userAccount(p.ID), pact.Stake, dealID, LedgerKindRefund, s.clock.Now())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s.moveShares(ctx, pact, dealID, dealDoc.effectiveWinner(), false)
+}
+
+// reverseStakes claws payout back from the side that was winner before the blame and pays it to the new one.
+// Deals that are not paid out yet need nothing, timeout will pay the actual winner
+func (s *service) reverseStakes(ctx context.Context, dealDoc *DealDocumentDB, oldWinner string) error {
+	pact, err := dealDoc.getCurrentPact()
+	if err != nil {
+		return err
+	}
+	newWinner := dealDoc.effectiveWinner()
+	if pact.Stake == 0 || oldWinner == newWinner || !dealDoc.hasStatus("WINNER_SET") || !dealDoc.hasStatus("TIME_OUT") {
+		return nil
+	}
+	dealID := dealDoc.ID.Hex()
+	if err := s.moveShares(ctx, pact, dealID, oldWinner, true); err != nil {
+		logging.Error(ctx, "Failed to claw back payout of deal "+dealID, "err", err)
+		return err
+	}
+	return s.moveShares(ctx, pact, dealID, newWinner, false)
+}
+
+// moveShares splits the whole deal pot between participants of {winner} side, pays them from escrow
+// or, in case of clawback, returns their shares to escrow
+func (s *service) moveShares(ctx context.Context, pact PactDB, dealID, winner string, clawback bool) error {
+	winners := pact.Red.Participants
+	if winner == "blue" {
+		winners = pact.Blue.Participants
+	}
+	if len(winners) == 0 {
+		return fmt.Errorf("Deal %s has no participants on %s side to pay", dealID, winner)
+	}
+	pot := pact.Stake * int64(len(pact.participants()))
+	share := pot / int64(len(winners))
+	for i, w := range winners {
+		amount := share
+		if i == 0 {
+			amount += pot % int64(len(winners))
+		}
+		var err error
+		if clawback {
+			err = s.ledger.Transfer(ctx, userAccount(w.ID), escrowAccount(dealID), amount, dealID, LedgerKindClawback, s.clock.Now())
+		} else {
+			err = s.ledger.Transfer(ctx, escrowAccount(dealID), userAccount(w.ID), amount, dealID, LedgerKindPayout, s.clock.Now())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func convertLedgerEntry(e *LedgerEntryDB) *pb.LedgerEntry {
+	return &pb.LedgerEntry{
+		Id:      e.ID.Hex(),
+		TxId:    e.TxID,
+		Account: e.Account,
+		Amount:  e.Amount,
+		DealId:  e.DealID,
+		Kind:    e.Kind,
+		Time:    e.Time.Format(timeoutLayout),
+	}
+}
@@ -0,0 +1,209 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+)
+
+// newTestLedgerService returns service with in-memory wallets that can run deals with stakes
+func newTestLedgerService() *service {
+	s := newTestService(NewMemDealRepo())
+	s.ledger = NewMemLedgerRepo()
+	s.transactions = true
+	return s
+}
+
+// newStakedDeal returns deal with {stake} where {red} and {blue} participants accepted it
+func newStakedDeal(stake int64, red, blue []string) *DealDocumentDB {
+	pact := PactDB{Version: "initial(#1)", Stake: stake}
+	for _, id := range red {
+		pact.Red.Participants = append(pact.Red.Participants, ParticipantDB{ID: id, Accepted: true})
+	}
+	for _, id := range blue {
+		pact.Blue.Participants = append(pact.Blue.Participants, ParticipantDB{ID: id, Accepted: true})
+	}
+	return &DealDocumentDB{
+		ID:           primitive.NewObjectID(),
+		Type:         "COMMON",
+		Pacts:        []PactDB{pact},
+		FinalVersion: pact.Version,
+	}
+}
+
+func expectBalance(t *testing.T, s *service, account string, want int64) {
+	t.Helper()
+	wallet, err := s.ledger.GetWallet(context.Background(), account)
+	if err != nil || wallet == nil {
+		t.Fatalf("Failed to get wallet %s: %v", account, err)
+	}
+	if wallet.Balance != want {
+		t.Fatalf("Account %s has %d credits, want %d", account, wallet.Balance, want)
+	}
+}
+
+func TestDebitFilterGuardsBalance(t *testing.T) {
+	hasGuard := func(filter bson.D) bool {
+		for _, e := range filter {
+			if e.Key == "balance" {
+				return true
+			}
+		}
+		return false
+	}
+	if filter := debitFilter(userAccount("alice"), 10, LedgerKindEscrow); !hasGuard(filter) {
+		t.Fatalf("Debit of user has no balance guard: %v", filter)
+	}
+	if filter := debitFilter(mintAccount, 10, LedgerKindMint); hasGuard(filter) {
+		t.Fatalf("Debit of system account has balance guard: %v", filter)
+	}
+	if filter := debitFilter(userAccount("alice"), 10, LedgerKindClawback); hasGuard(filter) {
+		t.Fatalf("Clawback has balance guard: %v", filter)
+	}
+}
+
+func TestTransferRejectsInsufficientFunds(t *testing.T) {
+	ctx := context.Background()
+	s := newTestLedgerService()
+	if _, err := s.ensureWallet(ctx, "alice"); err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	err := s.ledger.Transfer(ctx, userAccount("alice"), escrowAccount("deal"), initialCredits+1, "deal", LedgerKindEscrow, s.clock.Now())
+	if e := dealerrors.Parse(err); e == nil || e.Code != dealerrors.INSUFFICIENT_FUNDS {
+		t.Fatalf("Transfer over balance failed with %v, want INSUFFICIENT_FUNDS", err)
+	}
+	err = s.ledger.Transfer(ctx, userAccount("bob"), escrowAccount("deal"), 1, "deal", LedgerKindEscrow, s.clock.Now())
+	if e := dealerrors.Parse(err); e == nil || e.Code != dealerrors.INSUFFICIENT_FUNDS {
+		t.Fatalf("Transfer from missing wallet failed with %v, want INSUFFICIENT_FUNDS", err)
+	}
+	expectBalance(t, s, userAccount("alice"), initialCredits)
+	if err := s.checkBalance(ctx, "alice", initialCredits+1); err == nil {
+		t.Fatalf("Stake over balance passed the check")
+	}
+}
+
+func TestEnsureWalletMintsOnce(t *testing.T) {
+	ctx := context.Background()
+	s := newTestLedgerService()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.ensureWallet(ctx, "alice"); err != nil {
+				t.Errorf("Failed to ensure wallet: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	expectBalance(t, s, userAccount("alice"), initialCredits)
+	expectBalance(t, s, mintAccount, -initialCredits)
+	entries, _ := s.ledger.GetEntries(ctx, userAccount("alice"), 0, 0)
+	if len(entries) != 1 || entries[0].Kind != LedgerKindMint {
+		t.Fatalf("Wallet has entries %+v, want one mint", entries)
+	}
+}
+
+func TestMoveSharesSplitsRemainder(t *testing.T) {
+	ctx := context.Background()
+	s := newTestLedgerService()
+	dealDoc := newStakedDeal(5, []string{"red1", "red2"}, []string{"blue"})
+	dealDoc.Winner = "red"
+	if err := s.escrowStakes(ctx, dealDoc); err != nil {
+		t.Fatalf("Failed to escrow stakes: %v", err)
+	}
+	expectBalance(t, s, escrowAccount(dealDoc.ID.Hex()), 15)
+	if err := s.settleStakes(ctx, dealDoc, "WINNER_SET"); err != nil {
+		t.Fatalf("Failed to pay winners: %v", err)
+	}
+	// Pot of 15 doesn't split evenly, the first winner gets the remainder
+	expectBalance(t, s, userAccount("red1"), initialCredits-5+8)
+	expectBalance(t, s, userAccount("red2"), initialCredits-5+7)
+	expectBalance(t, s, userAccount("blue"), initialCredits-5)
+	expectBalance(t, s, escrowAccount(dealDoc.ID.Hex()), 0)
+}
+
+func TestSettleStakesRefundsExpiredDeal(t *testing.T) {
+	ctx := context.Background()
+	s := newTestLedgerService()
+	dealDoc := newStakedDeal(100, []string{"red"}, []string{"blue1", "blue2"})
+	if err := s.escrowStakes(ctx, dealDoc); err != nil {
+		t.Fatalf("Failed to escrow stakes: %v", err)
+	}
+	// Judge didn't decide before timeout, everyone gets the stake back
+	if err := s.settleStakes(ctx, dealDoc, "JUDGE_ACCEPTED"); err != nil {
+		t.Fatalf("Failed to refund stakes: %v", err)
+	}
+	for _, id := range []string{"red", "blue1", "blue2"} {
+		expectBalance(t, s, userAccount(id), initialCredits)
+	}
+	expectBalance(t, s, escrowAccount(dealDoc.ID.Hex()), 0)
+}
+
+func TestEscrowStakesFailsWithoutFunds(t *testing.T) {
+	ctx := context.Background()
+	s := newTestLedgerService()
+	dealDoc := newStakedDeal(initialCredits+1, []string{"red"}, []string{"blue"})
+	err := s.escrowStakes(ctx, dealDoc)
+	if e := dealerrors.Parse(err); e == nil || e.Code != dealerrors.INSUFFICIENT_FUNDS {
+		t.Fatalf("Escrow over balance failed with %v, want INSUFFICIENT_FUNDS", err)
+	}
+}
+
+func TestReverseStakesClawsBackPayout(t *testing.T) {
+	ctx := context.Background()
+	s := newTestLedgerService()
+	dealDoc := newStakedDeal(10, []string{"red"}, []string{"blue1", "blue2"})
+	dealDoc.Winner = "red"
+	at := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	dealDoc.Status = []Status{{Name: "WINNER_SET", Time: at}, {Name: "TIME_OUT", Time: at}}
+	if err := s.escrowStakes(ctx, dealDoc); err != nil {
+		t.Fatalf("Failed to escrow stakes: %v", err)
+	}
+	if err := s.settleStakes(ctx, dealDoc, "WINNER_SET"); err != nil {
+		t.Fatalf("Failed to pay winners: %v", err)
+	}
+	expectBalance(t, s, userAccount("red"), initialCredits+20)
+	// Blame makes blue side the winner, payout moves from red to blue
+	dealDoc.Blamed = "Yes"
+	if err := s.reverseStakes(ctx, dealDoc, "red"); err != nil {
+		t.Fatalf("Failed to reverse stakes: %v", err)
+	}
+	expectBalance(t, s, userAccount("red"), initialCredits-10)
+	expectBalance(t, s, userAccount("blue1"), initialCredits+5)
+	expectBalance(t, s, userAccount("blue2"), initialCredits+5)
+	expectBalance(t, s, escrowAccount(dealDoc.ID.Hex()), 0)
+	// Deal that isn't paid out yet needs nothing
+	unpaid := newStakedDeal(10, []string{"red"}, []string{"blue"})
+	unpaid.Winner, unpaid.Blamed = "red", "Yes"
+	if err := s.reverseStakes(ctx, unpaid, "red"); err != nil {
+		t.Fatalf("Reverse of unpaid deal failed: %v", err)
+	}
+}
+
+func TestStakesNeedTransactions(t *testing.T) {
+	s := newTestLedgerService()
+	s.transactions = false
+	err := s.checkStakes(10)
+	if e := dealerrors.Parse(err); e == nil || e.Code != dealerrors.UNIMPLEMENTED {
+		t.Fatalf("Stake without transactions failed with %v, want UNIMPLEMENTED", err)
+	}
+	if err := s.checkStakes(0); err != nil {
+		t.Fatalf("Deal without stake failed: %v", err)
+	}
+	if _, err := s.CreateDealDocument(context.Background(), primitive.NewObjectID().Hex(), &pb.Pact{Content: "Terms", Timeout: "2019-01-02T00:00:00Z", Stake: 10}); err == nil {
+		t.Fatalf("Deal with stake was created without transactions")
+	}
+}
@@ -0,0 +1,404 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	"github.com/DenysNahurnyi/deal/common/utils"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+)
+
+// bsonCopy copies {src} to {dst} through bson, the same way documents go to mongo and back.
+// When {dst} already has data, only fields present in {src} are overwritten, like mongo $set does
+func bsonCopy(src, dst interface{}) error {
+	raw, err := bson.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, dst)
+}
+
+// nestDotted turns "a.b" keys of mongo $set {update} into nested documents, so bsonCopy sets nested fields like mongo does
+func nestDotted(update bson.D) bson.D {
+	res := bson.D{}
+	for _, e := range update {
+		parts := strings.SplitN(e.Key, ".", 2)
+		if len(parts) == 1 {
+			res = append(res, e)
+			continue
+		}
+		i := 0
+		for i < len(res) && res[i].Key != parts[0] {
+			i++
+		}
+		if i == len(res) {
+			res = append(res, bson.E{Key: parts[0], Value: bson.D{}})
+		}
+		nested, _ := res[i].Value.(bson.D)
+		res[i].Value = nestDotted(append(nested, bson.E{Key: parts[1], Value: e.Value}))
+	}
+	return res
+}
+
+// memUserRepo is UserRepo that keeps users in memory, it's safe for concurrent use
+type memUserRepo struct {
+	m     sync.RWMutex
+	users map[string]*UserDB
+}
+
+// NewMemUserRepo creates empty in-memory UserRepo
+func NewMemUserRepo() UserRepo {
+	return &memUserRepo{users: map[string]*UserDB{}}
+}
+
+func (r *memUserRepo) Create(ctx context.Context, user UserDB) (string, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if user.ID == (primitive.ObjectID{}) {
+		user.ID = primitive.NewObjectID()
+	}
+	id := user.ID.Hex()
+	if _, ok := r.users[id]; ok {
+		return "", fmt.Errorf("User %s already exists", id)
+	}
+	stored := &UserDB{}
+	if err := bsonCopy(user, stored); err != nil {
+		return "", err
+	}
+	r.users[id] = stored
+	return id, nil
+}
+
+// get returns copy of user {userID}, caller has to hold the lock
+func (r *memUserRepo) get(userID string) (*UserDB, error) {
+	stored, ok := r.users[userID]
+	if !ok {
+		return nil, nil
+	}
+	user := &UserDB{}
+	if err := bsonCopy(stored, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// find returns copies of users that match {match}, ordered by username
+func (r *memUserRepo) find(match func(*UserDB) bool) ([]*UserDB, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	users := []*UserDB{}
+	for id, stored := range r.users {
+		if !match(stored) {
+			continue
+		}
+		user, err := r.get(id)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return users, nil
+}
+
+func (r *memUserRepo) GetByID(ctx context.Context, userID string) (*UserDB, error) {
+	if _, err := primitive.ObjectIDFromHex(userID); err != nil {
+		return nil, err
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.get(userID)
+}
+
+func (r *memUserRepo) GetByUsername(ctx context.Context, username string) (*UserDB, string, error) {
+	users, err := r.find(func(u *UserDB) bool {
+		return u.Username == username
+	})
+	if err != nil || len(users) == 0 {
+		return nil, "", err
+	}
+	return users[0], users[0].ID.Hex(), nil
+}
+
+func (r *memUserRepo) Search(ctx context.Context, prefix string, skip, limit int64) ([]*UserDB, error) {
+	prefix = strings.ToLower(prefix)
+	users, err := r.find(func(u *UserDB) bool {
+		return strings.HasPrefix(strings.ToLower(u.Username), prefix)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if skip >= int64(len(users)) {
+		return []*UserDB{}, nil
+	}
+	users = users[skip:]
+	if limit > 0 && limit < int64(len(users)) {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (r *memUserRepo) GetJudges(ctx context.Context) ([]*UserDB, error) {
+	return r.find(func(u *UserDB) bool {
+		return u.IsJudge
+	})
+}
+
+func (r *memUserRepo) Update(ctx context.Context, userID string, user *UserDB) error {
+	if _, err := primitive.ObjectIDFromHex(userID); err != nil {
+		return err
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	stored, ok := r.users[userID]
+	if !ok {
+		// Mongo doesn't complain about update that matched nothing either
+		return nil
+	}
+	return bsonCopy(nestDotted(user.toMongoFormat()), stored)
+}
+
+func (r *memUserRepo) Delete(ctx context.Context, userID string) (*UserDB, error) {
+	if _, err := primitive.ObjectIDFromHex(userID); err != nil {
+		return nil, err
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	user, err := r.get(userID)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	delete(r.users, userID)
+	return user, nil
+}
+
+// memDealRepo is DealRepo that keeps deals in memory, it's safe for concurrent use
+type memDealRepo struct {
+	m     sync.RWMutex
+	deals map[string]*DealDocumentDB
+}
+
+// NewMemDealRepo creates empty in-memory DealRepo
+func NewMemDealRepo() DealRepo {
+	return &memDealRepo{deals: map[string]*DealDocumentDB{}}
+}
+
+func (r *memDealRepo) Create(ctx context.Context, dealDoc DealDocumentDB) (string, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if dealDoc.ID == (primitive.ObjectID{}) {
+		dealDoc.ID = primitive.NewObjectID()
+	}
+	id := dealDoc.ID.Hex()
+	if _, ok := r.deals[id]; ok {
+		return "", fmt.Errorf("Deal %s already exists", id)
+	}
+	stored := &DealDocumentDB{}
+	if err := bsonCopy(dealDoc, stored); err != nil {
+		return "", err
+	}
+	r.deals[id] = stored
+	return id, nil
+}
+
+// get returns copy of deal {dealID}, caller has to hold the lock
+func (r *memDealRepo) get(dealID string) (*DealDocumentDB, error) {
+	stored, ok := r.deals[dealID]
+	if !ok {
+		return nil, nil
+	}
+	dealDoc := &DealDocumentDB{}
+	if err := bsonCopy(stored, dealDoc); err != nil {
+		return nil, err
+	}
+	return dealDoc, nil
+}
+
+// find returns copies of deals that match {match}, oldest first
+func (r *memDealRepo) find(match func(*DealDocumentDB) bool) ([]*DealDocumentDB, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	deals := make([]*DealDocumentDB, 0)
+	for id, stored := range r.deals {
+		if !match(stored) {
+			continue
+		}
+		dealDoc, err := r.get(id)
+		if err != nil {
+			return nil, err
+		}
+		deals = append(deals, dealDoc)
+	}
+	sort.Slice(deals, func(i, j int) bool {
+		return deals[i].ID.Hex() < deals[j].ID.Hex()
+	})
+	return deals, nil
+}
+
+func (r *memDealRepo) GetByID(ctx context.Context, dealID string) (*DealDocumentDB, error) {
+	if _, err := primitive.ObjectIDFromHex(dealID); err != nil {
+		return nil, err
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.get(dealID)
+}
+
+func (r *memDealRepo) GetCompleted(ctx context.Context) ([]*DealDocumentDB, error) {
+	return r.find(func(d *DealDocumentDB) bool {
+		return d.Completed
+	})
+}
+
+func (r *memDealRepo) GetOpenBlames(ctx context.Context) ([]*DealDocumentDB, error) {
+	return r.find(func(d *DealDocumentDB) bool {
+		return d.Type == "BLAME" && !d.Completed
+	})
+}
+
+func (r *memDealRepo) GetActive(ctx context.Context) ([]*DealDocumentDB, error) {
+	return r.find(func(d *DealDocumentDB) bool {
+		statuses := []string{}
+		for _, st := range d.Status {
+			statuses = append(statuses, st.Name)
+		}
+		return utils.StringInSlice("ALL_ACCEPTED", statuses) &&
+			!utils.StringInSlice("TIME_OUT", statuses) &&
+			!utils.StringInSlice("CANCELLED", statuses)
+	})
+}
+
+func (r *memDealRepo) Update(ctx context.Context, dealDoc DealDocumentDB) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	stored, ok := r.deals[dealDoc.ID.Hex()]
+	if !ok {
+		return nil
+	}
+	return bsonCopy(dealDoc.toMongoFormat(), stored)
+}
+
+func (r *memDealRepo) UpdateStatus(ctx context.Context, dealID, status string, at time.Time) error {
+	if _, err := primitive.ObjectIDFromHex(dealID); err != nil {
+		return err
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	stored, ok := r.deals[dealID]
+	if !ok {
+		return fmt.Errorf("Deal %s doesn't exist", dealID)
+	}
+	stored.Status = append(stored.Status, Status{
+		Name: status,
+		// Mongo keeps time with millisecond precision
+		Time: at.Truncate(time.Millisecond),
+	})
+	return nil
+}
+
+func (r *memDealRepo) AddSignature(ctx context.Context, dealID string, signature SignatureDB) error {
+	if _, err := primitive.ObjectIDFromHex(dealID); err != nil {
+		return err
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	if stored, ok := r.deals[dealID]; ok {
+		stored.Signatures = append(stored.Signatures, signature)
+	}
+	return nil
+}
+
+// memLedgerRepo is LedgerRepo that keeps wallets and entries in memory, it's safe for concurrent use
+type memLedgerRepo struct {
+	m       sync.Mutex
+	wallets map[string]int64
+	entries []*LedgerEntryDB
+}
+
+// NewMemLedgerRepo creates empty in-memory LedgerRepo
+func NewMemLedgerRepo() LedgerRepo {
+	return &memLedgerRepo{wallets: map[string]int64{}}
+}
+
+func (r *memLedgerRepo) GetWallet(ctx context.Context, account string) (*WalletDB, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	balance, ok := r.wallets[account]
+	if !ok {
+		return nil, nil
+	}
+	return &WalletDB{Account: account, Balance: balance}, nil
+}
+
+func (r *memLedgerRepo) CreateWallet(ctx context.Context, account string, credits int64, at time.Time) (bool, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if _, ok := r.wallets[account]; ok {
+		return false, nil
+	}
+	r.wallets[account] = credits
+	r.wallets[mintAccount] -= credits
+	r.record(mintAccount, account, credits, "", LedgerKindMint, at)
+	return true, nil
+}
+
+func (r *memLedgerRepo) Transfer(ctx context.Context, from, to string, amount int64, dealID, kind string, at time.Time) error {
+	if amount <= 0 {
+		return fmt.Errorf("Invalid transfer amount %d from %s to %s", amount, from, to)
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	balance, ok := r.wallets[from]
+	// The same as debitFilter: only system accounts are created by debit, others need the wallet and enough credits
+	if (!ok && !isSystemAccount(from)) || (!canOverdraw(from, kind) && balance < amount) {
+		return dealerrors.New(dealerrors.INSUFFICIENT_FUNDS, "Account %s doesn't have %d credits", from, amount)
+	}
+	r.wallets[from] -= amount
+	r.wallets[to] += amount
+	r.record(from, to, amount, dealID, kind, at)
+	return nil
+}
+
+// record writes both legs of the transfer, caller has to hold the lock
+func (r *memLedgerRepo) record(from, to string, amount int64, dealID, kind string, at time.Time) {
+	txID := primitive.NewObjectID().Hex()
+	r.entries = append(r.entries,
+		&LedgerEntryDB{ID: primitive.NewObjectID(), TxID: txID, Account: from, Amount: -amount, DealID: dealID, Kind: kind, Time: at},
+		&LedgerEntryDB{ID: primitive.NewObjectID(), TxID: txID, Account: to, Amount: amount, DealID: dealID, Kind: kind, Time: at},
+	)
+}
+
+func (r *memLedgerRepo) GetEntries(ctx context.Context, account string, skip, limit int64) ([]*LedgerEntryDB, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	entries := []*LedgerEntryDB{}
+	// Entries are appended in time order, so newest are at the end
+	for i := len(r.entries) - 1; i >= 0; i-- {
+		if r.entries[i].Account == account {
+			e := *r.entries[i]
+			entries = append(entries, &e)
+		}
+	}
+	if skip >= int64(len(entries)) {
+		return []*LedgerEntryDB{}, nil
+	}
+	entries = entries[skip:]
+	if limit > 0 && limit < int64(len(entries)) {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
@@ -0,0 +1,341 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+)
+
+func TestMemUserRepoCreateAndGet(t *testing.T) {
+	ctx := context.Background()
+	users := NewMemUserRepo()
+	id, err := users.Create(ctx, UserDB{Username: "alice", Name: "Alice"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	user, err := users.GetByID(ctx, id)
+	if err != nil || user == nil {
+		t.Fatalf("Failed to get user %s: %v", id, err)
+	}
+	if user.Username != "alice" || user.Name != "Alice" || user.ID.Hex() != id {
+		t.Fatalf("Got %+v, want alice with id %s", user, id)
+	}
+	// Returned user is a copy, changing it doesn't change the stored one
+	user.Name = "Changed"
+	again, _ := users.GetByID(ctx, id)
+	if again.Name != "Alice" {
+		t.Fatalf("Stored user changed without Update: %q", again.Name)
+	}
+	if _, err := users.Create(ctx, UserDB{ID: user.ID, Username: "bob"}); err == nil {
+		t.Fatalf("User with existing id was created")
+	}
+	missing, err := users.GetByID(ctx, primitive.NewObjectID().Hex())
+	if err != nil || missing != nil {
+		t.Fatalf("Got %+v, %v for unknown user, want nil, nil", missing, err)
+	}
+	if _, err := users.GetByID(ctx, "not-an-id"); err == nil {
+		t.Fatalf("Invalid id was accepted")
+	}
+	_, gotID, err := users.GetByUsername(ctx, "alice")
+	if err != nil || gotID != id {
+		t.Fatalf("Got %s, %v by username, want %s", gotID, err, id)
+	}
+}
+
+func TestMemUserRepoUpdateMergesFields(t *testing.T) {
+	ctx := context.Background()
+	users := NewMemUserRepo()
+	id, err := users.Create(ctx, UserDB{
+		Username:     "judy",
+		Name:         "Judy",
+		Surname:      "Hopps",
+		IsJudge:      true,
+		JudgeProfile: &JudgeProfile{Propositions: []string{"deal1"}},
+		Privacy:      &PrivacySettings{HideDeals: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	// Empty name and missing privacy are not part of the update, like fields missing in mongo $set
+	err = users.Update(ctx, id, &UserDB{
+		Surname:   "Changed",
+		Offerings: []string{"deal2"},
+		JudgeProfile: &JudgeProfile{
+			Participatings: []string{"deal1"},
+			Propositions:   []string{},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	user, _ := users.GetByID(ctx, id)
+	if user.Name != "Judy" || user.Username != "judy" {
+		t.Fatalf("Fields missing in update changed: %q %q", user.Name, user.Username)
+	}
+	if user.Surname != "Changed" {
+		t.Fatalf("Surname is %q, want Changed", user.Surname)
+	}
+	if user.Privacy == nil || !user.Privacy.HideDeals {
+		t.Fatalf("Privacy missing in update changed: %+v", user.Privacy)
+	}
+	if !user.IsJudge {
+		t.Fatalf("Judge flag missing in update changed")
+	}
+	if len(user.Offerings) != 1 || user.Offerings[0] != "deal2" {
+		t.Fatalf("Offerings are %v, want [deal2]", user.Offerings)
+	}
+	// Judge profile is set field by field with dotted keys
+	if user.JudgeProfile == nil || len(user.JudgeProfile.Propositions) != 0 ||
+		len(user.JudgeProfile.Participatings) != 1 || user.JudgeProfile.Participatings[0] != "deal1" {
+		t.Fatalf("Judge profile is %+v, want deal1 moved to participatings", user.JudgeProfile)
+	}
+	// Update of unknown user matches nothing, like in mongo
+	if err := users.Update(ctx, primitive.NewObjectID().Hex(), &UserDB{Name: "Nobody"}); err != nil {
+		t.Fatalf("Update of unknown user failed: %v", err)
+	}
+}
+
+func TestMemUserRepoSearch(t *testing.T) {
+	ctx := context.Background()
+	users := NewMemUserRepo()
+	for _, name := range []string{"carol", "Bob", "bobby", "alice"} {
+		if _, err := users.Create(ctx, UserDB{Username: name, IsJudge: name == "carol"}); err != nil {
+			t.Fatalf("Failed to create user %s: %v", name, err)
+		}
+	}
+	found, err := users.Search(ctx, "BOB", 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to search users: %v", err)
+	}
+	if len(found) != 2 || found[0].Username != "Bob" || found[1].Username != "bobby" {
+		t.Fatalf("Found %d users, want Bob and bobby ordered by username", len(found))
+	}
+	page, _ := users.Search(ctx, "", 1, 2)
+	if len(page) != 2 || page[0].Username != "alice" || page[1].Username != "bobby" {
+		t.Fatalf("Got wrong page of users: %d", len(page))
+	}
+	if empty, _ := users.Search(ctx, "", 10, 2); len(empty) != 0 {
+		t.Fatalf("Got %d users after the last page", len(empty))
+	}
+	judges, _ := users.GetJudges(ctx)
+	if len(judges) != 1 || judges[0].Username != "carol" {
+		t.Fatalf("Got %d judges, want carol", len(judges))
+	}
+}
+
+func TestMemUserRepoDelete(t *testing.T) {
+	ctx := context.Background()
+	users := NewMemUserRepo()
+	id, _ := users.Create(ctx, UserDB{Username: "alice"})
+	deleted, err := users.Delete(ctx, id)
+	if err != nil || deleted == nil || deleted.Username != "alice" {
+		t.Fatalf("Got %+v, %v, want deleted alice", deleted, err)
+	}
+	if user, _ := users.GetByID(ctx, id); user != nil {
+		t.Fatalf("Deleted user is still there")
+	}
+	if deleted, err := users.Delete(ctx, id); err != nil || deleted != nil {
+		t.Fatalf("Got %+v, %v for second delete, want nil, nil", deleted, err)
+	}
+}
+
+func TestMemUserRepoConcurrentUse(t *testing.T) {
+	ctx := context.Background()
+	users := NewMemUserRepo()
+	const workers = 20
+	ids := make([]string, workers)
+	for i := range ids {
+		id, err := users.Create(ctx, UserDB{Username: fmt.Sprintf("user%02d", i)})
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		ids[i] = id
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*3)
+	for i := 0; i < workers; i++ {
+		wg.Add(3)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				user, err := users.GetByID(ctx, ids[i])
+				if err != nil {
+					errs <- err
+					return
+				}
+				user.DealDocs = append(user.DealDocs, fmt.Sprintf("deal%d", j))
+				if err := users.Update(ctx, ids[i], user); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := users.Search(ctx, "user", 0, 0); err != nil {
+				errs <- err
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			if _, err := users.Create(ctx, UserDB{Username: fmt.Sprintf("new%02d", i)}); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Concurrent use failed: %v", err)
+	}
+	for i, id := range ids {
+		user, _ := users.GetByID(ctx, id)
+		if len(user.DealDocs) != 10 {
+			t.Fatalf("User %d has %d deals, want 10", i, len(user.DealDocs))
+		}
+	}
+	all, _ := users.Search(ctx, "", 0, 0)
+	if len(all) != workers*2 {
+		t.Fatalf("Got %d users, want %d", len(all), workers*2)
+	}
+}
+
+// newTestDeal returns deal with creator on red side and {blue} offered to blue side
+func newTestDeal(creator, blue string) DealDocumentDB {
+	pact := PactDB{
+		Content: "Terms",
+		Version: "initial(#1)",
+		Timeout: "2019-01-01T00:00:00Z",
+		Red: SideDB{
+			Type:         pb.SideType_RED,
+			Participants: []ParticipantDB{{ID: creator, Accepted: true}},
+		},
+		Blue: SideDB{
+			Type:         pb.SideType_BLUE,
+			Participants: []ParticipantDB{{ID: blue}},
+		},
+	}
+	pact.Red.Participants[0].PactHash = pact.hash()
+	return DealDocumentDB{
+		Type:         "COMMON",
+		Pacts:        []PactDB{pact},
+		FinalVersion: pact.Version,
+		Status:       []Status{{Name: "INITIAL DEAL STAGE", Time: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)}},
+	}
+}
+
+func TestMemDealRepoUpdateMergesFields(t *testing.T) {
+	ctx := context.Background()
+	deals := NewMemDealRepo()
+	id, err := deals.Create(ctx, newTestDeal("red", "blue"))
+	if err != nil {
+		t.Fatalf("Failed to create deal: %v", err)
+	}
+	signature := SignatureDB{Kind: SignatureDecision, Signer: "judge", Payload: "{}", Signature: "c2ln"}
+	if err := deals.AddSignature(ctx, id, signature); err != nil {
+		t.Fatalf("Failed to add signature: %v", err)
+	}
+	dealDoc, _ := deals.GetByID(ctx, id)
+	// Update without statuses and signatures keeps them, the same as mongo $set of the fields that are set
+	update := DealDocumentDB{ID: dealDoc.ID, Winner: "red", Completed: true}
+	if err := deals.Update(ctx, update); err != nil {
+		t.Fatalf("Failed to update deal: %v", err)
+	}
+	dealDoc, _ = deals.GetByID(ctx, id)
+	if dealDoc.Winner != "red" || !dealDoc.Completed {
+		t.Fatalf("Updated fields are not set: winner %q, completed %v", dealDoc.Winner, dealDoc.Completed)
+	}
+	if len(dealDoc.Pacts) != 1 || dealDoc.FinalVersion != "initial(#1)" || dealDoc.Type != "COMMON" {
+		t.Fatalf("Fields missing in update changed: %+v", dealDoc)
+	}
+	if len(dealDoc.Status) != 1 || len(dealDoc.Signatures) != 1 || dealDoc.Signatures[0].Signer != "judge" {
+		t.Fatalf("Statuses or signatures changed by update: %+v %+v", dealDoc.Status, dealDoc.Signatures)
+	}
+	completed, _ := deals.GetCompleted(ctx)
+	if len(completed) != 1 || completed[0].ID.Hex() != id {
+		t.Fatalf("Completed deal is not found")
+	}
+}
+
+func TestMemDealRepoStatuses(t *testing.T) {
+	ctx := context.Background()
+	deals := NewMemDealRepo()
+	id, _ := deals.Create(ctx, newTestDeal("red", "blue"))
+	at := time.Date(2019, 1, 2, 3, 4, 5, 6789, time.UTC)
+	if err := deals.UpdateStatus(ctx, id, "ALL_ACCEPTED", at); err != nil {
+		t.Fatalf("Failed to update status: %v", err)
+	}
+	dealDoc, _ := deals.GetByID(ctx, id)
+	status, _ := dealDoc.getStatus()
+	if status != "ALL_ACCEPTED" {
+		t.Fatalf("Status is %s, want ALL_ACCEPTED", status)
+	}
+	// Time is kept with the mongo precision
+	if got := dealDoc.Status[1].Time; !got.Equal(at.Truncate(time.Millisecond)) {
+		t.Fatalf("Status time is %v, want %v", got, at.Truncate(time.Millisecond))
+	}
+	active, _ := deals.GetActive(ctx)
+	if len(active) != 1 {
+		t.Fatalf("Got %d active deals, want 1", len(active))
+	}
+	deals.UpdateStatus(ctx, id, "TIME_OUT", at)
+	if active, _ := deals.GetActive(ctx); len(active) != 0 {
+		t.Fatalf("Timed out deal is still active")
+	}
+	if err := deals.UpdateStatus(ctx, primitive.NewObjectID().Hex(), "ALL_ACCEPTED", at); err == nil {
+		t.Fatalf("Status of unknown deal was updated")
+	}
+}
+
+func TestMemDealRepoConcurrentUse(t *testing.T) {
+	ctx := context.Background()
+	deals := NewMemDealRepo()
+	id, _ := deals.Create(ctx, newTestDeal("red", "blue"))
+	const workers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*3)
+	for i := 0; i < workers; i++ {
+		wg.Add(3)
+		go func(i int) {
+			defer wg.Done()
+			if err := deals.UpdateStatus(ctx, id, fmt.Sprintf("STATUS_%d", i), time.Now()); err != nil {
+				errs <- err
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			if err := deals.AddSignature(ctx, id, SignatureDB{Kind: SignatureDecision, Signer: fmt.Sprint(i)}); err != nil {
+				errs <- err
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := deals.GetByID(ctx, id); err != nil {
+				errs <- err
+			}
+			if _, err := deals.GetActive(ctx); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Concurrent use failed: %v", err)
+	}
+	dealDoc, _ := deals.GetByID(ctx, id)
+	if len(dealDoc.Status) != workers+1 || len(dealDoc.Signatures) != workers {
+		t.Fatalf("Got %d statuses and %d signatures, want %d and %d", len(dealDoc.Status), len(dealDoc.Signatures), workers+1, workers)
+	}
+}
@@ -0,0 +1,59 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Stages of the deal lifecycle that are counted by deal_deals_total
+const (
+	stageCreated  = "created"
+	stageAccepted = "accepted"
+	stageDecided  = "decided"
+	stageTimedOut = "timed_out"
+	stageBlamed   = "blamed"
+)
+
+// Channels of delivery failures
+const (
+	channelWebhook          = "webhook"
+	channelNotificationSink = "notification_sink"
+)
+
+var (
+	dealsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "deal",
+		Name:      "deals_total",
+		Help:      "Deals that reached the stage: created, accepted (by all participants), decided, timed_out or blamed",
+	}, []string{"stage"})
+	judgeAssignmentSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "deal",
+		Name:      "judge_assignment_seconds",
+		Help:      "Time from the deal being accepted by all participants to a judge taking it",
+		// 1 minute to ~11 days
+		Buckets: prometheus.ExponentialBuckets(60, 4, 8),
+	})
+	deliveryFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "deal",
+		Name:      "delivery_failures_total",
+		Help:      "Failed attempts to deliver webhook or notification, final=true if it won't be retried",
+	}, []string{"channel", "final"})
+)
+
+func init() {
+	prometheus.MustRegister(dealsTotal, judgeAssignmentSeconds, deliveryFailures)
+}
+
+// observeJudgeAssignment records how long deal {dealDoc} waited for judge until {assigned}
+func observeJudgeAssignment(dealDoc *DealDocumentDB, assigned time.Time) {
+	if accepted, ok := dealDoc.statusTime("ACCEPTED_BY_USERS"); ok {
+		judgeAssignmentSeconds.Observe(assigned.Sub(accepted).Seconds())
+	}
+}
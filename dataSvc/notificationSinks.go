@@ -0,0 +1,154 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/DenysNahurnyi/deal/common/config"
+	"github.com/DenysNahurnyi/deal/common/logging"
+)
+
+const (
+	// smtpDialTimeout limits connection to the SMTP server, smtpTimeout the whole delivery if context has no deadline
+	smtpDialTimeout = 10 * time.Second
+	smtpTimeout     = 30 * time.Second
+)
+
+// NotificationSink delivers notification outside of the inbox, e.g. by email.
+// Inbox always keeps the notification, so sink failure doesn't lose it. Deliver has to return once {ctx} is done
+type NotificationSink interface {
+	Deliver(ctx context.Context, user *UserDB, n *NotificationDB) error
+}
+
+// smtpSink sends notifications to users that have email in their notification settings.
+// Any SMTP server works, including local test double like MailHog
+type smtpSink struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func (s *smtpSink) Deliver(ctx context.Context, user *UserDB, n *NotificationDB) error {
+	if user.Notifications == nil || len(user.Notifications.Email) == 0 {
+		return nil
+	}
+	// Addresses are validated on update, but settings stored before that could have anything
+	to, err := parseEmail(user.Notifications.Email)
+	if err != nil {
+		return err
+	}
+	subject := "Deal notification: " + strings.ToLower(strings.Replace(n.Kind, "_", " ", -1))
+	body := n.Message
+	if len(n.DealID) > 0 {
+		body += "\r\n\r\nDeal: " + n.DealID
+	}
+	msg := "From: " + s.from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body + "\r\n"
+	return s.send(ctx, to, []byte(msg))
+}
+
+// send does what smtp.SendMail does, but gives up once {ctx} is done or its deadline, smtpTimeout if it has none, passes
+func (s *smtpSink) send(ctx context.Context, to string, msg []byte) error {
+	host, _, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return err
+	}
+	dialer := net.Dialer{Timeout: smtpDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(smtpTimeout)
+	}
+	conn.SetDeadline(deadline)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.auth != nil {
+		if err := c.Auth(s.auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// parseEmail returns bare address from {email}, names and anything that could break mail headers are rejected
+func parseEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return "", err
+	}
+	if addr.Address != email || strings.ContainsAny(email, "\r\n") {
+		return "", fmt.Errorf("Email %q must be bare address", email)
+	}
+	return addr.Address, nil
+}
+
+// newNotificationSinks returns sinks enabled by {cfg}, email is sent if SMTP address is set
+func newNotificationSinks(cfg config.SMTPConfig) []NotificationSink {
+	sinks := []NotificationSink{}
+	if len(cfg.Addr) == 0 {
+		return sinks
+	}
+	sink := &smtpSink{
+		addr: cfg.Addr,
+		from: cfg.From,
+	}
+	if len(cfg.User) > 0 {
+		// Address is validated with the config
+		host, _, _ := net.SplitHostPort(cfg.Addr)
+		sink.auth = smtp.PlainAuth("", cfg.User, cfg.Password, host)
+	}
+	logging.Info(context.Background(), "Email notifications are sent through "+cfg.Addr)
+	return append(sinks, sink)
+}
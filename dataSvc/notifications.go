@@ -0,0 +1,360 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+	"time"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/DenysNahurnyi/deal/common/tracing"
+	"github.com/DenysNahurnyi/deal/common/utils"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/options"
+)
+
+// Kinds of notifications, user can mute any of them in notification settings
+const (
+	NotificationOffer            = "OFFER"
+	NotificationAcceptance       = "ACCEPTANCE"
+	NotificationJudgeProposition = "JUDGE_PROPOSITION"
+	NotificationJudgeAssigned    = "JUDGE_ASSIGNED"
+	NotificationDecision         = "DECISION"
+	NotificationResult           = "RESULT"
+	NotificationBlame            = "BLAME"
+	NotificationDeadline         = "DEADLINE"
+)
+
+var notificationKinds = []string{
+	NotificationOffer,
+	NotificationAcceptance,
+	NotificationJudgeProposition,
+	NotificationJudgeAssigned,
+	NotificationDecision,
+	NotificationResult,
+	NotificationBlame,
+	NotificationDeadline,
+}
+
+const (
+	// Participants are reminded once when deal has less than this time left
+	deadlineReminderWindow   = 24 * time.Hour
+	deadlineReminderInterval = time.Minute
+	// Notifications wait for the sinks in the queue of this size, newer ones skip the sinks if it's full
+	notificationSinkBacklog = 1024
+	notificationSinkTimeout = 30 * time.Second
+)
+
+// NotificationSettings is an object that defines which notifications user gets and where
+type NotificationSettings struct {
+	Muted []string `bson:"muted,omitempty"` // Kinds of notifications user doesn't want to get
+	Email string   `bson:"email,omitempty"` // If set, notifications are also sent to this address
+}
+
+func (ns *NotificationSettings) isMuted(kind string) bool {
+	return ns != nil && utils.StringInSlice(kind, ns.Muted)
+}
+
+// NotificationDB is a notification in user inbox that stores in the DB
+type NotificationDB struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty"`
+	UserID  string             `bson:"user_id"`
+	Kind    string             `bson:"kind"`
+	DealID  string             `bson:"deal_id,omitempty"`
+	Status  string             `bson:"status,omitempty"` // Deal result for RESULT notifications
+	Message string             `bson:"message"`
+	Read    bool               `bson:"read"`
+	Created time.Time          `bson:"created"`
+	// Notifications with the same key are created only once, e.g. deadline reminder of the deal
+	Key string `bson:"key,omitempty"`
+}
+
+// CreateNotificationDB stores notification, returns false if notification with the same key already exists
+func CreateNotificationDB(ctx context.Context, n NotificationDB, table *mongo.Collection) (bool, error) {
+	ctx, span := tracing.StartDB(ctx, "CreateNotificationDB", table)
+	defer span.End()
+	if len(n.Key) == 0 {
+		_, err := table.InsertOne(ctx, n)
+		if err != nil {
+			logging.Error(ctx, "Error creating notification in mongo", "err", err)
+			return false, err
+		}
+		return true, nil
+	}
+	res, err := table.UpdateOne(ctx,
+		bson.D{{Key: "user_id", Value: n.UserID}, {Key: "key", Value: n.Key}},
+		bson.D{{"$setOnInsert", n}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		logging.Error(ctx, "Error creating notification in mongo", "err", err)
+		return false, err
+	}
+	return res.UpsertedCount > 0, nil
+}
+
+func notificationsFilter(userID string, unreadOnly bool) bson.D {
+	filter := bson.D{{Key: "user_id", Value: userID}}
+	if unreadOnly {
+		filter = append(filter, bson.E{Key: "read", Value: false})
+	}
+	return filter
+}
+
+// GetNotificationsDB returns notifications of user {userID}, newest first
+func GetNotificationsDB(ctx context.Context, userID string, unreadOnly bool, skip, limit int64, table *mongo.Collection) ([]*NotificationDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetNotificationsDB", table)
+	defer span.End()
+	cursor, err := table.Find(ctx,
+		notificationsFilter(userID, unreadOnly),
+		options.Find().SetSort(bson.D{{Key: "created", Value: -1}}).SetSkip(skip).SetLimit(limit),
+	)
+	if err != nil {
+		logging.Error(ctx, "Error getting notifications from mongo", "err", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	notifications := []*NotificationDB{}
+	for cursor.Next(ctx) {
+		n := &NotificationDB{}
+		if err := cursor.Decode(n); err != nil {
+			logging.Error(ctx, "Error getting notifications from mongo", "err", err)
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, nil
+}
+
+// CountUnreadNotificationsDB returns number of unread notifications of user {userID}
+func CountUnreadNotificationsDB(ctx context.Context, userID string, table *mongo.Collection) (int64, error) {
+	ctx, span := tracing.StartDB(ctx, "CountUnreadNotificationsDB", table)
+	defer span.End()
+	count, err := table.CountDocuments(ctx, notificationsFilter(userID, true))
+	if err != nil {
+		logging.Error(ctx, "Error counting notifications in mongo", "err", err)
+	}
+	return count, err
+}
+
+// MarkNotificationsReadDB marks notifications {ids} of user {userID} as read, all of them if {ids} is empty
+func MarkNotificationsReadDB(ctx context.Context, userID string, ids []primitive.ObjectID, table *mongo.Collection) (int64, error) {
+	ctx, span := tracing.StartDB(ctx, "MarkNotificationsReadDB", table)
+	defer span.End()
+	filter := notificationsFilter(userID, true)
+	if len(ids) > 0 {
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}})
+	}
+	res, err := table.UpdateMany(ctx, filter, bson.D{{"$set", bson.D{{Key: "read", Value: true}}}})
+	if err != nil {
+		logging.Error(ctx, "Error marking notifications read in mongo", "err", err)
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// notify puts notification to the inbox of user {userID} and passes it to the sinks.
+// Notification is a side effect of the change that already happened, so failure is only logged
+func (s *service) notify(ctx context.Context, n NotificationDB) {
+	if s.notificationTable == nil {
+		return
+	}
+	user, err := s.users.GetByID(ctx, n.UserID)
+	if err != nil || user == nil {
+		logging.Error(ctx, "Failed to get user "+n.UserID+" to notify", "err", err)
+		return
+	}
+	if user.Notifications.isMuted(n.Kind) {
+		return
+	}
+	n.ID = primitive.NewObjectID()
+	n.Created = s.clock.Now()
+	created, err := CreateNotificationDB(ctx, n, s.notificationTable)
+	if err != nil {
+		logging.Error(ctx, "Failed to create "+n.Kind+" notification for user "+n.UserID, "err", err)
+		return
+	}
+	if !created {
+		return
+	}
+	if len(s.notificationSinks) == 0 {
+		return
+	}
+	// Sinks can't take notification back, so it's passed to them once the change is committed
+	afterCommit(ctx, func(ctx context.Context) error {
+		select {
+		case s.sinkQueue <- sinkDelivery{user: user, n: n}:
+		default:
+			logging.Error(ctx, "Notification sinks are overloaded, notification "+n.ID.Hex()+" is left in the inbox only")
+			deliveryFailures.WithLabelValues(channelNotificationSink, "true").Inc()
+		}
+		return nil
+	})
+}
+
+// sinkDelivery is a notification waiting to be passed to the sinks
+type sinkDelivery struct {
+	user *UserDB
+	n    NotificationDB
+}
+
+// runNotificationSinks passes queued notifications to the sinks until {ctx} is done. Sinks talk to other servers,
+// so they are called here rather than by the change, and every delivery is limited by notificationSinkTimeout
+func (s *service) runNotificationSinks(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-s.sinkQueue:
+			for _, sink := range s.notificationSinks {
+				sinkCtx, cancel := context.WithTimeout(ctx, notificationSinkTimeout)
+				err := sink.Deliver(sinkCtx, d.user, &d.n)
+				cancel()
+				if err != nil {
+					logging.Error(ctx, "Failed to deliver notification "+d.n.ID.Hex()+" to sink", "err", err)
+					deliveryFailures.WithLabelValues(channelNotificationSink, "true").Inc()
+				}
+			}
+		}
+	}
+}
+
+// notifyUsers sends the same notification to each of {userIDs} except {actor}
+func (s *service) notifyUsers(ctx context.Context, userIDs []string, actor, kind, dealID, message string) {
+	for _, id := range utils.UniqueStringSlice(userIDs) {
+		if id == actor {
+			continue
+		}
+		s.notify(ctx, NotificationDB{UserID: id, Kind: kind, DealID: dealID, Message: message})
+	}
+}
+
+// notifyDeal sends notification to everybody related to the deal {dealID} except {actor}
+func (s *service) notifyDeal(ctx context.Context, dealID, actor, kind, message string) {
+	dealDoc, err := s.deals.GetByID(ctx, dealID)
+	if err != nil || dealDoc == nil {
+		logging.Error(ctx, "Failed to get deal "+dealID+" to notify about it", "err", err)
+		return
+	}
+	s.notifyUsers(ctx, dealAudience(dealDoc), actor, kind, dealID, message)
+}
+
+// resultMessage returns human readable deal result for the participant
+func resultMessage(result string) string {
+	switch result {
+	case "won":
+		return "You won the deal"
+	case "losed":
+		return "You lost the deal"
+	}
+	return "Deal expired without decision"
+}
+
+// runDeadlineReminders reminds participants and judges of active deals about coming timeout until {ctx} is done
+func (s *service) runDeadlineReminders(ctx context.Context) {
+	ticker := time.NewTicker(deadlineReminderInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.remindDeadlines(ctx); err != nil {
+			logging.Error(ctx, "Failed to remind about deal deadlines", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *service) remindDeadlines(ctx context.Context) error {
+	deals, err := s.deals.GetActive(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := s.clock.Now()
+	for _, dealDoc := range deals {
+		pact, err := dealDoc.getCurrentPact()
+		if err != nil {
+			continue
+		}
+		deadline, err := time.Parse(timeoutLayout, pact.Timeout)
+		if err != nil || deadline.Before(now) || deadline.Sub(now) > deadlineReminderWindow {
+			continue
+		}
+		dealID := dealDoc.ID.Hex()
+		for _, id := range utils.UniqueStringSlice(dealAudience(dealDoc)) {
+			// Key makes reminder one per deal, even if several replicas run it
+			s.notify(ctx, NotificationDB{
+				UserID:  id,
+				Kind:    NotificationDeadline,
+				DealID:  dealID,
+				Message: "Deal times out at " + pact.Timeout,
+				Key:     NotificationDeadline + ":" + dealID,
+			})
+		}
+	}
+	return nil
+}
+
+// ListNotifications returns page of notifications of user {userID} and number of unread ones
+func (s *service) ListNotifications(ctx context.Context, userID string, unreadOnly bool, page, pageSize int) ([]*NotificationDB, int64, error) {
+	if err := requireTable(s.notificationTable, "Notifications"); err != nil {
+		return nil, 0, err
+	}
+	skip, limit := pageBounds(page, pageSize)
+	notifications, err := GetNotificationsDB(ctx, userID, unreadOnly, int64(skip), int64(limit), s.notificationTable)
+	if err != nil {
+		return nil, 0, err
+	}
+	unread, err := CountUnreadNotificationsDB(ctx, userID, s.notificationTable)
+	if err != nil {
+		return nil, 0, err
+	}
+	return notifications, unread, nil
+}
+
+// MarkRead marks notifications {notificationIDs} of user {userID} as read, every notification if list is empty
+func (s *service) MarkRead(ctx context.Context, userID string, notificationIDs []string) (int64, error) {
+	if err := requireTable(s.notificationTable, "Notifications"); err != nil {
+		return 0, err
+	}
+	ids := []primitive.ObjectID{}
+	for _, id := range notificationIDs {
+		oid, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return 0, dealerrors.New(dealerrors.INVALID_ARGUMENT, "Invalid notification id %q", id)
+		}
+		ids = append(ids, oid)
+	}
+	return MarkNotificationsReadDB(ctx, userID, ids, s.notificationTable)
+}
+
+// GetUnreadCount returns number of unread notifications of user {userID}
+func (s *service) GetUnreadCount(ctx context.Context, userID string) (int64, error) {
+	if err := requireTable(s.notificationTable, "Notifications"); err != nil {
+		return 0, err
+	}
+	return CountUnreadNotificationsDB(ctx, userID, s.notificationTable)
+}
+
+func convertNotification(n *NotificationDB) *pb.Notification {
+	return &pb.Notification{
+		Id:      n.ID.Hex(),
+		Kind:    n.Kind,
+		DealId:  n.DealID,
+		Status:  n.Status,
+		Message: n.Message,
+		Read:    n.Read,
+		Created: n.Created.Format(timeoutLayout),
+	}
+}
@@ -0,0 +1,165 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/mongo"
+)
+
+// UserRepo keeps users of the service. Updates work like mongo $set: empty fields of the user are not changed
+type UserRepo interface {
+	Create(ctx context.Context, user UserDB) (string, error)
+	// GetByID returns nil if user doesn't exist
+	GetByID(ctx context.Context, userID string) (*UserDB, error)
+	// GetByUsername returns nil and empty id if user doesn't exist
+	GetByUsername(ctx context.Context, username string) (*UserDB, string, error)
+	// Search returns users whose username starts with {prefix} ignoring case, ordered by username
+	Search(ctx context.Context, prefix string, skip, limit int64) ([]*UserDB, error)
+	GetJudges(ctx context.Context) ([]*UserDB, error)
+	Update(ctx context.Context, userID string, user *UserDB) error
+	// Delete returns deleted user, nil if user doesn't exist
+	Delete(ctx context.Context, userID string) (*UserDB, error)
+}
+
+// DealRepo keeps deal and blame documents. Updates work like mongo $set: empty fields of the deal are not changed
+type DealRepo interface {
+	Create(ctx context.Context, dealDoc DealDocumentDB) (string, error)
+	// GetByID returns nil if deal doesn't exist
+	GetByID(ctx context.Context, dealID string) (*DealDocumentDB, error)
+	GetCompleted(ctx context.Context) ([]*DealDocumentDB, error)
+	// GetOpenBlames returns blame documents that are not activated yet
+	GetOpenBlames(ctx context.Context) ([]*DealDocumentDB, error)
+	// GetActive returns deals accepted by everyone that are not timed out or cancelled
+	GetActive(ctx context.Context) ([]*DealDocumentDB, error)
+	Update(ctx context.Context, dealDoc DealDocumentDB) error
+	// UpdateStatus appends status {status} set at {at} to the deal status history
+	UpdateStatus(ctx context.Context, dealID, status string, at time.Time) error
+	AddSignature(ctx context.Context, dealID string, signature SignatureDB) error
+}
+
+// LedgerRepo keeps wallets and the double-entry ledger of transfers between them
+type LedgerRepo interface {
+	// GetWallet returns nil if account has no wallet yet
+	GetWallet(ctx context.Context, account string) (*WalletDB, error)
+	// CreateWallet creates wallet of {account} with {credits} minted to it, returns false if wallet already exists
+	CreateWallet(ctx context.Context, account string, credits int64, at time.Time) (bool, error)
+	// Transfer moves {amount} from {from} to {to} and records it at {at}. It fails with INSUFFICIENT_FUNDS
+	// if {from} doesn't have {amount}, only system accounts and clawbacks can go below zero
+	Transfer(ctx context.Context, from, to string, amount int64, dealID, kind string, at time.Time) error
+	// GetEntries returns ledger entries of {account}, newest first
+	GetEntries(ctx context.Context, account string, skip, limit int64) ([]*LedgerEntryDB, error)
+}
+
+// mongoUserRepo is UserRepo on top of mongo collection
+type mongoUserRepo struct {
+	table *mongo.Collection
+}
+
+// NewMongoUserRepo creates UserRepo that keeps users in the {table}
+func NewMongoUserRepo(table *mongo.Collection) UserRepo {
+	return &mongoUserRepo{table: table}
+}
+
+func (r *mongoUserRepo) Create(ctx context.Context, user UserDB) (string, error) {
+	return CreateUserDB(ctx, user, r.table)
+}
+
+func (r *mongoUserRepo) GetByID(ctx context.Context, userID string) (*UserDB, error) {
+	return GetUserByIDDB(ctx, userID, r.table)
+}
+
+func (r *mongoUserRepo) GetByUsername(ctx context.Context, username string) (*UserDB, string, error) {
+	return GetUserByUsernameDB(ctx, username, r.table)
+}
+
+func (r *mongoUserRepo) Search(ctx context.Context, prefix string, skip, limit int64) ([]*UserDB, error) {
+	return SearchUsersByUsernameDB(ctx, prefix, skip, limit, r.table)
+}
+
+func (r *mongoUserRepo) GetJudges(ctx context.Context) ([]*UserDB, error) {
+	return GetJudges(ctx, r.table)
+}
+
+func (r *mongoUserRepo) Update(ctx context.Context, userID string, user *UserDB) error {
+	return UpdateUserDB(ctx, userID, user, r.table)
+}
+
+func (r *mongoUserRepo) Delete(ctx context.Context, userID string) (*UserDB, error) {
+	return DeleteUserByIDDB(ctx, userID, r.table)
+}
+
+// mongoDealRepo is DealRepo on top of mongo collection
+type mongoDealRepo struct {
+	table *mongo.Collection
+}
+
+// NewMongoDealRepo creates DealRepo that keeps deals in the {table}
+func NewMongoDealRepo(table *mongo.Collection) DealRepo {
+	return &mongoDealRepo{table: table}
+}
+
+func (r *mongoDealRepo) Create(ctx context.Context, dealDoc DealDocumentDB) (string, error) {
+	return CreateDealDocumentDB(ctx, dealDoc, r.table)
+}
+
+func (r *mongoDealRepo) GetByID(ctx context.Context, dealID string) (*DealDocumentDB, error) {
+	return GetDealDocByIdDB(ctx, dealID, r.table)
+}
+
+func (r *mongoDealRepo) GetCompleted(ctx context.Context) ([]*DealDocumentDB, error) {
+	return GetCompletedDeals(ctx, r.table)
+}
+
+func (r *mongoDealRepo) GetOpenBlames(ctx context.Context) ([]*DealDocumentDB, error) {
+	return GetOpenBlamesDB(ctx, r.table)
+}
+
+func (r *mongoDealRepo) GetActive(ctx context.Context) ([]*DealDocumentDB, error) {
+	return GetActiveDealsDB(ctx, r.table)
+}
+
+func (r *mongoDealRepo) Update(ctx context.Context, dealDoc DealDocumentDB) error {
+	return UpdateDeal(ctx, dealDoc, r.table)
+}
+
+func (r *mongoDealRepo) UpdateStatus(ctx context.Context, dealID, status string, at time.Time) error {
+	return UpdateDealStatus(ctx, dealID, status, at, r.table)
+}
+
+func (r *mongoDealRepo) AddSignature(ctx context.Context, dealID string, signature SignatureDB) error {
+	return AddDealSignatureDB(ctx, dealID, signature, r.table)
+}
+
+// mongoLedgerRepo is LedgerRepo on top of wallets and ledger collections
+type mongoLedgerRepo struct {
+	wallets *mongo.Collection
+	ledger  *mongo.Collection
+}
+
+// NewMongoLedgerRepo creates LedgerRepo that keeps balances in {wallets} and entries in {ledger}
+func NewMongoLedgerRepo(wallets, ledger *mongo.Collection) LedgerRepo {
+	return &mongoLedgerRepo{wallets: wallets, ledger: ledger}
+}
+
+func (r *mongoLedgerRepo) GetWallet(ctx context.Context, account string) (*WalletDB, error) {
+	return GetWalletDB(ctx, account, r.wallets)
+}
+
+func (r *mongoLedgerRepo) CreateWallet(ctx context.Context, account string, credits int64, at time.Time) (bool, error) {
+	return CreateWalletDB(ctx, account, credits, at, r.wallets, r.ledger)
+}
+
+func (r *mongoLedgerRepo) Transfer(ctx context.Context, from, to string, amount int64, dealID, kind string, at time.Time) error {
+	return TransferDB(ctx, from, to, amount, dealID, kind, at, r.wallets, r.ledger)
+}
+
+func (r *mongoLedgerRepo) GetEntries(ctx context.Context, account string, skip, limit int64) ([]*LedgerEntryDB, error) {
+	return GetLedgerEntriesDB(ctx, account, skip, limit, r.ledger)
+}
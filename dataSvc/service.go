@@ -9,17 +9,22 @@ package dataSvc
 import (
 	"context"
 	"crypto/rsa"
-	"errors"
 	"fmt"
-	"strconv"
+	"net/http"
+	"sort"
+	"sync"
 	"time"
 
+	"github.com/DenysNahurnyi/deal/common/blobstore"
+	"github.com/DenysNahurnyi/deal/common/clock"
+	"github.com/DenysNahurnyi/deal/common/config"
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
 	grpcutils "github.com/DenysNahurnyi/deal/common/grpc"
+	"github.com/DenysNahurnyi/deal/common/logging"
 	"github.com/DenysNahurnyi/deal/pb/generated/pb"
 	"github.com/go-kit/kit/log"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
 	"github.com/mongodb/mongo-go-driver/mongo"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 type Service interface {
@@ -31,159 +36,428 @@ type Service interface {
 	CreateBlameDocument(ctx context.Context, userID, blamedDealID, blameReason string) (string, error)
 	GetDealDocument(ctx context.Context, dealDocumentID string) (*pb.DealDocument, error)
 	DealTimeout(ctx context.Context, dealDocID string) error
-	OfferDealDocument(ctx context.Context, dealDocId, username string, toJudge bool) error
+	OfferDealDocument(ctx context.Context, inviterID, dealDocId, username string, toJudge, teammate bool) error
 	AcceptDealDocument(ctx context.Context, userID, dealDocId string, side pb.SideType) error
+	DeclineOffer(ctx context.Context, userID, dealDocID string) error
+	RevokeOffer(ctx context.Context, creatorID, dealDocID, username string) error
+	WithdrawFromDeal(ctx context.Context, userID, dealDocID string) error
+	CancelDeal(ctx context.Context, userID, dealDocID string) error
 	OfferJudges(ctx context.Context, dealDocId string) error
 	JudgeAccept(ctx context.Context, judgeID, dealDocId string) error
 	JudgeDecide(ctx context.Context, judgeID, dealDocID, redWon string) error
 	ActivateBlame(ctx context.Context, judgeID, blameID string) error
 	JoinBlame(ctx context.Context, userID, blameID string) error
-	GetPubKey() *rsa.PublicKey
-	getDealsTable() *mongo.Collection
+	GetJudgeQueue(ctx context.Context, judgeID string, page, pageSize int) (*JudgeQueue, error)
+	GetPublicProfile(ctx context.Context, callerID, username string) (*pb.PublicProfile, error)
+	SearchUsers(ctx context.Context, callerID, query string, page, pageSize int) ([]*pb.PublicProfile, error)
+	GetWallet(ctx context.Context, userID string, page, pageSize int) (*WalletDB, []*LedgerEntryDB, error)
+	UploadEvidence(ctx context.Context, userID, dealID, name, contentType string, visibility pb.EvidenceVisibility, data []byte) (*EvidenceDB, error)
+	ListEvidence(ctx context.Context, userID, dealID string) ([]*EvidenceDB, error)
+	DownloadEvidence(ctx context.Context, userID, evidenceID string) (*EvidenceDB, []byte, error)
+	PostComment(ctx context.Context, userID, dealID, parentID, body string, visibility pb.CommentVisibility) (*pb.Comment, error)
+	EditComment(ctx context.Context, userID, commentID, body string) (*pb.Comment, error)
+	FlagComment(ctx context.Context, userID, commentID, reason string) (*pb.Comment, error)
+	ListComments(ctx context.Context, userID, dealID, threadID string, page, pageSize int) ([]*pb.Comment, error)
+	StreamComments(ctx context.Context, userID, dealID string, since time.Time, send func(*pb.Comment) error) error
+	WatchEvents(ctx context.Context, userID, resumeToken, dealID string, types []string, send func(*pb.Event) error) error
+	RegisterWebhook(ctx context.Context, userID, url string, eventTypes []string) (*WebhookDB, error)
+	ListWebhooks(ctx context.Context, userID string) ([]*WebhookDB, error)
+	DeleteWebhook(ctx context.Context, userID, webhookID string) error
+	ReplayWebhook(ctx context.Context, userID, webhookID string, since time.Time) (int, error)
+	ListWebhookDeliveries(ctx context.Context, userID, webhookID string, page, pageSize int) ([]*DeliveryDB, error)
+	ListNotifications(ctx context.Context, userID string, unreadOnly bool, page, pageSize int) ([]*NotificationDB, int64, error)
+	MarkRead(ctx context.Context, userID string, notificationIDs []string) (int64, error)
+	GetUnreadCount(ctx context.Context, userID string) (int64, error)
+	GetDealHistory(ctx context.Context, userID, dealID string) ([]*AuditEntryDB, int64, error)
+	VerifyDealIntegrity(ctx context.Context, dealID string) (*pb.VerifyDealIntegrityResp, error)
+	GetPubKey() (*rsa.PublicKey, error)
+	Stop(ctx context.Context) error
+	getDealRepo() DealRepo
+	inTransaction(ctx context.Context, fn func(sc context.Context) error) error
+	recordAudit(ctx context.Context, action, actor, tid string, dealIDs []string, before map[string]*DealDocumentDB) error
 }
 
 type service struct {
-	envType          string
-	userTable        *mongo.Collection
-	dealDocTable     *mongo.Collection
-	authSvcClient    pb.AuthServiceClient
-	watcherSvcClient pb.WatcherServiceClient
-	uKey             *rsa.PublicKey
-}
-
-func NewService(logger log.Logger, mgc *mongo.Client, authSvcClient *pb.AuthServiceClient, watcherSvcClient *pb.WatcherServiceClient) (Service, error) {
-	userTable := mgc.Database("travel").Collection("users")
-	dealDocTable := mgc.Database("travel").Collection("dealDocuments")
-	ctx := context.Background()
-	authSvcClientValue := *authSvcClient
-	getPubKeyResp, err := authSvcClientValue.GetCheckTokenKey(ctx, &pb.EmptyReq{
-		ReqHdr: &pb.ReqHdr{
-			Tid: "call to get pub key",
-		},
-	})
+	envType           string
+	mongoClient       *mongo.Client
+	transactions      bool // Mongo supports transactions, otherwise changes are applied one by one
+	users             UserRepo
+	deals             DealRepo
+	ledger            LedgerRepo
+	evidenceTable     *mongo.Collection
+	evidenceStore     blobstore.Store
+	commentTable      *mongo.Collection
+	commentHub        *commentHub
+	eventTable        *mongo.Collection
+	counterTable      *mongo.Collection
+	eventBus          *eventBus
+	eventsFromStream  bool // Streams get events from mongo change stream instead of this process only
+	outboxTable       *mongo.Collection
+	webhookTable      *mongo.Collection
+	deliveryTable     *mongo.Collection
+	webhookClient     *http.Client
+	notificationTable *mongo.Collection
+	notificationSinks []NotificationSink
+	sinkQueue         chan sinkDelivery
+	auditTable        *mongo.Collection
+	authSvcClient     pb.AuthServiceClient
+	watcherSvcClient  pb.WatcherServiceClient
+	invitationPolicy  InvitationPolicy
+	uKey              *rsa.PublicKey
+	keyMu             sync.Mutex
+	clock             clock.Clock
+	stopWorkers       context.CancelFunc
+	workers           sync.WaitGroup
+}
+
+// NewService creates data service on top of mongo database from {cfg}
+func NewService(logger log.Logger, cfg *config.Config, mgc *mongo.Client, authSvcClient *pb.AuthServiceClient, watcherSvcClient *pb.WatcherServiceClient, evidenceStore blobstore.Store, clk clock.Clock) (Service, error) {
+	db := mgc.Database(cfg.Mongo.Database)
+	userTable := db.Collection(cfg.Collection("users"))
+	dealDocTable := db.Collection(cfg.Collection("dealDocuments"))
+	walletTable := db.Collection(cfg.Collection("wallets"))
+	ledgerTable := db.Collection(cfg.Collection("ledger"))
+	evidenceTable := db.Collection(cfg.Collection("evidence"))
+	commentTable := db.Collection(cfg.Collection("comments"))
+	eventTable := db.Collection(cfg.Collection("events"))
+	counterTable := db.Collection(cfg.Collection("counters"))
+	outboxTable := db.Collection(cfg.Collection("outbox"))
+	webhookTable := db.Collection(cfg.Collection("webhooks"))
+	deliveryTable := db.Collection(cfg.Collection("webhookDeliveries"))
+	notificationTable := db.Collection(cfg.Collection("notifications"))
+	auditTable := db.Collection(cfg.Collection("audit"))
+	transactions, err := supportsTransactions(context.Background(), mgc)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get pub key from authSvc, err:", err)
-		return nil, err
+		return nil, fmt.Errorf("Failed to check mongo transactions support: %v", err)
 	}
-	uKey, err := grpcutils.CreatePubKey(getPubKeyResp.GetNBase64(), int(getPubKeyResp.GetE()))
-	if err != nil {
-		fmt.Println("[LOG]:", "Failed to create pub key for authSvc tokens, err:", err)
-		return nil, err
+	if !transactions {
+		logging.Warn(context.Background(), "Mongo is not a replica set, deals with stakes are rejected and other changes are applied without transactions")
 	}
-	fmt.Println("Data svc uKey: ", uKey)
+	ctx, stopWorkers := context.WithCancel(context.Background())
+	// Key of authSvc tokens is fetched on the first use, so dataSvc doesn't wait for authSvc to start
+	authSvcClientValue := *authSvcClient
 	watcherSvcClientValue := *watcherSvcClient
 
+	svc := &service{
+		envType:           cfg.EnvType,
+		mongoClient:       mgc,
+		transactions:      transactions,
+		users:             NewMongoUserRepo(userTable),
+		deals:             NewMongoDealRepo(dealDocTable),
+		ledger:            NewMongoLedgerRepo(walletTable, ledgerTable),
+		evidenceTable:     evidenceTable,
+		evidenceStore:     evidenceStore,
+		commentTable:      commentTable,
+		commentHub:        newCommentHub(),
+		eventTable:        eventTable,
+		counterTable:      counterTable,
+		eventBus:          newEventBus(),
+		authSvcClient:     authSvcClientValue,
+		watcherSvcClient:  watcherSvcClientValue,
+		invitationPolicy:  defaultInvitationPolicy{},
+		eventsFromStream:  cfg.Events.Source == config.EVENTS_CHANGE_STREAM,
+		outboxTable:       outboxTable,
+		webhookTable:      webhookTable,
+		deliveryTable:     deliveryTable,
+		webhookClient:     newWebhookClient(),
+		notificationTable: notificationTable,
+		notificationSinks: newNotificationSinks(cfg.SMTP),
+		sinkQueue:         make(chan sinkDelivery, notificationSinkBacklog),
+		auditTable:        auditTable,
+		clock:             clk,
+		stopWorkers:       stopWorkers,
+	}
+	svc.runWorker(ctx, "event sequencer", svc.runEventSequencer)
+	if svc.eventsFromStream {
+		svc.runWorker(ctx, "event changes", svc.followEventChanges)
+	}
+	svc.runWorker(ctx, "webhook dispatcher", svc.runWebhookDispatcher)
+	svc.runWorker(ctx, "deadline reminders", svc.runDeadlineReminders)
+	if len(svc.notificationSinks) > 0 {
+		svc.runWorker(ctx, "notification sinks", svc.runNotificationSinks)
+	}
+	return svc, nil
+}
+
+// runWorker runs background {work} that Stop waits for, logs of the worker have its {name} and own transaction id
+func (s *service) runWorker(ctx context.Context, name string, work func(ctx context.Context)) {
+	ctx = logging.WithFields(logging.NewTid(ctx), "worker", name)
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		work(ctx)
+	}()
+}
+
+// Stop stops background workers and waits for them to finish current iteration until {ctx} is done
+func (s *service) Stop(ctx context.Context) error {
+	if s.stopWorkers == nil {
+		return nil
+	}
+	s.stopWorkers()
+	stopped := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("Background workers didn't stop: %v", ctx.Err())
+	}
+}
+
+// NewServiceWithRepos creates service on top of {users} and {deals} repos, e.g. in-memory ones for tests.
+// It has no mongo behind it, so events, notifications, webhooks, audit, wallets, comments and evidence are disabled:
+// their side effects are skipped and their RPCs fail with UNIMPLEMENTED. Deal statuses and decisions are stamped with {clk} time
+func NewServiceWithRepos(users UserRepo, deals DealRepo, authSvcClient pb.AuthServiceClient, watcherSvcClient pb.WatcherServiceClient, uKey *rsa.PublicKey, clk clock.Clock) Service {
 	return &service{
 		envType:          "test",
-		userTable:        userTable,
-		dealDocTable:     dealDocTable,
-		authSvcClient:    authSvcClientValue,
-		watcherSvcClient: watcherSvcClientValue,
+		users:            users,
+		deals:            deals,
+		commentHub:       newCommentHub(),
+		eventBus:         newEventBus(),
+		authSvcClient:    authSvcClient,
+		watcherSvcClient: watcherSvcClient,
+		invitationPolicy: defaultInvitationPolicy{},
 		uKey:             uKey,
-	}, nil
+		clock:            clk,
+	}
+}
+
+// requireTable fails with UNIMPLEMENTED if service has no {table} of {feature}, e.g. service created by NewServiceWithRepos
+func requireTable(table *mongo.Collection, feature string) error {
+	if table == nil {
+		return dealerrors.New(dealerrors.UNIMPLEMENTED, "%s are not supported by this service", feature)
+	}
+	return nil
 }
 
 func (s *service) CreateUser(ctx context.Context, userReq *UserDB) (string, error) {
-	userGet, _, err := GetUserByUsernameDB(ctx, userReq.Username, s.userTable)
+	userGet, _, err := s.users.GetByUsername(ctx, userReq.Username)
 	if err != nil {
-		fmt.Println("Failed to get user from DB")
+		logging.Error(ctx, "Failed to get user from DB")
 		return "", err
 	}
 	if userGet != nil && len(userGet.Username) > 0 {
-		fmt.Println("[WARNING] user already exist")
-		return "", errors.New("User already exist")
+		logging.Warn(ctx, "User already exists")
+		return "", dealerrors.New(dealerrors.USER_ALREADY_EXISTS, "User %s already exists", userReq.Username)
 	}
 	// Can't just use userReq because attacker can create it with participatin deals
-	userID, err := CreateUserDB(ctx, UserDB{
+	userID, err := s.users.Create(ctx, UserDB{
 		Name:     userReq.Name,
 		Surname:  userReq.Surname,
 		Username: userReq.Username,
-	}, s.userTable)
+	})
+	if err == nil {
+		s.emitUserEvent(ctx, EventUserCreated, userID)
+	}
 	return userID, err
 }
 
 func (s *service) GetUser(ctx context.Context, userID string) (*UserDB, error) {
-	return GetUserByIDDB(ctx, userID, s.userTable)
+	return s.users.GetByID(ctx, userID)
 }
 
 func (s *service) DeleteUser(ctx context.Context, userID string) (*UserDB, error) {
-	user, err := DeleteUserByIDDB(ctx, userID, s.userTable)
+	user, err := s.users.Delete(ctx, userID)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to delete user, err: ", err)
+		logging.Error(ctx, "Failed to delete user", "err", err)
 		return nil, err
 	}
 	_, err = s.authSvcClient.DeleteUser(ctx, &pb.DeleteSecureUserReq{
 		ReqHdr: &pb.ReqHdr{
-			Tid: "some transaction id to delete user in auth",
+			Tid: logging.Tid(ctx),
 		},
 		TokenId: userID,
 	})
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to delete user in auth service, err: ", err)
+		logging.Error(ctx, "Failed to delete user in auth service", "err", err)
 		return nil, err
 	}
+	s.emitUserEvent(ctx, EventUserDeleted, userID)
 	return user, nil
 }
 
-func (s *service) GetPubKey() *rsa.PublicKey {
-	return s.uKey
+// GetPublicProfile returns profile of user {username} as user {callerID} can see it
+func (s *service) GetPublicProfile(ctx context.Context, callerID, username string) (*pb.PublicProfile, error) {
+	user, userID, err := s.users.GetByUsername(ctx, username)
+	if err != nil {
+		logging.Error(ctx, "Failed to get user from DB", "err", err)
+		return nil, err
+	}
+	if len(userID) == 0 {
+		return nil, dealerrors.New(dealerrors.USER_NOT_FOUND, "User %s doesn't exist", username)
+	}
+	return s.buildPublicProfile(ctx, callerID, user)
+}
+
+// SearchUsers returns profiles of users whose username starts with {query}, ignoring case
+func (s *service) SearchUsers(ctx context.Context, callerID, query string, page, pageSize int) ([]*pb.PublicProfile, error) {
+	if len(query) == 0 {
+		return nil, dealerrors.New(dealerrors.INVALID_ARGUMENT, "Search query mustn't be empty")
+	}
+	// Huge number of users is possible, so paginate on the DB side
+	skip, limit := pageBounds(page, pageSize)
+	users, err := s.users.Search(ctx, query, int64(skip), int64(limit))
+	if err != nil {
+		logging.Error(ctx, "Failed to search users", "err", err)
+		return nil, err
+	}
+	profiles := []*pb.PublicProfile{}
+	for _, u := range users {
+		profile, err := s.buildPublicProfile(ctx, callerID, u)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
 }
 
-func (s *service) getDealsTable() *mongo.Collection {
-	return s.dealDocTable
+func (s *service) buildPublicProfile(ctx context.Context, callerID string, user *UserDB) (*pb.PublicProfile, error) {
+	stats, err := user.getDealStats(ctx, s.deals)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to count user %s deal stats, err: %v", user.ID.Hex(), err)
+	}
+	profile := &pb.PublicProfile{
+		Id:             user.ID.Hex(),
+		Username:       user.Username,
+		Name:           user.Name,
+		Surname:        user.Surname,
+		Success:        int64(stats.success),
+		CompletedDeals: int64(stats.completed),
+		WonDeals:       int64(stats.won),
+		LostDeals:      int64(stats.lost),
+		IsJudge:        user.IsJudge,
+	}
+	if user.IsJudge && user.JudgeProfile != nil {
+		justice, err := user.getJustice(ctx, s.deals)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to count judge %s justice, err: %v", user.ID.Hex(), err)
+		}
+		profile.JudgeStats = &pb.JudgeStats{
+			Justice:     int64(justice),
+			Decisions:   int64(len(user.JudgeProfile.Decisions)),
+			ActiveCases: int64(len(user.JudgeProfile.Participatings)),
+		}
+	}
+	// User always sees own deals
+	hideDeals := user.Privacy != nil && user.Privacy.HideDeals && user.ID.Hex() != callerID
+	if !hideDeals {
+		profile.DealResults = user.DealResults
+		profile.Participating = user.Participating
+	}
+	return profile, nil
+}
+
+// GetPubKey returns key that authSvc tokens and signatures are verified with, it's fetched from authSvc on the first use
+func (s *service) GetPubKey() (*rsa.PublicKey, error) {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	if s.uKey != nil {
+		return s.uKey, nil
+	}
+	ctx, cancel := context.WithTimeout(logging.NewTid(context.Background()), pubKeyTimeout)
+	defer cancel()
+	getPubKeyResp, err := s.authSvcClient.GetCheckTokenKey(ctx, &pb.EmptyReq{
+		ReqHdr: &pb.ReqHdr{
+			Tid: logging.Tid(ctx),
+		},
+	})
+	if err != nil {
+		logging.Error(ctx, "Failed to get pub key from authSvc", "err", err)
+		return nil, err
+	}
+	uKey, err := grpcutils.CreatePubKey(getPubKeyResp.GetNBase64(), int(getPubKeyResp.GetE()))
+	if err != nil {
+		logging.Error(ctx, "Failed to create pub key for authSvc tokens", "err", err)
+		return nil, err
+	}
+	s.uKey = uKey
+	return uKey, nil
+}
+
+func (s *service) getDealRepo() DealRepo {
+	return s.deals
 }
 
 func (s *service) UpdateUser(ctx context.Context, user *UserDB) (*UserDB, error) {
-	userExist, err := GetUserByIDDB(ctx, user.ID.Hex(), s.userTable)
+	userExist, err := s.users.GetByID(ctx, user.ID.Hex())
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get user, err: ", err)
+		logging.Error(ctx, "Failed to get user", "err", err)
 		return nil, err
 	}
 	if len(userExist.Username) == 0 {
-		fmt.Println("[WARNING] user doesn't exist")
-		return nil, errors.New("User doesn't exist")
+		logging.Warn(ctx, "User doesn't exist")
+		return nil, dealerrors.New(dealerrors.USER_NOT_FOUND, "User %s doesn't exist", user.ID.Hex())
 	}
 	// Update user common props
 	{
 		userExist.Name = user.Name
 		userExist.Surname = user.Surname
 		userExist.Username = user.Username
+		if user.Privacy != nil {
+			userExist.Privacy = user.Privacy
+		}
+		if user.Notifications != nil {
+			userExist.Notifications = user.Notifications
+		}
 	}
-	err = UpdateUserDB(ctx, user.ID.Hex(), userExist, s.userTable)
+	err = s.users.Update(ctx, user.ID.Hex(), userExist)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to update user in data service, err: ", err)
+		logging.Error(ctx, "Failed to update user in data service", "err", err)
 		return nil, err
 	}
+	s.emitUserEvent(ctx, EventUserUpdated, user.ID.Hex())
 	return user, nil
 }
 
 func (s *service) CreateBlameDocument(ctx context.Context, userID, blamedDealID, blameReason string) (string, error) {
-	userDB, err := GetUserByIDDB(ctx, userID, s.userTable)
+	userDB, err := s.users.GetByID(ctx, userID)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get user from DB, err: ", err)
+		logging.Error(ctx, "Failed to get user from DB", "err", err)
 		return "", err
 	}
 	if !userDB.IsJudge {
-		return "", fmt.Errorf("Invalid operation, user %s can't blame because he is not judge", userID)
+		return "", dealerrors.New(dealerrors.NOT_A_JUDGE, "User %s can't blame because they are not a judge", userID)
 	}
-	userJustice, err := userDB.getJustice(ctx, s.dealDocTable)
+	userJustice, err := userDB.getJustice(ctx, s.deals)
 	if err != nil {
 		return "", fmt.Errorf("Failed to get user %s justice, err: %v", userID, err)
 	}
 	if userJustice < 0 {
-		return "", fmt.Errorf("User %s can't join deal because his justice level is toxic", userID)
+		return "", dealerrors.New(dealerrors.JUSTICE_TOO_LOW, "User %s can't blame because their justice level is toxic", userID)
 	}
 	if userJustice == 0 {
-		return "", fmt.Errorf("User %s can't join deal because his justice level is useless", userID)
+		return "", dealerrors.New(dealerrors.JUSTICE_TOO_LOW, "User %s can't blame because their justice level is useless", userID)
 	}
-	blameDocumentDB, err := createInitBlameDocument(userID, blamedDealID, blameReason, "BLAME", userJustice)
+	blameDocumentDB, err := createInitBlameDocument(ctx, userID, blamedDealID, blameReason, "BLAME", userJustice, s.clock.Now())
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to create blame document, err: ", err)
+		logging.Error(ctx, "Failed to create blame document", "err", err)
 		return "", err
 	}
-	blameDocID, err := CreateDealDocumentDB(ctx, blameDocumentDB, s.dealDocTable)
+	// Blame is accepted by its creator on red and judge sides and automatically by the blamed deal on blue side
+	blameDocumentDB.ID = primitive.NewObjectID()
+	acceptances := []struct {
+		signer string
+		side   pb.SideType
+	}{
+		{userID, pb.SideType_RED},
+		{blamedDealID, pb.SideType_BLUE},
+		{userID, pb.SideType_JUDGE},
+	}
+	for _, a := range acceptances {
+		signature, err := s.signAcceptance(ctx, &blameDocumentDB, a.signer, a.side)
+		if err != nil {
+			return "", err
+		}
+		blameDocumentDB.Signatures = append(blameDocumentDB.Signatures, signature)
+	}
+	blameDocID, err := s.deals.Create(ctx, blameDocumentDB)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to create deal document, err: ", err)
+		logging.Error(ctx, "Failed to create deal document", "err", err)
 		return "", err
 	}
 	userDB.DealDocs = append(userDB.DealDocs, blameDocID)
@@ -191,62 +465,95 @@ func (s *service) CreateBlameDocument(ctx context.Context, userID, blamedDealID,
 	userDB.Participating = append(userDB.Participating, blameDocID)
 	userDB.JudgeProfile.Participatings = append(userDB.JudgeProfile.Participatings, blameDocID)
 
-	err = UpdateUserDB(ctx, userID, userDB, s.userTable)
+	err = s.users.Update(ctx, userID, userDB)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to add deal document to users, err: ", err)
+		logging.Error(ctx, "Failed to add deal document to users", "err", err)
 		return "", err
 	}
+	s.emitDealEvent(ctx, EventBlameCreated, blameDocID, userID, map[string]string{"blamed_deal_id": blamedDealID})
+	s.notifyDeal(ctx, blamedDealID, userID, NotificationBlame, "Deal is blamed, judges will review it")
 	return blameDocID, err
 }
 
 func (s *service) CreateDealDocument(ctx context.Context, userID string, dealDocument *pb.Pact) (string, error) {
-	dealDocumentDB, err := createInitDealDocument(userID, dealDocument.GetContent(), dealDocument.GetTimeout(), "COMMON")
+	if err := s.checkStakes(dealDocument.GetStake()); err != nil {
+		logging.Warn(ctx, "Deal can't be created", "err", err)
+		return "", err
+	}
+	if err := s.checkBalance(ctx, userID, dealDocument.GetStake()); err != nil {
+		logging.Warn(ctx, "Deal can't be created", "err", err)
+		return "", err
+	}
+	dealDocumentDB, err := createInitDealDocument(ctx, userID, dealDocument.GetContent(), dealDocument.GetTimeout(), "COMMON",
+		dealDocument.GetRed().GetMembers(), dealDocument.GetBlue().GetMembers(), dealDocument.GetStake(), s.clock.Now())
+	if err != nil {
+		logging.Error(ctx, "Failed to create deal document", "err", err)
+		return "", err
+	}
+	// Creator accepts the terms by creating the deal, id is set beforehand to bind the signature to the deal
+	dealDocumentDB.ID = primitive.NewObjectID()
+	signature, err := s.signAcceptance(ctx, &dealDocumentDB, userID, pb.SideType_RED)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to create deal document, err: ", err)
 		return "", err
 	}
-	dealDocID, err := CreateDealDocumentDB(ctx, dealDocumentDB, s.dealDocTable)
+	dealDocumentDB.Signatures = append(dealDocumentDB.Signatures, signature)
+	dealDocID, err := s.deals.Create(ctx, dealDocumentDB)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to create deal document, err: ", err)
+		logging.Error(ctx, "Failed to create deal document", "err", err)
 		return "", err
 	}
-	userDB, err := GetUserByIDDB(ctx, userID, s.userTable)
+	userDB, err := s.users.GetByID(ctx, userID)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get user from DB, err: ", err)
+		logging.Error(ctx, "Failed to get user from DB", "err", err)
 		return "", err
 	}
 	userDB.DealDocs = append(userDB.DealDocs, dealDocID)
 	userDB.Accepted = append(userDB.Accepted, dealDocID)
 
-	err = UpdateUserDB(ctx, userID, userDB, s.userTable)
+	err = s.users.Update(ctx, userID, userDB)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to add deal document to users, err: ", err)
+		logging.Error(ctx, "Failed to add deal document to users", "err", err)
 		return "", err
 	}
+	s.emitDealEvent(ctx, EventDealCreated, dealDocID, userID, nil)
+	dealsTotal.WithLabelValues(stageCreated).Inc()
 	return dealDocID, err
 }
 
-func createInitDealDocument(redUserID, content, timeout, docType string) (DealDocumentDB, error) {
+func createInitDealDocument(ctx context.Context, redUserID, content, timeout, docType string, redMembers, blueMembers, stake int64, now time.Time) (DealDocumentDB, error) {
 	// Checks
 	if len(redUserID) == 0 {
-		fmt.Println("[LOG] Invalid input, userID is invalid")
-		return DealDocumentDB{}, errors.New("Invalid input, userID is invalid: " + redUserID)
+		logging.Warn(ctx, "Invalid input, userID is invalid")
+		return DealDocumentDB{}, dealerrors.New(dealerrors.INVALID_DEAL, "User id mustn't be empty")
 	}
 	if len(content) == 0 {
-		fmt.Println("[LOG] Invalid input, content is invalid")
-		return DealDocumentDB{}, errors.New("Invalid input, content is invalid: " + content)
+		logging.Warn(ctx, "Invalid input, content is invalid")
+		return DealDocumentDB{}, dealerrors.New(dealerrors.INVALID_DEAL, "Content mustn't be empty")
 	}
 	if len(timeout) == 0 {
-		fmt.Println("[LOG] Invalid input, timeout is invalid")
-		return DealDocumentDB{}, errors.New("Invalid input, timeout is invalid: " + timeout)
+		logging.Warn(ctx, "Invalid input, timeout is invalid")
+		return DealDocumentDB{}, dealerrors.New(dealerrors.INVALID_DEAL, "Timeout mustn't be empty")
 	}
 	if len(docType) == 0 {
-		fmt.Println("[LOG] Invalid input, docType is invalid")
-		return DealDocumentDB{}, errors.New("Invalid input, docType is invalid: " + docType)
+		logging.Warn(ctx, "Invalid input, docType is invalid")
+		return DealDocumentDB{}, dealerrors.New(dealerrors.INVALID_DEAL, "Document type mustn't be empty")
+	}
+	if redMembers < 0 || redMembers > maxSideMembers {
+		logging.Warn(ctx, "Invalid input, red side members count is invalid")
+		return DealDocumentDB{}, dealerrors.New(dealerrors.INVALID_DEAL, "Red side members count must be in range [0, %d], 0 means one member", maxSideMembers)
+	}
+	if blueMembers < 0 || blueMembers > maxSideMembers {
+		logging.Warn(ctx, "Invalid input, blue side members count is invalid")
+		return DealDocumentDB{}, dealerrors.New(dealerrors.INVALID_DEAL, "Blue side members count must be in range [0, %d], 0 means one member", maxSideMembers)
+	}
+	if stake < 0 {
+		logging.Warn(ctx, "Invalid input, stake is invalid")
+		return DealDocumentDB{}, dealerrors.New(dealerrors.INVALID_DEAL, "Stake can't be negative")
 	}
 
 	redSide := SideDB{
-		Type: pb.SideType_RED,
+		Type:    pb.SideType_RED,
+		Members: redMembers,
 		Participants: []ParticipantDB{
 			ParticipantDB{
 				ID:       redUserID,
@@ -259,11 +566,15 @@ func createInitDealDocument(redUserID, content, timeout, docType string) (DealDo
 		Red:     redSide,
 		Blue: SideDB{
 			Type:         pb.SideType_BLUE,
+			Members:      blueMembers,
 			Participants: []ParticipantDB{},
 		},
 		Version: "initial(#1)",
 		Timeout: timeout,
+		Stake:   stake,
 	}
+	// Creator accepts the terms by creating the deal
+	firstPact.Red.Participants[0].PactHash = firstPact.hash()
 	return DealDocumentDB{
 		Type:         docType,
 		Pacts:        []PactDB{firstPact},
@@ -271,34 +582,34 @@ func createInitDealDocument(redUserID, content, timeout, docType string) (DealDo
 		Status: []Status{
 			Status{
 				Name: "INITIAL DEAL STAGE",
-				Time: time.Now(),
+				Time: now,
 			},
 		},
 	}, nil
 }
 
-func createInitBlameDocument(redUserID, blamedDealID, content, docType string, justiceCount int) (DealDocumentDB, error) {
+func createInitBlameDocument(ctx context.Context, redUserID, blamedDealID, content, docType string, justiceCount int, now time.Time) (DealDocumentDB, error) {
 	// Checks
 	if len(redUserID) == 0 {
-		fmt.Println("[LOG] Invalid input, userID is invalid")
-		return DealDocumentDB{}, errors.New("Invalid input, userID is invalid: " + redUserID)
+		logging.Warn(ctx, "Invalid input, userID is invalid")
+		return DealDocumentDB{}, dealerrors.New(dealerrors.INVALID_DEAL, "User id mustn't be empty")
 	}
 	// In case of blame deal blue side will contain deal ID as participant and it will accept deal autonatically
 	if len(blamedDealID) == 0 {
-		fmt.Println("[LOG] Invalid input, blamedDealID is invalid")
-		return DealDocumentDB{}, errors.New("Invalid input, blamedDealID is invalid: " + blamedDealID)
+		logging.Warn(ctx, "Invalid input, blamedDealID is invalid")
+		return DealDocumentDB{}, dealerrors.New(dealerrors.INVALID_DEAL, "Blamed deal id mustn't be empty")
 	}
 	if justiceCount <= 0 {
-		fmt.Println("[LOG] Invalid input, justiceCount is invalid")
-		return DealDocumentDB{}, errors.New("Invalid input, justiceCount is invalid: " + strconv.Itoa(justiceCount))
+		logging.Warn(ctx, "Invalid input, justiceCount is invalid")
+		return DealDocumentDB{}, dealerrors.New(dealerrors.JUSTICE_TOO_LOW, "Justice count %d must be positive", justiceCount)
 	}
 	if len(content) == 0 {
-		fmt.Println("[LOG] Invalid input, content is invalid")
-		return DealDocumentDB{}, errors.New("Invalid input, content is invalid: " + content)
+		logging.Warn(ctx, "Invalid input, content is invalid")
+		return DealDocumentDB{}, dealerrors.New(dealerrors.INVALID_DEAL, "Content mustn't be empty")
 	}
 	if len(docType) == 0 {
-		fmt.Println("[LOG] Invalid input, docType is invalid")
-		return DealDocumentDB{}, errors.New("Invalid input, docType is invalid: " + docType)
+		logging.Warn(ctx, "Invalid input, docType is invalid")
+		return DealDocumentDB{}, dealerrors.New(dealerrors.INVALID_DEAL, "Document type mustn't be empty")
 	}
 
 	redSide := SideDB{
@@ -325,6 +636,9 @@ func createInitBlameDocument(redUserID, blamedDealID, content, docType string, j
 		Blue:    blueSide,
 		Version: "initial(#1)",
 	}
+	pactHash := firstPact.hash()
+	firstPact.Red.Participants[0].PactHash = pactHash
+	firstPact.Blue.Participants[0].PactHash = pactHash
 	return DealDocumentDB{
 		Type:         docType,
 		Pacts:        []PactDB{firstPact},
@@ -337,38 +651,50 @@ func createInitBlameDocument(redUserID, blamedDealID, content, docType string, j
 				ParticipantDB{
 					ID:       redUserID,
 					Accepted: true,
+					PactHash: pactHash,
 				},
 			},
 		},
 		Status: []Status{
 			Status{
 				Name: "INITIAL BLAME DEAL STAGE",
-				Time: time.Now(),
+				Time: now,
 			},
 		},
 	}, nil
 }
 
-// OfferDealDocument offer another user deal document. If toJudge true, then it is offer to user with `username` to judge this deal, in another case it's offer to participate in the deal
-func (s *service) OfferDealDocument(ctx context.Context, dealDocID, username string, toJudge bool) error {
-	dealDoc, err := GetDealDocByIdDB(ctx, dealDocID, s.dealDocTable)
+// OfferDealDocument offer another user deal document. If toJudge true, then it is offer to user with `username` to judge this deal, in another case it's offer to participate in the deal.
+// Participant is offered to the inviter's side if teammate is true, to the opposite side otherwise
+func (s *service) OfferDealDocument(ctx context.Context, inviterID, dealDocID, username string, toJudge, teammate bool) error {
+	dealDoc, err := s.deals.GetByID(ctx, dealDocID)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get deal document from DB, err: ", err)
+		logging.Error(ctx, "Failed to get deal document from DB", "err", err)
 		return err
 	}
 	if dealDoc == nil {
-		fmt.Println("[LOG]:", "Deal document doesn't exist")
-		return errors.New("Deal document doesn't exist")
+		logging.Info(ctx, "Deal document doesn't exist")
+		return dealerrors.New(dealerrors.DEAL_NOT_FOUND, "Deal %s doesn't exist", dealDocID)
+	}
+	dealStatus, err := dealDoc.getStatus()
+	if err != nil {
+		return err
+	}
+	if dealStatus == "CANCELLED" {
+		return dealerrors.New(dealerrors.DEAL_CANCELLED, "Deal %s is cancelled", dealDocID)
+	}
+	if dealStatus != "INITIAL DEAL STAGE" {
+		return dealerrors.New(dealerrors.INVALID_TRANSITION, "Deal %s is not open for invitations, status: %s", dealDocID, dealStatus)
 	}
 
-	offeredUser, offeredUserID, err := GetUserByUsernameDB(ctx, username, s.userTable)
+	offeredUser, offeredUserID, err := s.users.GetByUsername(ctx, username)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get user from DB, err: ", err)
+		logging.Error(ctx, "Failed to get user from DB", "err", err)
 		return err
 	}
 	if len(offeredUserID) == 0 {
-		fmt.Println("[WARNING] user doesn't exist")
-		return errors.New("User doesn't exist")
+		logging.Warn(ctx, "User doesn't exist")
+		return dealerrors.New(dealerrors.USER_NOT_FOUND, "User %s doesn't exist", username)
 	}
 	var offerPersonSide pb.SideType
 	// To reduce repeated code it has to be one interface that gather User and Judge
@@ -411,22 +737,35 @@ func (s *service) OfferDealDocument(ctx context.Context, dealDocID, username str
 				return nil
 			}
 		}
+		offerPersonSide, err = s.checkInvitation(ctx, dealDoc, inviterID, offeredUserID, teammate)
+		if err != nil {
+			logging.Error(ctx, "Invitation rejected", "err", err)
+			return err
+		}
 		offeredUser.Offerings = append(offeredUser.Offerings, dealDocID)
-		offerPersonSide = pb.SideType_BLUE
 	}
 
-	err = UpdateUserDB(ctx, offeredUserID, offeredUser, s.userTable)
+	err = s.users.Update(ctx, offeredUserID, offeredUser)
+	if err != nil {
+		logging.Error(ctx, "Failed to user in DB", "err", err)
+		return err
+	}
+	err = OfferDealDocDB(ctx, dealDocID, offeredUserID, offerPersonSide, s.deals)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to user in DB, err: ", err)
 		return err
 	}
-	return OfferDealDocDB(ctx, dealDocID, offeredUserID, offerPersonSide, s.dealDocTable)
+	s.emitDealEvent(ctx, EventDealOffered, dealDocID, inviterID, map[string]string{
+		"user_id": offeredUserID,
+		"side":    offerPersonSide.String(),
+	})
+	s.notifyUsers(ctx, []string{offeredUserID}, inviterID, NotificationOffer, dealDocID, "You are offered to join the deal on "+offerPersonSide.String()+" side")
+	return nil
 }
 
 func (s *service) GetDealDocument(ctx context.Context, dealDocumentID string) (*pb.DealDocument, error) {
-	dealDoc, err := GetDealDocByIdDBConvert(ctx, dealDocumentID, s.dealDocTable)
+	dealDoc, err := GetDealDocByIdDBConvert(ctx, dealDocumentID, s.deals)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get deal document, err: ", err)
+		logging.Error(ctx, "Failed to get deal document", "err", err)
 		return nil, err
 	}
 	return dealDoc, err
@@ -436,37 +775,70 @@ func (s *service) GetDealDocument(ctx context.Context, dealDocumentID string) (*
 func (s *service) AcceptDealDocument(ctx context.Context, userID, dealDocID string, side pb.SideType) error {
 	// Mark document as accepted
 	// Get doc to make sure it exists
-	dealDoc, err := GetDealDocByIdDB(ctx, dealDocID, s.dealDocTable)
+	dealDoc, err := s.deals.GetByID(ctx, dealDocID)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get deal document from DB, err: ", err)
+		logging.Error(ctx, "Failed to get deal document from DB", "err", err)
 		return err
 	}
 	if dealDoc == nil {
-		fmt.Println("[LOG]:", "Deal document doesn't exist")
-		return errors.New("Deal document doesn't exist")
+		logging.Info(ctx, "Deal document doesn't exist")
+		return dealerrors.New(dealerrors.DEAL_NOT_FOUND, "Deal %s doesn't exist", dealDocID)
+	}
+	dealStatus, err := dealDoc.getStatus()
+	if err != nil {
+		return err
+	}
+	if dealStatus == "CANCELLED" {
+		return dealerrors.New(dealerrors.DEAL_CANCELLED, "Deal %s is cancelled", dealDocID)
+	}
+	// Participant who can't pay the stake would fail every judge acceptance, so they can't accept the deal
+	pact, err := dealDoc.getCurrentPact()
+	if err != nil {
+		return err
+	}
+	if err := s.checkStakes(pact.Stake); err != nil {
+		return err
+	}
+	if err := s.checkBalance(ctx, userID, pact.Stake); err != nil {
+		logging.Warn(ctx, "User can't accept deal", "err", err)
+		return err
+	}
+	signature, err := s.signAcceptance(ctx, dealDoc, userID, side)
+	if err != nil {
+		return err
 	}
-	err = AcceptDealDocDB(ctx, dealDocID, userID, side, s.dealDocTable, s.userTable)
+	// Acceptance and its signature are stored together, so every acceptance of new deals is signed
+	err = s.inTransaction(ctx, func(sc context.Context) error {
+		err := AcceptDealDocDB(sc, dealDocID, userID, side, s.deals, s.users)
+		if err != nil {
+			return err
+		}
+		return s.deals.AddSignature(sc, dealDocID, signature)
+	})
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to accept deal: ", err)
+		logging.Error(ctx, "Failed to accept deal", "err", err)
 		return err
 	}
+	s.emitDealEvent(ctx, EventDealAccepted, dealDocID, userID, map[string]string{"side": side.String()})
+	s.notifyDeal(ctx, dealDocID, userID, NotificationAcceptance, "Participant of "+side.String()+" side accepted the deal")
 	// Whethere it's accept deal action, maybe everyone accepted deal so we could run watchDeal on watcherSvc
-	isDealDocAcceptedByUsers, err := CheckToWatchDeal(ctx, dealDocID, s.dealDocTable)
+	isDealDocAcceptedByUsers, err := CheckToWatchDeal(ctx, dealDocID, s.deals)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to check wheter deal accepted by every participant: ", err)
+		logging.Error(ctx, "Failed to check wheter deal accepted by every participant", "err", err)
 		return err
 	}
 	if isDealDocAcceptedByUsers {
 		// Update deal status
-		err = UpdateDealStatus(ctx, dealDocID, "ACCEPTED_BY_USERS", s.dealDocTable)
+		err = s.updateDealStatus(ctx, dealDocID, "ACCEPTED_BY_USERS")
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to deal status: ", err)
+			logging.Error(ctx, "Failed to deal status", "err", err)
 			return err
 		}
+		dealsTotal.WithLabelValues(stageAccepted).Inc()
 		// Find the judge
 		err = s.OfferJudges(ctx, dealDocID)
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to offer the deal : "+dealDocID+" for judges: ", err)
+			logging.Error(ctx, "Failed to offer the deal : "+dealDocID+" for judges", "err", err)
 			return err
 		}
 		// Move this functionality to the independent method s.SendDealToWatcher
@@ -483,7 +855,7 @@ func (s *service) AcceptDealDocument(ctx context.Context, userID, dealDocID stri
 		// 	return err
 		// }
 		// // Update user deal status
-		// err = TellUserDealStarted(ctx, *dealDoc, s.userTable)
+		// err = TellUserDealStarted(ctx, *dealDoc, s.users)
 		// if err != nil {
 		// 	fmt.Printf("[LOG]: Failed to update user statuses to [PARTICIPATING] in deal %s: %s\n", dealDoc.ID, err)
 		// 	return err
@@ -492,15 +864,297 @@ func (s *service) AcceptDealDocument(ctx context.Context, userID, dealDocID stri
 	return err
 }
 
+// DeclineOffer removes offer of deal {dealDocID} from user {userID} and removes the user from the deal side
+func (s *service) DeclineOffer(ctx context.Context, userID, dealDocID string) error {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		logging.Error(ctx, "Failed to get user from DB", "err", err)
+		return err
+	}
+	if user == nil {
+		return dealerrors.New(dealerrors.USER_NOT_FOUND, "User %s doesn't exist", userID)
+	}
+	var offered bool
+	user.Offerings, offered = removeFromList(user.Offerings, dealDocID)
+	if !offered {
+		return dealerrors.New(dealerrors.NO_OFFER, "User %s doesn't have offer of deal %s", userID, dealDocID)
+	}
+	dealDoc, err := s.deals.GetByID(ctx, dealDocID)
+	if err != nil {
+		logging.Error(ctx, "Failed to get deal document from DB", "err", err)
+		return err
+	}
+	// Deal could be already cancelled, then only user offerings need to be cleaned
+	if dealDoc != nil {
+		dealStatus, err := dealDoc.getStatus()
+		if err != nil {
+			return err
+		}
+		if dealStatus == "INITIAL DEAL STAGE" {
+			pact, err := dealDoc.getCurrentPactRef()
+			if err != nil {
+				return err
+			}
+			participant, removed := pact.Red.removeParticipant(userID)
+			if !removed {
+				participant, removed = pact.Blue.removeParticipant(userID)
+			}
+			if removed && participant.Accepted {
+				return dealerrors.New(dealerrors.ALREADY_ACCEPTED, "User %s already accepted deal %s, withdraw instead", userID, dealDocID)
+			}
+			err = s.deals.Update(ctx, *dealDoc)
+			if err != nil {
+				logging.Error(ctx, "Failed to remove user from deal", "err", err)
+				return err
+			}
+		}
+	}
+	err = s.users.Update(ctx, userID, user)
+	if err != nil {
+		logging.Error(ctx, "Failed to update user offerings", "err", err)
+		return err
+	}
+	s.emitDealEvent(ctx, EventOfferDeclined, dealDocID, userID, nil)
+	return nil
+}
+
+// RevokeOffer takes back pending offer of deal {dealDocID} from user {username}, so its slot on the side is free
+// again. Only creator {creatorID} can revoke offers, and only before the deal is accepted by everybody
+func (s *service) RevokeOffer(ctx context.Context, creatorID, dealDocID, username string) error {
+	dealDoc, err := s.deals.GetByID(ctx, dealDocID)
+	if err != nil {
+		logging.Error(ctx, "Failed to get deal document from DB", "err", err)
+		return err
+	}
+	if dealDoc == nil {
+		return dealerrors.New(dealerrors.DEAL_NOT_FOUND, "Deal %s doesn't exist", dealDocID)
+	}
+	if dealDoc.Type != "COMMON" {
+		return dealerrors.New(dealerrors.INVALID_TRANSITION, "Can't revoke offer of deal %s of type %s", dealDocID, dealDoc.Type)
+	}
+	dealStatus, err := dealDoc.getStatus()
+	if err != nil {
+		return err
+	}
+	if dealStatus != "INITIAL DEAL STAGE" {
+		return dealerrors.New(dealerrors.INVALID_TRANSITION, "Can't revoke offer of deal %s with status %s", dealDocID, dealStatus)
+	}
+	pact, err := dealDoc.getCurrentPactRef()
+	if err != nil {
+		return err
+	}
+	if pact.creatorID() != creatorID {
+		return dealerrors.New(dealerrors.NOT_CREATOR, "Only creator can revoke offers of deal %s", dealDocID)
+	}
+	user, userID, err := s.users.GetByUsername(ctx, username)
+	if err != nil {
+		logging.Error(ctx, "Failed to get user from DB", "err", err)
+		return err
+	}
+	if len(userID) == 0 {
+		return dealerrors.New(dealerrors.USER_NOT_FOUND, "User %s doesn't exist", username)
+	}
+	var offered bool
+	user.Offerings, offered = removeFromList(user.Offerings, dealDocID)
+	if !offered {
+		return dealerrors.New(dealerrors.NO_OFFER, "User %s doesn't have offer of deal %s", username, dealDocID)
+	}
+	participant, removed := pact.Red.removeParticipant(userID)
+	if !removed {
+		participant, removed = pact.Blue.removeParticipant(userID)
+	}
+	if removed && participant.Accepted {
+		return dealerrors.New(dealerrors.ALREADY_ACCEPTED, "User %s already accepted deal %s, offer can't be revoked", username, dealDocID)
+	}
+	// Offer is removed from the side and from the user together
+	err = s.inTransaction(ctx, func(sc context.Context) error {
+		err := s.deals.Update(sc, *dealDoc)
+		if err != nil {
+			logging.Error(ctx, "Failed to remove user from deal", "err", err)
+			return err
+		}
+		err = s.users.Update(sc, userID, user)
+		if err != nil {
+			logging.Error(ctx, "Failed to update user offerings", "err", err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// User isn't on the side anymore, but has to know that the offer is gone
+	s.emitDealEvent(ctx, EventOfferRevoked, dealDocID, creatorID, map[string]string{"user": userID}, userID)
+	return nil
+}
+
+// WithdrawFromDeal removes user {userID} that already accepted deal {dealDocID} from it, possible only before judge took the deal
+func (s *service) WithdrawFromDeal(ctx context.Context, userID, dealDocID string) error {
+	dealDoc, err := s.deals.GetByID(ctx, dealDocID)
+	if err != nil {
+		logging.Error(ctx, "Failed to get deal document from DB", "err", err)
+		return err
+	}
+	if dealDoc == nil {
+		return dealerrors.New(dealerrors.DEAL_NOT_FOUND, "Deal %s doesn't exist", dealDocID)
+	}
+	if dealDoc.Type != "COMMON" {
+		return dealerrors.New(dealerrors.INVALID_TRANSITION, "Can't withdraw from deal %s of type %s", dealDocID, dealDoc.Type)
+	}
+	dealStatus, err := dealDoc.getStatus()
+	if err != nil {
+		return err
+	}
+	if dealStatus != "INITIAL DEAL STAGE" && dealStatus != "ACCEPTED_BY_USERS" {
+		return dealerrors.New(dealerrors.INVALID_TRANSITION, "Can't withdraw from deal %s with status %s", dealDocID, dealStatus)
+	}
+	pact, err := dealDoc.getCurrentPactRef()
+	if err != nil {
+		return err
+	}
+	if pact.creatorID() == userID {
+		return dealerrors.New(dealerrors.INVALID_TRANSITION, "Creator can't withdraw from deal %s, cancel it instead", dealDocID)
+	}
+	participant, removed := pact.Red.removeParticipant(userID)
+	if !removed {
+		participant, removed = pact.Blue.removeParticipant(userID)
+	}
+	if !removed || !participant.Accepted {
+		return dealerrors.New(dealerrors.NOT_A_PARTICIPANT, "User %s doesn't participate in deal %s", userID, dealDocID)
+	}
+	// Participant, lists of the user and deal status change together
+	err = s.inTransaction(ctx, func(sc context.Context) error {
+		err := s.deals.Update(sc, *dealDoc)
+		if err != nil {
+			logging.Error(ctx, "Failed to remove user from deal", "err", err)
+			return err
+		}
+		user, err := s.users.GetByID(sc, userID)
+		if err != nil {
+			logging.Error(ctx, "Failed to get user from DB", "err", err)
+			return err
+		}
+		if user != nil {
+			user.Accepted, _ = removeFromList(user.Accepted, dealDocID)
+			user.DealDocs, _ = removeFromList(user.DealDocs, dealDocID)
+			err = s.users.Update(sc, userID, user)
+			if err != nil {
+				logging.Error(ctx, "Failed to update user accepted deals", "err", err)
+				return err
+			}
+		}
+		if dealStatus == "ACCEPTED_BY_USERS" {
+			// Deal is not accepted by every side anymore, so judges can't take it
+			err = s.updateDealStatus(sc, dealDocID, "INITIAL DEAL STAGE")
+			if err != nil {
+				logging.Error(ctx, "Failed to update deal status", "err", err)
+				return err
+			}
+			err = RemoveDealFromJudgesDB(sc, dealDocID, s.users)
+			if err != nil {
+				logging.Error(ctx, "Failed to remove deal from judge propositions", "err", err)
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.emitDealEvent(ctx, EventDealWithdrawn, dealDocID, userID, nil)
+	return nil
+}
+
+// CancelDeal cancels deal {dealDocID} by its creator {userID} before it's activated and cleans up all related lists
+func (s *service) CancelDeal(ctx context.Context, userID, dealDocID string) error {
+	dealDoc, err := s.deals.GetByID(ctx, dealDocID)
+	if err != nil {
+		logging.Error(ctx, "Failed to get deal document from DB", "err", err)
+		return err
+	}
+	if dealDoc == nil {
+		return dealerrors.New(dealerrors.DEAL_NOT_FOUND, "Deal %s doesn't exist", dealDocID)
+	}
+	if dealDoc.Type != "COMMON" {
+		return dealerrors.New(dealerrors.INVALID_TRANSITION, "Can't cancel deal %s of type %s", dealDocID, dealDoc.Type)
+	}
+	pact, err := dealDoc.getCurrentPact()
+	if err != nil {
+		return err
+	}
+	if pact.creatorID() != userID {
+		return dealerrors.New(dealerrors.NOT_CREATOR, "Only creator can cancel deal %s", dealDocID)
+	}
+	dealStatus, err := dealDoc.getStatus()
+	if err != nil {
+		return err
+	}
+	if dealStatus != "INITIAL DEAL STAGE" && dealStatus != "ACCEPTED_BY_USERS" {
+		return dealerrors.New(dealerrors.INVALID_TRANSITION, "Can't cancel deal %s with status %s", dealDocID, dealStatus)
+	}
+	// Lists of participants and judges are cleaned up together with the status change
+	err = s.inTransaction(ctx, func(sc context.Context) error {
+		participants := append(append([]ParticipantDB{}, pact.Red.Participants...), pact.Blue.Participants...)
+		for _, p := range participants {
+			user, err := s.users.GetByID(sc, p.ID)
+			if err != nil {
+				return fmt.Errorf("Failed to get participant %s of deal %s, err: %v", p.ID, dealDocID, err)
+			}
+			if user == nil {
+				continue
+			}
+			user.Offerings, _ = removeFromList(user.Offerings, dealDocID)
+			user.Accepted, _ = removeFromList(user.Accepted, dealDocID)
+			user.Participating, _ = removeFromList(user.Participating, dealDocID)
+			user.DealDocs, _ = removeFromList(user.DealDocs, dealDocID)
+			err = s.users.Update(sc, p.ID, user)
+			if err != nil {
+				return fmt.Errorf("Failed to clean up deal %s for user %s, err: %v", dealDocID, p.ID, err)
+			}
+		}
+		err := RemoveDealFromJudgesDB(sc, dealDocID, s.users)
+		if err != nil {
+			logging.Error(ctx, "Failed to remove deal from judge propositions", "err", err)
+			return err
+		}
+		err = s.updateDealStatus(sc, dealDocID, "CANCELLED")
+		if err != nil {
+			logging.Error(ctx, "Failed to update deal status", "err", err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Deal isn't activated yet, but make sure no timer left for it
+	afterCommit(ctx, func(ctx context.Context) error {
+		_, err := s.watcherSvcClient.StopWatching(ctx, &pb.StopWatchingReq{
+			ReqHdr: &pb.ReqHdr{
+				Tid: logging.Tid(ctx),
+			},
+			DealId: dealDocID,
+		})
+		if err != nil {
+			// Deal is already cancelled, DealTimeout ignores cancelled deals if their timer is left
+			logging.Error(ctx, "Failed to stop watching deal "+dealDocID, "err", err)
+		}
+		return nil
+	})
+	s.emitDealEvent(ctx, EventDealCancelled, dealDocID, userID, nil)
+	return nil
+}
+
 func (s *service) OfferJudges(ctx context.Context, dealDocID string) error {
 	// Get all judges
-	judges, err := GetJudges(ctx, s.userTable)
-	fmt.Println("judges, err: ", judges, err)
+	judges, err := s.users.GetJudges(ctx)
+	logging.Debug(ctx, "Got judges", "count", len(judges), "err", err)
+	offered := []string{}
 	// Update propositions
 	for _, j := range judges {
 		if j.JudgeProfile == nil {
-			fmt.Println("[LOG]:", "Invalid judge profile data: ", err)
-			return status.Errorf(codes.InvalidArgument, "Judge with id "+j.ID.Hex()+" has invalid judge profile")
+			logging.Error(ctx, "Invalid judge profile data", "err", err)
+			return dealerrors.New(dealerrors.INTERNAL, "Judge %s has invalid judge profile", j.ID.Hex())
 		}
 		alreadyOffered := false
 		for _, p := range j.JudgeProfile.Propositions {
@@ -512,42 +1166,47 @@ func (s *service) OfferJudges(ctx context.Context, dealDocID string) error {
 		if !alreadyOffered {
 			j.JudgeProfile.Propositions = append(j.JudgeProfile.Propositions, dealDocID)
 			// Save that judges
-			err := UpdateUserDB(ctx, j.ID.Hex(), j, s.userTable)
+			err := s.users.Update(ctx, j.ID.Hex(), j)
 			if err != nil {
-				fmt.Println("[LOG]:", "Failed to update judge "+j.ID.Hex()+" propositions: ", err)
+				logging.Error(ctx, "Failed to update judge "+j.ID.Hex()+" propositions", "err", err)
 				return err
 			}
+			offered = append(offered, j.ID.Hex())
 		}
 	}
+	if len(offered) > 0 {
+		s.emitDealEvent(ctx, EventJudgesOffered, dealDocID, "", nil, offered...)
+		s.notifyUsers(ctx, offered, "", NotificationJudgeProposition, dealDocID, "You are proposed to judge the deal")
+	}
 	return nil
 }
 
 func (s *service) JudgeAccept(ctx context.Context, judgeID, dealDocID string) error {
 	// Get judge
-	judge, err := GetUserByIDDB(ctx, judgeID, s.userTable)
+	judge, err := s.users.GetByID(ctx, judgeID)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get user from DB, err: ", err)
+		logging.Error(ctx, "Failed to get user from DB", "err", err)
 		return err
 	}
 	if judge == nil {
-		err := status.Errorf(codes.InvalidArgument, "Judge with id "+judge.ID.Hex()+" doesn't exist")
-		fmt.Println("[LOG]:", err.Error())
+		err := dealerrors.New(dealerrors.USER_NOT_FOUND, "Judge %s doesn't exist", judgeID)
+		logging.Warn(ctx, "Judge can't accept deal", "err", err)
 		return err
 	}
 	if judge.IsJudge == false {
-		err := status.Errorf(codes.InvalidArgument, "User with id "+judge.ID.Hex()+" is not judge")
-		fmt.Println("[LOG]:", err.Error())
+		err := dealerrors.New(dealerrors.NOT_A_JUDGE, "User %s is not a judge", judgeID)
+		logging.Warn(ctx, "Judge can't accept deal", "err", err)
 		return err
 	}
 	if judge.JudgeProfile == nil {
-		err := status.Errorf(codes.Internal, "Judge with id "+judge.ID.Hex()+" has invalid data")
-		fmt.Println("[LOG]:", err.Error())
+		err := dealerrors.New(dealerrors.INTERNAL, "Judge %s has invalid data", judgeID)
+		logging.Error(ctx, "Judge can't accept deal", "err", err)
 		return err
 	}
 	// Check deal status, because someone could already take it
-	dealDoc, err := GetDealDocByIdDB(ctx, dealDocID, s.dealDocTable)
+	dealDoc, err := s.deals.GetByID(ctx, dealDocID)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get deal document from DB, err: ", err)
+		logging.Error(ctx, "Failed to get deal document from DB", "err", err)
 		return err
 	}
 	dealStatus, err := (*dealDoc).getStatus()
@@ -555,6 +1214,15 @@ func (s *service) JudgeAccept(ctx context.Context, judgeID, dealDocID string) er
 		return err
 	}
 	if dealStatus == "ACCEPTED_BY_USERS" {
+		// Deal created before database lost transactions support can't be started safely
+		pact, err := dealDoc.getCurrentPact()
+		if err != nil {
+			return err
+		}
+		if err := s.checkStakes(pact.Stake); err != nil {
+			logging.Warn(ctx, "Judge can't accept deal", "err", err)
+			return err
+		}
 		// If deal still waiting for judge
 		// Move deal from judge [Propositions] to [Participations]
 		propositionAccepted := false
@@ -563,29 +1231,56 @@ func (s *service) JudgeAccept(ctx context.Context, judgeID, dealDocID string) er
 				propositionAccepted = true
 				judge.JudgeProfile.Propositions = append(judge.JudgeProfile.Propositions[:i], judge.JudgeProfile.Propositions[i+1:]...)
 				judge.JudgeProfile.Participatings = append(judge.JudgeProfile.Participatings, dealDocID)
-				err := UpdateUserDB(ctx, judge.ID.Hex(), judge, s.userTable)
+				signature, err := s.signAcceptance(ctx, dealDoc, judgeID, pb.SideType_JUDGE)
 				if err != nil {
-					fmt.Println("[LOG]:", "Failed to update judge "+judge.ID.Hex()+" propositions: ", err)
 					return err
 				}
-				// All participants accepted, deal is ready to wait for resolve
-				err = JudgeAcceptDeal(ctx, judgeID, dealDocID, s.dealDocTable)
+				// Judge assignment, stakes escrow and deal status change either happen together or not at all
+				err = s.inTransaction(ctx, func(sc context.Context) error {
+					err := s.users.Update(sc, judge.ID.Hex(), judge)
+					if err != nil {
+						logging.Error(ctx, "Failed to update judge "+judge.ID.Hex()+" propositions", "err", err)
+						return err
+					}
+					// All participants accepted, deal is ready to wait for resolve
+					err = JudgeAcceptDeal(sc, judgeID, dealDocID, s.clock.Now(), s.deals)
+					if err != nil {
+						logging.Error(ctx, "Failed to update deal "+dealDocID+" status", "err", err)
+						return err
+					}
+					err = s.deals.AddSignature(sc, dealDocID, signature)
+					if err != nil {
+						return err
+					}
+					err = s.enqueueOutbox(sc, WebhookDealActivated, dealDocID, map[string]string{"judge": judgeID})
+					if err != nil {
+						return err
+					}
+					return s.startDeal(sc, dealDoc)
+				})
 				if err != nil {
-					fmt.Println("[LOG]:", "Failed to update deal "+dealDocID+" status: ", err)
 					return err
 				}
-				// Update deal status and call watcher
-				err = s.SendDealToWatcher(ctx, dealDoc)
+				s.emitDealEvent(ctx, EventJudgeAssigned, dealDocID, judgeID, nil)
+				observeJudgeAssignment(dealDoc, s.clock.Now())
+				s.notifyDeal(ctx, dealDocID, judgeID, NotificationJudgeAssigned, "Judge is assigned, deal is active")
+				// Watcher is called only after deal start is committed, so it never watches the deal that was rolled back
+				err = afterCommit(ctx, func(ctx context.Context) error {
+					err := s.SendDealToWatcher(ctx, dealDoc)
+					if err != nil {
+						logging.Error(ctx, "Deal "+dealDocID+" is active, but watcher service doesn't watch it", "err", err)
+					}
+					return err
+				})
 				if err != nil {
-					fmt.Println("[LOG]:", "Failed to send deal "+dealDoc.ID.Hex()+" to watcher service: ", err)
 					return err
 				}
 				break
 			}
 		}
 		if !propositionAccepted {
-			err := status.Errorf(codes.Internal, "Judge with id "+judge.ID.Hex()+" doesn't have deal with id "+dealDocID+" in propositions")
-			fmt.Println("[LOG]:", err.Error())
+			err := dealerrors.New(dealerrors.NO_OFFER, "Judge %s doesn't have deal %s in propositions", judgeID, dealDocID)
+			logging.Warn(ctx, "Judge can't accept deal", "err", err)
 			return err
 		}
 		return nil
@@ -596,9 +1291,9 @@ func (s *service) JudgeAccept(ctx context.Context, judgeID, dealDocID string) er
 		// Remove dealID from propositions
 		if p == dealDocID {
 			judge.JudgeProfile.Propositions = append(judge.JudgeProfile.Propositions[:i], judge.JudgeProfile.Propositions[i+1:]...)
-			err := UpdateUserDB(ctx, judge.ID.Hex(), judge, s.userTable)
+			err := s.users.Update(ctx, judge.ID.Hex(), judge)
 			if err != nil {
-				fmt.Println("[LOG]:", "Failed to update judge "+judge.ID.Hex()+" propositions: ", err)
+				logging.Error(ctx, "Failed to update judge "+judge.ID.Hex()+" propositions", "err", err)
 				return err
 			}
 			break
@@ -608,29 +1303,42 @@ func (s *service) JudgeAccept(ctx context.Context, judgeID, dealDocID string) er
 	return nil
 }
 
-func (s *service) SendDealToWatcher(ctx context.Context, dealDoc *DealDocumentDB) error {
+// startDeal escrows participants stakes and marks deal as started for them.
+// It joins the transaction of {ctx}, so stakes are escrowed together with the judge assignment
+func (s *service) startDeal(ctx context.Context, dealDoc *DealDocumentDB) error {
 	dealID := dealDoc.ID.Hex()
+	return s.inTransaction(ctx, func(sc context.Context) error {
+		err := s.escrowStakes(sc, dealDoc)
+		if err != nil {
+			logging.Error(ctx, "Failed to escrow stakes of deal "+dealID, "err", err)
+			return err
+		}
+		// Update user deal status
+		err = TellUserDealStarted(sc, *dealDoc, s.users)
+		if err != nil {
+			logging.Error(ctx, "Failed to update user statuses to [PARTICIPATING] in deal "+dealID, "err", err)
+		}
+		return err
+	})
+}
+
+// SendDealToWatcher sends started deal to the watcher, so it times out by its pact timeout.
+// Watcher can't take part in the transaction, so it is called only after deal start is committed
+func (s *service) SendDealToWatcher(ctx context.Context, dealDoc *DealDocumentDB) error {
 	currentPact, err := (*dealDoc).getCurrentPact()
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get deal document current pact: ", err)
+		logging.Error(ctx, "Failed to get deal document current pact", "err", err)
 		return err
 	}
-	// Send deal to watcher
 	_, err = s.watcherSvcClient.HoldAndWatch(ctx, &pb.HoldAndWatchReq{
 		ReqHdr: &pb.ReqHdr{
-			Tid: "1234",
+			Tid: logging.Tid(ctx),
 		},
-		DealId:  dealID,
+		DealId:  dealDoc.ID.Hex(),
 		Timeout: currentPact.Timeout,
 	})
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to watch new deal: ", err)
-		return err
-	}
-	// Update user deal status
-	err = TellUserDealStarted(ctx, *dealDoc, s.userTable)
-	if err != nil {
-		fmt.Printf("[LOG]: Failed to update user statuses to [PARTICIPATING] in deal %s: %s\n", dealDoc.ID, err)
+		logging.Error(ctx, "Failed to watch new deal", "err", err)
 		return err
 	}
 	return nil
@@ -638,65 +1346,102 @@ func (s *service) SendDealToWatcher(ctx context.Context, dealDoc *DealDocumentDB
 
 // DealTimeout can be called only by watcherSvc that watch a timer for deal timeout
 func (s *service) DealTimeout(ctx context.Context, dealDocID string) error {
-	fmt.Println("[LOG]:", "Inside DealTimeout, docID: ", dealDocID)
-	dealDoc, err := GetDealDocByIdDB(ctx, dealDocID, s.dealDocTable)
+	logging.Info(ctx, "Deal timed out", "deal", dealDocID)
+	dealDoc, err := s.deals.GetByID(ctx, dealDocID)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get deal document current pact: ", err)
+		logging.Error(ctx, "Failed to get deal document current pact", "err", err)
 		return err
 	}
 	if dealDoc == nil {
-		err = fmt.Errorf("[LOG]:", "No deal with id %s, error: %v", dealDocID, err)
+		err = dealerrors.New(dealerrors.DEAL_NOT_FOUND, "Deal %s doesn't exist", dealDocID)
 		return err
 	}
 	// Check if winner chosen and set winner status or expiration
 	dealStatus, err := dealDoc.getStatus()
+	if err != nil {
+		return err
+	}
+	// Watcher can't always be told that deal is cancelled, so timer of cancelled deal can still fire
+	if dealStatus == "CANCELLED" {
+		logging.Info(ctx, "Deal "+dealDocID+" is cancelled, nothing to time out")
+		return nil
+	}
 	pact, err := dealDoc.getCurrentPact()
 	blueParticipants := pact.Blue.Participants
 	redParticipants := pact.Red.Participants
 
-	// Notify users about deal result
-	if dealStatus == "WINNER_SET" {
-		blueStatus := "losed"
-		redStatus := "won"
-		if dealDoc.Winner == "blue" {
-			blueStatus, redStatus = redStatus, blueStatus
-		}
-		for _, rP := range redParticipants {
-			err = notifyParticipantAboutResult(ctx, dealDoc.ID.Hex(), rP, redStatus, s.userTable)
-			if err != nil {
-				return fmt.Errorf("Failed to notify user about deal result: %v", err)
+	// Result of every participant, they are notified once the transaction is committed
+	results := map[string]string{}
+	// Results, stakes and TIME_OUT status are written in one transaction, so timeout can't pay twice
+	err = s.inTransaction(ctx, func(sc context.Context) error {
+		// Notify users about deal result
+		if dealStatus == "WINNER_SET" {
+			blueStatus := "losed"
+			redStatus := "won"
+			if dealDoc.Winner == "blue" {
+				blueStatus, redStatus = redStatus, blueStatus
 			}
-		}
-		for _, bP := range blueParticipants {
-			err = notifyParticipantAboutResult(ctx, dealDoc.ID.Hex(), bP, blueStatus, s.userTable)
-			if err != nil {
-				return fmt.Errorf("Failed to notify user about deal result: %v", err)
+			for _, rP := range redParticipants {
+				results[rP.ID] = redStatus
+				err := notifyParticipantAboutResult(sc, dealDoc.ID.Hex(), rP, redStatus, s.users)
+				if err != nil {
+					return fmt.Errorf("Failed to notify user about deal result: %v", err)
+				}
 			}
-		}
-	} else {
-		// Judge hasn't set the winner so we will set deal result as expired
-		participants := append(blueParticipants, redParticipants...)
-		for _, p := range participants {
-			err = notifyParticipantAboutResult(ctx, dealDoc.ID.Hex(), p, "EXPIRED", s.userTable)
-			if err != nil {
-				return fmt.Errorf("Failed to notify user about deal result: %v", err)
+			for _, bP := range blueParticipants {
+				results[bP.ID] = blueStatus
+				err := notifyParticipantAboutResult(sc, dealDoc.ID.Hex(), bP, blueStatus, s.users)
+				if err != nil {
+					return fmt.Errorf("Failed to notify user about deal result: %v", err)
+				}
+			}
+		} else {
+			// Judge hasn't set the winner so we will set deal result as expired
+			participants := append(blueParticipants, redParticipants...)
+			for _, p := range participants {
+				results[p.ID] = "EXPIRED"
+				err := notifyParticipantAboutResult(sc, dealDoc.ID.Hex(), p, "EXPIRED", s.users)
+				if err != nil {
+					return fmt.Errorf("Failed to notify user about deal result: %v", err)
+				}
 			}
 		}
-	}
-	err = UpdateDealStatus(ctx, dealDocID, "TIME_OUT", s.dealDocTable)
+		// Pay the winners or refund stakes if deal expired
+		err := s.settleStakes(sc, dealDoc, dealStatus)
+		if err != nil {
+			logging.Error(ctx, "Failed to settle deal stakes", "err", err)
+			return err
+		}
+		err = s.updateDealStatus(sc, dealDocID, "TIME_OUT")
+		if err != nil {
+			logging.Error(ctx, "Failed to update deal status", "err", err)
+		}
+		return err
+	})
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to update deal status: ", err)
+		return err
 	}
-	return err
+	s.emitDealEvent(ctx, EventDealTimedOut, dealDocID, "", map[string]string{"status": dealStatus})
+	dealsTotal.WithLabelValues(stageTimedOut).Inc()
+	for userID, result := range results {
+		s.notify(ctx, NotificationDB{
+			UserID:  userID,
+			Kind:    NotificationResult,
+			DealID:  dealDocID,
+			Status:  result,
+			Message: resultMessage(result),
+		})
+	}
+	return nil
 }
 
-func notifyParticipantAboutResult(ctx context.Context, dealDocID string, participant ParticipantDB, status string, userTable *mongo.Collection) error {
-	fmt.Println("{DEBUG}:", "Inside notifyParticipantAboutResult")
-	fmt.Println("{DEBUG}:", "Update status of "+participant.ID+" to status "+status)
+func notifyParticipantAboutResult(ctx context.Context, dealDocID string, participant ParticipantDB, status string, users UserRepo) error {
+	logging.Debug(ctx, "Inside notifyParticipantAboutResult")
+	logging.Debug(ctx, "Update status of "+participant.ID+" to status "+status)
 	// Get user
-	user, err := GetUserByIDDB(ctx, participant.ID, userTable)
+	user, err := users.GetByID(ctx, participant.ID)
 	if err != nil {
-		fmt.Println("[LOG]", "Failed to get user from DB to set winner status")
+		logging.Error(ctx, "Failed to get user from DB to set winner status")
 	}
 	// Check if user participating in the deal
 	partDealIndex := -1
@@ -707,38 +1452,38 @@ func notifyParticipantAboutResult(ctx context.Context, dealDocID string, partici
 		}
 	}
 	if partDealIndex == -1 {
-		fmt.Println("{DEBUG}:", "User "+participant.ID+" doesn't participate in deal "+dealDocID)
-		return errors.New("User " + participant.ID + " doesn't participate in deal " + dealDocID)
+		logging.Debug(ctx, "User "+participant.ID+" doesn't participate in deal "+dealDocID)
+		return dealerrors.New(dealerrors.NOT_A_PARTICIPANT, "User %s doesn't participate in deal %s", participant.ID, dealDocID)
 	}
 	// Move from participating to deal_results
 	user.Participating = append(user.Participating[:partDealIndex], user.Participating[partDealIndex+1:]...)
 	user.DealResults = append(user.DealResults, dealDocID)
-	err = UpdateUserDB(ctx, participant.ID, user, userTable)
+	err = users.Update(ctx, participant.ID, user)
 	if err != nil {
 		return fmt.Errorf("Failed to notify user %s about of %s result of deal %s", participant.ID, status, dealDocID)
 	}
 	return err
 }
 
-// JudgeDecide make a decision who won that deal (red if redWon is true)
+// JudgeDecide makes decision of judge {judgeID} that {winner} side ("red" or "blue") won deal {dealDocID}
 func (s *service) JudgeDecide(ctx context.Context, judgeID, dealDocID, winner string) error {
-	fmt.Println("Inputs: [judgeID]: ", judgeID, "[dealDocID]: ", dealDocID, "[winner]: ", winner)
-	dealDoc, err := GetDealDocByIdDB(ctx, dealDocID, s.dealDocTable)
+	logging.Debug(ctx, "Judge decides", "judge", judgeID, "deal", dealDocID, "winner", winner)
+	dealDoc, err := s.deals.GetByID(ctx, dealDocID)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get deal document current pact: ", err)
+		logging.Error(ctx, "Failed to get deal document current pact", "err", err)
 		return err
 	}
 	if dealDoc == nil {
-		return fmt.Errorf("No deal with id %s, error: %v", dealDocID, err)
+		return dealerrors.New(dealerrors.DEAL_NOT_FOUND, "Deal %s doesn't exist", dealDocID)
 	}
 	// Get judge profile
-	judge, err := GetUserByIDDB(ctx, judgeID, s.userTable)
+	judge, err := s.users.GetByID(ctx, judgeID)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get judge: ", err)
+		logging.Error(ctx, "Failed to get judge", "err", err)
 		return err
 	}
 	if judge == nil {
-		return fmt.Errorf("No judge with id %s, error: %v", judgeID, err)
+		return dealerrors.New(dealerrors.USER_NOT_FOUND, "Judge %s doesn't exist", judgeID)
 	}
 	// Check whether judge participate in the deal
 	participatingInDeal := false
@@ -749,52 +1494,75 @@ func (s *service) JudgeDecide(ctx context.Context, judgeID, dealDocID, winner st
 		}
 	}
 	if !participatingInDeal {
-		return fmt.Errorf("Judge %s doesn't participate in deal %s", judgeID, dealDocID)
+		return dealerrors.New(dealerrors.NOT_A_PARTICIPANT, "Judge %s doesn't participate in deal %s", judgeID, dealDocID)
 	}
-	err = SetDealWinner(ctx, dealDocID, winner, s.dealDocTable, s.userTable)
+	signature, err := s.signDeal(ctx, dealDoc, SignatureDecision, judgeID, map[string]string{"winner": winner})
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to set deal winner: ", err)
+		return err
 	}
-	err = MakeDecision(ctx, judge, dealDocID, winner, s.userTable)
+	err = s.inTransaction(ctx, func(sc context.Context) error {
+		err := SetDealWinner(sc, dealDocID, winner, s.clock.Now(), s.deals, s.users)
+		if err != nil {
+			return err
+		}
+		err = s.deals.AddSignature(sc, dealDocID, signature)
+		if err != nil {
+			return err
+		}
+		// Decision is recorded in judge stats together with the winner, so judge justice matches decided deals
+		err = MakeDecision(sc, judge, dealDocID, winner, s.clock.Now(), s.users)
+		if err != nil {
+			logging.Error(ctx, "Failed to update judge decisions stats", "err", err)
+			return err
+		}
+		return s.enqueueOutbox(sc, WebhookDealDecided, dealDocID, map[string]string{"judge": judgeID})
+	})
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to update judge decisions stats: ", err)
+		logging.Error(ctx, "Failed to set deal winner", "err", err)
+		return err
 	}
-	return err
+	s.emitDealEvent(ctx, EventDealDecided, dealDocID, judgeID, map[string]string{"winner": winner})
+	dealsTotal.WithLabelValues(stageDecided).Inc()
+	s.notifyDeal(ctx, dealDocID, judgeID, NotificationDecision, "Judge decided that "+winner+" side won the deal")
+	return nil
 }
 
 func (s *service) JoinBlame(ctx context.Context, userID, blameID string) error {
-	userDB, err := GetUserByIDDB(ctx, userID, s.userTable)
+	userDB, err := s.users.GetByID(ctx, userID)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get user %s from DB, err: ", userID, err)
+		logging.Error(ctx, "Failed to get user "+userID+" from DB", "err", err)
 		return err
 	}
 	if !userDB.IsJudge {
-		return fmt.Errorf("User %s can't join blame %s because he is not a judge", userID, blameID)
+		return dealerrors.New(dealerrors.NOT_A_JUDGE, "User %s can't join blame %s because they are not a judge", userID, blameID)
 	}
 	for _, pD := range userDB.Participating {
 		if pD == blameID {
-			return fmt.Errorf("User %s can't join blame %s because he is already participating", userID, blameID)
+			return dealerrors.New(dealerrors.ALREADY_PARTICIPATES, "User %s already participates in blame %s", userID, blameID)
 		}
 	}
-	userJustice, err := userDB.getJustice(ctx, s.dealDocTable)
+	userJustice, err := userDB.getJustice(ctx, s.deals)
 	if err != nil {
 		return fmt.Errorf("Failed to get user %s justice, err: %v", userID, err)
 	}
 	// Gotcha, hacker
 	if userJustice < 0 {
-		return fmt.Errorf("User %s can't join blame %s because his justice level is toxic", userID, blameID)
+		return dealerrors.New(dealerrors.JUSTICE_TOO_LOW, "User %s can't join blame %s because their justice level is toxic", userID, blameID)
 	}
 	if userJustice == 0 {
-		return fmt.Errorf("User %s can't join blame %s because his justice level is useless", userID, blameID)
+		return dealerrors.New(dealerrors.JUSTICE_TOO_LOW, "User %s can't join blame %s because their justice level is useless", userID, blameID)
 	}
 	// Update user deals for participation and judge
-	blameDoc, err := GetDealDocByIdDB(ctx, blameID, s.dealDocTable)
+	blameDoc, err := s.deals.GetByID(ctx, blameID)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get blame %s document, err: ", blameID, err)
+		logging.Error(ctx, "Failed to get blame "+blameID+" document", "err", err)
 		return err
 	}
+	if blameDoc == nil {
+		return dealerrors.New(dealerrors.BLAME_NOT_FOUND, "Blame %s doesn't exist", blameID)
+	}
 	if blameDoc.Completed {
-		return fmt.Errorf("User %s can't join blame %s because it's already completed", userID, blameID)
+		return dealerrors.New(dealerrors.INVALID_TRANSITION, "User %s can't join blame %s because it's already completed", userID, blameID)
 	}
 	for i, p := range blameDoc.Pacts {
 		if p.Version == blameDoc.FinalVersion {
@@ -813,29 +1581,30 @@ func (s *service) JoinBlame(ctx context.Context, userID, blameID string) error {
 		}
 	}
 	// Save deal document
-	err = UpdateDeal(ctx, *blameDoc, s.dealDocTable)
+	err = s.deals.Update(ctx, *blameDoc)
 	if err != nil {
-		return fmt.Errorf("User %s can't join deal because his justice level is useless")
+		return fmt.Errorf("Failed to update blame %s, err: %v", blameID, err)
 	}
 	// Update user
 	userDB.Participating = append(userDB.Participating, blameID)
 	userDB.JudgeProfile.Participatings = append(userDB.JudgeProfile.Participatings, blameID)
 
-	err = UpdateUserDB(ctx, userID, userDB, s.userTable)
+	err = s.users.Update(ctx, userID, userDB)
 	if err != nil {
 		return fmt.Errorf("Failed to update user %s, err: %v", userID, err)
 	}
+	s.emitDealEvent(ctx, EventBlameJoined, blameID, userID, nil)
 	return nil
 }
 
 func (s *service) ActivateBlame(ctx context.Context, judgeID, blameID string) error {
-	userDB, err := GetUserByIDDB(ctx, judgeID, s.userTable)
+	userDB, err := s.users.GetByID(ctx, judgeID)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get user %s from DB, err: ", judgeID, err)
+		logging.Error(ctx, "Failed to get user "+judgeID+" from DB", "err", err)
 		return err
 	}
 	if !userDB.IsJudge {
-		return fmt.Errorf("User %s can't join blame %s because he is not a judge", judgeID, blameID)
+		return dealerrors.New(dealerrors.NOT_A_JUDGE, "User %s can't activate blame %s because they are not a judge", judgeID, blameID)
 	}
 	participation := false
 	for _, pD := range userDB.Participating {
@@ -845,15 +1614,18 @@ func (s *service) ActivateBlame(ctx context.Context, judgeID, blameID string) er
 		}
 	}
 	if !participation {
-		return fmt.Errorf("User %s can't activate blame %s because he doesn't participate in it", judgeID, blameID)
+		return dealerrors.New(dealerrors.NOT_A_PARTICIPANT, "User %s can't activate blame %s because they don't participate in it", judgeID, blameID)
 	}
 	// Ok, user can activate blame, let's check whether blame is possible to activate with current justiceCount
-	blameDoc, err := GetDealDocByIdDB(ctx, blameID, s.dealDocTable)
+	blameDoc, err := s.deals.GetByID(ctx, blameID)
 	if err != nil {
 		return fmt.Errorf("Failed to get blame %s document, err: %v", blameID, err)
 	}
+	if blameDoc == nil {
+		return dealerrors.New(dealerrors.BLAME_NOT_FOUND, "Blame %s doesn't exist", blameID)
+	}
 	if blameDoc.Completed {
-		return fmt.Errorf("User %s can't activate blame %s because it's already activated", judgeID, blameID)
+		return dealerrors.New(dealerrors.INVALID_TRANSITION, "User %s can't activate blame %s because it's already activated", judgeID, blameID)
 	}
 	var blamedDealID string
 	for _, p := range blameDoc.Pacts {
@@ -861,73 +1633,88 @@ func (s *service) ActivateBlame(ctx context.Context, judgeID, blameID string) er
 			blamedDealID = p.Blue.Participants[0].ID
 		}
 	}
-	blamedDealDoc, err := GetDealDocByIdDB(ctx, blamedDealID, s.dealDocTable)
+	blamedDealDoc, err := s.deals.GetByID(ctx, blamedDealID)
 	if err != nil {
 		return fmt.Errorf("Failed to get blamed deal document %s document, err: %v", blamedDealID, err)
 	}
 	if blamedDealDoc.JusticeCount > blameDoc.JusticeCount {
-		return fmt.Errorf("User %s can't activate blame %s because bale justice count %d is not enough, %d needed", judgeID, blameID, blameDoc.JusticeCount, blamedDealDoc.JusticeCount)
+		return dealerrors.New(dealerrors.JUSTICE_TOO_LOW, "User %s can't activate blame %s because blame justice count %d is not enough, %d needed", judgeID, blameID, blameDoc.JusticeCount, blamedDealDoc.JusticeCount)
 	}
 
-	blameDoc.Completed = true
-	blameDoc.Blamed = "No"
-	err = UpdateDeal(ctx, *blameDoc, s.dealDocTable)
+	signature, err := s.signDeal(ctx, blameDoc, SignatureBlameActivation, judgeID, map[string]string{"blamed_deal_id": blamedDealID})
 	if err != nil {
-		return fmt.Errorf("Failed to activate blame document %s, err: %v", blameDoc.ID.Hex(), err)
+		return err
 	}
+	blameDoc.Completed = true
+	blameDoc.Blamed = "No"
+	// Blame activation, reversal of the chain, stakes clawback and judge states are applied together
+	err = s.inTransaction(ctx, func(sc context.Context) error {
+		err := s.deals.Update(sc, *blameDoc)
+		if err != nil {
+			return fmt.Errorf("Failed to activate blame document %s, err: %v", blameDoc.ID.Hex(), err)
+		}
+		err = s.deals.AddSignature(sc, blameDoc.ID.Hex(), signature)
+		if err != nil {
+			return err
+		}
 
-	// Reverse status of blamed deals (that can be chain of documents like BLAME -> BLAME -> ... -> COMMON)
-
-	lastBlameID := blamedDealDoc.BlameID
-	if len(lastBlameID) == 0 {
-		lastBlameID = blamedDealDoc.ID.Hex()
-		// This deal blamed first time so need to reverse only it's {blamed}
-	}
-	err = s.blameDeal(ctx, lastBlameID, blameDoc.ID.Hex(), blameDoc.JusticeCount)
-	if err != nil {
-		return fmt.Errorf("Failed blame chain of documents starting from document %s, err: %v", blamedDealDoc.ID.Hex(), err)
-	}
+		// Reverse status of blamed deals (that can be chain of documents like BLAME -> BLAME -> ... -> COMMON)
 
-	// Update user deal states
-	for _, j := range blameDoc.Judge.Participants {
-		participant, err := GetUserByIDDB(ctx, j.ID, s.userTable)
+		lastBlameID := blamedDealDoc.BlameID
+		if len(lastBlameID) == 0 {
+			lastBlameID = blamedDealDoc.ID.Hex()
+			// This deal blamed first time so need to reverse only it's {blamed}
+		}
+		err = s.blameDeal(sc, lastBlameID, blameDoc.ID.Hex(), blameDoc.JusticeCount)
 		if err != nil {
-			return fmt.Errorf("Failed to get participant %s from blame %s, err: %v", j.ID, blamedDealID, err)
+			return fmt.Errorf("Failed blame chain of documents starting from document %s, err: %v", blamedDealDoc.ID.Hex(), err)
 		}
-		for i, d := range participant.Participating {
-			if d == blameDoc.ID.Hex() {
-				participant.Participating = append(participant.Participating[:i], participant.Participating[i+1:]...)
-				participant.DealResults = append(participant.DealResults, d)
-				break
+		// Update user deal states
+		for _, j := range blameDoc.Judge.Participants {
+			participant, err := s.users.GetByID(sc, j.ID)
+			if err != nil {
+				return fmt.Errorf("Failed to get participant %s from blame %s, err: %v", j.ID, blamedDealID, err)
 			}
-		}
-		judgeProfile := *participant.JudgeProfile
-		for i, d := range judgeProfile.Participatings {
-			if d == blameDoc.ID.Hex() {
-				judgeProfile.Participatings = append(judgeProfile.Participatings[:i], judgeProfile.Participatings[i+1:]...)
-				judgeProfile.Decisions = append(judgeProfile.Decisions, Decision{
-					DealID: blameDoc.ID.Hex(),
-					Winner: "Me",
-					When:   time.Now().String(),
-				})
-				break
+			for i, d := range participant.Participating {
+				if d == blameDoc.ID.Hex() {
+					participant.Participating = append(participant.Participating[:i], participant.Participating[i+1:]...)
+					participant.DealResults = append(participant.DealResults, d)
+					break
+				}
+			}
+			judgeProfile := *participant.JudgeProfile
+			for i, d := range judgeProfile.Participatings {
+				if d == blameDoc.ID.Hex() {
+					judgeProfile.Participatings = append(judgeProfile.Participatings[:i], judgeProfile.Participatings[i+1:]...)
+					judgeProfile.Decisions = append(judgeProfile.Decisions, Decision{
+						DealID: blameDoc.ID.Hex(),
+						Winner: "Me",
+						When:   s.clock.Now().UTC().Format(timeoutLayout),
+					})
+					break
+				}
+			}
+			participant.JudgeProfile = &judgeProfile
+			err = s.users.Update(sc, participant.ID.Hex(), participant)
+			if err != nil {
+				return fmt.Errorf("Failed to change statuses of blame deal %s for user %s, err: %v", blamedDealID, participant.ID.Hex(), err)
 			}
 		}
-		participant.JudgeProfile = &judgeProfile
-		err = UpdateUserDB(ctx, participant.ID.Hex(), participant, s.userTable)
-		if err != nil {
-			return fmt.Errorf("Failed to chnage statuses of blame deal %s for user %s, err: %v", blamedDealID, participant.ID.Hex(), err)
-		}
-	}
+		return s.enqueueOutbox(sc, WebhookDealBlamed, blamedDealID, map[string]string{"blame_id": blameDoc.ID.Hex()})
+	})
 	if err != nil {
-		return fmt.Errorf("Failed to get blame participants %s, err: %v", blamedDealID, err)
+		return err
 	}
-
+	dealsTotal.WithLabelValues(stageBlamed).Inc()
+	s.emitDealEvent(ctx, EventBlameActivated, blameID, judgeID, map[string]string{"blamed_deal_id": blamedDealID})
+	// Parties of the blamed deal have to know that result of their deal changed
+	s.emitDealEvent(ctx, EventBlameActivated, blamedDealID, judgeID, map[string]string{"blame_id": blameID})
+	s.notifyDeal(ctx, blamedDealID, judgeID, NotificationBlame, "Blame against the deal is accepted, deal result is reversed")
 	return nil
 }
 
 func (s *service) blameDeal(ctx context.Context, dealID, blameID string, justiceCount int) error {
-	deal, err := GetDealDocByIdDB(ctx, dealID, s.dealDocTable)
+	deal, err := s.deals.GetByID(ctx, dealID)
 	if err != nil {
 		return fmt.Errorf("Failed to get deal %s to blame, err: %v", dealID, err)
 	}
@@ -938,6 +1725,7 @@ func (s *service) blameDeal(ctx context.Context, dealID, blameID string, justice
 	switch deal.Type {
 	case "COMMON":
 		// We reverse, so it the deal was blamed, we have to reverse it (by blaming the blame)
+		oldWinner := deal.effectiveWinner()
 		if deal.Blamed == "Yes" {
 			deal.Blamed = "No"
 		} else if deal.Blamed == "No" {
@@ -945,7 +1733,14 @@ func (s *service) blameDeal(ctx context.Context, dealID, blameID string, justice
 		}
 		deal.JusticeCount = justiceCount
 		deal.BlameID = blameID
-		return UpdateDeal(ctx, *deal, s.dealDocTable)
+		return s.inTransaction(ctx, func(sc context.Context) error {
+			err := s.deals.Update(sc, *deal)
+			if err != nil {
+				return err
+			}
+			// Winner changed, so the money has to follow
+			return s.reverseStakes(sc, deal, oldWinner)
+		})
 	// To bale the "BLAME" you have to do that recursively
 	case "BLAME":
 		if deal.Blamed == "Yes" {
@@ -953,10 +1748,10 @@ func (s *service) blameDeal(ctx context.Context, dealID, blameID string, justice
 		} else if deal.Blamed == "No" {
 			deal.Blamed = "Yes"
 		}
-		fmt.Println("{BLAME}: ", "Blame deal "+dealID+" to blamed "+deal.Blamed)
-		err := UpdateDeal(ctx, *deal, s.dealDocTable)
+		logging.Debug(ctx, "Blame deal "+dealID+" to blamed "+deal.Blamed)
+		err := s.deals.Update(ctx, *deal)
 		if err != nil {
-			fmt.Errorf("Failed to update deal document %s, err: %v", deal.ID.Hex(), err)
+			return fmt.Errorf("Failed to update deal document %s, err: %v", deal.ID.Hex(), err)
 		}
 		var blamedDealID string
 		for _, p := range deal.Pacts {
@@ -969,3 +1764,240 @@ func (s *service) blameDeal(ctx context.Context, dealID, blameID string, justice
 		return fmt.Errorf("Can't blame deal %s, with unknown type: %v", deal.ID.Hex(), deal.Type)
 	}
 }
+
+const (
+	// Layout of the deal timeout, the same one watcherSvc uses to parse it
+	timeoutLayout     = "2006-01-02T15:04:05.000Z"
+	contentPreviewLen = 140
+	defaultPageSize   = 20
+	maxPageSize       = 100
+	// How long to wait for authSvc to give the key of its tokens
+	pubKeyTimeout = 5 * time.Second
+)
+
+// JudgeQueue is a set of deals judge can take or already works on
+type JudgeQueue struct {
+	Propositions      []*pb.DealSummary
+	ActiveCases       []*pb.DealSummary
+	OpenBlames        []*pb.DealSummary
+	PropositionsTotal int
+	ActiveCasesTotal  int
+	OpenBlamesTotal   int
+}
+
+// GetJudgeQueue returns hydrated propositions, active cases sorted by deadline and open blames judge {judgeID} could join
+func (s *service) GetJudgeQueue(ctx context.Context, judgeID string, page, pageSize int) (*JudgeQueue, error) {
+	judge, err := s.users.GetByID(ctx, judgeID)
+	if err != nil {
+		logging.Error(ctx, "Failed to get judge from DB", "err", err)
+		return nil, err
+	}
+	if judge == nil {
+		return nil, dealerrors.New(dealerrors.USER_NOT_FOUND, "Judge %s doesn't exist", judgeID)
+	}
+	if !judge.IsJudge || judge.JudgeProfile == nil {
+		return nil, dealerrors.New(dealerrors.NOT_A_JUDGE, "User %s is not a judge", judgeID)
+	}
+	// Same participants appear in many deals, count their reputation only once
+	reputations := map[string]*pb.PartyReputation{}
+
+	propositions := []*pb.DealSummary{}
+	for _, dealID := range judge.JudgeProfile.Propositions {
+		deal, err := s.deals.GetByID(ctx, dealID)
+		if err != nil {
+			logging.Error(ctx, "Failed to get proposed deal "+dealID, "err", err)
+			return nil, err
+		}
+		if deal == nil {
+			continue
+		}
+		dealStatus, err := deal.getStatus()
+		if err != nil {
+			return nil, err
+		}
+		// Another judge could already take it
+		if dealStatus != "ACCEPTED_BY_USERS" {
+			continue
+		}
+		summary, err := s.summarizeDeal(ctx, deal, reputations)
+		if err != nil {
+			return nil, err
+		}
+		propositions = append(propositions, summary)
+	}
+	sortByDeadline(propositions)
+
+	activeCases := []*pb.DealSummary{}
+	for _, dealID := range judge.JudgeProfile.Participatings {
+		deal, err := s.deals.GetByID(ctx, dealID)
+		if err != nil {
+			logging.Error(ctx, "Failed to get active deal "+dealID, "err", err)
+			return nil, err
+		}
+		if deal == nil || deal.Completed {
+			continue
+		}
+		summary, err := s.summarizeDeal(ctx, deal, reputations)
+		if err != nil {
+			return nil, err
+		}
+		activeCases = append(activeCases, summary)
+	}
+	sortByDeadline(activeCases)
+
+	blames, err := s.deals.GetOpenBlames(ctx)
+	if err != nil {
+		logging.Error(ctx, "Failed to get open blames", "err", err)
+		return nil, err
+	}
+	openBlames := []*pb.DealSummary{}
+	for _, blame := range blames {
+		if isDealJudge(blame, judgeID) {
+			continue
+		}
+		summary, err := s.summarizeDeal(ctx, blame, reputations)
+		if err != nil {
+			return nil, err
+		}
+		openBlames = append(openBlames, summary)
+	}
+
+	start, end := paginate(len(propositions), page, pageSize)
+	queue := &JudgeQueue{
+		Propositions:      propositions[start:end],
+		PropositionsTotal: len(propositions),
+		ActiveCasesTotal:  len(activeCases),
+		OpenBlamesTotal:   len(openBlames),
+	}
+	start, end = paginate(len(activeCases), page, pageSize)
+	queue.ActiveCases = activeCases[start:end]
+	start, end = paginate(len(openBlames), page, pageSize)
+	queue.OpenBlames = openBlames[start:end]
+	return queue, nil
+}
+
+// summarizeDeal builds short view of the deal with reputation of its parties
+func (s *service) summarizeDeal(ctx context.Context, deal *DealDocumentDB, reputations map[string]*pb.PartyReputation) (*pb.DealSummary, error) {
+	pact, err := deal.getCurrentPact()
+	if err != nil {
+		return nil, err
+	}
+	dealStatus, err := deal.getStatus()
+	if err != nil {
+		return nil, err
+	}
+	summary := &pb.DealSummary{
+		DealId:         deal.ID.Hex(),
+		Type:           deal.Type,
+		Status:         dealStatus,
+		ContentPreview: previewContent(pact.Content),
+		Deadline:       pact.Timeout,
+		Judges:         int64(len(deal.Judge.Participants)),
+		JusticeCount:   int64(deal.JusticeCount),
+	}
+	switch deal.Type {
+	case "BLAME":
+		summary.PanelOpen = !deal.Completed
+	default:
+		summary.PanelOpen = dealStatus == "ACCEPTED_BY_USERS"
+	}
+	for _, p := range pact.Red.Participants {
+		rep, err := s.getReputation(ctx, p.ID, reputations)
+		if err != nil {
+			return nil, err
+		}
+		summary.Red = append(summary.Red, rep)
+	}
+	// Blue side of the blame contains blamed deal instead of users
+	if deal.Type != "BLAME" {
+		for _, p := range pact.Blue.Participants {
+			rep, err := s.getReputation(ctx, p.ID, reputations)
+			if err != nil {
+				return nil, err
+			}
+			summary.Blue = append(summary.Blue, rep)
+		}
+	}
+	return summary, nil
+}
+
+func (s *service) getReputation(ctx context.Context, userID string, reputations map[string]*pb.PartyReputation) (*pb.PartyReputation, error) {
+	if rep, ok := reputations[userID]; ok {
+		return rep, nil
+	}
+	rep := &pb.PartyReputation{UserId: userID}
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get participant %s, err: %v", userID, err)
+	}
+	// User could be deleted, show what we know
+	if user != nil {
+		success, err := user.getSuccess(ctx, s.deals)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to count participant %s success, err: %v", userID, err)
+		}
+		rep.Username = user.Username
+		rep.Success = int64(success)
+	}
+	reputations[userID] = rep
+	return rep, nil
+}
+
+func isDealJudge(deal *DealDocumentDB, judgeID string) bool {
+	for _, j := range deal.Judge.Participants {
+		if j.ID == judgeID {
+			return true
+		}
+	}
+	return false
+}
+
+func previewContent(content string) string {
+	runes := []rune(content)
+	if len(runes) <= contentPreviewLen {
+		return content
+	}
+	return string(runes[:contentPreviewLen]) + "..."
+}
+
+// sortByDeadline sorts deals by the closest deadline, deals without deadline go last
+func sortByDeadline(deals []*pb.DealSummary) {
+	sort.SliceStable(deals, func(i, j int) bool {
+		iDeadline, iErr := time.Parse(timeoutLayout, deals[i].Deadline)
+		jDeadline, jErr := time.Parse(timeoutLayout, deals[j].Deadline)
+		if iErr != nil {
+			return false
+		}
+		if jErr != nil {
+			return true
+		}
+		return iDeadline.Before(jDeadline)
+	})
+}
+
+// pageBounds returns how many elements to skip and take for {page} (starts from 1)
+func pageBounds(page, pageSize int) (int, int) {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	if page <= 0 {
+		page = 1
+	}
+	return (page - 1) * pageSize, pageSize
+}
+
+// paginate returns bounds of {page} (starts from 1) in the list of {total} elements
+func paginate(total, page, pageSize int) (int, int) {
+	start, pageSize := pageBounds(page, pageSize)
+	if start > total {
+		start = total
+	}
+	end := start + pageSize
+	if end > total {
+		end = total
+	}
+	return start, end
+}
@@ -0,0 +1,238 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DenysNahurnyi/deal/common/clock"
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+)
+
+func newTestService(deals DealRepo) *service {
+	clk := clock.NewFake(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC))
+	return NewServiceWithRepos(NewMemUserRepo(), deals, nil, nil, nil, clk).(*service)
+}
+
+// newTestBlame returns blame of deal {blamedID} that is already decided
+func newTestBlame(blamedID string) DealDocumentDB {
+	pact := PactDB{
+		Content: "Reason",
+		Version: "initial(#1)",
+		Red:     SideDB{Type: pb.SideType_RED, Participants: []ParticipantDB{{ID: "judge", Accepted: true}}},
+		Blue:    SideDB{Type: pb.SideType_BLUE, Participants: []ParticipantDB{{ID: blamedID, Accepted: true}}},
+	}
+	return DealDocumentDB{
+		Type:         "BLAME",
+		Pacts:        []PactDB{pact},
+		FinalVersion: pact.Version,
+		Winner:       "red",
+		Blamed:       "No",
+	}
+}
+
+func TestBlameDealReversesCommonDeal(t *testing.T) {
+	ctx := context.Background()
+	deals := NewMemDealRepo()
+	s := newTestService(deals)
+	deal := newTestDeal("red", "blue")
+	deal.Winner = "red"
+	deal.Blamed = "No"
+	dealID, _ := deals.Create(ctx, deal)
+
+	if err := s.blameDeal(ctx, dealID, "blame1", 3); err != nil {
+		t.Fatalf("Failed to blame deal: %v", err)
+	}
+	blamed, _ := deals.GetByID(ctx, dealID)
+	if blamed.Blamed != "Yes" || blamed.effectiveWinner() != "blue" {
+		t.Fatalf("Deal is blamed %q with winner %s, want Yes and blue", blamed.Blamed, blamed.effectiveWinner())
+	}
+	if blamed.BlameID != "blame1" || blamed.JusticeCount != 3 {
+		t.Fatalf("Blame %q with justice %d is not recorded", blamed.BlameID, blamed.JusticeCount)
+	}
+	// Blaming it again reverses it back
+	if err := s.blameDeal(ctx, dealID, "blame2", 5); err != nil {
+		t.Fatalf("Failed to blame deal again: %v", err)
+	}
+	blamed, _ = deals.GetByID(ctx, dealID)
+	if blamed.Blamed != "No" || blamed.effectiveWinner() != "red" || blamed.BlameID != "blame2" {
+		t.Fatalf("Deal is blamed %q with winner %s by %s, want No, red and blame2", blamed.Blamed, blamed.effectiveWinner(), blamed.BlameID)
+	}
+}
+
+func TestBlameDealFollowsBlameChain(t *testing.T) {
+	ctx := context.Background()
+	deals := NewMemDealRepo()
+	s := newTestService(deals)
+	deal := newTestDeal("red", "blue")
+	deal.Winner = "blue"
+	deal.Blamed = "Yes"
+	dealID, _ := deals.Create(ctx, deal)
+	firstBlameID, _ := deals.Create(ctx, newTestBlame(dealID))
+	// Blame of the blame reverses the first blame and the deal under it
+	if err := s.blameDeal(ctx, firstBlameID, "blame2", 7); err != nil {
+		t.Fatalf("Failed to blame the blame: %v", err)
+	}
+	firstBlame, _ := deals.GetByID(ctx, firstBlameID)
+	if firstBlame.Blamed != "Yes" {
+		t.Fatalf("Blame is blamed %q, want Yes", firstBlame.Blamed)
+	}
+	blamed, _ := deals.GetByID(ctx, dealID)
+	if blamed.Blamed != "No" || blamed.effectiveWinner() != "blue" || blamed.BlameID != "blame2" || blamed.JusticeCount != 7 {
+		t.Fatalf("Deal under the blame is %+v, want unblamed with blue winner by blame2", blamed)
+	}
+}
+
+func TestBlameDealFailures(t *testing.T) {
+	ctx := context.Background()
+	deals := NewMemDealRepo()
+	s := newTestService(deals)
+	if err := s.blameDeal(ctx, primitive.NewObjectID().Hex(), "blame", 1); err == nil {
+		t.Fatalf("Unknown deal was blamed")
+	}
+	deal := newTestDeal("red", "blue")
+	deal.Type = "UNKNOWN"
+	dealID, _ := deals.Create(ctx, deal)
+	if err := s.blameDeal(ctx, dealID, "blame", 1); err == nil {
+		t.Fatalf("Deal of unknown type was blamed")
+	}
+	// Blame chain that points to missing deal is corrupted
+	blameID, _ := deals.Create(ctx, newTestBlame(primitive.NewObjectID().Hex()))
+	if err := s.blameDeal(ctx, blameID, "blame", 1); err == nil {
+		t.Fatalf("Broken blame chain was blamed")
+	}
+}
+
+func TestServiceWithReposRejectsFeaturesWithoutTables(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(NewMemDealRepo())
+	dealID := primitive.NewObjectID().Hex()
+	calls := map[string]func() error{
+		"GetWallet": func() error {
+			_, _, err := s.GetWallet(ctx, "user", 0, 0)
+			return err
+		},
+		"PostComment": func() error {
+			_, err := s.PostComment(ctx, "user", dealID, "", "Hi", pb.CommentVisibility_ALL)
+			return err
+		},
+		"UploadEvidence": func() error {
+			_, err := s.UploadEvidence(ctx, "user", dealID, "photo", "", pb.EvidenceVisibility_DEAL, []byte("data"))
+			return err
+		},
+		"ListNotifications": func() error {
+			_, _, err := s.ListNotifications(ctx, "user", false, 0, 0)
+			return err
+		},
+		"RegisterWebhook": func() error {
+			_, err := s.RegisterWebhook(ctx, "user", "https://example.com/hook", nil)
+			return err
+		},
+		"GetDealHistory": func() error {
+			_, _, err := s.GetDealHistory(ctx, "user", dealID)
+			return err
+		},
+	}
+	for name, call := range calls {
+		err := call()
+		if e := dealerrors.Parse(err); e == nil || e.Code != dealerrors.UNIMPLEMENTED {
+			t.Fatalf("%s failed with %v, want UNIMPLEMENTED", name, err)
+		}
+	}
+}
+
+func TestDealTimeoutIgnoresCancelledDeal(t *testing.T) {
+	ctx := context.Background()
+	deals := NewMemDealRepo()
+	s := newTestService(deals)
+	deal := newTestDeal("red", "blue")
+	deal.Status = append(deal.Status, Status{Name: "CANCELLED", Time: time.Date(2019, 1, 1, 1, 0, 0, 0, time.UTC)})
+	dealID, _ := deals.Create(ctx, deal)
+	// Timer of cancelled deal is left if watcher wasn't told to stop watching it
+	if err := s.DealTimeout(ctx, dealID); err != nil {
+		t.Fatalf("Timeout of cancelled deal failed: %v", err)
+	}
+	cancelled, _ := deals.GetByID(ctx, dealID)
+	if status, _ := cancelled.getStatus(); status != "CANCELLED" || cancelled.Completed {
+		t.Fatalf("Cancelled deal got status %s and completed %v on timeout", status, cancelled.Completed)
+	}
+}
+
+func TestInTransactionRunsHooksAfterCommit(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(NewMemDealRepo())
+	calls := []string{}
+	err := s.inTransaction(ctx, func(sc context.Context) error {
+		afterCommit(sc, func(context.Context) error {
+			calls = append(calls, "outer")
+			return nil
+		})
+		// Nested transaction joins the outer one, so its hooks wait for the outer commit too
+		return s.inTransaction(sc, func(sc context.Context) error {
+			afterCommit(sc, func(context.Context) error {
+				calls = append(calls, "nested")
+				return nil
+			})
+			calls = append(calls, "change")
+			return nil
+		})
+	})
+	if err != nil || strings.Join(calls, ",") != "change,outer,nested" {
+		t.Fatalf("Got calls %v and %v, want hooks after the change", calls, err)
+	}
+
+	calls = nil
+	err = s.inTransaction(ctx, func(sc context.Context) error {
+		afterCommit(sc, func(context.Context) error {
+			calls = append(calls, "hook")
+			return nil
+		})
+		return dealerrors.New(dealerrors.INTERNAL, "failed")
+	})
+	if err == nil || len(calls) != 0 {
+		t.Fatalf("Hooks %v ran for failed change", calls)
+	}
+	// Without transaction hook runs right away
+	if err := afterCommit(ctx, func(context.Context) error { return dealerrors.New(dealerrors.INTERNAL, "hook") }); err == nil {
+		t.Fatalf("Error of the hook is lost")
+	}
+}
+
+func TestRevokeOfferFreesSlot(t *testing.T) {
+	ctx := context.Background()
+	deals := NewMemDealRepo()
+	s := newTestService(deals)
+	creatorID, _ := s.users.Create(ctx, UserDB{Username: "creator"})
+	bobID, _ := s.users.Create(ctx, UserDB{Username: "bob"})
+	dealID, _ := deals.Create(ctx, newTestDeal(creatorID, bobID))
+	bob, _ := s.users.GetByID(ctx, bobID)
+	bob.Offerings = []string{dealID}
+	s.users.Update(ctx, bobID, bob)
+
+	err := s.RevokeOffer(ctx, bobID, dealID, "bob")
+	if e := dealerrors.Parse(err); e == nil || e.Code != dealerrors.NOT_CREATOR {
+		t.Fatalf("Revoke by invitee failed with %v, want NOT_CREATOR", err)
+	}
+	if err := s.RevokeOffer(ctx, creatorID, dealID, "bob"); err != nil {
+		t.Fatalf("Failed to revoke offer: %v", err)
+	}
+	bob, _ = s.users.GetByID(ctx, bobID)
+	dealDoc, _ := deals.GetByID(ctx, dealID)
+	pact, _ := dealDoc.getCurrentPact()
+	if len(bob.Offerings) != 0 || len(pact.Blue.Participants) != 0 || pact.Blue.isFull() {
+		t.Fatalf("Offer is left after revoke: offerings %v, blue side %+v", bob.Offerings, pact.Blue)
+	}
+	err = s.RevokeOffer(ctx, creatorID, dealID, "bob")
+	if e := dealerrors.Parse(err); e == nil || e.Code != dealerrors.NO_OFFER {
+		t.Fatalf("Second revoke failed with %v, want NO_OFFER", err)
+	}
+}
@@ -8,8 +8,11 @@ package dataSvc
 
 import (
 	"context"
+	"time"
 
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
 	grpcutils "github.com/DenysNahurnyi/deal/common/grpc"
+	"github.com/DenysNahurnyi/deal/common/logging"
 	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
 	"github.com/go-kit/kit/log"
 	grpctransport "github.com/go-kit/kit/transport/grpc"
@@ -18,7 +21,6 @@ import (
 type grpcServer struct {
 	createUser              grpctransport.Handler
 	getUser                 grpctransport.Handler
-	readiness               grpctransport.Handler
 	deleteUser              grpctransport.Handler
 	updateUser              grpctransport.Handler
 	existenceCheck          grpctransport.Handler
@@ -32,6 +34,34 @@ type grpcServer struct {
 	createBlameDocument     grpctransport.Handler
 	joinBlame               grpctransport.Handler
 	activateBlame           grpctransport.Handler
+	getJudgeQueue           grpctransport.Handler
+	getPublicProfile        grpctransport.Handler
+	searchUsers             grpctransport.Handler
+	declineOffer            grpctransport.Handler
+	revokeOffer             grpctransport.Handler
+	withdrawFromDeal        grpctransport.Handler
+	cancelDeal              grpctransport.Handler
+	getWallet               grpctransport.Handler
+	uploadEvidence          grpctransport.Handler
+	listEvidence            grpctransport.Handler
+	downloadEvidence        grpctransport.Handler
+	postComment             grpctransport.Handler
+	editComment             grpctransport.Handler
+	flagComment             grpctransport.Handler
+	listComments            grpctransport.Handler
+	registerWebhook         grpctransport.Handler
+	listWebhooks            grpctransport.Handler
+	deleteWebhook           grpctransport.Handler
+	replayWebhook           grpctransport.Handler
+	listWebhookDeliveries   grpctransport.Handler
+	listNotifications       grpctransport.Handler
+	markRead                grpctransport.Handler
+	getUnreadCount          grpctransport.Handler
+	getDealHistory          grpctransport.Handler
+	verifyDealIntegrity     grpctransport.Handler
+	// Streams are not supported by go-kit transport, they call service directly
+	svc          Service
+	streamBefore []grpctransport.ServerRequestFunc
 }
 
 func NewGRPCServer(svc Service, logger log.Logger) pb.DataServiceServer {
@@ -51,7 +81,7 @@ func NewGRPCServer(svc Service, logger log.Logger) pb.DataServiceServer {
 			append(options, grpctransport.ServerBefore(
 				grpcutils.ParseCookies(),
 				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
-				grpcutils.VerifyToken(svc.GetPubKey())))...,
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
 		),
 		deleteUser: grpctransport.NewServer(
 			makeDeleteUserEndpoint(svc),
@@ -60,7 +90,7 @@ func NewGRPCServer(svc Service, logger log.Logger) pb.DataServiceServer {
 			append(options, grpctransport.ServerBefore(
 				grpcutils.ParseCookies(),
 				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
-				grpcutils.VerifyToken(svc.GetPubKey())))...,
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
 		),
 		updateUser: grpctransport.NewServer(
 			makeUpdateUserEndpoint(svc),
@@ -69,7 +99,7 @@ func NewGRPCServer(svc Service, logger log.Logger) pb.DataServiceServer {
 			append(options, grpctransport.ServerBefore(
 				grpcutils.ParseCookies(),
 				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
-				grpcutils.VerifyToken(svc.GetPubKey())))...,
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
 		),
 		existenceCheck: grpctransport.NewServer(
 			makeExistenceCheckEndpoint(svc),
@@ -77,22 +107,22 @@ func NewGRPCServer(svc Service, logger log.Logger) pb.DataServiceServer {
 			encodeEmptyResp,
 			options...),
 		createDealDocument: grpctransport.NewServer(
-			makeCreateDealDocumentEndpoint(svc),
+			auditEndpoint(svc, "CreateDealDocument", makeCreateDealDocumentEndpoint(svc)),
 			decodeCreateDealDocumentReq,
 			encodeCreateDealDocumentResp,
 			append(options, grpctransport.ServerBefore(
 				grpcutils.ParseCookies(),
 				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
-				grpcutils.VerifyToken(svc.GetPubKey())))...,
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
 		),
 		offerDealDocument: grpctransport.NewServer(
-			makeOfferDealDocumentEndpoint(svc),
+			auditEndpoint(svc, "OfferDealDocument", makeOfferDealDocumentEndpoint(svc)),
 			decodeOfferDealDocumentReq,
 			encodeOfferDealDocumentResp,
 			append(options, grpctransport.ServerBefore(
 				grpcutils.ParseCookies(),
 				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
-				grpcutils.VerifyToken(svc.GetPubKey())))...,
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
 		),
 		getDealDocument: grpctransport.NewServer(
 			makeGetDealDocumentEndpoint(svc),
@@ -101,69 +131,315 @@ func NewGRPCServer(svc Service, logger log.Logger) pb.DataServiceServer {
 			append(options, grpctransport.ServerBefore(
 				grpcutils.ParseCookies(),
 				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
-				grpcutils.VerifyToken(svc.GetPubKey())))...,
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
 		),
 		acceptDealDocument: grpctransport.NewServer(
-			makeAcceptDealDocumentEndpoint(svc),
+			auditEndpoint(svc, "AcceptDealDocument", makeAcceptDealDocumentEndpoint(svc)),
 			decodeAcceptDealDocumentReq,
 			encodeAcceptDealDocumentResp,
 			append(options, grpctransport.ServerBefore(
 				grpcutils.ParseCookies(),
 				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
-				grpcutils.VerifyToken(svc.GetPubKey())))...,
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
 		),
 		judgeAcceptDealDocument: grpctransport.NewServer(
-			makeJudgeAcceptDealDocumentEndpoint(svc),
+			auditEndpoint(svc, "JudgeAcceptDealDocument", makeJudgeAcceptDealDocumentEndpoint(svc)),
 			decodeJudgeAcceptDealDocumentReq,
 			encodeJudgeAcceptDealDocumentResp,
 			append(options, grpctransport.ServerBefore(
 				grpcutils.ParseCookies(),
 				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
-				grpcutils.VerifyToken(svc.GetPubKey())))...,
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
 		),
 		dealTimeout: grpctransport.NewServer(
-			makeDealTimeoutEndpoint(svc),
+			auditEndpoint(svc, "DealTimeout", makeDealTimeoutEndpoint(svc)),
 			decodeDealTimeoutReq,
 			encodeDealTimeoutResp,
 			options...,
 		),
 		judgeDecide: grpctransport.NewServer(
-			makeJudgeDecideEndpoint(svc),
+			auditEndpoint(svc, "JudgeDecide", makeJudgeDecideEndpoint(svc)),
 			decodeJudgeDecideReq,
 			encodeJudgeDecideResp,
 			append(options, grpctransport.ServerBefore(
 				grpcutils.ParseCookies(),
 				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
-				grpcutils.VerifyToken(svc.GetPubKey())))...,
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
 		),
 		createBlameDocument: grpctransport.NewServer(
-			makeCreateBlameDocumentEndpoint(svc),
+			auditEndpoint(svc, "CreateBlameDocument", makeCreateBlameDocumentEndpoint(svc)),
 			decodeCreateBlameDocumentReq,
 			encodeCreateBlameDocumentResp,
 			append(options, grpctransport.ServerBefore(
 				grpcutils.ParseCookies(),
 				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
-				grpcutils.VerifyToken(svc.GetPubKey())))...,
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
 		),
 		joinBlame: grpctransport.NewServer(
-			makeJoinBlameEndpoint(svc),
+			auditEndpoint(svc, "JoinBlame", makeJoinBlameEndpoint(svc)),
 			decodeJoinBlameReq,
 			encodeJoinBlameResp,
 			append(options, grpctransport.ServerBefore(
 				grpcutils.ParseCookies(),
 				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
-				grpcutils.VerifyToken(svc.GetPubKey())))...,
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
 		),
 		activateBlame: grpctransport.NewServer(
-			makeActivateBlameEndpoint(svc),
+			auditEndpoint(svc, "ActivateBlame", makeActivateBlameEndpoint(svc)),
 			decodeActivateBlameReq,
 			encodeActivateBlameResp,
 			append(options, grpctransport.ServerBefore(
 				grpcutils.ParseCookies(),
 				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
-				grpcutils.VerifyToken(svc.GetPubKey())))...,
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
 		),
+		getJudgeQueue: grpctransport.NewServer(
+			makeGetJudgeQueueEndpoint(svc),
+			decodeGetJudgeQueueReq,
+			encodeGetJudgeQueueResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		getPublicProfile: grpctransport.NewServer(
+			makeGetPublicProfileEndpoint(svc),
+			decodeGetPublicProfileReq,
+			encodeGetPublicProfileResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		searchUsers: grpctransport.NewServer(
+			makeSearchUsersEndpoint(svc),
+			decodeSearchUsersReq,
+			encodeSearchUsersResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		declineOffer: grpctransport.NewServer(
+			auditEndpoint(svc, "DeclineOffer", makeDeclineOfferEndpoint(svc)),
+			decodeDeclineOfferReq,
+			encodeDeclineOfferResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		revokeOffer: grpctransport.NewServer(
+			auditEndpoint(svc, "RevokeOffer", makeRevokeOfferEndpoint(svc)),
+			decodeRevokeOfferReq,
+			encodeRevokeOfferResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		withdrawFromDeal: grpctransport.NewServer(
+			auditEndpoint(svc, "WithdrawFromDeal", makeWithdrawFromDealEndpoint(svc)),
+			decodeWithdrawFromDealReq,
+			encodeWithdrawFromDealResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		cancelDeal: grpctransport.NewServer(
+			auditEndpoint(svc, "CancelDeal", makeCancelDealEndpoint(svc)),
+			decodeCancelDealReq,
+			encodeCancelDealResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		getWallet: grpctransport.NewServer(
+			makeGetWalletEndpoint(svc),
+			decodeGetWalletReq,
+			encodeGetWalletResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		uploadEvidence: grpctransport.NewServer(
+			makeUploadEvidenceEndpoint(svc),
+			decodeUploadEvidenceReq,
+			encodeUploadEvidenceResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		listEvidence: grpctransport.NewServer(
+			makeListEvidenceEndpoint(svc),
+			decodeListEvidenceReq,
+			encodeListEvidenceResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		downloadEvidence: grpctransport.NewServer(
+			makeDownloadEvidenceEndpoint(svc),
+			decodeDownloadEvidenceReq,
+			encodeDownloadEvidenceResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		postComment: grpctransport.NewServer(
+			makePostCommentEndpoint(svc),
+			decodePostCommentReq,
+			encodePostCommentResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		editComment: grpctransport.NewServer(
+			makeEditCommentEndpoint(svc),
+			decodeEditCommentReq,
+			encodeEditCommentResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		flagComment: grpctransport.NewServer(
+			makeFlagCommentEndpoint(svc),
+			decodeFlagCommentReq,
+			encodeFlagCommentResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		listComments: grpctransport.NewServer(
+			makeListCommentsEndpoint(svc),
+			decodeListCommentsReq,
+			encodeListCommentsResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		registerWebhook: grpctransport.NewServer(
+			makeRegisterWebhookEndpoint(svc),
+			decodeRegisterWebhookReq,
+			encodeRegisterWebhookResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		listWebhooks: grpctransport.NewServer(
+			makeListWebhooksEndpoint(svc),
+			decodeListWebhooksReq,
+			encodeListWebhooksResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		deleteWebhook: grpctransport.NewServer(
+			makeDeleteWebhookEndpoint(svc),
+			decodeDeleteWebhookReq,
+			encodeDeleteWebhookResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		replayWebhook: grpctransport.NewServer(
+			makeReplayWebhookEndpoint(svc),
+			decodeReplayWebhookReq,
+			encodeReplayWebhookResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		listWebhookDeliveries: grpctransport.NewServer(
+			makeListWebhookDeliveriesEndpoint(svc),
+			decodeListWebhookDeliveriesReq,
+			encodeListWebhookDeliveriesResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		listNotifications: grpctransport.NewServer(
+			makeListNotificationsEndpoint(svc),
+			decodeListNotificationsReq,
+			encodeListNotificationsResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		markRead: grpctransport.NewServer(
+			makeMarkReadEndpoint(svc),
+			decodeMarkReadReq,
+			encodeMarkReadResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		getUnreadCount: grpctransport.NewServer(
+			makeGetUnreadCountEndpoint(svc),
+			decodeGetUnreadCountReq,
+			encodeGetUnreadCountResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		getDealHistory: grpctransport.NewServer(
+			makeGetDealHistoryEndpoint(svc),
+			decodeGetDealHistoryReq,
+			encodeGetDealHistoryResp,
+			append(options, grpctransport.ServerBefore(
+				grpcutils.ParseCookies(),
+				grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+				grpcutils.VerifyTokenWith(svc.GetPubKey)))...,
+		),
+		verifyDealIntegrity: grpctransport.NewServer(
+			makeVerifyDealIntegrityEndpoint(svc),
+			decodeVerifyDealIntegrityReq,
+			encodeVerifyDealIntegrityResp,
+			options...,
+		),
+		svc: svc,
+		streamBefore: []grpctransport.ServerRequestFunc{
+			grpcutils.ParseCookies(),
+			grpcutils.ParseHeader(grpcutils.GRPCAUTHORIZATIONHEADER),
+			grpcutils.VerifyTokenWith(svc.GetPubKey),
+		},
+	}
+}
+
+func (s *grpcServer) GetJudgeQueue(ctx context.Context, req *pb.GetJudgeQueueReq) (*pb.GetJudgeQueueResp, error) {
+	_, resp, err := s.getJudgeQueue.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
 	}
+	return resp.(*pb.GetJudgeQueueResp), nil
+}
+
+func decodeGetJudgeQueueReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.GetJudgeQueueReq)
+	return req, nil
+}
+
+func encodeGetJudgeQueueResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.GetJudgeQueueResp)
+	return &resp, nil
 }
 
 func (s *grpcServer) ActivateBlame(ctx context.Context, req *pb.ActivateBlameReq) (*pb.ActivateBlameResp, error) {
@@ -435,3 +711,462 @@ func encodeGetUserResp(_ context.Context, response interface{}) (interface{}, er
 	resp := response.(pb.GetUserResp)
 	return &resp, nil
 }
+
+func (s *grpcServer) GetPublicProfile(ctx context.Context, req *pb.GetPublicProfileReq) (*pb.GetPublicProfileResp, error) {
+	_, resp, err := s.getPublicProfile.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.GetPublicProfileResp), nil
+}
+
+func decodeGetPublicProfileReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.GetPublicProfileReq)
+	return req, nil
+}
+
+func encodeGetPublicProfileResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.GetPublicProfileResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) SearchUsers(ctx context.Context, req *pb.SearchUsersReq) (*pb.SearchUsersResp, error) {
+	_, resp, err := s.searchUsers.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.SearchUsersResp), nil
+}
+
+func decodeSearchUsersReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.SearchUsersReq)
+	return req, nil
+}
+
+func encodeSearchUsersResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.SearchUsersResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) DeclineOffer(ctx context.Context, req *pb.DeclineOfferReq) (*pb.DeclineOfferResp, error) {
+	_, resp, err := s.declineOffer.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.DeclineOfferResp), nil
+}
+
+func decodeDeclineOfferReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.DeclineOfferReq)
+	return req, nil
+}
+
+func encodeDeclineOfferResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.DeclineOfferResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) RevokeOffer(ctx context.Context, req *pb.RevokeOfferReq) (*pb.RevokeOfferResp, error) {
+	_, resp, err := s.revokeOffer.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.RevokeOfferResp), nil
+}
+
+func decodeRevokeOfferReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.RevokeOfferReq)
+	return req, nil
+}
+
+func encodeRevokeOfferResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.RevokeOfferResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) WithdrawFromDeal(ctx context.Context, req *pb.WithdrawFromDealReq) (*pb.WithdrawFromDealResp, error) {
+	_, resp, err := s.withdrawFromDeal.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.WithdrawFromDealResp), nil
+}
+
+func decodeWithdrawFromDealReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.WithdrawFromDealReq)
+	return req, nil
+}
+
+func encodeWithdrawFromDealResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.WithdrawFromDealResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) CancelDeal(ctx context.Context, req *pb.CancelDealReq) (*pb.CancelDealResp, error) {
+	_, resp, err := s.cancelDeal.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.CancelDealResp), nil
+}
+
+func decodeCancelDealReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.CancelDealReq)
+	return req, nil
+}
+
+func encodeCancelDealResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.CancelDealResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) GetWallet(ctx context.Context, req *pb.GetWalletReq) (*pb.GetWalletResp, error) {
+	_, resp, err := s.getWallet.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.GetWalletResp), nil
+}
+
+func decodeGetWalletReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.GetWalletReq)
+	return req, nil
+}
+
+func encodeGetWalletResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.GetWalletResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) UploadEvidence(ctx context.Context, req *pb.UploadEvidenceReq) (*pb.UploadEvidenceResp, error) {
+	_, resp, err := s.uploadEvidence.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.UploadEvidenceResp), nil
+}
+
+func decodeUploadEvidenceReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.UploadEvidenceReq)
+	return req, nil
+}
+
+func encodeUploadEvidenceResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.UploadEvidenceResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) ListEvidence(ctx context.Context, req *pb.ListEvidenceReq) (*pb.ListEvidenceResp, error) {
+	_, resp, err := s.listEvidence.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.ListEvidenceResp), nil
+}
+
+func decodeListEvidenceReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ListEvidenceReq)
+	return req, nil
+}
+
+func encodeListEvidenceResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.ListEvidenceResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) DownloadEvidence(ctx context.Context, req *pb.DownloadEvidenceReq) (*pb.DownloadEvidenceResp, error) {
+	_, resp, err := s.downloadEvidence.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.DownloadEvidenceResp), nil
+}
+
+func decodeDownloadEvidenceReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.DownloadEvidenceReq)
+	return req, nil
+}
+
+func encodeDownloadEvidenceResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.DownloadEvidenceResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) PostComment(ctx context.Context, req *pb.PostCommentReq) (*pb.PostCommentResp, error) {
+	_, resp, err := s.postComment.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.PostCommentResp), nil
+}
+
+func decodePostCommentReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.PostCommentReq)
+	return req, nil
+}
+
+func encodePostCommentResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.PostCommentResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) EditComment(ctx context.Context, req *pb.EditCommentReq) (*pb.EditCommentResp, error) {
+	_, resp, err := s.editComment.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.EditCommentResp), nil
+}
+
+func decodeEditCommentReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.EditCommentReq)
+	return req, nil
+}
+
+func encodeEditCommentResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.EditCommentResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) FlagComment(ctx context.Context, req *pb.FlagCommentReq) (*pb.FlagCommentResp, error) {
+	_, resp, err := s.flagComment.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.FlagCommentResp), nil
+}
+
+func decodeFlagCommentReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.FlagCommentReq)
+	return req, nil
+}
+
+func encodeFlagCommentResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.FlagCommentResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) ListComments(ctx context.Context, req *pb.ListCommentsReq) (*pb.ListCommentsResp, error) {
+	_, resp, err := s.listComments.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.ListCommentsResp), nil
+}
+
+func decodeListCommentsReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ListCommentsReq)
+	return req, nil
+}
+
+func encodeListCommentsResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.ListCommentsResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) StreamComments(req *pb.StreamCommentsReq, stream pb.DataService_StreamCommentsServer) error {
+	ctx := grpcutils.StreamContext(stream.Context(), s.streamBefore...)
+	userID, err := grpcutils.GetUserIDFromJWT(ctx)
+	if err != nil {
+		logging.Error(ctx, "Failed to get user id from token", "err", err)
+		return err
+	}
+	var since time.Time
+	if len(req.GetSince()) > 0 {
+		since, err = time.Parse(timeoutLayout, req.GetSince())
+		if err != nil {
+			return dealerrors.New(dealerrors.INVALID_ARGUMENT, "Since must be in format %s", timeoutLayout)
+		}
+	}
+	return s.svc.StreamComments(ctx, userID, req.GetDealId(), since, stream.Send)
+}
+
+func (s *grpcServer) WatchEvents(req *pb.WatchEventsReq, stream pb.DataService_WatchEventsServer) error {
+	ctx := grpcutils.StreamContext(stream.Context(), s.streamBefore...)
+	userID, err := grpcutils.GetUserIDFromJWT(ctx)
+	if err != nil {
+		logging.Error(ctx, "Failed to get user id from token", "err", err)
+		return err
+	}
+	return s.svc.WatchEvents(ctx, userID, req.GetResumeToken(), req.GetDealId(), req.GetTypes(), stream.Send)
+}
+
+func (s *grpcServer) RegisterWebhook(ctx context.Context, req *pb.RegisterWebhookReq) (*pb.RegisterWebhookResp, error) {
+	_, resp, err := s.registerWebhook.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.RegisterWebhookResp), nil
+}
+
+func decodeRegisterWebhookReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.RegisterWebhookReq)
+	return req, nil
+}
+
+func encodeRegisterWebhookResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.RegisterWebhookResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) ListWebhooks(ctx context.Context, req *pb.ListWebhooksReq) (*pb.ListWebhooksResp, error) {
+	_, resp, err := s.listWebhooks.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.ListWebhooksResp), nil
+}
+
+func decodeListWebhooksReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ListWebhooksReq)
+	return req, nil
+}
+
+func encodeListWebhooksResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.ListWebhooksResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) DeleteWebhook(ctx context.Context, req *pb.DeleteWebhookReq) (*pb.DeleteWebhookResp, error) {
+	_, resp, err := s.deleteWebhook.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.DeleteWebhookResp), nil
+}
+
+func decodeDeleteWebhookReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.DeleteWebhookReq)
+	return req, nil
+}
+
+func encodeDeleteWebhookResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.DeleteWebhookResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) ReplayWebhook(ctx context.Context, req *pb.ReplayWebhookReq) (*pb.ReplayWebhookResp, error) {
+	_, resp, err := s.replayWebhook.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.ReplayWebhookResp), nil
+}
+
+func decodeReplayWebhookReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ReplayWebhookReq)
+	return req, nil
+}
+
+func encodeReplayWebhookResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.ReplayWebhookResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) ListWebhookDeliveries(ctx context.Context, req *pb.ListWebhookDeliveriesReq) (*pb.ListWebhookDeliveriesResp, error) {
+	_, resp, err := s.listWebhookDeliveries.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.ListWebhookDeliveriesResp), nil
+}
+
+func decodeListWebhookDeliveriesReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ListWebhookDeliveriesReq)
+	return req, nil
+}
+
+func encodeListWebhookDeliveriesResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.ListWebhookDeliveriesResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) ListNotifications(ctx context.Context, req *pb.ListNotificationsReq) (*pb.ListNotificationsResp, error) {
+	_, resp, err := s.listNotifications.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.ListNotificationsResp), nil
+}
+
+func decodeListNotificationsReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ListNotificationsReq)
+	return req, nil
+}
+
+func encodeListNotificationsResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.ListNotificationsResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) MarkRead(ctx context.Context, req *pb.MarkReadReq) (*pb.MarkReadResp, error) {
+	_, resp, err := s.markRead.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.MarkReadResp), nil
+}
+
+func decodeMarkReadReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.MarkReadReq)
+	return req, nil
+}
+
+func encodeMarkReadResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.MarkReadResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) GetUnreadCount(ctx context.Context, req *pb.GetUnreadCountReq) (*pb.GetUnreadCountResp, error) {
+	_, resp, err := s.getUnreadCount.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.GetUnreadCountResp), nil
+}
+
+func decodeGetUnreadCountReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.GetUnreadCountReq)
+	return req, nil
+}
+
+func encodeGetUnreadCountResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.GetUnreadCountResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) GetDealHistory(ctx context.Context, req *pb.GetDealHistoryReq) (*pb.GetDealHistoryResp, error) {
+	_, resp, err := s.getDealHistory.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.GetDealHistoryResp), nil
+}
+
+func decodeGetDealHistoryReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.GetDealHistoryReq)
+	return req, nil
+}
+
+func encodeGetDealHistoryResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.GetDealHistoryResp)
+	return &resp, nil
+}
+
+func (s *grpcServer) VerifyDealIntegrity(ctx context.Context, req *pb.VerifyDealIntegrityReq) (*pb.VerifyDealIntegrityResp, error) {
+	_, resp, err := s.verifyDealIntegrity.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.VerifyDealIntegrityResp), nil
+}
+
+func decodeVerifyDealIntegrityReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.VerifyDealIntegrityReq)
+	return req, nil
+}
+
+func encodeVerifyDealIntegrityResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.VerifyDealIntegrityResp)
+	return &resp, nil
+}
@@ -0,0 +1,240 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/DenysNahurnyi/deal/common/validation"
+	"github.com/DenysNahurnyi/deal/pb/generated/pb"
+)
+
+// Validation rules of every request of dataSvc, they are checked by the server interceptor,
+// so endpoints and service get only valid requests
+func init() {
+	// Users
+	validation.Register(&pb.CreateUserReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.CreateUserReq)
+		v.Present("user", r.GetUser() != nil)
+		if r.GetUser() != nil {
+			v.Required("user.username", r.GetUser().GetUsername())
+		}
+	})
+	validation.Register(&pb.UpdateUserReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.UpdateUserReq)
+		v.Present("user", r.GetUser() != nil)
+		for i, kind := range r.GetUser().GetNotifications().GetMuted() {
+			v.OneOf(fmt.Sprintf("user.notifications.muted[%d]", i), kind, notificationKinds...)
+		}
+		// Empty email turns email notifications off
+		if email := r.GetUser().GetNotifications().GetEmail(); len(email) > 0 {
+			v.Email("user.notifications.email", email)
+		}
+	})
+	validation.Register(&pb.GetUserReq{})
+	validation.Register(&pb.DeleteUserReq{})
+	validation.Register(&pb.GetPublicProfileReq{}, func(req interface{}, v *validation.Violations) {
+		v.Required("username", req.(*pb.GetPublicProfileReq).GetUsername())
+	})
+	validation.Register(&pb.SearchUsersReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.SearchUsersReq)
+		v.Required("query", r.GetQuery())
+		v.Pagination(r.GetPage(), r.GetPageSize(), maxPageSize)
+	})
+
+	// Deals
+	validation.Register(&pb.CreateDealDocumentReq{}, func(req interface{}, v *validation.Violations) {
+		pact := req.(*pb.CreateDealDocumentReq).GetDealDocument()
+		v.Present("deal_document", pact != nil)
+		if pact == nil {
+			return
+		}
+		v.Required("deal_document.content", pact.GetContent())
+		v.Time("deal_document.timeout", pact.GetTimeout(), timeoutLayout)
+		// 0 means the side needs one member, like in deals created before multi-party sides
+		v.Range("deal_document.red.members", pact.GetRed().GetMembers(), 0, maxSideMembers)
+		v.Range("deal_document.blue.members", pact.GetBlue().GetMembers(), 0, maxSideMembers)
+		if pact.GetStake() < 0 {
+			v.Add("deal_document.stake", "Can't be negative")
+		}
+	})
+	validation.Register(&pb.GetDealDocumentReq{}, func(req interface{}, v *validation.Violations) {
+		v.ID("deal_document_id", req.(*pb.GetDealDocumentReq).GetDealDocumentId())
+	})
+	validation.Register(&pb.OfferDealDocumentReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.OfferDealDocumentReq)
+		v.ID("deal_doc_id", r.GetDealDocId())
+		v.Required("username", r.GetUsername())
+		// Judges take deals from the judge queue, offering deal to them isn't supported yet
+		if r.GetToJudge() {
+			v.Add("to_judge", "Deals can't be offered to judges, they take deals from the judge queue")
+		}
+	})
+	validation.Register(&pb.AcceptDealDocumentReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.AcceptDealDocumentReq)
+		v.ID("deal_doc_id", r.GetDealDocId())
+		// Judges accept deals with JudgeAcceptDealDocument
+		v.OneOf("side_type", r.GetSideType().String(), pb.SideType_RED.String(), pb.SideType_BLUE.String())
+	})
+	validation.Register(&pb.DeclineOfferReq{}, func(req interface{}, v *validation.Violations) {
+		v.ID("deal_doc_id", req.(*pb.DeclineOfferReq).GetDealDocId())
+	})
+	validation.Register(&pb.RevokeOfferReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.RevokeOfferReq)
+		v.ID("deal_doc_id", r.GetDealDocId())
+		v.Required("username", r.GetUsername())
+	})
+	validation.Register(&pb.WithdrawFromDealReq{}, func(req interface{}, v *validation.Violations) {
+		v.ID("deal_doc_id", req.(*pb.WithdrawFromDealReq).GetDealDocId())
+	})
+	validation.Register(&pb.CancelDealReq{}, func(req interface{}, v *validation.Violations) {
+		v.ID("deal_doc_id", req.(*pb.CancelDealReq).GetDealDocId())
+	})
+	validation.Register(&pb.DealTimeoutReq{}, func(req interface{}, v *validation.Violations) {
+		v.ID("deal_document_id", req.(*pb.DealTimeoutReq).GetDealDocumentId())
+	})
+	validation.Register(&pb.GetDealHistoryReq{}, func(req interface{}, v *validation.Violations) {
+		v.ID("deal_id", req.(*pb.GetDealHistoryReq).GetDealId())
+	})
+	validation.Register(&pb.VerifyDealIntegrityReq{}, func(req interface{}, v *validation.Violations) {
+		v.ID("deal_id", req.(*pb.VerifyDealIntegrityReq).GetDealId())
+	})
+
+	// Judges and blames
+	validation.Register(&pb.JudgeAcceptDealDocumentReq{}, func(req interface{}, v *validation.Violations) {
+		v.ID("deal_doc_id", req.(*pb.JudgeAcceptDealDocumentReq).GetDealDocId())
+	})
+	validation.Register(&pb.JudgeDecideReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.JudgeDecideReq)
+		v.ID("deal_document_id", r.GetDealDocumentId())
+		// Empty winner means old client that sends red_won
+		if len(r.GetWinner()) > 0 {
+			v.OneOf("winner", r.GetWinner(), "red", "blue")
+		}
+	})
+	validation.Register(&pb.GetJudgeQueueReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.GetJudgeQueueReq)
+		v.Pagination(r.GetPage(), r.GetPageSize(), maxPageSize)
+	})
+	validation.Register(&pb.CreateBlameDocumentReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.CreateBlameDocumentReq)
+		v.ID("blamed_deal_id", r.GetBlamedDealId())
+		v.Required("blame_reson", r.GetBlameReson())
+	})
+	validation.Register(&pb.JoinBlameReq{}, func(req interface{}, v *validation.Violations) {
+		v.ID("blame_id", req.(*pb.JoinBlameReq).GetBlameId())
+	})
+	validation.Register(&pb.ActivateBlameReq{}, func(req interface{}, v *validation.Violations) {
+		v.ID("blame_id", req.(*pb.ActivateBlameReq).GetBlameId())
+	})
+
+	// Wallet
+	validation.Register(&pb.GetWalletReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.GetWalletReq)
+		v.Pagination(r.GetPage(), r.GetPageSize(), maxPageSize)
+	})
+
+	// Evidence
+	validation.Register(&pb.UploadEvidenceReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.UploadEvidenceReq)
+		v.ID("deal_id", r.GetDealId())
+		v.Length("name", r.GetName(), 1, maxEvidenceNameLen)
+		if len(r.GetData()) == 0 {
+			v.Add("data", "Mustn't be empty")
+		}
+		if len(r.GetData()) > maxEvidenceSize {
+			v.Add("data", "Can't be bigger than %d bytes", maxEvidenceSize)
+		}
+		v.Enum("visibility", int32(r.GetVisibility()), pb.EvidenceVisibility_name)
+	})
+	validation.Register(&pb.ListEvidenceReq{}, func(req interface{}, v *validation.Violations) {
+		v.ID("deal_id", req.(*pb.ListEvidenceReq).GetDealId())
+	})
+	validation.Register(&pb.DownloadEvidenceReq{}, func(req interface{}, v *validation.Violations) {
+		v.ID("evidence_id", req.(*pb.DownloadEvidenceReq).GetEvidenceId())
+	})
+
+	// Comments
+	validation.Register(&pb.PostCommentReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.PostCommentReq)
+		v.ID("deal_id", r.GetDealId())
+		if len(r.GetParentId()) > 0 {
+			v.ID("parent_id", r.GetParentId())
+		}
+		v.Length("body", r.GetBody(), 1, maxCommentLen)
+		v.Enum("visibility", int32(r.GetVisibility()), pb.CommentVisibility_name)
+	})
+	validation.Register(&pb.EditCommentReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.EditCommentReq)
+		v.ID("comment_id", r.GetCommentId())
+		v.Length("body", r.GetBody(), 1, maxCommentLen)
+	})
+	validation.Register(&pb.FlagCommentReq{}, func(req interface{}, v *validation.Violations) {
+		v.ID("comment_id", req.(*pb.FlagCommentReq).GetCommentId())
+	})
+	validation.Register(&pb.ListCommentsReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.ListCommentsReq)
+		v.ID("deal_id", r.GetDealId())
+		if len(r.GetThreadId()) > 0 {
+			v.ID("thread_id", r.GetThreadId())
+		}
+		v.Pagination(r.GetPage(), r.GetPageSize(), maxPageSize)
+	})
+	validation.Register(&pb.StreamCommentsReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.StreamCommentsReq)
+		v.ID("deal_id", r.GetDealId())
+		if len(r.GetSince()) > 0 {
+			v.Time("since", r.GetSince(), timeoutLayout)
+		}
+	})
+
+	// Events and webhooks
+	validation.Register(&pb.WatchEventsReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.WatchEventsReq)
+		if len(r.GetDealId()) > 0 {
+			v.ID("deal_id", r.GetDealId())
+		}
+	})
+	validation.Register(&pb.RegisterWebhookReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.RegisterWebhookReq)
+		// Host is resolved and checked by the service, validation doesn't do network calls
+		u, err := url.Parse(r.GetUrl())
+		if err != nil || u.Scheme != "https" || len(u.Hostname()) == 0 {
+			v.Add("url", "Must be absolute https url")
+		}
+		for i, t := range r.GetEventTypes() {
+			v.OneOf(fmt.Sprintf("event_types[%d]", i), t, webhookEventTypes...)
+		}
+	})
+	validation.Register(&pb.ListWebhooksReq{})
+	validation.Register(&pb.DeleteWebhookReq{}, func(req interface{}, v *validation.Violations) {
+		v.ID("webhook_id", req.(*pb.DeleteWebhookReq).GetWebhookId())
+	})
+	validation.Register(&pb.ReplayWebhookReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.ReplayWebhookReq)
+		v.ID("webhook_id", r.GetWebhookId())
+		v.Time("since", r.GetSince(), timeoutLayout)
+	})
+	validation.Register(&pb.ListWebhookDeliveriesReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.ListWebhookDeliveriesReq)
+		v.ID("webhook_id", r.GetWebhookId())
+		v.Pagination(r.GetPage(), r.GetPageSize(), maxPageSize)
+	})
+
+	// Notifications
+	validation.Register(&pb.ListNotificationsReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.ListNotificationsReq)
+		v.Pagination(r.GetPage(), r.GetPageSize(), maxPageSize)
+	})
+	validation.Register(&pb.MarkReadReq{}, func(req interface{}, v *validation.Violations) {
+		for i, id := range req.(*pb.MarkReadReq).GetNotificationIds() {
+			v.ID(fmt.Sprintf("notification_ids[%d]", i), id)
+		}
+	})
+	validation.Register(&pb.GetUnreadCountReq{})
+}
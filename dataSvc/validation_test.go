@@ -0,0 +1,97 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	"github.com/DenysNahurnyi/deal/common/validation"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+)
+
+// invalidFields returns sorted fields of violations of {err}, nil if {err} is nil
+func invalidFields(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	e := dealerrors.Parse(err)
+	if e == nil || e.Code != dealerrors.INVALID_ARGUMENT {
+		t.Fatalf("Validation failed with %v, want INVALID_ARGUMENT", err)
+	}
+	fields := []string{}
+	for _, v := range e.Violations {
+		fields = append(fields, v.GetField())
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestValidationRules(t *testing.T) {
+	hdr := &pb.ReqHdr{Tid: "tid"}
+	id := primitive.NewObjectID().Hex()
+	pact := func(content, timeout string, members, stake int64) *pb.Pact {
+		return &pb.Pact{Content: content, Timeout: timeout, Red: &pb.Side{Members: members}, Stake: stake}
+	}
+	tests := []struct {
+		name    string
+		req     interface{}
+		invalid []string
+	}{
+		{"decision with winner", &pb.JudgeDecideReq{ReqHdr: hdr, DealDocumentId: id, Winner: "blue"}, nil},
+		{"decision of old client", &pb.JudgeDecideReq{ReqHdr: hdr, DealDocumentId: id, RedWon: true}, nil},
+		{"decision with unknown winner", &pb.JudgeDecideReq{ReqHdr: hdr, DealDocumentId: id, Winner: "green"}, []string{"winner"}},
+		{"decision with winner in upper case", &pb.JudgeDecideReq{ReqHdr: hdr, DealDocumentId: id, Winner: "RED"}, []string{"winner"}},
+		{"decision without header and id", &pb.JudgeDecideReq{Winner: "red"}, []string{"deal_document_id", "req_hdr"}},
+		{"deal", &pb.CreateDealDocumentReq{ReqHdr: hdr, DealDocument: pact("Terms", "2019-01-02T00:00:00.000Z", 2, 10)}, nil},
+		{"deal without pact", &pb.CreateDealDocumentReq{ReqHdr: hdr}, []string{"deal_document"}},
+		{"deal with invalid pact", &pb.CreateDealDocumentReq{ReqHdr: hdr, DealDocument: pact("  ", "tomorrow", maxSideMembers+1, -1)},
+			[]string{"deal_document.content", "deal_document.red.members", "deal_document.stake", "deal_document.timeout"}},
+		{"acceptance by judge", &pb.AcceptDealDocumentReq{ReqHdr: hdr, DealDocId: id, SideType: pb.SideType_JUDGE}, []string{"side_type"}},
+		{"acceptance with invalid id", &pb.AcceptDealDocumentReq{ReqHdr: hdr, DealDocId: "42", SideType: pb.SideType_RED}, []string{"deal_doc_id"}},
+		{"offer to judge", &pb.OfferDealDocumentReq{ReqHdr: hdr, DealDocId: id, Username: "bob", ToJudge: true}, []string{"to_judge"}},
+		{"user with email", &pb.UpdateUserReq{ReqHdr: hdr, User: &pb.User{Notifications: &pb.NotificationSettings{Email: "bob@example.com"}}}, nil},
+		{"user with invalid email", &pb.UpdateUserReq{ReqHdr: hdr, User: &pb.User{Notifications: &pb.NotificationSettings{Email: "Bob <bob@example.com>"}}},
+			[]string{"user.notifications.email"}},
+		{"user muting unknown kind", &pb.UpdateUserReq{ReqHdr: hdr, User: &pb.User{Notifications: &pb.NotificationSettings{Muted: []string{"SPAM"}}}},
+			[]string{"user.notifications.muted[0]"}},
+		{"page too big", &pb.GetJudgeQueueReq{ReqHdr: hdr, PageSize: maxPageSize + 1}, []string{"page_size"}},
+	}
+	for _, tt := range tests {
+		got := invalidFields(t, validation.Validate(tt.req))
+		if strings.Join(got, ",") != strings.Join(tt.invalid, ",") {
+			t.Errorf("%s: invalid fields %v, want %v", tt.name, got, tt.invalid)
+		}
+	}
+}
+
+func TestSetDealWinnerRejectsUnknownWinner(t *testing.T) {
+	ctx := context.Background()
+	users := NewMemUserRepo()
+	deals := NewMemDealRepo()
+	dealID, err := deals.Create(ctx, newTestDeal("red", "blue"))
+	if err != nil {
+		t.Fatalf("Failed to create deal: %v", err)
+	}
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, winner := range []string{"", "green", "Red"} {
+		err := SetDealWinner(ctx, dealID, winner, now, deals, users)
+		if fields := invalidFields(t, err); len(fields) != 1 || fields[0] != "winner" {
+			t.Fatalf("Winner %q failed with %v, want invalid winner", winner, err)
+		}
+	}
+	dealDoc, _ := deals.GetByID(ctx, dealID)
+	if dealDoc.Completed || len(dealDoc.Winner) > 0 {
+		t.Fatalf("Deal was decided with invalid winner: %+v", dealDoc)
+	}
+}
@@ -0,0 +1,125 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+)
+
+// blockedWebhookNets are networks webhooks can't point to: loopback, private, link-local (with cloud metadata),
+// shared, multicast and reserved ones, so webhooks can't be used to reach services inside our network
+var blockedWebhookNets = parseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(fmt.Sprintf("Invalid network %s: %v", cidr, err))
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// publicIP checks that {ip} isn't in any of blocked networks, IPv4-mapped IPv6 addresses are checked as IPv4
+func publicIP(ip net.IP) bool {
+	for _, n := range blockedWebhookNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolvePublicHost resolves {host} and fails if any of its addresses isn't public
+func resolvePublicHost(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("Host %s has no addresses", host)
+	}
+	for _, a := range addrs {
+		if !publicIP(a.IP) {
+			return nil, fmt.Errorf("Host %s resolves to not public address %s", host, a.IP)
+		}
+	}
+	return addrs, nil
+}
+
+// checkWebhookURL checks that {webhookURL} is https url of the public host
+func checkWebhookURL(ctx context.Context, webhookURL string) error {
+	invalid := func(format string, args ...interface{}) error {
+		return dealerrors.Invalid([]*pb.FieldViolation{{Field: "url", Description: fmt.Sprintf(format, args...)}})
+	}
+	u, err := url.Parse(webhookURL)
+	if err != nil || u.Scheme != "https" || len(u.Hostname()) == 0 {
+		return invalid("Must be absolute https url")
+	}
+	if _, err := resolvePublicHost(ctx, u.Hostname()); err != nil {
+		return invalid("Must point to public host: %v", err)
+	}
+	return nil
+}
+
+// newWebhookClient creates client that dials only public addresses and doesn't follow redirects.
+// Addresses are checked on every dial, so webhook can't reach our network by changing its DNS after registration
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			addrs, err := resolvePublicHost(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			// Dial the checked address, so it isn't resolved again to another one
+			return dialer.DialContext(ctx, network, net.JoinHostPort(addrs[0].IP.String(), port))
+		},
+		TLSHandshakeTimeout: webhookTimeout,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: transport,
+		// Redirect is a failed delivery, otherwise webhook could redirect us to our network
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,109 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+)
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"1.1.1.1", true},
+		{"2001:4860:4860::8888", true},
+		{"0.0.0.0", false},
+		{"10.1.2.3", false},
+		{"100.64.0.1", false},
+		{"127.0.0.1", false},
+		{"169.254.169.254", false},
+		{"172.16.0.1", false},
+		{"172.31.255.255", false},
+		{"192.168.1.1", false},
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::", false},
+		{"::1", false},
+		{"64:ff9b::a00:1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"ff02::1", false},
+		// IPv4-mapped IPv6 addresses are checked as IPv4 ones
+		{"::ffff:8.8.8.8", true},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+	}
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.ip)
+		if ip == nil {
+			t.Fatalf("Invalid test address %s", tt.ip)
+		}
+		if got := publicIP(ip); got != tt.public {
+			t.Errorf("publicIP(%s) = %v, want %v", tt.ip, got, tt.public)
+		}
+	}
+}
+
+func TestCheckWebhookURL(t *testing.T) {
+	// Hosts are IP literals, so nothing is resolved through DNS
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://8.8.8.8/hook", true},
+		{"https://8.8.8.8:8443/hook", true},
+		{"https://[2001:4860:4860::8888]/hook", true},
+		{"http://8.8.8.8/hook", false},
+		{"ftp://8.8.8.8/hook", false},
+		{"8.8.8.8/hook", false},
+		{"https:///hook", false},
+		{"https://127.0.0.1/hook", false},
+		{"https://10.0.0.1/hook", false},
+		{"https://169.254.169.254/latest/meta-data", false},
+		{"https://[::1]/hook", false},
+		{"https://[::ffff:10.0.0.1]/hook", false},
+		{"https://[fe80::1]/hook", false},
+	}
+	for _, tt := range tests {
+		err := checkWebhookURL(context.Background(), tt.url)
+		if tt.valid && err != nil {
+			t.Errorf("checkWebhookURL(%s) failed: %v", tt.url, err)
+		}
+		if !tt.valid {
+			if e := dealerrors.Parse(err); e == nil || e.Code != dealerrors.INVALID_ARGUMENT {
+				t.Errorf("checkWebhookURL(%s) returned %v, want INVALID_ARGUMENT", tt.url, err)
+			}
+		}
+	}
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	// Signatures are HMAC-SHA256 of "timestamp.payload", computed independently of the service
+	payload := `{"type":"DEAL_CREATED","deal_id":"42"}`
+	tests := []struct {
+		secret, timestamp, signature string
+	}{
+		{"whsec_test", "1546300800", "sha256=aba0e05407a5e5437548e4fb460fffd663bfe4f1e653f6733e0de70360527fd3"},
+		{"whsec_test", "1546300801", "sha256=fabdb8a5cc8a1f744660bdd972540ddb0a137a6d2551d700db834b3e1f4ee721"},
+	}
+	for _, tt := range tests {
+		if got := signWebhookPayload(tt.secret, tt.timestamp, payload); got != tt.signature {
+			t.Errorf("signWebhookPayload(%s, %s) = %s, want %s", tt.secret, tt.timestamp, got, tt.signature)
+		}
+	}
+	if signWebhookPayload("other", "1546300800", payload) == tests[0].signature {
+		t.Errorf("Signature doesn't depend on the secret")
+	}
+}
@@ -0,0 +1,669 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package dataSvc
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/DenysNahurnyi/deal/common/tracing"
+	"github.com/DenysNahurnyi/deal/common/utils"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/options"
+)
+
+// Types of deal lifecycle events delivered to webhooks
+const (
+	WebhookDealAccepted  = "deal.accepted"
+	WebhookDealActivated = "deal.activated"
+	WebhookDealReopened  = "deal.reopened"
+	WebhookDealCancelled = "deal.cancelled"
+	WebhookDealDecided   = "deal.decided"
+	WebhookDealTimedOut  = "deal.timed_out"
+	WebhookDealBlamed    = "deal.blamed"
+)
+
+var webhookEventTypes = []string{
+	WebhookDealAccepted,
+	WebhookDealActivated,
+	WebhookDealReopened,
+	WebhookDealCancelled,
+	WebhookDealDecided,
+	WebhookDealTimedOut,
+	WebhookDealBlamed,
+}
+
+// Statuses of webhook delivery
+const (
+	DeliveryPending   = "PENDING"
+	DeliveryDelivered = "DELIVERED"
+	DeliveryFailed    = "FAILED"
+)
+
+const (
+	maxWebhooksPerUser      = 10
+	maxDeliveryAttempts     = 8
+	maxDeliveryBackoff      = time.Hour
+	webhookTimeout          = 10 * time.Second
+	webhookDispatchInterval = time.Second
+	webhookDispatchBatch    = 100
+	// Delivery is leased by the dispatcher for this time, so other replicas don't send it at the same time
+	webhookDeliveryLease = time.Minute
+)
+
+// OutboxDB is a lifecycle event written in the same transaction as the deal change that caused it
+type OutboxDB struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	Type       string             `bson:"type"`
+	DealID     string             `bson:"deal_id"`
+	Audience   []string           `bson:"audience"`
+	Payload    string             `bson:"payload"`
+	Created    time.Time          `bson:"created"`
+	Dispatched bool               `bson:"dispatched"`
+}
+
+// WebhookDB is an endpoint of external system that gets lifecycle events of deals its owner participates in
+type WebhookDB struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	Owner      string             `bson:"owner"`
+	URL        string             `bson:"url"`
+	Secret     string             `bson:"secret"`
+	EventTypes []string           `bson:"event_types,omitempty"`
+	Created    time.Time          `bson:"created"`
+}
+
+func (w *WebhookDB) wants(eventType string) bool {
+	return len(w.EventTypes) == 0 || utils.StringInSlice(eventType, w.EventTypes)
+}
+
+// DeliveryDB is a delivery of one outbox event to one webhook with the log of attempts
+type DeliveryDB struct {
+	ID          string            `bson:"_id"` // webhook id + outbox id, so the same event is never queued twice
+	WebhookID   string            `bson:"webhook_id"`
+	OutboxID    string            `bson:"outbox_id"`
+	Type        string            `bson:"type"`
+	Payload     string            `bson:"payload"`
+	Status      string            `bson:"status"`
+	Attempts    []DeliveryAttempt `bson:"attempts,omitempty"`
+	NextAttempt time.Time         `bson:"next_attempt"`
+	Created     time.Time         `bson:"created"`
+}
+
+// DeliveryAttempt is one try to deliver event to the webhook
+type DeliveryAttempt struct {
+	Time         time.Time `bson:"time"`
+	ResponseCode int       `bson:"response_code,omitempty"`
+	Error        string    `bson:"error,omitempty"`
+}
+
+func deliveryID(webhookID, outboxID string) string {
+	return webhookID + ":" + outboxID
+}
+
+// AddOutboxDB stores outbox event, has to be called in the transaction of the change
+func AddOutboxDB(ctx context.Context, entry OutboxDB, table *mongo.Collection) error {
+	ctx, span := tracing.StartDB(ctx, "AddOutboxDB", table)
+	defer span.End()
+	_, err := table.InsertOne(ctx, entry)
+	if err != nil {
+		logging.Error(ctx, "Error adding outbox entry to mongo", "err", err)
+	}
+	return err
+}
+
+// GetOutboxDB returns outbox entries matching {filter}, oldest first
+func GetOutboxDB(ctx context.Context, filter bson.D, limit int64, table *mongo.Collection) ([]*OutboxDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetOutboxDB", table)
+	defer span.End()
+	cursor, err := table.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created", Value: 1}}).SetLimit(limit))
+	if err != nil {
+		logging.Error(ctx, "Error getting outbox entries from mongo", "err", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []*OutboxDB{}
+	for cursor.Next(ctx) {
+		e := &OutboxDB{}
+		if err := cursor.Decode(e); err != nil {
+			logging.Error(ctx, "Error getting outbox entries from mongo", "err", err)
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// MarkOutboxDispatchedDB marks outbox entry as fanned out to the webhooks
+func MarkOutboxDispatchedDB(ctx context.Context, id primitive.ObjectID, table *mongo.Collection) error {
+	ctx, span := tracing.StartDB(ctx, "MarkOutboxDispatchedDB", table)
+	defer span.End()
+	_, err := table.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: id}},
+		bson.D{{"$set", bson.D{{Key: "dispatched", Value: true}}}},
+	)
+	return err
+}
+
+// GetWebhooksDB returns webhooks matching {filter}
+func GetWebhooksDB(ctx context.Context, filter bson.D, table *mongo.Collection) ([]*WebhookDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetWebhooksDB", table)
+	defer span.End()
+	cursor, err := table.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created", Value: 1}}))
+	if err != nil {
+		logging.Error(ctx, "Error getting webhooks from mongo", "err", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	webhooks := []*WebhookDB{}
+	for cursor.Next(ctx) {
+		w := &WebhookDB{}
+		if err := cursor.Decode(w); err != nil {
+			logging.Error(ctx, "Error getting webhooks from mongo", "err", err)
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, nil
+}
+
+// GetWebhookByIDDB returns webhook by it's id, nil if there is no such webhook
+func GetWebhookByIDDB(ctx context.Context, webhookID string, table *mongo.Collection) (*WebhookDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetWebhookByIDDB", table)
+	defer span.End()
+	webhookIDDB, err := primitive.ObjectIDFromHex(webhookID)
+	if err != nil {
+		return nil, dealerrors.New(dealerrors.INVALID_ARGUMENT, "Invalid webhook id %q", webhookID)
+	}
+	webhook := &WebhookDB{}
+	err = table.FindOne(ctx, bson.D{{Key: "_id", Value: webhookIDDB}}).Decode(webhook)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return nil, nil
+		}
+		logging.Error(ctx, "Error getting webhook from mongo", "err", err)
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// QueueDeliveryDB queues delivery, already queued deliveries are reset to be sent again
+func QueueDeliveryDB(ctx context.Context, delivery DeliveryDB, table *mongo.Collection) error {
+	ctx, span := tracing.StartDB(ctx, "QueueDeliveryDB", table)
+	defer span.End()
+	_, err := table.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: delivery.ID}},
+		bson.D{
+			{"$set", bson.D{
+				{Key: "status", Value: DeliveryPending},
+				{Key: "next_attempt", Value: delivery.NextAttempt},
+			}},
+			{"$setOnInsert", bson.D{
+				{Key: "webhook_id", Value: delivery.WebhookID},
+				{Key: "outbox_id", Value: delivery.OutboxID},
+				{Key: "type", Value: delivery.Type},
+				{Key: "payload", Value: delivery.Payload},
+				{Key: "created", Value: delivery.Created},
+			}},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		logging.Error(ctx, "Error queueing webhook delivery in mongo", "err", err)
+	}
+	return err
+}
+
+// ClaimDeliveryDB leases one pending delivery that is due, nil if there is nothing to send
+func ClaimDeliveryDB(ctx context.Context, now time.Time, table *mongo.Collection) (*DeliveryDB, error) {
+	ctx, span := tracing.StartDB(ctx, "ClaimDeliveryDB", table)
+	defer span.End()
+	delivery := &DeliveryDB{}
+	err := table.FindOneAndUpdate(ctx,
+		bson.D{
+			{Key: "status", Value: DeliveryPending},
+			{Key: "next_attempt", Value: bson.D{{Key: "$lte", Value: now}}},
+		},
+		bson.D{{"$set", bson.D{{Key: "next_attempt", Value: now.Add(webhookDeliveryLease)}}}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt", Value: 1}}),
+	).Decode(delivery)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// RecordDeliveryAttemptDB appends attempt to the delivery log and sets it's next state
+func RecordDeliveryAttemptDB(ctx context.Context, id string, attempt DeliveryAttempt, deliveryStatus string, nextAttempt time.Time, table *mongo.Collection) error {
+	ctx, span := tracing.StartDB(ctx, "RecordDeliveryAttemptDB", table)
+	defer span.End()
+	_, err := table.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: id}},
+		bson.D{
+			{"$set", bson.D{
+				{Key: "status", Value: deliveryStatus},
+				{Key: "next_attempt", Value: nextAttempt},
+			}},
+			{"$push", bson.D{{Key: "attempts", Value: attempt}}},
+		},
+	)
+	return err
+}
+
+// GetDeliveriesDB returns deliveries of the webhook {webhookID}, newest first
+func GetDeliveriesDB(ctx context.Context, webhookID string, skip, limit int64, table *mongo.Collection) ([]*DeliveryDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetDeliveriesDB", table)
+	defer span.End()
+	cursor, err := table.Find(ctx,
+		bson.D{{Key: "webhook_id", Value: webhookID}},
+		options.Find().SetSort(bson.D{{Key: "created", Value: -1}}).SetSkip(skip).SetLimit(limit),
+	)
+	if err != nil {
+		logging.Error(ctx, "Error getting webhook deliveries from mongo", "err", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := []*DeliveryDB{}
+	for cursor.Next(ctx) {
+		d := &DeliveryDB{}
+		if err := cursor.Decode(d); err != nil {
+			logging.Error(ctx, "Error getting webhook deliveries from mongo", "err", err)
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
+
+// webhookTypeForStatus returns lifecycle event caused by change of deal status, empty if status isn't interesting outside
+func webhookTypeForStatus(dealStatus string) string {
+	switch dealStatus {
+	case "ACCEPTED_BY_USERS":
+		return WebhookDealAccepted
+	case "ALL_ACCEPTED":
+		return WebhookDealActivated
+	case "INITIAL DEAL STAGE":
+		return WebhookDealReopened
+	case "CANCELLED":
+		return WebhookDealCancelled
+	case "WINNER_SET":
+		return WebhookDealDecided
+	case "TIME_OUT":
+		return WebhookDealTimedOut
+	}
+	return ""
+}
+
+// enqueueOutbox writes lifecycle event of the deal {dealID} to the outbox, {ctx} has to be the transaction of the change
+func (s *service) enqueueOutbox(ctx context.Context, eventType, dealID string, data map[string]string) error {
+	if s.outboxTable == nil {
+		return nil
+	}
+	dealDoc, err := s.deals.GetByID(ctx, dealID)
+	if err != nil {
+		return err
+	}
+	if dealDoc == nil {
+		return dealerrors.New(dealerrors.DEAL_NOT_FOUND, "Deal %s doesn't exist", dealID)
+	}
+	entry := OutboxDB{
+		ID:       primitive.NewObjectID(),
+		Type:     eventType,
+		DealID:   dealID,
+		Audience: utils.UniqueStringSlice(dealAudience(dealDoc)),
+		Created:  s.clock.Now(),
+	}
+	payload := map[string]string{}
+	for k, v := range data {
+		payload[k] = v
+	}
+	payload["id"] = entry.ID.Hex()
+	payload["type"] = eventType
+	payload["deal_id"] = dealID
+	payload["deal_type"] = dealDoc.Type
+	payload["time"] = entry.Created.Format(timeoutLayout)
+	if dealStatus, err := dealDoc.getStatus(); err == nil {
+		payload["status"] = dealStatus
+	}
+	if len(dealDoc.Winner) > 0 {
+		payload["winner"] = dealDoc.effectiveWinner()
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	entry.Payload = string(body)
+	return AddOutboxDB(ctx, entry, s.outboxTable)
+}
+
+// updateDealStatus changes deal status and writes lifecycle event in one transaction
+func (s *service) updateDealStatus(ctx context.Context, dealID, dealStatus string) error {
+	return s.inTransaction(ctx, func(sc context.Context) error {
+		if err := s.deals.UpdateStatus(sc, dealID, dealStatus, s.clock.Now()); err != nil {
+			return err
+		}
+		if eventType := webhookTypeForStatus(dealStatus); len(eventType) > 0 {
+			return s.enqueueOutbox(sc, eventType, dealID, nil)
+		}
+		return nil
+	})
+}
+
+// RegisterWebhook registers {webhookURL} of user {userID}, secret for signatures is returned only here
+func (s *service) RegisterWebhook(ctx context.Context, userID, webhookURL string, eventTypes []string) (*WebhookDB, error) {
+	if err := requireTable(s.webhookTable, "Webhooks"); err != nil {
+		return nil, err
+	}
+	existing, err := GetWebhooksDB(ctx, bson.D{{Key: "owner", Value: userID}}, s.webhookTable)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= maxWebhooksPerUser {
+		return nil, dealerrors.New(dealerrors.WEBHOOK_LIMIT_REACHED, "User can't have more than %d webhooks", maxWebhooksPerUser)
+	}
+	err = checkWebhookURL(ctx, webhookURL)
+	if err != nil {
+		logging.Warn(ctx, "Webhook can't be registered", "err", err)
+		return nil, err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	webhook := WebhookDB{
+		ID:         primitive.NewObjectID(),
+		Owner:      userID,
+		URL:        webhookURL,
+		Secret:     hex.EncodeToString(secret),
+		EventTypes: utils.UniqueStringSlice(eventTypes),
+		Created:    s.clock.Now(),
+	}
+	if _, err := s.webhookTable.InsertOne(ctx, webhook); err != nil {
+		logging.Error(ctx, "Failed to create webhook", "err", err)
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// ListWebhooks returns webhooks of user {userID}
+func (s *service) ListWebhooks(ctx context.Context, userID string) ([]*WebhookDB, error) {
+	if err := requireTable(s.webhookTable, "Webhooks"); err != nil {
+		return nil, err
+	}
+	return GetWebhooksDB(ctx, bson.D{{Key: "owner", Value: userID}}, s.webhookTable)
+}
+
+// getOwnWebhook returns webhook {webhookID} if it belongs to user {userID}
+func (s *service) getOwnWebhook(ctx context.Context, userID, webhookID string) (*WebhookDB, error) {
+	webhook, err := GetWebhookByIDDB(ctx, webhookID, s.webhookTable)
+	if err != nil {
+		return nil, err
+	}
+	if webhook == nil || webhook.Owner != userID {
+		return nil, dealerrors.New(dealerrors.NOT_FOUND, "Webhook %s doesn't exist", webhookID)
+	}
+	return webhook, nil
+}
+
+// DeleteWebhook deletes webhook {webhookID} of user {userID} with it's pending deliveries
+func (s *service) DeleteWebhook(ctx context.Context, userID, webhookID string) error {
+	if err := requireTable(s.webhookTable, "Webhooks"); err != nil {
+		return err
+	}
+	webhook, err := s.getOwnWebhook(ctx, userID, webhookID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.webhookTable.DeleteOne(ctx, bson.D{{Key: "_id", Value: webhook.ID}}); err != nil {
+		return err
+	}
+	_, err = s.deliveryTable.DeleteMany(ctx, bson.D{
+		{Key: "webhook_id", Value: webhookID},
+		{Key: "status", Value: DeliveryPending},
+	})
+	return err
+}
+
+// ReplayWebhook queues again every event of the webhook owner since {since}, including already delivered ones
+func (s *service) ReplayWebhook(ctx context.Context, userID, webhookID string, since time.Time) (int, error) {
+	if err := requireTable(s.webhookTable, "Webhooks"); err != nil {
+		return 0, err
+	}
+	webhook, err := s.getOwnWebhook(ctx, userID, webhookID)
+	if err != nil {
+		return 0, err
+	}
+	replayed := 0
+	for {
+		entries, err := GetOutboxDB(ctx, bson.D{
+			{Key: "audience", Value: userID},
+			{Key: "created", Value: bson.D{{Key: "$gte", Value: since}}},
+		}, webhookDispatchBatch, s.outboxTable)
+		if err != nil {
+			return replayed, err
+		}
+		for _, e := range entries {
+			if !webhook.wants(e.Type) {
+				continue
+			}
+			if err := s.queueDelivery(ctx, webhook, e); err != nil {
+				return replayed, err
+			}
+			replayed++
+		}
+		if len(entries) < webhookDispatchBatch {
+			return replayed, nil
+		}
+		// Entries with the same time as the last one are queued again, that's fine as queueing is idempotent
+		since = entries[len(entries)-1].Created.Add(time.Nanosecond)
+	}
+}
+
+// ListWebhookDeliveries returns delivery log of webhook {webhookID}
+func (s *service) ListWebhookDeliveries(ctx context.Context, userID, webhookID string, page, pageSize int) ([]*DeliveryDB, error) {
+	if err := requireTable(s.webhookTable, "Webhooks"); err != nil {
+		return nil, err
+	}
+	if _, err := s.getOwnWebhook(ctx, userID, webhookID); err != nil {
+		return nil, err
+	}
+	skip, limit := pageBounds(page, pageSize)
+	return GetDeliveriesDB(ctx, webhookID, int64(skip), int64(limit), s.deliveryTable)
+}
+
+func (s *service) queueDelivery(ctx context.Context, webhook *WebhookDB, entry *OutboxDB) error {
+	now := s.clock.Now()
+	return QueueDeliveryDB(ctx, DeliveryDB{
+		ID:          deliveryID(webhook.ID.Hex(), entry.ID.Hex()),
+		WebhookID:   webhook.ID.Hex(),
+		OutboxID:    entry.ID.Hex(),
+		Type:        entry.Type,
+		Payload:     entry.Payload,
+		NextAttempt: now,
+		Created:     now,
+	}, s.deliveryTable)
+}
+
+// runWebhookDispatcher fans outbox entries out to webhooks and sends due deliveries until {ctx} is done
+func (s *service) runWebhookDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(webhookDispatchInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.dispatchOutbox(ctx); err != nil {
+			logging.Error(ctx, "Failed to dispatch outbox", "err", err)
+		}
+		if err := s.sendDueDeliveries(ctx); err != nil {
+			logging.Error(ctx, "Failed to send webhook deliveries", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchOutbox queues deliveries of not dispatched outbox entries to the webhooks of their audience
+func (s *service) dispatchOutbox(ctx context.Context) error {
+	entries, err := GetOutboxDB(ctx, bson.D{{Key: "dispatched", Value: false}}, webhookDispatchBatch, s.outboxTable)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		webhooks, err := GetWebhooksDB(ctx, bson.D{{Key: "owner", Value: bson.D{{Key: "$in", Value: e.Audience}}}}, s.webhookTable)
+		if err != nil {
+			return err
+		}
+		for _, w := range webhooks {
+			if !w.wants(e.Type) {
+				continue
+			}
+			// Queueing is idempotent, so it's safe if another replica dispatches the same entry
+			if err := s.queueDelivery(ctx, w, e); err != nil {
+				return err
+			}
+		}
+		if err := MarkOutboxDispatchedDB(ctx, e.ID, s.outboxTable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *service) sendDueDeliveries(ctx context.Context) error {
+	for i := 0; i < webhookDispatchBatch; i++ {
+		delivery, err := ClaimDeliveryDB(ctx, s.clock.Now(), s.deliveryTable)
+		if err != nil || delivery == nil {
+			return err
+		}
+		webhook, err := GetWebhookByIDDB(ctx, delivery.WebhookID, s.webhookTable)
+		if err != nil {
+			return err
+		}
+		if webhook == nil {
+			// Webhook is deleted, nobody to deliver to
+			now := s.clock.Now()
+			err = RecordDeliveryAttemptDB(ctx, delivery.ID, DeliveryAttempt{Time: now, Error: "webhook deleted"}, DeliveryFailed, now, s.deliveryTable)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		attempt := s.deliver(ctx, webhook, delivery)
+		deliveryStatus := DeliveryDelivered
+		nextAttempt := attempt.Time
+		if len(attempt.Error) > 0 {
+			deliveryStatus = DeliveryPending
+			attempts := len(delivery.Attempts) + 1
+			if attempts >= maxDeliveryAttempts {
+				deliveryStatus = DeliveryFailed
+			}
+			deliveryFailures.WithLabelValues(channelWebhook, strconv.FormatBool(deliveryStatus == DeliveryFailed)).Inc()
+			nextAttempt = attempt.Time.Add(deliveryBackoff(attempts))
+		}
+		err = RecordDeliveryAttemptDB(ctx, delivery.ID, attempt, deliveryStatus, nextAttempt, s.deliveryTable)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliveryBackoff returns wait before the next attempt: 2^attempts seconds up to an hour
+func deliveryBackoff(attempts int) time.Duration {
+	backoff := time.Second << uint(attempts)
+	if backoff <= 0 || backoff > maxDeliveryBackoff {
+		return maxDeliveryBackoff
+	}
+	return backoff
+}
+
+// signWebhookPayload signs "{timestamp}.{payload}" with the webhook secret, receivers check it and the timestamp
+// to make sure event came from us and is not replayed
+func signWebhookPayload(secret, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *service) deliver(ctx context.Context, webhook *WebhookDB, delivery *DeliveryDB) DeliveryAttempt {
+	attempt := DeliveryAttempt{Time: s.clock.Now()}
+	timestamp := strconv.FormatInt(attempt.Time.Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Deal-Event", delivery.Type)
+	req.Header.Set("X-Deal-Delivery", delivery.ID)
+	req.Header.Set("X-Deal-Timestamp", timestamp)
+	req.Header.Set("X-Deal-Signature", signWebhookPayload(webhook.Secret, timestamp, delivery.Payload))
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	resp.Body.Close()
+	attempt.ResponseCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		attempt.Error = "unexpected response status " + resp.Status
+	}
+	return attempt
+}
+
+func convertWebhook(w *WebhookDB, withSecret bool) *pb.Webhook {
+	res := &pb.Webhook{
+		Id:         w.ID.Hex(),
+		Url:        w.URL,
+		EventTypes: w.EventTypes,
+		Created:    w.Created.Format(timeoutLayout),
+	}
+	if withSecret {
+		res.Secret = w.Secret
+	}
+	return res
+}
+
+func convertDelivery(d *DeliveryDB) *pb.WebhookDelivery {
+	res := &pb.WebhookDelivery{
+		Id:          d.ID,
+		WebhookId:   d.WebhookID,
+		Type:        d.Type,
+		Payload:     d.Payload,
+		Status:      d.Status,
+		NextAttempt: d.NextAttempt.Format(timeoutLayout),
+		Created:     d.Created.Format(timeoutLayout),
+	}
+	for _, a := range d.Attempts {
+		res.Attempts = append(res.Attempts, &pb.DeliveryAttempt{
+			Time:         a.Time.Format(timeoutLayout),
+			ResponseCode: int64(a.ResponseCode),
+			Error:        a.Error,
+		})
+	}
+	return res
+}
@@ -0,0 +1,254 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package harness
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/DenysNahurnyi/deal/authSvc"
+	"github.com/DenysNahurnyi/deal/common/clock"
+	"github.com/DenysNahurnyi/deal/common/grpc"
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/DenysNahurnyi/deal/dataSvc"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/DenysNahurnyi/deal/watcherSvc"
+	"github.com/go-kit/kit/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	bufSize = 1024 * 1024
+	// TimeoutLayout is the format of pact timeout, the same as dataSvc and watcherSvc use
+	TimeoutLayout = "2006-01-02T15:04:05.000Z"
+	// pollInterval is how often Wait* helpers check the deal, it's real time, not the fake clock one
+	pollInterval = 10 * time.Millisecond
+)
+
+// Harness runs auth, data and watcher services in-process. Services talk to each other over
+// in-memory connections, keep data in in-memory repos and run on the fake clock
+type Harness struct {
+	Clock   *clock.Fake
+	Auth    pb.AuthServiceClient
+	Data    pb.DataServiceClient
+	Watcher pb.WatcherServiceClient
+
+	users   dataSvc.UserRepo
+	servers []*grpc.Server
+	conns   []*grpc.ClientConn
+}
+
+// User is a signed up user, calls made with User.Ctx are authorized with the user token
+type User struct {
+	ID       string
+	Username string
+	Token    string
+}
+
+// Start boots all three services, they are ready to serve when it returns
+func Start(logger log.Logger) (*Harness, error) {
+	rKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate token key: %v", err)
+	}
+	h := &Harness{
+		// Mongo keeps time with millisecond precision, so does the pact timeout
+		Clock: clock.NewFake(time.Now().UTC().Truncate(time.Millisecond)),
+		users: dataSvc.NewMemUserRepo(),
+	}
+
+	// Services depend on each other, so listeners and client connections are created first,
+	// connections are lazy and don't need the server to be up yet
+	authL, dataL, watcherL := bufconn.Listen(bufSize), bufconn.Listen(bufSize), bufconn.Listen(bufSize)
+	authConn, err := h.dial(authL)
+	if err != nil {
+		return nil, err
+	}
+	dataConn, err := h.dial(dataL)
+	if err != nil {
+		h.Stop()
+		return nil, err
+	}
+	watcherConn, err := h.dial(watcherL)
+	if err != nil {
+		h.Stop()
+		return nil, err
+	}
+	h.Auth = pb.NewAuthServiceClient(authConn)
+	h.Data = pb.NewDataServiceClient(dataConn)
+	h.Watcher = pb.NewWatcherServiceClient(watcherConn)
+
+	authService := authSvc.NewServiceWithRepo(authSvc.NewMemSecureUserRepo(), h.Data, rKey)
+	dataService := dataSvc.NewServiceWithRepos(h.users, dataSvc.NewMemDealRepo(), h.Auth, h.Watcher, &rKey.PublicKey, h.Clock)
+	watcherService, err := watcherSvc.NewServiceWithRepo(context.Background(), watcherSvc.NewMemWatchQueueRepo(), h.Data, h.Clock)
+	if err != nil {
+		h.Stop()
+		return nil, fmt.Errorf("Failed to create watcher service: %v", err)
+	}
+
+	authServer := grpcutils.NewServer(pb.ServiceId_AUTH)
+	pb.RegisterAuthServiceServer(authServer, authSvc.NewGRPCServer(authService, logger))
+	h.serve(authServer, authL)
+	dataServer := grpcutils.NewServer(pb.ServiceId_DATA)
+	pb.RegisterDataServiceServer(dataServer, dataSvc.NewGRPCServer(dataService, logger))
+	h.serve(dataServer, dataL)
+	watcherServer := grpcutils.NewServer(pb.ServiceId_WATCHER)
+	pb.RegisterWatcherServiceServer(watcherServer, watcherSvc.NewGRPCServer(watcherService, logger))
+	h.serve(watcherServer, watcherL)
+
+	return h, nil
+}
+
+func (h *Harness) dial(l *bufconn.Listener) (*grpc.ClientConn, error) {
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithDialer(func(string, time.Duration) (net.Conn, error) {
+			return l.Dial()
+		}),
+		grpc.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to dial in-memory listener: %v", err)
+	}
+	h.conns = append(h.conns, conn)
+	return conn, nil
+}
+
+func (h *Harness) serve(srv *grpc.Server, l net.Listener) {
+	h.servers = append(h.servers, srv)
+	go func() {
+		if err := srv.Serve(l); err != nil {
+			logging.Error(context.Background(), "Harness server stopped", "err", err)
+		}
+	}()
+}
+
+// Stop closes client connections and stops all services
+func (h *Harness) Stop() {
+	for _, conn := range h.conns {
+		conn.Close()
+	}
+	for _, srv := range h.servers {
+		srv.Stop()
+	}
+}
+
+// Hdr returns request header with transaction id {tid}
+func Hdr(tid string) *pb.ReqHdr {
+	return &pb.ReqHdr{Tid: tid}
+}
+
+// Ctx returns context that authorizes calls as the user
+func (u *User) Ctx(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, grpcutils.GRPCAUTHORIZATIONHEADER, grpcutils.BEARER+" "+u.Token)
+}
+
+// SignUp creates user {username} through authSvc and logs the user in
+func (h *Harness) SignUp(ctx context.Context, username string) (*User, error) {
+	password := username + "-password"
+	_, err := h.Auth.SignUp(ctx, &pb.SignUpReq{
+		ReqHdr:   Hdr("harness-sign-up-" + username),
+		Password: password,
+		UserReq: &pb.CreateUserReq{
+			ReqHdr: Hdr("harness-create-" + username),
+			User: &pb.User{
+				Username: username,
+				Name:     username,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to sign up %s: %v", username, err)
+	}
+	loginResp, err := h.Auth.Login(ctx, &pb.LoginReq{
+		ReqHdr:   Hdr("harness-login-" + username),
+		Username: username,
+		Password: password,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to log in %s: %v", username, err)
+	}
+	user := &User{
+		Username: username,
+		Token:    loginResp.GetToken(),
+	}
+	userResp, err := h.Data.GetUser(user.Ctx(ctx), &pb.GetUserReq{ReqHdr: Hdr("harness-get-" + username)})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get %s: %v", username, err)
+	}
+	user.ID = userResp.GetUser().GetId()
+	return user, nil
+}
+
+// MakeJudge turns user into judge. There is no API for that, so it goes straight to the user repo
+func (h *Harness) MakeJudge(ctx context.Context, user *User) error {
+	userDB, err := h.users.GetByID(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if userDB == nil {
+		return fmt.Errorf("User %s doesn't exist", user.Username)
+	}
+	userDB.IsJudge = true
+	if userDB.JudgeProfile == nil {
+		userDB.JudgeProfile = &dataSvc.JudgeProfile{}
+	}
+	return h.users.Update(ctx, user.ID, userDB)
+}
+
+// Deal returns deal {dealID} as {user} sees it
+func (h *Harness) Deal(ctx context.Context, user *User, dealID string) (*pb.DealDocument, error) {
+	resp, err := h.Data.GetDealDocument(user.Ctx(ctx), &pb.GetDealDocumentReq{
+		ReqHdr:         Hdr("harness-get-deal"),
+		DealDocumentId: dealID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetDealDocument(), nil
+}
+
+// WaitForTimers waits until {n} timers are pending on the fake clock, watcher starts timers asynchronously
+func (h *Harness) WaitForTimers(ctx context.Context, n int, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for h.Clock.Pending() < n {
+		select {
+		case <-deadline:
+			return fmt.Errorf("%d timers are pending, expected %d", h.Clock.Pending(), n)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+	return nil
+}
+
+// WaitForStatus waits until deal {dealID} gets status {status}, watcher handles timeouts asynchronously
+// so it has to be used after the fake clock is advanced
+func (h *Harness) WaitForStatus(ctx context.Context, user *User, dealID, status string, timeout time.Duration) (*pb.DealDocument, error) {
+	deadline := time.After(timeout)
+	for {
+		dealDoc, err := h.Deal(ctx, user, dealID)
+		if err != nil {
+			return nil, err
+		}
+		if dealDoc.GetStatus() == status {
+			return dealDoc, nil
+		}
+		select {
+		case <-deadline:
+			return nil, fmt.Errorf("Deal %s has status %s, expected %s", dealID, dealDoc.GetStatus(), status)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
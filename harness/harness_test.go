@@ -0,0 +1,275 @@
+//
+// Copyright 2019
+//
+// @author: Denys Nahurnyi
+// @email:  dnahurnyi@gmail.com
+// ---------------------------------------------------------------------------
+package harness
+
+import (
+	"context"
+	"flag"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/DenysNahurnyi/deal/common/utils"
+	pb "github.com/DenysNahurnyi/deal/pb/generated/pb"
+	"github.com/go-kit/kit/log"
+)
+
+// waitTimeout is how long test waits for asynchronous watcher work, in real time
+const waitTimeout = 5 * time.Second
+
+// TestMain silences logs of the services, they are shown with -v
+func TestMain(m *testing.M) {
+	flag.Parse()
+	logger := log.NewNopLogger()
+	if testing.Verbose() {
+		logger = logging.New("harness", logging.DEBUG)
+	}
+	logging.SetLogger(logger)
+	os.Exit(m.Run())
+}
+
+// start boots fresh harness that is stopped when the test ends
+func start(t *testing.T) (context.Context, *Harness) {
+	t.Helper()
+	h, err := Start(logging.Logger())
+	if err != nil {
+		t.Fatalf("Failed to start harness: %v", err)
+	}
+	t.Cleanup(h.Stop)
+	return context.Background(), h
+}
+
+// signUp signs up users with {usernames}
+func signUp(ctx context.Context, t *testing.T, h *Harness, usernames ...string) []*User {
+	t.Helper()
+	users := []*User{}
+	for _, username := range usernames {
+		user, err := h.SignUp(ctx, username)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		users = append(users, user)
+	}
+	return users
+}
+
+// signUpJudges signs up users with {usernames} and makes them judges
+func signUpJudges(ctx context.Context, t *testing.T, h *Harness, usernames ...string) []*User {
+	t.Helper()
+	judges := signUp(ctx, t, h, usernames...)
+	for _, judge := range judges {
+		if err := h.MakeJudge(ctx, judge); err != nil {
+			t.Fatalf("Failed to make %s judge: %v", judge.Username, err)
+		}
+	}
+	return judges
+}
+
+// startDeal walks deal from creation to ALL_ACCEPTED: {red} creates it and offers it to {blue},
+// {blue} accepts it and {judge} takes it. Deal times out {timeout} after the current fake time
+func startDeal(ctx context.Context, t *testing.T, h *Harness, red, blue, judge *User, timeout time.Duration) string {
+	t.Helper()
+	createResp, err := h.Data.CreateDealDocument(red.Ctx(ctx), &pb.CreateDealDocumentReq{
+		ReqHdr: Hdr("harness-create-deal"),
+		DealDocument: &pb.Pact{
+			Content: red.Username + " bets against " + blue.Username,
+			Red:     &pb.Side{Members: 1},
+			Blue:    &pb.Side{Members: 1},
+			Timeout: h.Clock.Now().Add(timeout).UTC().Format(TimeoutLayout),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create deal: %v", err)
+	}
+	dealID := createResp.GetDealDocumentId()
+	_, err = h.Data.OfferDealDocument(red.Ctx(ctx), &pb.OfferDealDocumentReq{
+		ReqHdr:    Hdr("harness-offer-deal"),
+		DealDocId: dealID,
+		Username:  blue.Username,
+	})
+	if err != nil {
+		t.Fatalf("Failed to offer deal %s: %v", dealID, err)
+	}
+	_, err = h.Data.AcceptDealDocument(blue.Ctx(ctx), &pb.AcceptDealDocumentReq{
+		ReqHdr:    Hdr("harness-accept-deal"),
+		DealDocId: dealID,
+		SideType:  pb.SideType_BLUE,
+	})
+	if err != nil {
+		t.Fatalf("Failed to accept deal %s: %v", dealID, err)
+	}
+	expectStatus(ctx, t, h, red, dealID, "ACCEPTED_BY_USERS")
+	_, err = h.Data.JudgeAcceptDealDocument(judge.Ctx(ctx), &pb.JudgeAcceptDealDocumentReq{
+		ReqHdr:    Hdr("harness-judge-accept-deal"),
+		DealDocId: dealID,
+	})
+	if err != nil {
+		t.Fatalf("Failed to accept deal %s by judge: %v", dealID, err)
+	}
+	expectStatus(ctx, t, h, red, dealID, "ALL_ACCEPTED")
+	return dealID
+}
+
+func decide(ctx context.Context, t *testing.T, h *Harness, judge *User, dealID, winner string) {
+	t.Helper()
+	_, err := h.Data.JudgeDecide(judge.Ctx(ctx), &pb.JudgeDecideReq{
+		ReqHdr:         Hdr("harness-judge-decide"),
+		DealDocumentId: dealID,
+		Winner:         winner,
+	})
+	if err != nil {
+		t.Fatalf("Failed to decide deal %s: %v", dealID, err)
+	}
+}
+
+// blame creates blame of {judge} on deal {dealID} and activates it right away
+func blame(ctx context.Context, t *testing.T, h *Harness, judge *User, dealID string) string {
+	t.Helper()
+	createResp, err := h.Data.CreateBlameDocument(judge.Ctx(ctx), &pb.CreateBlameDocumentReq{
+		ReqHdr:       Hdr("harness-create-blame"),
+		BlamedDealId: dealID,
+		BlameReson:   judge.Username + " disagrees",
+	})
+	if err != nil {
+		t.Fatalf("Failed to blame deal %s: %v", dealID, err)
+	}
+	blameID := createResp.GetBlameDocumentId()
+	_, err = h.Data.ActivateBlame(judge.Ctx(ctx), &pb.ActivateBlameReq{
+		ReqHdr:  Hdr("harness-activate-blame"),
+		BlameId: blameID,
+	})
+	if err != nil {
+		t.Fatalf("Failed to activate blame %s: %v", blameID, err)
+	}
+	return blameID
+}
+
+func deal(ctx context.Context, t *testing.T, h *Harness, user *User, dealID string) *pb.DealDocument {
+	t.Helper()
+	dealDoc, err := h.Deal(ctx, user, dealID)
+	if err != nil {
+		t.Fatalf("Failed to get deal %s: %v", dealID, err)
+	}
+	return dealDoc
+}
+
+func expectStatus(ctx context.Context, t *testing.T, h *Harness, user *User, dealID, status string) {
+	t.Helper()
+	if got := deal(ctx, t, h, user, dealID).GetStatus(); got != status {
+		t.Fatalf("Deal %s has status %s, expected %s", dealID, got, status)
+	}
+}
+
+func waitForStatus(ctx context.Context, t *testing.T, h *Harness, user *User, dealID, status string) *pb.DealDocument {
+	t.Helper()
+	dealDoc, err := h.WaitForStatus(ctx, user, dealID, status, waitTimeout)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return dealDoc
+}
+
+func expectBlamed(ctx context.Context, t *testing.T, h *Harness, user *User, dealID, blamed string) {
+	t.Helper()
+	if got := deal(ctx, t, h, user, dealID).GetBlamed(); got != blamed {
+		t.Fatalf("Deal %s has blamed %q, expected %q", dealID, got, blamed)
+	}
+}
+
+// expectResult checks that deal {dealID} is in results of every user
+func expectResult(ctx context.Context, t *testing.T, h *Harness, dealID string, users ...*User) {
+	t.Helper()
+	for _, user := range users {
+		userResp, err := h.Data.GetUser(user.Ctx(ctx), &pb.GetUserReq{ReqHdr: Hdr("harness-get-results")})
+		if err != nil {
+			t.Fatalf("Failed to get %s: %v", user.Username, err)
+		}
+		if !utils.StringInSlice(dealID, userResp.GetUser().GetDealResults()) {
+			t.Fatalf("Deal %s is not in results of %s", dealID, user.Username)
+		}
+	}
+}
+
+// TestDealLifecycle goes through the whole deal: creation, offer, acceptance, judge decision and timeout
+func TestDealLifecycle(t *testing.T) {
+	ctx, h := start(t)
+	users := signUp(ctx, t, h, "alice", "bob")
+	alice, bob, judge := users[0], users[1], signUpJudges(ctx, t, h, "judge")[0]
+
+	dealID := startDeal(ctx, t, h, alice, bob, judge, 48*time.Hour)
+	decide(ctx, t, h, judge, dealID, "red")
+	dealDoc := deal(ctx, t, h, bob, dealID)
+	if dealDoc.GetStatus() != "WINNER_SET" || dealDoc.GetWinner() != "red" {
+		t.Fatalf("Deal %s has status %s and winner %q after decision", dealID, dealDoc.GetStatus(), dealDoc.GetWinner())
+	}
+
+	h.Clock.Advance(49 * time.Hour)
+	waitForStatus(ctx, t, h, alice, dealID, "TIME_OUT")
+	expectResult(ctx, t, h, dealID, alice, bob)
+}
+
+// TestDealExpiresWithoutDecision checks that watcher times out deal the judge didn't decide
+func TestDealExpiresWithoutDecision(t *testing.T) {
+	ctx, h := start(t)
+	users := signUp(ctx, t, h, "alice", "bob")
+	alice, bob, judge := users[0], users[1], signUpJudges(ctx, t, h, "judge")[0]
+
+	dealID := startDeal(ctx, t, h, alice, bob, judge, time.Hour)
+	// Timeout is not reached yet
+	h.Clock.Advance(59 * time.Minute)
+	expectStatus(ctx, t, h, alice, dealID, "ALL_ACCEPTED")
+	// Pact timeout is the only pending timer
+	if err := h.WaitForTimers(ctx, 1, waitTimeout); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if fired := h.Clock.FirePending(); fired != 1 {
+		t.Fatalf("Expected one pending timer, fired %d", fired)
+	}
+	dealDoc := waitForStatus(ctx, t, h, alice, dealID, "TIME_OUT")
+	if len(dealDoc.GetWinner()) != 0 {
+		t.Fatalf("Deal %s expired with winner %q", dealID, dealDoc.GetWinner())
+	}
+}
+
+// TestDealsTimeOutInOrder checks that watcher queue times out deals by their timeout, not by the order they came
+func TestDealsTimeOutInOrder(t *testing.T) {
+	ctx, h := start(t)
+	users := signUp(ctx, t, h, "alice", "bob")
+	alice, bob, judge := users[0], users[1], signUpJudges(ctx, t, h, "judge")[0]
+
+	late := startDeal(ctx, t, h, alice, bob, judge, 3*time.Hour)
+	early := startDeal(ctx, t, h, alice, bob, judge, time.Hour)
+	h.Clock.Advance(2 * time.Hour)
+	waitForStatus(ctx, t, h, alice, early, "TIME_OUT")
+	expectStatus(ctx, t, h, alice, late, "ALL_ACCEPTED")
+	h.Clock.Advance(2 * time.Hour)
+	waitForStatus(ctx, t, h, alice, late, "TIME_OUT")
+}
+
+// TestBlameChain checks that blame on a blame takes back the first blame: judge1 blames deal of judge2,
+// then judge3 blames judge1's blame, so judge2's deal is not blamed anymore
+func TestBlameChain(t *testing.T) {
+	ctx, h := start(t)
+	users := signUp(ctx, t, h, "alice", "bob")
+	judges := signUpJudges(ctx, t, h, "judge1", "judge2", "judge3")
+	alice, bob := users[0], users[1]
+
+	// Every judge needs a decided deal to have justice for blames
+	deals := []string{}
+	for _, judge := range judges {
+		dealID := startDeal(ctx, t, h, alice, bob, judge, 48*time.Hour)
+		decide(ctx, t, h, judge, dealID, "red")
+		deals = append(deals, dealID)
+	}
+
+	firstBlame := blame(ctx, t, h, judges[0], deals[1])
+	expectBlamed(ctx, t, h, alice, deals[1], "Yes")
+	blame(ctx, t, h, judges[2], firstBlame)
+	expectBlamed(ctx, t, h, alice, firstBlame, "Yes")
+	expectBlamed(ctx, t, h, alice, deals[1], "No")
+}
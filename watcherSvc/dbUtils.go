@@ -2,10 +2,11 @@ package watcherSvc
 
 import (
 	"context"
-	"fmt"
 	"sort"
 	"time"
 
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/DenysNahurnyi/deal/common/tracing"
 	"github.com/mongodb/mongo-go-driver/bson"
 	"github.com/mongodb/mongo-go-driver/bson/primitive"
 	"github.com/mongodb/mongo-go-driver/mongo"
@@ -37,14 +38,16 @@ func (d *DealDB) toMongoFormat() bson.D {
 
 // Put deal to the queue and return {needToUpdateTimer}
 func PutDealToQueue(ctx context.Context, deal *DealDB, table *mongo.Collection) (insertedDealId string, needToUpdateTimer bool, err error) {
+	ctx, span := tracing.StartDB(ctx, "PutDealToQueue", table)
+	defer span.End()
 	firstDeal, err := GetFirstDeal(ctx, table)
 	if err != nil {
-		fmt.Println("Error getting first deal from mongo: ", err)
+		logging.Error(ctx, "Error getting first deal from mongo", "err", err)
 		return "", false, err
 	}
 	if firstDeal != nil {
-		fmt.Println("{DEBUG} first deal from queue " + firstDeal.ID.Hex() + " timeout: " + firstDeal.Timeout.String())
-		fmt.Println("{DEBUG} Incomming deal " + firstDeal.ID.Hex() + " timeout: " + firstDeal.Timeout.String())
+		logging.Debug(ctx, "First deal from queue "+firstDeal.ID.Hex()+" timeout: "+firstDeal.Timeout.String())
+		logging.Debug(ctx, "Incomming deal "+firstDeal.ID.Hex()+" timeout: "+firstDeal.Timeout.String())
 		needToUpdateTimer = firstDeal.Timeout.After(deal.Timeout)
 	} else {
 		needToUpdateTimer = true
@@ -52,13 +55,15 @@ func PutDealToQueue(ctx context.Context, deal *DealDB, table *mongo.Collection)
 	// Add new deal
 	res, err := table.InsertOne(ctx, deal)
 	if err != nil {
-		fmt.Println("Error adding new deal to the queue in mongo: ", err)
+		logging.Error(ctx, "Error adding new deal to the queue in mongo", "err", err)
 		return "", false, err
 	}
 	return res.InsertedID.(primitive.ObjectID).Hex(), needToUpdateTimer, err
 }
 
 func GetFirstDeal(ctx context.Context, table *mongo.Collection) (*DealDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetFirstDeal", table)
+	defer span.End()
 	deals := []*DealDB{}
 	// Get WATCHING deal
 	watchingDeal := &DealDB{}
@@ -68,7 +73,7 @@ func GetFirstDeal(ctx context.Context, table *mongo.Collection) (*DealDB, error)
 		if err.Error() == "mongo: no documents in result" {
 			// it's ok
 		} else {
-			fmt.Println("Error getting waiting deal mongo: ", err)
+			logging.Error(ctx, "Error getting waiting deal mongo", "err", err)
 			return nil, err
 		}
 	}
@@ -78,7 +83,7 @@ func GetFirstDeal(ctx context.Context, table *mongo.Collection) (*DealDB, error)
 	// Get all deals is no watching deals
 	cursor, err := table.Find(ctx, bson.D{{Key: "status", Value: "IN_QUEUE"}})
 	if err != nil {
-		fmt.Println("Error getting deals from mongo: ", err)
+		logging.Error(ctx, "Error getting deals from mongo", "err", err)
 		return nil, err
 	}
 	defer cursor.Close(ctx)
@@ -86,18 +91,18 @@ func GetFirstDeal(ctx context.Context, table *mongo.Collection) (*DealDB, error)
 	for cursor.Next(ctx) {
 		d := &DealDB{}
 		if err := cursor.Decode(d); err != nil {
-			fmt.Println("Error getting deals from mongo: ", err)
+			logging.Error(ctx, "Error getting deals from mongo", "err", err)
 			return nil, err
 		}
 		deals = append(deals, d)
 	}
-	fmt.Println("Deals in DB: ", len(deals))
+	logging.Debug(ctx, "Got deals from queue", "count", len(deals))
 	// Sort in the right order and get {needToUpdateTimer} value
 	if len(deals) != 0 {
 		sort.Slice(deals, func(i, j int) bool {
-			return deals[i].Timeout.After(deals[i].Timeout)
+			return deals[i].Timeout.Before(deals[j].Timeout)
 		})
-		fmt.Println("reutrn deal: ", deals[0])
+		logging.Debug(ctx, "First deal in queue", "deal", deals[0].ID.Hex(), "timeout", deals[0].Timeout)
 		return deals[0], nil
 	} else {
 		return nil, nil
@@ -106,9 +111,11 @@ func GetFirstDeal(ctx context.Context, table *mongo.Collection) (*DealDB, error)
 
 // UpdateStatus updates deal status
 func UpdateStatus(ctx context.Context, dealID, status string, table *mongo.Collection) error {
+	ctx, span := tracing.StartDB(ctx, "UpdateStatus", table)
+	defer span.End()
 	id, err := primitive.ObjectIDFromHex(dealID)
 	if err != nil {
-		fmt.Println("Error creating object id to get user: ", err)
+		logging.Error(ctx, "Error creating object id to get user", "err", err)
 		return err
 	}
 	_, err = table.UpdateOne(ctx,
@@ -116,7 +123,46 @@ func UpdateStatus(ctx context.Context, dealID, status string, table *mongo.Colle
 		bson.D{{"$set", bson.D{{Key: "status", Value: status}}}},
 	)
 	if err != nil {
-		fmt.Println("Error updating user in mongo: ", err)
+		logging.Error(ctx, "Error updating user in mongo", "err", err)
 	}
 	return err
 }
+
+// GetQueuedDealsByDealID returns queue records of deal {dealID} that are still waiting for timeout
+func GetQueuedDealsByDealID(ctx context.Context, dealID string, table *mongo.Collection) ([]*DealDB, error) {
+	ctx, span := tracing.StartDB(ctx, "GetQueuedDealsByDealID", table)
+	defer span.End()
+	cursor, err := table.Find(ctx, bson.D{
+		{Key: "deal_id", Value: dealID},
+		{Key: "status", Value: bson.D{{Key: "$in", Value: []string{"IN_QUEUE", "WATCHING"}}}},
+	})
+	if err != nil {
+		logging.Error(ctx, "Error getting deals from mongo", "err", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	deals := []*DealDB{}
+	for cursor.Next(ctx) {
+		d := &DealDB{}
+		if err := cursor.Decode(d); err != nil {
+			logging.Error(ctx, "Error getting deals from mongo", "err", err)
+			return nil, err
+		}
+		deals = append(deals, d)
+	}
+	return deals, nil
+}
+
+// CountQueuedDeals returns number of deals that are still waiting for timeout
+func CountQueuedDeals(ctx context.Context, table *mongo.Collection) (int64, error) {
+	ctx, span := tracing.StartDB(ctx, "CountQueuedDeals", table)
+	defer span.End()
+	count, err := table.CountDocuments(ctx, bson.D{
+		{Key: "status", Value: bson.D{{Key: "$in", Value: []string{"IN_QUEUE", "WATCHING"}}}},
+	})
+	if err != nil {
+		logging.Error(ctx, "Error counting deals in mongo", "err", err)
+	}
+	return count, err
+}
@@ -22,3 +22,18 @@ func makeHoldAndWatchEndpoint(svc Service) endpoint.Endpoint {
 		}, err
 	}
 }
+
+func makeStopWatchingEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*pb.StopWatchingReq)
+		tid := "unknown"
+		if req.ReqHdr != nil {
+			tid = req.ReqHdr.Tid
+		}
+		err := svc.StopWatching(ctx, req.GetDealId())
+
+		return pb.StopWatchingResp{
+			RespHdr: &pb.RespHdr{Tid: tid, ReqTid: tid},
+		}, err
+	}
+}
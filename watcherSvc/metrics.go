@@ -0,0 +1,45 @@
+package watcherSvc
+
+import (
+	"context"
+
+	"github.com/DenysNahurnyi/deal/common/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	queueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "deal",
+		Subsystem: "watcher",
+		Name:      "queue_depth",
+		Help:      "Deals in the queue that wait for their timeout, including the watched one",
+	})
+	timerLag = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "deal",
+		Subsystem: "watcher",
+		Name:      "timer_lag_seconds",
+		Help:      "Delay between deal timeout and the moment it was processed",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600},
+	})
+	timeoutSignalFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "deal",
+		Subsystem: "watcher",
+		Name:      "timeout_signal_failures_total",
+		Help:      "Deal timeouts that failed to be delivered to dataSvc",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(queueDepth, timerLag, timeoutSignalFailures)
+}
+
+// updateQueueDepth sets queue depth gauge, it's called after every change of the queue.
+// Other instances change the queue too, so the gauge is as fresh as the last change this instance made
+func (s *service) updateQueueDepth(ctx context.Context) {
+	depth, err := s.queue.Count(ctx)
+	if err != nil {
+		logging.Warn(ctx, "Failed to count deals in the queue", "err", err)
+		return
+	}
+	queueDepth.Set(float64(depth))
+}
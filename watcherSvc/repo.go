@@ -0,0 +1,141 @@
+package watcherSvc
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+	"github.com/mongodb/mongo-go-driver/mongo"
+)
+
+// WatchQueueRepo keeps deals that wait for their timeout
+type WatchQueueRepo interface {
+	// Put adds deal to the queue and tells whether it times out before the deal timer is running for
+	Put(ctx context.Context, deal *DealDB) (queueID string, needToUpdateTimer bool, err error)
+	// GetFirst returns WATCHING deal or IN_QUEUE deal that times out first, nil if queue is empty
+	GetFirst(ctx context.Context) (*DealDB, error)
+	UpdateStatus(ctx context.Context, queueID, status string) error
+	// GetByDealID returns queue records of deal {dealID} that are still waiting for timeout
+	GetByDealID(ctx context.Context, dealID string) ([]*DealDB, error)
+	// Count returns number of deals that are still waiting for timeout
+	Count(ctx context.Context) (int64, error)
+}
+
+// mongoWatchQueueRepo is WatchQueueRepo on top of mongo collection
+type mongoWatchQueueRepo struct {
+	table *mongo.Collection
+}
+
+// NewMongoWatchQueueRepo creates WatchQueueRepo that keeps the queue in the {table}
+func NewMongoWatchQueueRepo(table *mongo.Collection) WatchQueueRepo {
+	return &mongoWatchQueueRepo{table: table}
+}
+
+func (r *mongoWatchQueueRepo) Put(ctx context.Context, deal *DealDB) (string, bool, error) {
+	return PutDealToQueue(ctx, deal, r.table)
+}
+
+func (r *mongoWatchQueueRepo) GetFirst(ctx context.Context) (*DealDB, error) {
+	return GetFirstDeal(ctx, r.table)
+}
+
+func (r *mongoWatchQueueRepo) UpdateStatus(ctx context.Context, queueID, status string) error {
+	return UpdateStatus(ctx, queueID, status, r.table)
+}
+
+func (r *mongoWatchQueueRepo) GetByDealID(ctx context.Context, dealID string) ([]*DealDB, error) {
+	return GetQueuedDealsByDealID(ctx, dealID, r.table)
+}
+
+func (r *mongoWatchQueueRepo) Count(ctx context.Context) (int64, error) {
+	return CountQueuedDeals(ctx, r.table)
+}
+
+// memWatchQueueRepo is WatchQueueRepo that keeps the queue in memory, it's safe for concurrent use
+type memWatchQueueRepo struct {
+	m     sync.Mutex
+	deals map[string]*DealDB
+}
+
+// NewMemWatchQueueRepo creates empty in-memory WatchQueueRepo
+func NewMemWatchQueueRepo() WatchQueueRepo {
+	return &memWatchQueueRepo{deals: map[string]*DealDB{}}
+}
+
+func (r *memWatchQueueRepo) Put(ctx context.Context, deal *DealDB) (string, bool, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	first := r.first()
+	needToUpdateTimer := first == nil || first.Timeout.After(deal.Timeout)
+	stored := *deal
+	stored.ID = primitive.NewObjectID()
+	r.deals[stored.ID.Hex()] = &stored
+	return stored.ID.Hex(), needToUpdateTimer, nil
+}
+
+// first returns copy of the first deal in the queue, caller has to hold the lock
+func (r *memWatchQueueRepo) first() *DealDB {
+	queued := []*DealDB{}
+	for _, d := range r.deals {
+		if d.Status == "WATCHING" {
+			res := *d
+			return &res
+		}
+		if d.Status == "IN_QUEUE" {
+			queued = append(queued, d)
+		}
+	}
+	if len(queued) == 0 {
+		return nil
+	}
+	sort.Slice(queued, func(i, j int) bool {
+		return queued[i].Timeout.Before(queued[j].Timeout)
+	})
+	res := *queued[0]
+	return &res
+}
+
+func (r *memWatchQueueRepo) GetFirst(ctx context.Context) (*DealDB, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.first(), nil
+}
+
+func (r *memWatchQueueRepo) UpdateStatus(ctx context.Context, queueID, status string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if _, err := primitive.ObjectIDFromHex(queueID); err != nil {
+		return err
+	}
+	// Like mongo update, unknown deal is not an error
+	if d, ok := r.deals[queueID]; ok {
+		d.Status = status
+	}
+	return nil
+}
+
+func (r *memWatchQueueRepo) GetByDealID(ctx context.Context, dealID string) ([]*DealDB, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	deals := []*DealDB{}
+	for _, d := range r.deals {
+		if d.DealID == dealID && (d.Status == "IN_QUEUE" || d.Status == "WATCHING") {
+			res := *d
+			deals = append(deals, &res)
+		}
+	}
+	return deals, nil
+}
+
+func (r *memWatchQueueRepo) Count(ctx context.Context) (int64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	var count int64
+	for _, d := range r.deals {
+		if d.Status == "IN_QUEUE" || d.Status == "WATCHING" {
+			count++
+		}
+	}
+	return count, nil
+}
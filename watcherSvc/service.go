@@ -6,6 +6,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/DenysNahurnyi/deal/common/clock"
+	"github.com/DenysNahurnyi/deal/common/config"
+	dealerrors "github.com/DenysNahurnyi/deal/common/errors"
+	"github.com/DenysNahurnyi/deal/common/logging"
 	"github.com/DenysNahurnyi/deal/pb/generated/pb"
 	"github.com/go-kit/kit/log"
 
@@ -14,176 +18,334 @@ import (
 
 type service struct {
 	envType       string
-	queueTable    *mongo.Collection
+	queue         WatchQueueRepo
+	clock         clock.Clock
 	dataSvcClient pb.DataServiceClient
 	dT            *DealTimer
 }
 
 type DealTimer struct {
-	timer        *time.Timer
+	timer        clock.Timer
 	turnOffTimer chan bool
 	m            sync.Mutex
 	currentDeal  string
+	// stopped is set once service is stopped, no new timers are started after that
+	stopped bool
+	// notifying counts timeouts being sent to dataSvc, they are sent without the lock and Stop waits for them
+	notifying sync.WaitGroup
 }
 
-// NewService creates new service of watchSvc that allows to call it's functions to handle watcherSvc domain
-func NewService(ctx context.Context, logger log.Logger, mgc *mongo.Client, dataSvcClient *pb.DataServiceClient) (Service, error) {
+// NewService creates new service of watchSvc that allows to call it's functions to handle watcherSvc domain,
+// deal timers run on {clk}
+func NewService(ctx context.Context, logger log.Logger, cfg *config.Config, mgc *mongo.Client, dataSvcClient *pb.DataServiceClient, clk clock.Clock) (Service, error) {
+	queue := NewMongoWatchQueueRepo(mgc.Database(cfg.Mongo.Database).Collection(cfg.Collection("dealWaitingQueue")))
+	return newService(ctx, cfg.EnvType, queue, *dataSvcClient, clk)
+}
+
+// NewServiceWithRepo creates service that keeps the deal queue in {queue}, e.g. in-memory repo for tests.
+// Deal timers run on {clk}, so fake clock could time out deals without waiting
+func NewServiceWithRepo(ctx context.Context, queue WatchQueueRepo, dataSvcClient pb.DataServiceClient, clk clock.Clock) (Service, error) {
+	return newService(ctx, "test", queue, dataSvcClient, clk)
+}
+
+func newService(ctx context.Context, envType string, queue WatchQueueRepo, dataSvcClient pb.DataServiceClient, clk clock.Clock) (Service, error) {
 	// If this service falled, run timer on the first deal on start
 	s := &service{
-		envType:       "test",
-		dataSvcClient: *dataSvcClient,
-		queueTable:    mgc.Database("travel").Collection("dealWaitingQueue"),
+		envType:       envType,
+		dataSvcClient: dataSvcClient,
+		queue:         queue,
+		clock:         clk,
 		dT: &DealTimer{
 			timer: nil,
 			m:     sync.Mutex{},
 		},
 	}
-	deal, err := GetFirstDeal(ctx, s.queueTable)
+	deal, err := s.queue.GetFirst(ctx)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to get the first deal from the queue: ", err)
+		logging.Error(ctx, "Failed to get the first deal from the queue", "err", err)
 		return nil, err
 	}
 	if deal != nil {
-		fmt.Println("{DEBUG}", "Service got first deal "+deal.ID.Hex()+" from queue and will create timer for that")
-		err = UpdateStatus(ctx, deal.ID.Hex(), "WATCHING", s.queueTable)
-		fmt.Println("{DEBUG}", "Update deal "+deal.ID.Hex()+" status to WATCHING, err: ", err)
+		logging.Debug(ctx, "Service got first deal "+deal.ID.Hex()+" from queue and will create timer for that")
+		err = s.queue.UpdateStatus(ctx, deal.ID.Hex(), "WATCHING")
+		logging.Debug(ctx, "Update deal "+deal.ID.Hex()+" status to WATCHING", "err", err)
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to update deal status: ", err)
+			logging.Error(ctx, "Failed to update deal status", "err", err)
 			return nil, err
 		}
 		go s.runTimer(deal.Timeout, deal.ID.Hex())
 	}
+	s.updateQueueDepth(ctx)
 	return s, nil
 }
 
 func (s *service) runTimer(timeout time.Time, dealQueueID string) {
-	ctx := context.Background()
+	// Timer isn't started by request, it's a transaction of its own
+	ctx := logging.WithFields(logging.NewTid(context.Background()), "deal", dealQueueID)
+	s.dT.m.Lock()
+	if s.dT.stopped {
+		s.dT.m.Unlock()
+		logging.Info(ctx, "Service is stopped, deal "+dealQueueID+" will be watched after restart")
+		return
+	}
 	// Check if another goroutine running timer
 	if s.dT.turnOffTimer != nil {
 		if len(s.dT.currentDeal) != 0 {
-			err := UpdateStatus(ctx, s.dT.currentDeal, "IN_QUEUE", s.queueTable)
-			fmt.Println("{DEBUG}", "Update deal "+s.dT.currentDeal+" status to BACK_TO_QUEUE, err: ", err)
+			err := s.queue.UpdateStatus(ctx, s.dT.currentDeal, "IN_QUEUE")
+			logging.Debug(ctx, "Update deal "+s.dT.currentDeal+" status to BACK_TO_QUEUE", "err", err)
 			if err != nil {
 				// Normal case
-				fmt.Println("[LOG]:", "Failed to update deal status: ", err)
+				logging.Error(ctx, "Failed to update deal status", "err", err)
+				s.dT.m.Unlock()
 				return
 			}
 		}
-		fmt.Println("{DEBUG}", "Service closed timer")
+		logging.Debug(ctx, "Service closed timer")
 		// If another goroutine running timer, close it
 		close(s.dT.turnOffTimer)
 		s.dT.turnOffTimer = nil
 	}
-	s.dT.turnOffTimer = make(chan bool)
-	s.dT.timer = time.NewTimer(time.Until(timeout))
+	// Timer and channel of this goroutine, other goroutines could replace the ones in {dT} once lock is released
+	turnOffTimer := make(chan bool)
+	timer := s.clock.NewTimer(timeout.Sub(s.clock.Now()))
+	s.dT.turnOffTimer = turnOffTimer
+	s.dT.timer = timer
 	s.dT.currentDeal = dealQueueID
-	fmt.Println("{DEBUG}", "Service created new timer for ", time.Until(timeout))
+	s.dT.m.Unlock()
+	logging.Debug(ctx, "Service created new timer", "deal", dealQueueID, "duration", timeout.Sub(s.clock.Now()))
 
 	select {
-	case <-s.dT.timer.C:
-		fmt.Println("{DEBUG}", "timer expired ")
-		s.dT.m.Lock()
-		defer s.dT.m.Unlock()
-		// Since this goroutine will be closed, {turnOffTimer} is not more possible to use
-		close(s.dT.turnOffTimer)
-		s.dT.turnOffTimer = nil
-		// Get first deal from DB
-		deal, err := GetFirstDeal(ctx, s.queueTable)
-		if err != nil {
-			fmt.Println("[LOG]:", "Can't get the first deal from queue: ", err)
-			return
-		}
+	case <-timer.C():
+		logging.Debug(ctx, "Timer expired")
+		// dataSvc is called once the lock is released, so slow dataSvc doesn't block other timers and requests
+		deal := s.expireTimer(ctx, turnOffTimer, timeout)
 		if deal == nil {
-			// Normal case
-			fmt.Println("[LOG]:", "No deal in queue")
 			return
 		}
-		fmt.Println("{DEBUG}", "Get first deal on timer expire: ", deal.ID.Hex())
-		fmt.Println("{DEBUG}", "Deal timeout: ", deal.Timeout)
-		fmt.Println("{DEBUG}", "Timer timeout: ", timeout)
-		fmt.Println("{DEBUG}", "IDs compare: ", deal.ID.Hex(), " vs current: ", s.dT.currentDeal)
-		if deal.ID.Hex() == s.dT.currentDeal {
-			fmt.Println("[LOG]:", "Deal "+deal.DealID+" timeout happened")
-			// Call dataSvc to update deal status
-			// In another case this timer is obsolete
-			// Start new timer:
-			//--Update last deal status
-			err = UpdateStatus(ctx, deal.ID.Hex(), "PROCESSED", s.queueTable)
-			fmt.Println("{DEBUG}", "Update deal "+deal.ID.Hex()+" status to PROCESSED, err: ", err)
-			if err != nil {
-				// Normal case
-				fmt.Println("[LOG]:", "Failed to update deal status: ", err)
-				return
-			}
-			_, err = s.dataSvcClient.DealTimeout(ctx, &pb.DealTimeoutReq{
-				ReqHdr: &pb.ReqHdr{
-					Tid: "Some transaction ID",
-				},
-				DealDocumentId: deal.DealID,
-			})
-			fmt.Println("{DEBUG}", "Send deal "+deal.ID.Hex()+" timeout signal")
-			if err != nil {
-				// We don't care, we just have to notify
-				fmt.Println("[LOG]", "Send deal "+deal.ID.Hex()+" timeout signal failed, err: ", err)
-			}
-		}
-		//--Create timer for the new one
-		deal, err = GetFirstDeal(ctx, s.queueTable)
+		defer s.dT.notifying.Done()
+		_, err := s.dataSvcClient.DealTimeout(ctx, &pb.DealTimeoutReq{
+			ReqHdr: &pb.ReqHdr{
+				Tid: logging.Tid(ctx),
+			},
+			DealDocumentId: deal.DealID,
+		})
+		logging.Debug(ctx, "Send deal "+deal.ID.Hex()+" timeout signal")
 		if err != nil {
-			fmt.Println("[LOG]:", "Can't get the first deal from queue: ", err)
-			return
+			// We don't care, we just have to notify
+			logging.Error(ctx, "Send deal "+deal.ID.Hex()+" timeout signal failed", "err", err)
+			timeoutSignalFailures.Inc()
 		}
-		if deal != nil {
-			fmt.Println("[LOG]:", "Create timer for the next deal")
-			err = UpdateStatus(ctx, deal.ID.Hex(), "WATCHING", s.queueTable)
-			fmt.Println("{DEBUG}", "Update deal "+deal.ID.Hex()+" status to WATCHING, err: ", err)
+	case <-turnOffTimer:
+		logging.Info(ctx, "Timer has to be recreated")
+	}
+}
 
-			if err != nil {
-				fmt.Println("[LOG]:", "Failed to update deal status: ", err)
-				return
-			}
-			go s.runTimer(deal.Timeout, deal.ID.Hex())
-		} else {
-			fmt.Println("[LOG]:", "No deals left in queue")
+// expireTimer marks the deal of expired timer {turnOffTimer} as processed and starts timer of the next deal,
+// it returns the timed out deal or nil if the timer is obsolete. Caller notifies dataSvc about returned deal and
+// marks it done in {notifying}
+func (s *service) expireTimer(ctx context.Context, turnOffTimer chan bool, timeout time.Time) *DealDB {
+	s.dT.m.Lock()
+	defer s.dT.m.Unlock()
+	if s.dT.turnOffTimer != turnOffTimer {
+		// Timer was turned off at the same time it expired, it's obsolete
+		logging.Info(ctx, "Timer has to be recreated")
+		return nil
+	}
+	// Since this goroutine will be closed, {turnOffTimer} is not more possible to use
+	close(s.dT.turnOffTimer)
+	s.dT.turnOffTimer = nil
+	// Get first deal from DB
+	deal, err := s.queue.GetFirst(ctx)
+	if err != nil {
+		logging.Error(ctx, "Can't get the first deal from queue", "err", err)
+		return nil
+	}
+	if deal == nil {
+		// Normal case
+		logging.Info(ctx, "No deal in queue")
+		return nil
+	}
+	logging.Debug(ctx, "Got first deal on timer expire", "deal", deal.ID.Hex(), "dealTimeout", deal.Timeout, "timerTimeout", timeout, "currentDeal", s.dT.currentDeal)
+	var timedOut *DealDB
+	if deal.ID.Hex() == s.dT.currentDeal {
+		logging.Info(ctx, "Deal "+deal.DealID+" timeout happened")
+		// dataSvc is notified by the caller, in another case this timer is obsolete
+		err = s.queue.UpdateStatus(ctx, deal.ID.Hex(), "PROCESSED")
+		logging.Debug(ctx, "Update deal "+deal.ID.Hex()+" status to PROCESSED", "err", err)
+		if err != nil {
+			// Normal case
+			logging.Error(ctx, "Failed to update deal status", "err", err)
+			return nil
 		}
+		timerLag.Observe(s.clock.Now().Sub(deal.Timeout).Seconds())
+		s.updateQueueDepth(ctx)
+		timedOut = deal
+		s.dT.notifying.Add(1)
+	}
+	//--Create timer for the new one
+	deal, err = s.queue.GetFirst(ctx)
+	if err != nil {
+		logging.Error(ctx, "Can't get the first deal from queue", "err", err)
+		return timedOut
+	}
+	if deal != nil {
+		logging.Info(ctx, "Create timer for the next deal")
+		err = s.queue.UpdateStatus(ctx, deal.ID.Hex(), "WATCHING")
+		logging.Debug(ctx, "Update deal "+deal.ID.Hex()+" status to WATCHING", "err", err)
 
-	case <-s.dT.turnOffTimer:
-		fmt.Println("[LOG]:", "Timer has to be recreated")
+		if err != nil {
+			logging.Error(ctx, "Failed to update deal status", "err", err)
+			return timedOut
+		}
+		go s.runTimer(deal.Timeout, deal.ID.Hex())
+	} else {
+		logging.Info(ctx, "No deals left in queue")
 	}
+	return timedOut
 }
 
 type Service interface {
 	HoldAndWatch(ctx context.Context, dealID, timeout string) error
+	StopWatching(ctx context.Context, dealID string) error
+	CheckScheduler(ctx context.Context) error
+	Stop(ctx context.Context) error
 }
 
 func (s *service) HoldAndWatch(ctx context.Context, dealID, timeoutStr string) error {
+	if s.isStopped() {
+		return dealerrors.New(dealerrors.WATCHER_STOPPING, "Watcher is shutting down")
+	}
 	// Check if timeout valid
 	LAYOUT := "2006-01-02T15:04:05.000Z"
 	timeout, err := time.Parse(LAYOUT, timeoutStr)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to parse timeout for deal "+dealID+": ", err)
-		return err
+		logging.Error(ctx, "Failed to parse timeout for deal "+dealID, "err", err)
+		return dealerrors.New(dealerrors.INVALID_TIMEOUT, "Timeout must be in format %s", LAYOUT)
 	}
 	deal := &DealDB{
 		DealID:  dealID,
 		Timeout: timeout,
 		Status:  "IN_QUEUE",
 	}
-	dealQueueID, updateTimer, err := PutDealToQueue(ctx, deal, s.queueTable)
+	dealQueueID, updateTimer, err := s.queue.Put(ctx, deal)
 	if err != nil {
-		fmt.Println("[LOG]:", "Failed to add deal "+dealID+" to the queue: ", err)
+		logging.Error(ctx, "Failed to add deal "+dealID+" to the queue", "err", err)
 		return err
 	}
-	fmt.Println("{DEBUG}", "Deal placed in queue, need to update timer: ", updateTimer)
+	logging.Debug(ctx, "Deal placed in queue", "deal", dealQueueID, "updateTimer", updateTimer)
+	s.updateQueueDepth(ctx)
 	if updateTimer {
-		fmt.Println("{DEBUG}", "Update timer with timeout: ", deal.Timeout)
-		err = UpdateStatus(ctx, dealQueueID, "WATCHING", s.queueTable)
-		fmt.Println("{DEBUG}", "Update deal "+dealQueueID+" status to WATCHING, err: ", err)
+		logging.Debug(ctx, "Update timer", "timeout", deal.Timeout)
+		err = s.queue.UpdateStatus(ctx, dealQueueID, "WATCHING")
+		logging.Debug(ctx, "Update deal "+dealQueueID+" status to WATCHING", "err", err)
 		if err != nil {
-			fmt.Println("[LOG]:", "Failed to update deal status: ", err)
+			logging.Error(ctx, "Failed to update deal status", "err", err)
 			return err
 		}
 		go s.runTimer(deal.Timeout, dealQueueID)
 	}
 	return nil
 }
+
+// StopWatching removes deal {dealID} from the queue, if its timer is running, timer moves to the next deal
+func (s *service) StopWatching(ctx context.Context, dealID string) error {
+	// Timer goroutines change the queue under the same lock, so they don't bring cancelled deal back
+	s.dT.m.Lock()
+	defer s.dT.m.Unlock()
+	deals, err := s.queue.GetByDealID(ctx, dealID)
+	if err != nil {
+		logging.Error(ctx, "Failed to get deal "+dealID+" from the queue", "err", err)
+		return err
+	}
+	timerCancelled := false
+	for _, d := range deals {
+		err = s.queue.UpdateStatus(ctx, d.ID.Hex(), "CANCELLED")
+		if err != nil {
+			logging.Error(ctx, "Failed to update deal status", "err", err)
+			return err
+		}
+		if d.ID.Hex() == s.dT.currentDeal {
+			// Forget cancelled deal, so it won't be returned back to the queue once timer recreated
+			s.dT.currentDeal = ""
+			timerCancelled = true
+		}
+	}
+	if len(deals) != 0 {
+		s.updateQueueDepth(ctx)
+	}
+	if !timerCancelled {
+		return nil
+	}
+	deal, err := s.queue.GetFirst(ctx)
+	if err != nil {
+		logging.Error(ctx, "Can't get the first deal from queue", "err", err)
+		return err
+	}
+	if deal == nil {
+		logging.Info(ctx, "No deals left in queue")
+		if s.dT.turnOffTimer != nil {
+			close(s.dT.turnOffTimer)
+			s.dT.turnOffTimer = nil
+		}
+		return nil
+	}
+	err = s.queue.UpdateStatus(ctx, deal.ID.Hex(), "WATCHING")
+	if err != nil {
+		logging.Error(ctx, "Failed to update deal status", "err", err)
+		return err
+	}
+	go s.runTimer(deal.Timeout, deal.ID.Hex())
+	return nil
+}
+
+// CheckScheduler returns error if the deal queue can't be read or the first deal in the queue isn't watched by timer.
+// Watcher has no leader election, every instance runs timers on the queue, so scheduler state is all it can report.
+// Queue changes between the read and the timer switch to the new deal, so the check can fail for a moment
+func (s *service) CheckScheduler(ctx context.Context) error {
+	deal, err := s.queue.GetFirst(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to read deal queue: %v", err)
+	}
+	if deal == nil {
+		return nil
+	}
+	s.dT.m.Lock()
+	currentDeal := s.dT.currentDeal
+	s.dT.m.Unlock()
+	if deal.ID.Hex() != currentDeal {
+		return fmt.Errorf("Deal %s is the first in queue, but timer watches %q", deal.ID.Hex(), currentDeal)
+	}
+	return nil
+}
+
+// Stop stops the deal timer, timeout that is being processed is finished first. Watched deal stays WATCHING
+// in the queue, so it's watched again once service is started. Returns error if {ctx} is done before that
+func (s *service) Stop(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.dT.m.Lock()
+		s.dT.stopped = true
+		if s.dT.timer != nil {
+			s.dT.timer.Stop()
+		}
+		if s.dT.turnOffTimer != nil {
+			close(s.dT.turnOffTimer)
+			s.dT.turnOffTimer = nil
+		}
+		s.dT.m.Unlock()
+		s.dT.notifying.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("Deal timeout is still being processed: %v", ctx.Err())
+	}
+}
+
+func (s *service) isStopped() bool {
+	s.dT.m.Lock()
+	defer s.dT.m.Unlock()
+	return s.dT.stopped
+}
@@ -16,6 +16,7 @@ import (
 
 type grpcServer struct {
 	holdAndWatch grpctransport.Handler
+	stopWatching grpctransport.Handler
 }
 
 func NewGRPCServer(svc Service, logger log.Logger) pb.WatcherServiceServer {
@@ -28,6 +29,12 @@ func NewGRPCServer(svc Service, logger log.Logger) pb.WatcherServiceServer {
 			decodeHoldAndWatchReq,
 			encodeHoldAndWatchResp,
 			options...),
+		stopWatching: grpctransport.NewServer(
+			makeStopWatchingEndpoint(svc),
+			decodeStopWatchingReq,
+			encodeStopWatchingResp,
+			options...,
+		),
 	}
 }
 
@@ -48,3 +55,21 @@ func encodeHoldAndWatchResp(_ context.Context, response interface{}) (interface{
 	resp := response.(pb.HoldAndWatchResp)
 	return &resp, nil
 }
+
+func (s *grpcServer) StopWatching(ctx context.Context, req *pb.StopWatchingReq) (*pb.StopWatchingResp, error) {
+	_, resp, err := s.stopWatching.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.StopWatchingResp), nil
+}
+
+func decodeStopWatchingReq(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.StopWatchingReq)
+	return req, nil
+}
+
+func encodeStopWatchingResp(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(pb.StopWatchingResp)
+	return &resp, nil
+}
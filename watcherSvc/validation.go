@@ -0,0 +1,19 @@
+package watcherSvc
+
+import (
+	"github.com/DenysNahurnyi/deal/common/validation"
+	"github.com/DenysNahurnyi/deal/pb/generated/pb"
+)
+
+// Validation rules of every request of watcherSvc, they are checked by the server interceptor
+func init() {
+	validation.Register(&pb.HoldAndWatchReq{}, func(req interface{}, v *validation.Violations) {
+		r := req.(*pb.HoldAndWatchReq)
+		v.ID("deal_id", r.GetDealId())
+		// Format of the timeout is checked by the service, it has its own code
+		v.Required("timeout", r.GetTimeout())
+	})
+	validation.Register(&pb.StopWatchingReq{}, func(req interface{}, v *validation.Violations) {
+		v.ID("deal_id", req.(*pb.StopWatchingReq).GetDealId())
+	})
+}